@@ -0,0 +1,116 @@
+package lokigo
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ActiveStreamsConfig tunes Metrics.ActiveStreams, the number of distinct
+// streams pushed recently, and Config.OnStreamCardinalityAlert.
+type ActiveStreamsConfig struct {
+	// Window is how long a stream stays active after its last push.
+	// Defaults to 1h.
+	Window time.Duration
+	// MaxTracked caps the streams tracked, at about 50 bytes each. Past it,
+	// new streams are not counted until tracked ones expire, so the count
+	// stops at MaxTracked. Defaults to 100000.
+	MaxTracked int
+	// AlertThreshold is the count at which OnStreamCardinalityAlert fires.
+	// Zero disables the alert.
+	AlertThreshold int
+}
+
+// activeStreams is a bounded set of stream hashes with their last push
+// time. Expired streams are pruned at most every Window/8, so the count may
+// include streams idle for up to that much longer than Window. It is safe
+// for concurrent use.
+type activeStreams struct {
+	cfg  ActiveStreamsConfig
+	seed maphash.Seed
+	now  func() time.Time
+
+	mu        sync.Mutex
+	seen      map[uint64]time.Time
+	lastPrune time.Time
+	// alerted is set once the count reached AlertThreshold, until it falls
+	// below it again.
+	alerted bool
+	// alert holds the count of a crossing not yet passed to
+	// OnStreamCardinalityAlert, or zero.
+	alert atomic.Int64
+}
+
+func newActiveStreams(cfg ActiveStreamsConfig) *activeStreams {
+	return &activeStreams{cfg: cfg, seed: maphash.MakeSeed(), now: time.Now, seen: map[uint64]time.Time{}}
+}
+
+// see marks the stream key active. It returns the count and whether it
+// just reached AlertThreshold.
+func (a *activeStreams) see(key string) (int, bool) {
+	h := maphash.String(a.seed, key)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	a.pruneLocked(now, false)
+	if _, ok := a.seen[h]; ok || len(a.seen) < a.cfg.MaxTracked {
+		a.seen[h] = now
+	} else if a.pruneLocked(now, true); len(a.seen) < a.cfg.MaxTracked {
+		a.seen[h] = now
+	}
+	n := len(a.seen)
+	if a.cfg.AlertThreshold > 0 && n >= a.cfg.AlertThreshold && !a.alerted {
+		a.alerted = true
+		return n, true
+	}
+	return n, false
+}
+
+// count returns the number of active streams.
+func (a *activeStreams) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pruneLocked(a.now(), false)
+	return len(a.seen)
+}
+
+// pruneLocked drops expired streams, unless the last prune was less than
+// Window/8 ago and force is not set.
+func (a *activeStreams) pruneLocked(now time.Time, force bool) {
+	if !force && now.Sub(a.lastPrune) < a.cfg.Window/8 {
+		return
+	}
+	a.lastPrune = now
+	for h, last := range a.seen {
+		if now.Sub(last) >= a.cfg.Window {
+			delete(a.seen, h)
+		}
+	}
+	if len(a.seen) < a.cfg.AlertThreshold {
+		a.alerted = false
+	}
+}
+
+// sawStream records that a batch carries the stream key. The payload
+// builders call it once per stream and batch; a threshold crossing is kept
+// for reportCardinalityAlert, which knows which goroutine it runs on.
+func (c *Client) sawStream(key string) {
+	if c.active == nil {
+		return
+	}
+	if n, crossed := c.active.see(key); crossed && c.cfg.OnStreamCardinalityAlert != nil {
+		c.active.alert.Store(int64(n))
+	}
+}
+
+// reportCardinalityAlert fires OnStreamCardinalityAlert for a crossing
+// sawStream recorded, if any. Pushes call it once their batch is encoded.
+func (c *Client) reportCardinalityAlert(onWorker bool) {
+	if c.active == nil {
+		return
+	}
+	if n := c.active.alert.Swap(0); n > 0 {
+		c.callback("OnStreamCardinalityAlert", onWorker, func() { c.cfg.OnStreamCardinalityAlert(int(n)) })
+	}
+}
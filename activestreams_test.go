@@ -0,0 +1,95 @@
+package lokigo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestActiveStreamsCountsDistinctLabelSets(t *testing.T) {
+	srv, _ := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 7, StaticLabels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 120 {
+		if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"pod": fmt.Sprint(i % 40)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Metrics().ActiveStreams; n != 40 {
+		t.Fatalf("ActiveStreams = %d, want 40", n)
+	}
+	if n := c.MetricsReset().ActiveStreams; n != 40 {
+		t.Fatalf("MetricsReset().ActiveStreams = %d, want the gauge unchanged", n)
+	}
+}
+
+func TestStreamCardinalityAlertFiresOncePerCrossing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }))
+	defer srv.Close()
+	var alerts []int
+	c, err := NewClient(Config{
+		Endpoint:                 srv.URL,
+		DisableBatching:          true,
+		ActiveStreams:            ActiveStreamsConfig{Window: time.Minute, AlertThreshold: 5},
+		OnStreamCardinalityAlert: func(n int) { alerts = append(alerts, n) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	c.active.now = clock.Now
+	send := func(from, to int) {
+		for i := from; i < to; i++ {
+			if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"pod": fmt.Sprint(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	send(0, 8)
+	if !slices.Equal(alerts, []int{5}) {
+		t.Fatalf("alerts = %v after 8 streams, want one at 5", alerts)
+	}
+	// Still above the threshold: no new alert.
+	_ = clock.Sleep(context.Background(), 30*time.Second)
+	send(0, 8)
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %v, want no second alert without falling below", alerts)
+	}
+	// Every stream expires, then the count rises past the threshold again.
+	_ = clock.Sleep(context.Background(), 2*time.Minute)
+	if n := c.Metrics().ActiveStreams; n != 0 {
+		t.Fatalf("ActiveStreams = %d after the window, want 0", n)
+	}
+	send(100, 106)
+	if !slices.Equal(alerts, []int{5, 5}) {
+		t.Fatalf("alerts = %v, want a second alert after the second crossing", alerts)
+	}
+}
+
+func TestActiveStreamsMaxTracked(t *testing.T) {
+	a := newActiveStreams(ActiveStreamsConfig{Window: time.Minute, MaxTracked: 3})
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	a.now = clock.Now
+	for i := range 5 {
+		a.see(fmt.Sprint(i))
+	}
+	if n := a.count(); n != 3 {
+		t.Fatalf("count = %d, want MaxTracked 3", n)
+	}
+	_ = clock.Sleep(context.Background(), time.Minute)
+	a.see("new")
+	if n := a.count(); n != 1 {
+		t.Fatalf("count = %d after expiry, want only the new stream", n)
+	}
+}
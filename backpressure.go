@@ -3,11 +3,17 @@ package lokigo
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 var errDroppedInternal = errors.New("dropped")
 
-func enqueueWithMode(ctx context.Context, ch chan Entry, v Entry, mode BackpressureMode) (int, error) {
+// enqueueWithMode puts v on ch according to mode and returns how many
+// entries were dropped. Under BackpressureDropNew, a full ch is waited on
+// for up to grace before v is dropped. onDropOldest, if non-nil, receives
+// each queued entry evicted by BackpressureDropOldest. v itself was enqueued
+// iff err is nil.
+func enqueueWithMode[T any](ctx context.Context, ch chan T, v T, mode BackpressureMode, grace time.Duration, onDropOldest func(T)) (int, error) {
 	switch mode {
 	case BackpressureBlock:
 		select {
@@ -21,7 +27,19 @@ func enqueueWithMode(ctx context.Context, ch chan Entry, v Entry, mode Backpress
 		case ch <- v:
 			return 0, nil
 		default:
+		}
+		if grace <= 0 {
+			return 1, errDroppedInternal
+		}
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+		select {
+		case ch <- v:
+			return 0, nil
+		case <-timer.C:
 			return 1, errDroppedInternal
+		case <-ctx.Done():
+			return 0, ctx.Err()
 		}
 	case BackpressureDropOldest:
 		dropped := 0
@@ -31,8 +49,11 @@ func enqueueWithMode(ctx context.Context, ch chan Entry, v Entry, mode Backpress
 				return dropped, nil
 			default:
 				select {
-				case <-ch:
+				case old := <-ch:
 					dropped++
+					if onDropOldest != nil {
+						onDropOldest(old)
+					}
 				default:
 				}
 			}
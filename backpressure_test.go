@@ -2,6 +2,11 @@ package lokigo
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -9,7 +14,7 @@ import (
 func TestBackpressureDropNew(t *testing.T) {
 	ch := make(chan Entry, 1)
 	ch <- Entry{Line: "old"}
-	dropped, err := enqueueWithMode(context.Background(), ch, Entry{Line: "new"}, BackpressureDropNew)
+	dropped, err := enqueueWithMode(context.Background(), ch, Entry{Line: "new"}, BackpressureDropNew, 0, nil)
 	if err != errDroppedInternal {
 		t.Fatalf("expected dropped err, got %v", err)
 	}
@@ -25,7 +30,7 @@ func TestBackpressureDropNew(t *testing.T) {
 func TestBackpressureDropOldest(t *testing.T) {
 	ch := make(chan Entry, 1)
 	ch <- Entry{Line: "old"}
-	dropped, err := enqueueWithMode(context.Background(), ch, Entry{Line: "new"}, BackpressureDropOldest)
+	dropped, err := enqueueWithMode(context.Background(), ch, Entry{Line: "new"}, BackpressureDropOldest, 0, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -43,8 +48,158 @@ func TestBackpressureBlockRespectsContext(t *testing.T) {
 	ch <- Entry{Line: "full"}
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
 	defer cancel()
-	_, err := enqueueWithMode(ctx, ch, Entry{Line: "blocked"}, BackpressureBlock)
+	_, err := enqueueWithMode(ctx, ch, Entry{Line: "blocked"}, BackpressureBlock, 0, nil)
 	if err == nil {
 		t.Fatal("expected context timeout error")
 	}
 }
+
+func TestDropNewGraceRidesOutSlowFlushes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	drops := func(grace time.Duration) uint64 {
+		c, err := NewClient(Config{
+			Endpoint:         srv.URL,
+			QueueSize:        8,
+			BatchMaxEntries:  8,
+			BatchMaxWait:     time.Minute,
+			BackpressureMode: BackpressureDropNew,
+			DropNewGrace:     grace,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(context.Background())
+		for range 200 {
+			err := c.Send(context.Background(), Entry{Line: "x"})
+			if err != nil && !errors.Is(err, ErrDropped) {
+				t.Fatal(err)
+			}
+		}
+		return c.Metrics().DroppedByReason[DropQueueFull]
+	}
+	without, with := drops(0), drops(100*time.Millisecond)
+	if without < 50 || with > without/10 {
+		t.Fatalf("dropped %d without grace and %d with it, want far fewer with it", without, with)
+	}
+}
+
+func TestDropNewGraceBoundedByContext(t *testing.T) {
+	ch := make(chan Entry, 1)
+	ch <- Entry{Line: "old"}
+	start := time.Now()
+	dropped, err := enqueueWithMode(context.Background(), ch, Entry{Line: "new"}, BackpressureDropNew, 20*time.Millisecond, nil)
+	if dropped != 1 || !errors.Is(err, errDroppedInternal) || time.Since(start) < 20*time.Millisecond {
+		t.Fatalf("dropped %d, err %v after %s; want a drop once the grace elapsed", dropped, err, time.Since(start))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := enqueueWithMode(ctx, ch, Entry{Line: "new"}, BackpressureDropNew, time.Hour, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context error before the grace elapsed", err)
+	}
+}
+
+func TestQueueDepthAndHighWaterUnderDropNew(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		select {
+		case arrived <- struct{}{}:
+		default:
+		}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, QueueSize: 4, BatchMaxEntries: 1, BackpressureMode: BackpressureDropNew})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	defer close(release)
+	if got := c.QueueCap(); got != 4 {
+		t.Fatalf("QueueCap = %d, want 4", got)
+	}
+	// The first entry is in flight, so the next four fill the queue and the
+	// two after that are dropped.
+	if err := c.Send(context.Background(), Entry{Line: "in flight"}); err != nil {
+		t.Fatal(err)
+	}
+	<-arrived
+	for i := range 6 {
+		err := c.Send(context.Background(), Entry{Line: "x"})
+		if want := i >= 4; errors.Is(err, ErrDropped) != want {
+			t.Fatalf("Send %d = %v, dropped want %v", i, err, want)
+		}
+	}
+	if got := c.QueueLen(); got != 4 {
+		t.Fatalf("QueueLen = %d, want 4", got)
+	}
+	m := c.MetricsReset()
+	if m.QueueHighWater != 4 || m.Dropped != 2 {
+		t.Fatalf("QueueHighWater = %d, Dropped = %d, want 4 and 2", m.QueueHighWater, m.Dropped)
+	}
+	if got := c.Metrics().QueueHighWater; got != 0 {
+		t.Fatalf("QueueHighWater after MetricsReset = %d, want 0", got)
+	}
+}
+
+func TestOnDropReceivesDroppedEntry(t *testing.T) {
+	for _, tc := range []struct {
+		mode       BackpressureMode
+		wantLine   string
+		wantReason DropReason
+	}{
+		{BackpressureDropNew, "c", DropQueueFull},
+		{BackpressureDropOldest, "a", DropQueueEvicted},
+	} {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			arrived := make(chan struct{}, 1)
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				select {
+				case arrived <- struct{}{}:
+				default:
+				}
+				<-release
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			type drop struct {
+				line   string
+				reason DropReason
+			}
+			var drops []drop
+			c, err := NewClient(Config{
+				Endpoint: srv.URL, QueueSize: 2, BatchMaxEntries: 1, BackpressureMode: tc.mode,
+				OnDrop: func(e Entry, reason DropReason) {
+					drops = append(drops, drop{e.Line, reason})
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close(context.Background())
+			defer close(release)
+			if err := c.Send(context.Background(), Entry{Line: "in flight"}); err != nil {
+				t.Fatal(err)
+			}
+			<-arrived
+			for _, line := range []string{"a", "b", "c"} {
+				_ = c.Send(context.Background(), Entry{Line: line})
+			}
+			want := []drop{{tc.wantLine, tc.wantReason}}
+			if !reflect.DeepEqual(drops, want) {
+				t.Fatalf("OnDrop got %v, want %v", drops, want)
+			}
+		})
+	}
+}
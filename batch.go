@@ -0,0 +1,60 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+)
+
+// streamKey identifies the Loki stream an entry belongs to after merging
+// static labels.
+func (c *Client) streamKey(e Entry) string {
+	return c.protoStream(e)
+}
+
+// flushBatch pushes entries, partitioned by Routes, and split along stream
+// boundaries into several requests when MaxStreamsPerBatch is exceeded. Every
+// part is pushed even if an earlier one fails; the errors are joined.
+func (c *Client) flushBatch(ctx context.Context, entries []Entry) error {
+	targets, routed := c.routeBatch(entries)
+	var errs []error
+	for i, target := range targets {
+		parts := [][]Entry{routed[i]}
+		if c.cfg.MaxStreamsPerBatch > 0 {
+			parts = splitByStreams(routed[i], c.cfg.MaxStreamsPerBatch, c.streamKey)
+		}
+		for _, part := range parts {
+			if err := c.pushWithRetry(ctx, target, part); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// splitByStreams partitions entries into groups holding at most maxStreams
+// distinct stream keys. Streams keep their first-seen order and entries keep
+// their relative order within a stream.
+func splitByStreams(entries []Entry, maxStreams int, key func(Entry) string) [][]Entry {
+	order := make([]string, 0)
+	byKey := map[string][]Entry{}
+	for _, e := range entries {
+		k := key(e)
+		if _, ok := byKey[k]; !ok {
+			order = append(order, k)
+		}
+		byKey[k] = append(byKey[k], e)
+	}
+	if len(order) <= maxStreams {
+		return [][]Entry{entries}
+	}
+	parts := make([][]Entry, 0, (len(order)+maxStreams-1)/maxStreams)
+	for i := 0; i < len(order); i += maxStreams {
+		end := min(i+maxStreams, len(order))
+		var part []Entry
+		for _, k := range order[i:end] {
+			part = append(part, byKey[k]...)
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
@@ -0,0 +1,330 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushedValue is one value of a JSON push: its timestamp, its line and,
+// when sent, its structured metadata.
+type pushedValue struct {
+	ts       string
+	line     string
+	metadata map[string]string
+}
+
+func (v *pushedValue) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) < 2 || len(raw) > 3 {
+		return fmt.Errorf("value has %d elements, want 2 or 3", len(raw))
+	}
+	if err := json.Unmarshal(raw[0], &v.ts); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw[1], &v.line); err != nil {
+		return err
+	}
+	if len(raw) == 3 {
+		return json.Unmarshal(raw[2], &v.metadata)
+	}
+	return nil
+}
+
+// pushedStream is one decoded stream of a JSON push.
+type pushedStream struct {
+	Stream map[string]string `json:"stream"`
+	Values []pushedValue     `json:"values"`
+}
+
+// jsonPush is one JSON push received by captureJSONPushes.
+type jsonPush struct {
+	path    string
+	header  http.Header
+	streams []pushedStream
+}
+
+// jsonPushRecorder records the pushes received by captureJSONPushes.
+type jsonPushRecorder struct {
+	mu     sync.Mutex
+	fail   int
+	hook   func(http.ResponseWriter, *http.Request)
+	pushes []jsonPush
+}
+
+// captureJSONPushes returns a server recording the path, headers and
+// streams of every JSON push it receives.
+func captureJSONPushes(t *testing.T) (*httptest.Server, *jsonPushRecorder) {
+	t.Helper()
+	rec := &jsonPushRecorder{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		rec.mu.Lock()
+		hook := rec.hook
+		rec.mu.Unlock()
+		if hook != nil {
+			hook(w, r)
+		}
+		var payload struct {
+			Streams []pushedStream `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.pushes = append(rec.pushes, jsonPush{path: r.URL.Path, header: r.Header.Clone(), streams: payload.Streams})
+		if rec.fail > 0 {
+			rec.fail--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, rec
+}
+
+// failFirst makes the next n pushes fail with 500; they are still recorded.
+func (r *jsonPushRecorder) failFirst(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fail = n
+}
+
+// onPush makes the server call fn for every later push before reading it,
+// for example to set response headers or to hold the push.
+func (r *jsonPushRecorder) onPush(fn func(http.ResponseWriter, *http.Request)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hook = fn
+}
+
+// received returns the pushes recorded so far.
+func (r *jsonPushRecorder) received() []jsonPush {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]jsonPush(nil), r.pushes...)
+}
+
+// streams returns the streams of every push recorded so far, in order.
+func (r *jsonPushRecorder) streams() []pushedStream {
+	var streams []pushedStream
+	for _, p := range r.received() {
+		streams = append(streams, p.streams...)
+	}
+	return streams
+}
+
+// pushedEntry is a pushed value along with its stream labels.
+type pushedEntry struct {
+	labels map[string]string
+	pushedValue
+}
+
+// entries returns every value pushed so far with its stream labels, in
+// order.
+func (r *jsonPushRecorder) entries() []pushedEntry {
+	var entries []pushedEntry
+	for _, s := range r.streams() {
+		for _, v := range s.Values {
+			entries = append(entries, pushedEntry{s.Stream, v})
+		}
+	}
+	return entries
+}
+
+type capturedPush struct {
+	streams int
+	entries int
+}
+
+// newJSONCaptureServer records the number of streams and entries of every
+// JSON push it receives.
+func newJSONCaptureServer(t *testing.T) (*httptest.Server, func() []capturedPush) {
+	t.Helper()
+	srv, rec := captureJSONPushes(t)
+	return srv, func() []capturedPush {
+		var pushes []capturedPush
+		for _, p := range rec.received() {
+			cp := capturedPush{streams: len(p.streams)}
+			for _, s := range p.streams {
+				cp.entries += len(s.Values)
+			}
+			pushes = append(pushes, cp)
+		}
+		return pushes
+	}
+}
+
+func TestMaxStreamsPerBatchSplitsAlongStreamBoundaries(t *testing.T) {
+	srv, pushes := newJSONCaptureServer(t)
+	c, err := NewClient(Config{
+		Endpoint:           srv.URL,
+		Encoding:           EncodingJSON,
+		BatchMaxEntries:    10,
+		BatchMaxWait:       time.Minute,
+		MaxStreamsPerBatch: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		e := Entry{Line: "x", Labels: map[string]string{"stream": fmt.Sprintf("s%d", i%5)}}
+		if err := c.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := pushes()
+	want := []capturedPush{{streams: 2, entries: 4}, {streams: 2, entries: 4}, {streams: 1, entries: 2}}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d requests, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("request %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestMaxEntriesPerStreamForcesFlush(t *testing.T) {
+	srv, pushes := newJSONCaptureServer(t)
+	c, err := NewClient(Config{
+		Endpoint:            srv.URL,
+		Encoding:            EncodingJSON,
+		BatchMaxEntries:     100,
+		BatchMaxWait:        time.Minute,
+		MaxEntriesPerStream: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "b", "a", "b"} {
+		if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"stream": s}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := pushes()
+	if len(got) != 2 || got[0] != (capturedPush{streams: 2, entries: 3}) || got[1] != (capturedPush{streams: 1, entries: 1}) {
+		t.Fatalf("expected flush when stream a hit the cap, got %+v", got)
+	}
+}
+
+func TestSplitByStreamsKeepsSmallBatchIntact(t *testing.T) {
+	entries := []Entry{{Line: "1"}, {Line: "2"}}
+	parts := splitByStreams(entries, 3, func(e Entry) string { return e.Line })
+	if len(parts) != 1 || len(parts[0]) != 2 {
+		t.Fatalf("expected batch to stay intact, got %+v", parts)
+	}
+}
+
+func TestOversizeEntryPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy  OversizeEntryPolicy
+		want    [][]string
+		dropped uint64
+		cut     uint64
+	}{
+		{OversizeSendAlone, [][]string{{"small"}, {"this line is too long"}, {"tail"}}, 0, 0},
+		{OversizeTruncate, [][]string{{"small"}, {"this li…"}, {"tail"}}, 0, 1},
+		{OversizeDrop, [][]string{{"small", "tail"}}, 1, 0},
+	} {
+		// BatchMaxWait flushes the tail while running; a long one leaves
+		// it to the Close drain.
+		for _, atClose := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/atClose=%t", tc.policy, atClose), func(t *testing.T) {
+				srv, rec := captureJSONPushes(t)
+				wait := 20 * time.Millisecond
+				if atClose {
+					wait = time.Minute
+				}
+				c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxBytes: 10, BatchMaxWait: wait, OversizeEntryPolicy: tc.policy})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, line := range []string{"small", "this line is too long", "tail"} {
+					if err := c.Send(context.Background(), Entry{Line: line, Labels: map[string]string{"app": "a"}}); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if !atClose {
+					deadline := time.Now().Add(2 * time.Second)
+					for len(rec.received()) < len(tc.want) {
+						if time.Now().After(deadline) {
+							t.Fatalf("got %d pushes, want %d", len(rec.received()), len(tc.want))
+						}
+						time.Sleep(time.Millisecond)
+					}
+				}
+				if err := c.Close(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				got := linesByApp(rec.received())
+				if len(got) != len(tc.want) {
+					t.Fatalf("pushes = %v, want %v", got, tc.want)
+				}
+				for i, p := range got {
+					if fmt.Sprint(p["a"]) != fmt.Sprint(tc.want[i]) {
+						t.Fatalf("push %d = %v, want %v", i, p["a"], tc.want[i])
+					}
+				}
+				m := c.Metrics()
+				if m.DroppedByReason[DropOversize] != tc.dropped || m.TruncatedLines != tc.cut {
+					t.Fatalf("dropped %d, truncated %d; want %d and %d", m.DroppedByReason[DropOversize], m.TruncatedLines, tc.dropped, tc.cut)
+				}
+			})
+		}
+	}
+}
+
+func TestWorkerPushesEachStreamInOrder(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 7, BatchMaxWait: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const perStream = 200
+	for i := range perStream {
+		for _, app := range []string{"hot-a", "hot-b"} {
+			if err := c.Send(context.Background(), Entry{Line: fmt.Sprintf("%03d", i), Labels: map[string]string{"app": app}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for _, p := range linesByApp(rec.received()) {
+		for app, lines := range p {
+			got[app] = append(got[app], lines...)
+		}
+	}
+	for _, app := range []string{"hot-a", "hot-b"} {
+		lines := got[app]
+		if len(lines) != perStream {
+			t.Fatalf("%s: %d lines pushed, want %d", app, len(lines), perStream)
+		}
+		for i, line := range lines {
+			if line != fmt.Sprintf("%03d", i) {
+				t.Fatalf("%s: line %d is %q, out of order", app, i, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package lokigo
+
+// Batcher decides when the worker flushes the batch it is assembling. The
+// worker still owns the queue, the batch itself and the flushes: it flushes
+// before an entry would push the batch past BatchMaxEntries or
+// BatchMaxBytes, when MaxBatchAge expires and on Close, whatever the Batcher
+// says. All methods are called from the worker goroutine only.
+type Batcher interface {
+	// Add is called after e joined the batch and reports whether to flush
+	// now.
+	Add(e Entry) (flushNow bool)
+	// OnTick is called every BatchMaxWait interval and reports whether to
+	// flush now.
+	OnTick() (flushNow bool)
+	// Reset is called after every flush.
+	Reset()
+}
+
+// defaultBatcher is the built-in policy: flush once BatchMaxEntries entries
+// or MaxEntriesPerStream entries of one stream are batched, and on every
+// tick.
+type defaultBatcher struct {
+	maxEntries   int
+	maxPerStream int
+	streamKey    func(Entry) string
+	n            int
+	// streams tracks entries per stream; it is only maintained when
+	// maxPerStream is set.
+	streams map[string]int
+}
+
+func (c *Client) newDefaultBatcher() *defaultBatcher {
+	return &defaultBatcher{maxEntries: c.cfg.BatchMaxEntries, maxPerStream: c.cfg.MaxEntriesPerStream, streamKey: c.streamKey}
+}
+
+func (b *defaultBatcher) Add(e Entry) bool {
+	b.n++
+	if b.maxPerStream > 0 {
+		if b.streams == nil {
+			b.streams = map[string]int{}
+		}
+		k := b.streamKey(e)
+		b.streams[k]++
+		if b.streams[k] >= b.maxPerStream {
+			return true
+		}
+	}
+	return b.n >= b.maxEntries
+}
+
+func (b *defaultBatcher) OnTick() bool { return true }
+
+func (b *defaultBatcher) Reset() {
+	b.n = 0
+	clear(b.streams)
+}
+
+// StreamCountBatcher flushes as soon as any stream, identified by the
+// entry's own Labels, has PerStream entries in the batch, and on every tick.
+// Unlike the default policy it does not flush at BatchMaxEntries itself;
+// that remains a hard cap of the worker.
+type StreamCountBatcher struct {
+	PerStream int
+
+	counts map[string]int
+}
+
+func (b *StreamCountBatcher) Add(e Entry) bool {
+	if b.counts == nil {
+		b.counts = map[string]int{}
+	}
+	k := toLokiLabelSet(e.Labels)
+	b.counts[k]++
+	return b.counts[k] >= b.PerStream
+}
+
+func (b *StreamCountBatcher) OnTick() bool { return true }
+
+func (b *StreamCountBatcher) Reset() { clear(b.counts) }
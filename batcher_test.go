@@ -0,0 +1,129 @@
+package lokigo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDefaultBatcher(t *testing.T) {
+	b := &defaultBatcher{maxEntries: 3, maxPerStream: 2, streamKey: func(e Entry) string { return e.Labels["s"] }}
+	if b.Add(Entry{Labels: map[string]string{"s": "a"}}) || b.Add(Entry{Labels: map[string]string{"s": "b"}}) {
+		t.Fatal("expected no flush below the limits")
+	}
+	if !b.Add(Entry{Labels: map[string]string{"s": "a"}}) {
+		t.Fatal("expected a flush when a stream reaches MaxEntriesPerStream")
+	}
+	b.Reset()
+	for i := 0; i < 2; i++ {
+		if b.Add(Entry{Labels: map[string]string{"s": fmt.Sprint(i)}}) {
+			t.Fatal("expected Reset to clear counts")
+		}
+	}
+	if !b.Add(Entry{Labels: map[string]string{"s": "x"}}) {
+		t.Fatal("expected a flush at BatchMaxEntries")
+	}
+	if !b.OnTick() {
+		t.Fatal("expected the default policy to flush on tick")
+	}
+}
+
+func TestStreamCountBatcherFlushesPerStream(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		BatchMaxEntries: 100,
+		BatchMaxWait:    time.Minute,
+		Batcher:         &StreamCountBatcher{PerStream: 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The third "hot" entry flushes everything batched so far.
+	for i, app := range []string{"quiet", "hot", "quiet", "hot", "other", "hot"} {
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprint(i), Labels: map[string]string{"app": app}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(streams()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a flush once a stream reached 3 entries")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if c.QueueLen() != 0 {
+		t.Fatalf("expected nothing left pending, got %d", c.QueueLen())
+	}
+	total := 0
+	for _, s := range streams() {
+		total += s.entries
+	}
+	if total != 6 {
+		t.Fatalf("expected all 6 entries flushed together, got %d", total)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tickIgnoringBatcher flushes only on entries whose line is "flush".
+type tickIgnoringBatcher struct{}
+
+func (tickIgnoringBatcher) Add(e Entry) bool { return e.Line == "flush" }
+func (tickIgnoringBatcher) OnTick() bool     { return false }
+func (tickIgnoringBatcher) Reset()           {}
+
+func TestStaleBatchSlackForcesFlushAfterSlowPush(t *testing.T) {
+	pushes := make(chan time.Time, 4)
+	release := make(chan struct{})
+	var first atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if first.CompareAndSwap(false, true) {
+			<-release
+		}
+		pushes <- time.Now()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		BatchMaxWait:    20 * time.Millisecond,
+		StaleBatchSlack: 20 * time.Millisecond,
+		Batcher:         tickIgnoringBatcher{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	// The first push blocks the worker while the next entry waits queued.
+	if err := c.Send(context.Background(), Entry{Line: "flush"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(context.Background(), Entry{Line: "stale"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	released := time.Now()
+	close(release)
+	<-pushes
+	select {
+	case at := <-pushes:
+		if waited := at.Sub(released); waited > time.Second {
+			t.Fatalf("second batch pushed %v after the slow push, want it bounded by the watchdog", waited)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the watchdog to flush the batch the Batcher kept waiting")
+	}
+	if got := c.Metrics().ForcedFlushes; got != 1 {
+		t.Fatalf("ForcedFlushes = %d, want 1", got)
+	}
+}
@@ -0,0 +1,84 @@
+package lokigo
+
+import (
+	crand "crypto/rand"
+	"strconv"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet of ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID for t: 48 bits of Unix milliseconds followed by 80
+// random bits, as 26 Crockford base32 characters.
+func newULID(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := range 6 {
+		b[i] = byte(ms >> (8 * (5 - i)))
+	}
+	_, _ = crand.Read(b[6:])
+	// 128 bits as 26 characters: the first carries the top 3 bits.
+	var out [26]byte
+	var acc uint32
+	bits := 2 // pad to 130 bits
+	j := 0
+	for _, v := range b {
+		acc = acc<<8 | uint32(v)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[j] = crockford[(acc>>bits)&31]
+			j++
+		}
+	}
+	return string(out[:])
+}
+
+// InstanceID returns the ULID identifying this client, sent in
+// Config.InstanceLabel.
+func (c *Client) InstanceID() string {
+	return c.instanceID
+}
+
+// tagBatch returns a copy of entries carrying BatchSequenceLabel and
+// InstanceLabel, or entries itself when neither is set. Each call takes
+// the next sequence number, so a batch is tagged once, before its first
+// attempt, and its retries carry the same number.
+func (c *Client) tagBatch(entries []Entry) []Entry {
+	if c.cfg.BatchSequenceLabel == "" && c.cfg.InstanceLabel == "" {
+		return entries
+	}
+	extra := make(map[string]string, 2)
+	if name := c.cfg.BatchSequenceLabel; name != "" {
+		extra[name] = strconv.FormatUint(c.batchSeq.Add(1), 10)
+	}
+	if name := c.cfg.InstanceLabel; name != "" {
+		extra[name] = c.instanceID
+	}
+	tagged := make([]Entry, len(entries))
+	if c.cfg.BatchLabelsAsMetadata {
+		for i, e := range entries {
+			e.Metadata = mergeLabels(e.Metadata, extra)
+			tagged[i] = e
+		}
+		return tagged
+	}
+	// Entries of one LabelSet share a single derived LabelSet.
+	derived := map[*labelSet]*labelSet{}
+	for i, e := range entries {
+		if ls := e.labelSet; ls != nil {
+			d, ok := derived[ls]
+			if !ok {
+				labels := mergeLabels(ls.labels, extra)
+				d = &labelSet{labels: labels, key: toLokiLabelSet(labels)}
+				derived[ls] = d
+			}
+			e.labelSet = d
+		} else {
+			e.Labels = mergeLabels(e.Labels, extra)
+		}
+		tagged[i] = e
+	}
+	return tagged
+}
@@ -0,0 +1,111 @@
+package lokigo
+
+import (
+	"context"
+	"maps"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// labelsWithMetadata returns the labels of every pushed stream together
+// with the metadata of its first value, keyed "md:<name>".
+func labelsWithMetadata(pushes []jsonPush) []map[string]string {
+	var seen []map[string]string
+	for _, p := range pushes {
+		for _, s := range p.streams {
+			got := maps.Clone(s.Stream)
+			for k, v := range s.Values[0].metadata {
+				got["md:"+k] = v
+			}
+			seen = append(seen, got)
+		}
+	}
+	return seen
+}
+
+func TestBatchSequenceLabelIncreasesPerBatch(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+	c, err := NewClient(Config{
+		Endpoint:           srv.URL,
+		Encoding:           EncodingJSON,
+		DisableBatching:    true,
+		StaticLabels:       map[string]string{"app": "api"},
+		BatchSequenceLabel: "batch_seq",
+		InstanceLabel:      "instance",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	ls := c.NewLabelSet(map[string]string{"env": "prod"})
+	for i := range 4 {
+		if i%2 == 0 {
+			err = c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"env": "prod"}})
+		} else {
+			err = c.SendWithLabelSet(context.Background(), time.Now(), "x", ls)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := labelsWithMetadata(rec.received())
+	if len(got) != 4 {
+		t.Fatalf("got %d streams, want 4", len(got))
+	}
+	if id := c.InstanceID(); len(id) != 26 {
+		t.Fatalf("InstanceID = %q, want a 26-character ULID", id)
+	}
+	for i, labels := range got {
+		want := map[string]string{"app": "api", "env": "prod", "batch_seq": strconv.Itoa(i + 1), "instance": c.InstanceID()}
+		if !maps.Equal(labels, want) {
+			t.Fatalf("batch %d labels = %v, want %v", i+1, labels, want)
+		}
+	}
+}
+
+func TestBatchSequenceStableAcrossRetries(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+	rec.failFirst(2)
+	c, err := NewClient(Config{
+		Endpoint:              srv.URL,
+		Encoding:              EncodingJSON,
+		DisableBatching:       true,
+		BatchSequenceLabel:    "batch_seq",
+		BatchLabelsAsMetadata: true,
+		Retry:                 RetryConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	for range 2 {
+		if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"app": "api"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var seqs []string
+	for _, labels := range labelsWithMetadata(rec.received()) {
+		if _, ok := labels["batch_seq"]; ok {
+			t.Fatalf("labels = %v, want the sequence in metadata only", labels)
+		}
+		seqs = append(seqs, labels["md:batch_seq"])
+	}
+	if got := strings.Join(seqs, ","); got != "1,1,1,2" {
+		t.Fatalf("sequence per request = %s, want 1,1,1,2 (three attempts of the first batch)", got)
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	a := newULID(time.UnixMilli(0))
+	if len(a) != 26 || !strings.HasPrefix(a, "0000000000") {
+		t.Fatalf("newULID(epoch) = %q, want 26 characters starting with ten zeros", a)
+	}
+	if b := newULID(time.UnixMilli(1<<48 - 1)); !strings.HasPrefix(b, "7ZZZZZZZZZ") {
+		t.Fatalf("newULID(max) = %q, want prefix 7ZZZZZZZZZ", b)
+	}
+	if x, y := newULID(time.UnixMilli(1000)), newULID(time.UnixMilli(2000)); x >= y {
+		t.Fatalf("ULIDs not ordered by time: %q >= %q", x, y)
+	}
+}
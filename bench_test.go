@@ -1,7 +1,15 @@
 package lokigo
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -23,6 +31,53 @@ func benchmarkEntries(n int) []Entry {
 	return entries
 }
 
+// labelSetEntries returns benchmarkEntries(n) carrying precomputed label
+// sets of c, so payload builds measure only the encode pipeline.
+func labelSetEntries(c *Client, n int) []Entry {
+	var sets [8]LabelSet
+	for i := range sets {
+		sets[i] = c.NewLabelSet(map[string]string{"service": "api", "env": "bench", "stream": fmt.Sprintf("s%d", i)})
+	}
+	entries := benchmarkEntries(n)
+	for i := range entries {
+		ls := sets[i%len(sets)].p
+		entries[i].Labels, entries[i].labelSet = ls.labels, ls
+	}
+	return entries
+}
+
+// TestPayloadBuildAllocs guards the pooled encode pipeline: beyond the
+// payload itself, JSON allocates one timestamp string per entry and
+// protobuf nothing per entry.
+func TestPayloadBuildAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation counts are measured in full runs")
+	}
+	for _, tc := range []struct {
+		encoding Encoding
+		max      float64
+	}{
+		// Headroom covers sync.Pool misses, which -race makes frequent.
+		{EncodingJSON, 500 + 100},
+		{EncodingProtobufSnappy, 50},
+	} {
+		c, err := NewClient(Config{Endpoint: "http://127.0.0.1:3100/loki/api/v1/push", Encoding: tc.encoding})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := labelSetEntries(c, 500)
+		allocs := testing.AllocsPerRun(50, func() {
+			if _, _, _, err := c.buildPayload(entries); err != nil {
+				t.Fatal(err)
+			}
+		})
+		c.cancel()
+		if allocs > tc.max {
+			t.Errorf("%s: %.0f allocs per 500-entry build, want <= %.0f", tc.encoding, allocs, tc.max)
+		}
+	}
+}
+
 func BenchmarkPayloadBuildEncode_JSON_500Entries(b *testing.B) {
 	entries := benchmarkEntries(500)
 	c, err := NewClient(Config{Endpoint: "http://127.0.0.1:3100/loki/api/v1/push", Encoding: EncodingJSON})
@@ -66,3 +121,213 @@ func BenchmarkPayloadBuildEncode_ProtobufSnappy_500Entries(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkPayloadBuildEncode_JSON_500Entries_NilLabels(b *testing.B) {
+	entries := benchmarkEntries(500)
+	for i := range entries {
+		entries[i].Labels = nil
+	}
+	c, err := NewClient(Config{
+		Endpoint:     "http://127.0.0.1:3100/loki/api/v1/push",
+		Encoding:     EncodingJSON,
+		StaticLabels: map[string]string{"service": "api", "env": "bench"},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.cancel()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := c.buildPayload(entries); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPayloadBuildEncode_JSON_500Entries_LabelSet(b *testing.B) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1:3100/loki/api/v1/push", Encoding: EncodingJSON})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.cancel()
+	entries := labelSetEntries(c, 500)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := c.buildPayload(entries); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	entries := benchmarkEntries(500)
+	for _, validate := range []bool{false, true} {
+		b.Run(fmt.Sprintf("ValidateOnSend=%t", validate), func(b *testing.B) {
+			c, err := NewClient(Config{Endpoint: srv.URL, BackpressureMode: BackpressureDropNew, ValidateOnSend: validate})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close(context.Background())
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e := entries[i%len(entries)]
+				e.Timestamp = time.Time{}
+				if err := c.Send(context.Background(), e); err != nil && !errors.Is(err, ErrDropped) {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBulkImport compares per-entry Send with SendBatchOwned handing
+// over 1000 entries at a time, against a transport that accepts instantly.
+func BenchmarkBulkImport(b *testing.B) {
+	const chunk = 1000
+	accept := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: r}, nil
+	})
+	entries := benchmarkEntries(chunk)
+	for _, owned := range []bool{false, true} {
+		b.Run(fmt.Sprintf("SendBatchOwned=%t", owned), func(b *testing.B) {
+			c, err := NewClient(Config{Endpoint: "http://loki.invalid", HTTPClient: &http.Client{Transport: accept}, BatchMaxEntries: chunk, QueueSize: chunk})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if owned {
+					if err := c.SendBatchOwned(context.Background(), slices.Clone(entries)); err != nil {
+						b.Fatal(err)
+					}
+					continue
+				}
+				for _, e := range entries {
+					if err := c.Send(context.Background(), e); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			if err := c.Close(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+// BenchmarkNewEntry compares NewEntry with a hand-built Entry for the
+// common case of a few labels.
+func BenchmarkNewEntry(b *testing.B) {
+	ts := time.Unix(1700000000, 0)
+	var sink Entry
+	for labels := 0; labels <= 3; labels++ {
+		keys := []string{"service", "env", "level"}[:labels]
+		b.Run(fmt.Sprintf("labels=%d/NewEntry", labels), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				opts := [4]EntryOption{WithTime(ts)}
+				for j, k := range keys {
+					opts[j+1] = WithLabel(k, "v")
+				}
+				sink = NewEntry("line", opts[:labels+1]...)
+			}
+		})
+		b.Run(fmt.Sprintf("labels=%d/struct", labels), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				e := Entry{Timestamp: ts, Line: "line"}
+				if labels > 0 {
+					e.Labels = map[string]string{}
+					for _, k := range keys {
+						e.Labels[k] = "v"
+					}
+				}
+				sink = e
+			}
+		})
+	}
+	_ = sink
+}
+
+// BenchmarkPush10MBJSON pushes a 10MB JSON batch buffered and streamed;
+// B/op shows the memory StreamLargePayloads saves.
+func BenchmarkPush10MBJSON(b *testing.B) {
+	accept := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		r.Body.Close()
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: r}, nil
+	})
+	line := strings.Repeat("level=info msg=payload ", 44)
+	entries := make([]Entry, 10<<20/len(line))
+	for i := range entries {
+		entries[i] = Entry{Timestamp: time.Unix(1700000000, int64(i)), Line: line, Labels: map[string]string{"stream": fmt.Sprintf("s%d", i%8)}}
+	}
+	for _, stream := range []bool{false, true} {
+		b.Run(fmt.Sprintf("StreamLargePayloads=%t", stream), func(b *testing.B) {
+			c, err := NewClient(Config{Endpoint: "http://loki.invalid", HTTPClient: &http.Client{Transport: accept}, Encoding: EncodingJSON, StreamLargePayloads: stream})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close(context.Background())
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.flushBatch(context.Background(), entries); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSlogHandleAllowList handles records of 20 attrs against a
+// 200-entry allow list mixing exact keys and globs, with and without the
+// matcher memo.
+func BenchmarkSlogHandleAllowList(b *testing.B) {
+	accept := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		return &http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody, Request: r}, nil
+	})
+	allow := make([]string, 0, 200)
+	for i := range 180 {
+		allow = append(allow, fmt.Sprintf("schema.field_%03d", i))
+	}
+	for i := range 20 {
+		allow = append(allow, fmt.Sprintf("*.label_%02d_*", i))
+	}
+	attrs := make([]any, 0, 40)
+	for i := range 20 {
+		attrs = append(attrs, fmt.Sprintf("request.attr_%02d", i), i)
+	}
+	for _, memo := range []bool{false, true} {
+		b.Run(fmt.Sprintf("memo=%t", memo), func(b *testing.B) {
+			c, err := NewClient(Config{Endpoint: "http://loki.invalid", HTTPClient: &http.Client{Transport: accept}, BackpressureMode: BackpressureDropNew})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close(context.Background())
+			h := NewSlogHandler(c, WithLabelAllowList(allow...), WithSlogSendTimeout(0)).(*slogHandler)
+			if !memo {
+				h.cfg.matcher.memo.Store(nil)
+			}
+			logger := slog.New(h)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				logger.Info("request served", attrs...)
+			}
+		})
+	}
+}
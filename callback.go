@@ -0,0 +1,95 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+)
+
+// CallbackPanicError describes a panic recovered from a user callback. It
+// is passed to DebugLogger as the "error" attribute.
+type CallbackPanicError struct {
+	// Callback names the Config field, for example "OnError".
+	Callback string
+	Value    any
+	Stack    []byte
+}
+
+func (e *CallbackPanicError) Error() string {
+	return fmt.Sprintf("lokigo: %s callback panicked: %v", e.Callback, e.Value)
+}
+
+// callback runs the user callback fn named name. A panic is recovered and
+// reported instead of unwinding the worker, which would leave Send filling a
+// queue nobody drains. onWorker tells whether fn runs on the worker
+// goroutine: a Send from within such a callback does not block on a full
+// queue, since the worker could not make room; see DropCallbackReentry.
+func (c *Client) callback(name string, onWorker bool, fn func()) {
+	if onWorker {
+		c.enterCallback(goroutineID(), 1)
+	}
+	defer func() {
+		if onWorker {
+			c.enterCallback(goroutineID(), -1)
+		}
+		if v := recover(); v != nil {
+			c.callbackPanics.Add(1)
+			c.debug("callback panicked", "callback", name, "error", &CallbackPanicError{Callback: name, Value: v, Stack: debug.Stack()})
+		}
+	}()
+	fn()
+}
+
+type workerKey struct{}
+
+// withWorker marks ctx as the context of a worker flush, so callbacks run
+// by its pushes know they run on the worker goroutine.
+func withWorker(ctx context.Context) context.Context {
+	return context.WithValue(ctx, workerKey{}, true)
+}
+
+// onWorker reports whether ctx is the context of a worker flush.
+func onWorker(ctx context.Context) bool {
+	w, _ := ctx.Value(workerKey{}).(bool)
+	return w
+}
+
+// enterCallback adds delta to the worker callbacks running on goroutine id.
+func (c *Client) enterCallback(id uint64, delta int) {
+	c.callbackMu.Lock()
+	defer c.callbackMu.Unlock()
+	if c.callbackGoroutines == nil {
+		c.callbackGoroutines = make(map[uint64]int)
+	}
+	if c.callbackGoroutines[id] += delta; c.callbackGoroutines[id] == 0 {
+		delete(c.callbackGoroutines, id)
+	}
+	c.inCallback.Add(int32(delta))
+}
+
+// inWorkerCallback reports whether the calling goroutine is running a
+// worker callback. Sends made elsewhere while one runs are not affected.
+func (c *Client) inWorkerCallback() bool {
+	if c.inCallback.Load() == 0 {
+		return false
+	}
+	id := goroutineID()
+	c.callbackMu.Lock()
+	defer c.callbackMu.Unlock()
+	return c.callbackGoroutines[id] > 0
+}
+
+// goroutineID returns the id of the calling goroutine, read from the
+// "goroutine 7 [running]:" header of its stack trace.
+func goroutineID() uint64 {
+	var buf [32]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
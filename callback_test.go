@@ -0,0 +1,305 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPanickingCallbacksKeepWorkerAlive(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	var logMu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(&lockedWriter{mu: &logMu, w: &logs}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		BatchMaxEntries: 1,
+		DebugLogger:     logger,
+		OnError:         func(error) { panic("OnError") },
+		OnFlush:         func(Metrics) { panic("OnFlush") },
+		OnFlushStats:    func(FlushStats) { panic("OnFlushStats") },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"rejected", "a", "b"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = c.Close(context.Background())
+
+	m := c.Metrics()
+	if m.Pushed != 2 {
+		t.Fatalf("Pushed = %d, want 2 after panicking callbacks", m.Pushed)
+	}
+	// OnError once, OnFlush and OnFlushStats for each of the three batches.
+	if m.CallbackPanics < 7 {
+		t.Fatalf("CallbackPanics = %d, want at least 7", m.CallbackPanics)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	for _, name := range []string{"OnError", "OnFlush", "OnFlushStats"} {
+		if !strings.Contains(logs.String(), "callback="+name+" ") {
+			t.Fatalf("expected a debug log for %s, got %q", name, logs.String())
+		}
+	}
+}
+
+func TestOnErrorLoggingThroughClientDoesNotDeadlock(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		<-release
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	var logger atomic.Pointer[slog.Logger]
+	c, err := NewClient(Config{
+		Endpoint:         srv.URL,
+		Encoding:         EncodingJSON,
+		QueueSize:        1,
+		BatchMaxEntries:  1,
+		BackpressureMode: BackpressureBlock,
+		OnError:          func(err error) { logger.Load().Error("push failed", "err", err) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Store(slog.New(NewSlogHandler(c, WithSlogSendTimeout(0))))
+
+	// The first entry is in flight and the second fills the queue, so the
+	// record OnError logs finds no room.
+	for _, line := range []string{"a", "b"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.QueueLen() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("queue never filled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	unblock()
+
+	// Sends after Close are refused with ErrClosed, so wait for OnError to
+	// run before closing.
+	for c.Metrics().DroppedByReason[DropCallbackReentry] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a callback_reentry drop")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = c.Close(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close deadlocked on a Send from OnError")
+	}
+}
+
+func TestOnDeadLetterReceivesFailedBatch(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	type deadLetter struct {
+		entries []Entry
+		err     error
+	}
+	var letters []deadLetter
+	c, err := NewClient(Config{
+		Endpoint:           srv.URL,
+		BatchMaxEntries:    2,
+		BatchMaxWait:       time.Hour,
+		BatchSequenceLabel: "batch_seq",
+		Retry:              RetryConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		OnDeadLetter: func(entries []Entry, err error) {
+			letters = append(letters, deadLetter{entries, err})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a", "b"} {
+		if err := c.Send(context.Background(), Entry{Labels: map[string]string{"app": "x"}, Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = c.Close(context.Background())
+
+	if len(letters) != 1 {
+		t.Fatalf("OnDeadLetter called %d times, want 1", len(letters))
+	}
+	if got := requests.Load(); got != 3 {
+		t.Fatalf("requests = %d, want 3 before dead-lettering", got)
+	}
+	got := letters[0]
+	var statusErr *HTTPStatusPushError
+	if !errors.As(got.err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want the 503 push error", got.err)
+	}
+	if len(got.entries) != 2 || got.entries[0].Line != "a" || got.entries[1].Line != "b" {
+		t.Fatalf("entries = %+v, want a and b", got.entries)
+	}
+	if _, ok := got.entries[0].Labels["batch_seq"]; ok {
+		t.Fatalf("labels = %v, want them as sent, without batch_seq", got.entries[0].Labels)
+	}
+}
+
+func TestCallbackOffWorkerDoesNotDowngradeBlockingSend(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+	var requests atomic.Int32
+	arrived := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			close(arrived)
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// OnFlush blocks once, when armed, on the goroutine calling Push.
+	var armed atomic.Bool
+	inFlush := make(chan struct{})
+	releaseFlush := make(chan struct{})
+	c, err := NewClient(Config{
+		Endpoint:         srv.URL,
+		QueueSize:        1,
+		BatchMaxEntries:  1,
+		BackpressureMode: BackpressureBlock,
+		OnFlush: func(Metrics) {
+			if armed.CompareAndSwap(true, false) {
+				close(inFlush)
+				<-releaseFlush
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	// The first entry is in flight and the second fills the queue, so
+	// the worker is blocked outside any callback.
+	for _, line := range []string{"a", "b"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-arrived
+	armed.Store(true)
+	pushed := make(chan error, 1)
+	go func() { pushed <- c.Push(context.Background(), []Entry{{Line: "sync"}}) }()
+	<-inFlush
+
+	sent := make(chan error, 1)
+	go func() { sent <- c.Send(context.Background(), Entry{Line: "c"}) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("Send returned %v while OnFlush ran on another goroutine, want it to block", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(releaseFlush)
+	unblock()
+	if err := <-sent; err != nil {
+		t.Fatalf("Send = %v, want it to get in once the worker made room", err)
+	}
+	if err := <-pushed; err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Metrics().DroppedByReason[DropCallbackReentry]; n != 0 {
+		t.Fatalf("callback_reentry drops = %d, want 0", n)
+	}
+}
+
+func TestSendBlocksWhileWorkerCallbackRunsElsewhere(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// OnFlush blocks on the worker after the first push.
+	var once sync.Once
+	inFlush := make(chan struct{})
+	releaseFlush := make(chan struct{})
+	c, err := NewClient(Config{
+		Endpoint:         srv.URL,
+		QueueSize:        1,
+		BatchMaxEntries:  1,
+		BackpressureMode: BackpressureBlock,
+		DropNewGrace:     time.Minute,
+		OnFlush: func(Metrics) {
+			once.Do(func() {
+				close(inFlush)
+				<-releaseFlush
+			})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	if err := c.Send(context.Background(), Entry{Line: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	<-inFlush
+	// The worker is stuck in OnFlush, so this entry fills the queue.
+	if err := c.Send(context.Background(), Entry{Line: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan error, 1)
+	go func() { sent <- c.Send(context.Background(), Entry{Line: "c"}) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("Send returned %v while OnFlush ran on the worker, want it to block", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(releaseFlush)
+	if err := <-sent; err != nil {
+		t.Fatalf("Send = %v, want it to get in once the worker made room", err)
+	}
+	if n := c.Metrics().DroppedByReason[DropCallbackReentry]; n != 0 {
+		t.Fatalf("callback_reentry drops = %d, want 0", n)
+	}
+}
@@ -2,17 +2,27 @@ package lokigo
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	crand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"math/rand"
 	"net/http"
+	"runtime"
+	"runtime/debug"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
 
 	"github.com/golang/snappy"
 	"github.com/zabihimohsen/lokigo/internal/push"
@@ -20,14 +30,32 @@ import (
 
 var ErrDropped = errors.New("entry dropped due to backpressure")
 
+// ErrClosed is returned by Send, SendBatchOwned, Push, Import, Flush and
+// Sync once Close was called.
+var ErrClosed = errors.New("lokigo: client closed")
+
 type Entry struct {
 	Timestamp time.Time
 	Line      string
 	Labels    map[string]string
+	// Metadata is Loki structured metadata: key/value pairs stored with the
+	// entry that, unlike Labels, do not create streams. It needs a Loki
+	// with structured metadata enabled (2.9+, TSDB schema v13).
+	Metadata map[string]string
+	// TenantID, when non-empty, replaces the tenant the entry is pushed
+	// for, Config.TenantID or that of its Route; the endpoint is unchanged.
+	TenantID string
+
+	// labelSet carries precomputed stream labels from SendWithLabelSet.
+	labelSet *labelSet
 }
 
+// NetworkPushError is a push that failed before an HTTP response arrived.
 type NetworkPushError struct {
 	Err error
+	// Kind classifies Err, for example to tell timeouts from refused
+	// connections.
+	Kind NetworkErrorKind
 }
 
 func (e *NetworkPushError) Error() string { return e.Err.Error() }
@@ -36,48 +64,398 @@ func (e *NetworkPushError) Unwrap() error { return e.Err }
 type HTTPStatusPushError struct {
 	StatusCode int
 	Body       string
+	// RetryAfter is the delay asked for by a Retry-After response header,
+	// or zero.
+	RetryAfter time.Duration
 }
 
 func (e *HTTPStatusPushError) Error() string {
 	return fmt.Sprintf("loki push failed: %d %s", e.StatusCode, e.Body)
 }
 
+// TenantPushError is the failure of one tenant's push under TenantFanout.
+// Failures of several tenants are combined with errors.Join.
+type TenantPushError struct {
+	TenantID string
+	Err      error
+}
+
+func (e *TenantPushError) Error() string {
+	return fmt.Sprintf("tenant %q: %v", e.TenantID, e.Err)
+}
+
+func (e *TenantPushError) Unwrap() error { return e.Err }
+
 type Client struct {
-	cfg    Config
-	queue  chan Entry
-	cancel context.CancelFunc
-	wg     sync.WaitGroup
+	cfg        Config
+	httpClient *http.Client
+	limiter    *rateLimiter
+	health     *healthTracker
+	shadow     *shadowPusher
+	oauth      *oauthTokenSource
+	fallback   *fallbackSink
+	// staticLabels is a client-owned copy of Config.StaticLabels that may be
+	// shared read-only across entries.
+	staticLabels map[string]string
+	// staticEmpty is the number of empty values in staticLabels.
+	staticEmpty int
+	// emptyStream is the client-owned copy of Config.EmptyStreamLabels, or
+	// nil.
+	emptyStream map[string]string
+	routes      []compiledRoute
+	inFlight    *inFlight
+	active      *activeStreams
+	shutdown    shutdownCounters
+	rates       *rateRing
+	queue       chan queuedEntry
+	// bulk carries SendBatchOwned handoffs; bulkLen counts their entries
+	// until the worker takes them.
+	bulk    chan bulkHandoff
+	bulkLen atomic.Int64
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	// abortCtx bounds worker pushes; abort is called when the Close context
+	// is done, interrupting in-flight retries and the shutdown drain.
+	abortCtx context.Context
+	abort    context.CancelFunc
+	// closeDeadline is the deadline of the Close context in Unix
+	// nanoseconds, or 0.
+	closeDeadline atomic.Int64
+	// closeStarted is set by the first Close, which starts the shutdown;
+	// closeDone is closed once closeStats and closeErr hold its outcome.
+	closeStarted atomic.Bool
+	closeDone    chan struct{}
+	closeStats   ShutdownStats
+	closeErr     error
+	// instanceID is the ULID of InstanceID; batchSeq numbers pushed batches
+	// for BatchSequenceLabel.
+	instanceID string
+	batchSeq   atomic.Uint64
+
+	dropped      atomic.Uint64
+	pushed       atomic.Uint64
+	pushErrors   atomic.Uint64
+	retries      atomic.Uint64
+	redirects    atomic.Uint64
+	deduplicated atomic.Uint64
+	sampled      atomic.Uint64
+	skew         clockSkew
+	// rejectedByServer counts entries reported rejected by partial
+	// successes.
+	rejectedByServer atomic.Uint64
+	shadowErrors     atomic.Uint64
+	emptyLabels      atomic.Uint64
+	// sending counts Send calls between their stopped check and enqueueing.
+	sending atomic.Int64
+	// stopped is set once the shutdown drain stops accepting entries.
+	stopped atomic.Bool
+	// callbackPanics counts recovered panics of user callbacks.
+	callbackPanics atomic.Uint64
+	// workerPanics counts panics recovered from the worker, and workerDown
+	// is set once it stopped restarting.
+	workerPanics atomic.Uint64
+	workerDown   atomic.Bool
+	// flushReq takes explicit flush requests to the worker; workerDone is
+	// closed once the worker returned.
+	flushReq   chan flushRequest
+	workerDone chan struct{}
+	// truncatedLines counts lines cut by OversizeTruncate.
+	truncatedLines atomic.Uint64
+	// inCallback counts user callbacks running on the worker goroutine,
+	// and callbackGoroutines counts them per goroutine id; see
+	// DropCallbackReentry.
+	inCallback         atomic.Int32
+	callbackMu         sync.Mutex
+	callbackGoroutines map[uint64]int
+	reentryWarned      atomic.Bool
+
+	connRotatedAt atomic.Int64
+
+	// queuedBytes is the line bytes waiting in queue; batchLen and
+	// batchBytes mirror the batch being assembled by the worker.
+	queuedBytes atomic.Int64
+	batchLen    atomic.Int64
+	batchBytes  atomic.Int64
+	// queueHighWater is the largest queueDepth seen by Send since the last
+	// MetricsReset.
+	queueHighWater atomic.Int64
+	// forcedFlushes counts flushes of the StaleBatchSlack watchdog.
+	forcedFlushes atomic.Uint64
+	// fallbackErrors counts failed FallbackWriter writes.
+	fallbackErrors atomic.Uint64
+
+	droppedBy  keyedCounts[DropReason]
+	netErrorBy keyedCounts[NetworkErrorKind]
+	batchesBy  keyedCounts[Encoding]
 
-	dropped    atomic.Uint64
-	pushed     atomic.Uint64
-	pushErrors atomic.Uint64
-	retries    atomic.Uint64
+	// classes are the QueueClasses queues, highest MinLevel first; entries
+	// then bypass queue, and classWake signals the worker instead.
+	classes        []*queueClass
+	classWake      chan struct{}
+	droppedByClass keyedCounts[string]
+
+	pushedByTenant     keyedCounts[string]
+	pushErrorsByTenant keyedCounts[string]
+	// negotiated is the encoding chosen by NegotiateEncoding, nil until a
+	// probe was conclusive. negotiateMu serializes probes.
+	negotiated  atomic.Pointer[Encoding]
+	negotiateMu sync.Mutex
+	// preconnectDone is closed once PreconnectOnStart finished.
+	preconnectDone chan struct{}
+	// limitsLabels is set when label values have byte limits, and
+	// truncatedLabels counts the values cut to them by label key.
+	limitsLabels    bool
+	truncatedLabels keyedCounts[string]
+	// streamOverflows counts entries over MaxBytesPerStreamPerBatch by
+	// stream key.
+	streamOverflows keyedCounts[string]
 
 	errMu   sync.Mutex
 	lastErr error
+
+	// clock drives the worker's flush timers.
+	clock workerClock
 }
 
 func NewClient(cfg Config) (*Client, error) {
+	return newClient(cfg, systemClock{})
+}
+
+// newClient is NewClient with the worker timers on clock.
+func newClient(cfg Config, clock workerClock) (*Client, error) {
 	cfg.setDefaults()
 	if err := cfg.validate(); err != nil {
 		return nil, err
 	}
 
+	routes, err := compileRoutes(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
-	c := &Client{cfg: cfg, queue: make(chan Entry, cfg.QueueSize), cancel: cancel}
+	abortCtx, abort := context.WithCancel(context.Background())
+	c := &Client{
+		cfg:        cfg,
+		httpClient: noRedirectClient(cfg.HTTPClient),
+		limiter:    newRateLimiter(cfg.RateLimit),
+		health:     newHealthTracker(cfg.HealthRecovery),
+		routes:     routes,
+		inFlight:   newInFlight(),
+		active:     newActiveStreams(cfg.ActiveStreams),
+		rates:      newRateRing(),
+		oauth:      newOAuthTokenSource(cfg.OAuth2, cfg.HTTPClient),
+		fallback:   newFallbackSink(cfg.FallbackWriter),
+		queue:      make(chan queuedEntry, cfg.QueueSize),
+		bulk:       make(chan bulkHandoff, 1),
+		flushReq:   make(chan flushRequest),
+		workerDone: make(chan struct{}),
+		closeDone:  make(chan struct{}),
+		instanceID: newULID(time.Now()),
+		clock:      clock,
+		cancel:     cancel,
+		abortCtx:   abortCtx,
+		abort:      abort,
+	}
+	if len(cfg.QueueClasses) > 0 {
+		c.classes = newQueueClasses(cfg.QueueClasses)
+		c.classWake = make(chan struct{}, 1)
+	}
+	c.initLabels()
+	for _, w := range cfg.Warnings() {
+		c.debug("config warning", "warning", w)
+	}
+	c.connRotatedAt.Store(time.Now().UnixNano())
+	c.startShadow()
+	if cfg.PreconnectOnStart {
+		c.preconnect()
+	}
+	if cfg.Heartbeat.Interval > 0 {
+		ticker := time.NewTicker(cfg.Heartbeat.Interval)
+		c.startHeartbeat(ctx, cfg.Heartbeat, ticker.C, ticker.Stop)
+	}
+	if cfg.SelfDiagnostics.Interval > 0 {
+		ticker := time.NewTicker(cfg.SelfDiagnostics.Interval)
+		c.startSelfDiagnostics(ctx, cfg.SelfDiagnostics, ticker.C, ticker.Stop)
+	}
+	if cfg.DisableBatching {
+		return c, nil
+	}
 	c.wg.Add(1)
 	go c.run(ctx)
 	return c, nil
 }
 
+// QueueLen returns the number of entries accepted by Send and not yet handed
+// to a push: those waiting in the queue plus those in the batch being
+// assembled. It is an instantaneous approximation.
+func (c *Client) QueueLen() int {
+	return len(c.queue) + c.classedLen() + int(c.bulkLen.Load()) + int(c.batchLen.Load())
+}
+
+// QueueCap returns how many entries the queues hold before BackpressureMode
+// applies: QueueSize plus the QueueClasses sizes. It is zero with
+// DisableBatching, which has no queue.
+func (c *Client) QueueCap() int {
+	if c.cfg.DisableBatching {
+		return 0
+	}
+	n := cap(c.queue)
+	for _, qc := range c.classes {
+		n += cap(qc.ch)
+	}
+	return n
+}
+
+// queueDepth returns the entries waiting for the worker: QueueLen without
+// the batch being assembled.
+func (c *Client) queueDepth() int {
+	return len(c.queue) + c.classedLen() + int(c.bulkLen.Load())
+}
+
+// observeQueueDepth raises the queue high-water mark to the current depth.
+func (c *Client) observeQueueDepth() {
+	d := int64(c.queueDepth())
+	for {
+		hw := c.queueHighWater.Load()
+		if d <= hw || c.queueHighWater.CompareAndSwap(hw, d) {
+			return
+		}
+	}
+}
+
+// PendingBytes returns the line bytes of the entries counted by QueueLen. It
+// is an instantaneous approximation.
+func (c *Client) PendingBytes() int {
+	return int(c.queuedBytes.Load() + c.batchBytes.Load())
+}
+
+// drop counts n entries dropped for reason and reports the metrics.
+func (c *Client) drop(reason DropReason, n int) {
+	c.countDrop(reason, n)
+	c.reportFlushMetrics(false)
+}
+
+// workerDrop is drop for the worker goroutine.
+func (c *Client) workerDrop(reason DropReason, n int) {
+	c.countDrop(reason, n)
+	c.reportFlushMetrics(true)
+}
+
+func (c *Client) countDrop(reason DropReason, n int) {
+	c.dropped.Add(uint64(n))
+	c.droppedBy.add(reason, uint64(n))
+	c.rates.add(Rates{Dropped: uint64(n)})
+}
+
+// reportDrops passes each of entries to OnDrop, if set.
+func (c *Client) reportDrops(entries []Entry, reason DropReason) {
+	if c.cfg.OnDrop == nil {
+		return
+	}
+	for _, e := range entries {
+		c.callback("OnDrop", false, func() { c.cfg.OnDrop(e, reason) })
+	}
+}
+
+// dequeued accounts for e leaving the queue.
+func (c *Client) dequeued(e queuedEntry) {
+	c.queuedBytes.Add(-int64(len(e.Line)))
+}
+
+// EffectiveConfig returns the configuration the client runs with, after
+// defaults were applied, with credentials redacted as by Config.Redacted.
+func (c *Client) EffectiveConfig() Config {
+	return c.cfg.Redacted()
+}
+
+// Send enqueues e for the background worker, or pushes it at once with
+// DisableBatching. Labels and Metadata are copied first, so the caller may
+// reuse or modify its maps as soon as Send returns. Once Close was called,
+// Send returns ErrClosed; an entry accepted by a Send racing Close is
+// drained and pushed by Close like any queued entry.
 func (c *Client) Send(ctx context.Context, e Entry) error {
-	if e.Timestamp.IsZero() {
-		e.Timestamp = time.Now().UTC()
+	if e.labelSet == nil {
+		e.Labels = maps.Clone(e.Labels)
+	}
+	e.Metadata = maps.Clone(e.Metadata)
+	return c.sendOwned(ctx, e)
+}
+
+// sendOwned is Send for an entry whose maps the client may keep, such as
+// those built afresh by the slog handler.
+func (c *Client) sendOwned(ctx context.Context, e Entry) error {
+	if err := c.checkEntry(&e); err != nil {
+		return err
+	}
+	if c.sampledOut(e) {
+		return nil
+	}
+	c.countEmptyLabels(e)
+	c.countTruncatedLabels(e)
+	defer c.endSend()
+	if !c.beginSend() {
+		return c.rejectStopped(1)
+	}
+	if c.cfg.DisableBatching {
+		return c.flushBatch(ctx, []Entry{e})
+	}
+	size := int64(len(e.Line))
+	c.queuedBytes.Add(size)
+	ch, mode := c.queue, c.cfg.BackpressureMode
+	var class *queueClass
+	if c.classes != nil {
+		class = c.classFor(e)
+		ch, mode = class.ch, class.mode
+	}
+	// A callback may run on the worker, which cannot make room while it
+	// waits: no blocking and no DropNewGrace there.
+	inCallback := c.inWorkerCallback()
+	reentrant := mode == BackpressureBlock && inCallback
+	if reentrant {
+		mode = BackpressureDropNew
+	}
+	grace := c.cfg.DropNewGrace
+	if inCallback {
+		grace = 0
+	}
+	onDropOldest := c.dequeued
+	var evicted []Entry
+	if c.cfg.OnDrop != nil {
+		onDropOldest = func(old queuedEntry) {
+			c.dequeued(old)
+			evicted = append(evicted, old.Entry)
+		}
+	}
+	dropped, err := enqueueWithMode(ctx, ch, queuedEntry{Entry: e, enqueued: time.Now()}, mode, grace, onDropOldest)
+	if err != nil {
+		c.queuedBytes.Add(-size)
+	} else {
+		c.observeQueueDepth()
+		if class != nil {
+			c.wakeWorker()
+		}
 	}
-	dropped, err := enqueueWithMode(ctx, c.queue, e, c.cfg.BackpressureMode)
 	if dropped > 0 {
-		c.dropped.Add(uint64(dropped))
-		c.reportFlushMetrics()
+		reason := DropQueueEvicted
+		switch {
+		case reentrant:
+			reason = DropCallbackReentry
+			if c.reentryWarned.CompareAndSwap(false, true) {
+				c.debug("dropping entries sent from a callback while the queue is full; a callback logging through this client would otherwise deadlock")
+			}
+		case mode == BackpressureDropNew:
+			reason = DropQueueFull
+		}
+		c.drop(reason, dropped)
+		if class != nil {
+			c.droppedByClass.add(class.name, uint64(dropped))
+		}
+		if reason == DropQueueEvicted {
+			c.reportDrops(evicted, reason)
+		} else {
+			c.reportDrops([]Entry{e}, reason)
+		}
 	}
 	if err != nil {
 		if errors.Is(err, errDroppedInternal) {
@@ -88,239 +466,1059 @@ func (c *Client) Send(ctx context.Context, e Entry) error {
 	return nil
 }
 
-func (c *Client) Close(ctx context.Context) error {
-	c.cancel()
-	done := make(chan struct{})
-	go func() {
-		c.wg.Wait()
-		close(done)
-	}()
-	select {
-	case <-done:
-	case <-ctx.Done():
-		return ctx.Err()
+// checkEntry defaults the timestamp of e and applies the checks Send makes
+// before enqueueing.
+func (c *Client) checkEntry(e *Entry) error {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = c.now()
 	}
-	c.errMu.Lock()
-	defer c.errMu.Unlock()
-	return c.lastErr
+	if err := c.checkReservedLabels(*e); err != nil {
+		return err
+	}
+	if c.cfg.ValidateOnSend {
+		if err := c.validateEntry(*e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the client, draining queued entries and waiting for pushes
+// in flight. See CloseWithStats.
+func (c *Client) Close(ctx context.Context) error {
+	_, err := c.CloseWithStats(ctx)
+	return err
 }
 
 const (
-	// If a temporary spike causes the batch backing array to grow far beyond the
-	// normal target, shrink it after flush so long-lived clients don't retain
-	// oversized memory indefinitely.
-	batchReuseShrinkFactor = 4
+	// maxErrorBodyHardCap bounds error body capture when MaxErrorBodyBytes
+	// asks for unlimited capture.
+	maxErrorBodyHardCap = 1 << 20
+
+	// maxWarningBytes bounds FlushStats.Warning.
+	maxWarningBytes = 1 << 10
+
+	// maxAcceptedBodyBytes bounds the capture of accepted push response
+	// bodies, parsed for partial success reports.
+	maxAcceptedBodyBytes = 64 << 10
+
+	// releaseEveryFlushes is how often the worker rebuilds its per-stream
+	// maps, which keep their size after being cleared.
+	releaseEveryFlushes = 64
 )
 
-func (c *Client) run(ctx context.Context) {
-	defer c.wg.Done()
-	ticker := time.NewTicker(c.cfg.BatchMaxWait)
-	defer ticker.Stop()
+// runWorker runs the worker loop until it returns for Close, or until it
+// panics, which is recovered and returned along with the entries it held.
+func (c *Client) runWorker(ctx context.Context) (panicked *WorkerPanicError) {
+	// Pushes run under pushCtx, which outlives ctx so that Close can drain,
+	// and is canceled once the Close context is done.
+	pushCtx := c.abortCtx
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	flushTimer := c.clock.NewTimer(firstFlushDelay(c.cfg, rng))
+	defer flushTimer.Stop()
 
 	baselineCap := c.cfg.BatchMaxEntries
 	batch := make([]Entry, 0, baselineCap)
+	// enqueued holds when Send accepted each entry of batch.
+	enqueued := make([]time.Time, 0, baselineCap)
 	batchBytes := 0
+	latencySampled := 0
+	batcher := c.cfg.Batcher
+	if batcher == nil {
+		batcher = c.newDefaultBatcher()
+	}
+
+	// ageTimer bounds how long the oldest entry of the current batch may wait.
+	// It is armed when an entry enters an empty batch; ageC is nil otherwise.
+	ageTimer := c.clock.NewTimer(time.Hour)
+	ageTimer.Stop()
+	defer ageTimer.Stop()
+	var ageC <-chan time.Time
+	// batchStart is when the oldest entry of the current batch joined it,
+	// for the StaleBatchSlack watchdog.
+	var batchStart time.Time
+	staleAfter := c.cfg.BatchMaxWait
+	if c.cfg.MaxBatchAge > 0 {
+		staleAfter = c.cfg.MaxBatchAge
+	}
+	staleAfter += c.cfg.StaleBatchSlack
+
+	// budget enforces MaxBytesPerStreamPerBatch; nil when it is unset.
+	budget := newStreamBudget(c.cfg.MaxBytesPerStreamPerBatch)
+	var add func(flushCtx context.Context, e queuedEntry)
+	var respilling bool
+	// collected gathers flush errors while an explicit flush runs.
+	var collected *[]error
+	flushes := 0
+	// lastFlush is how long the last flush took, to tell whether another
+	// one fits before the Close deadline.
+	var lastFlush time.Duration
 
 	flush := func(flushCtx context.Context) {
 		if len(batch) == 0 {
 			return
 		}
-		if err := c.pushWithRetry(flushCtx, batch); err != nil {
-			c.setErr(err)
+		ageTimer.Stop()
+		ageC = nil
+		c.batchLen.Store(int64(budget.heldLen()))
+		c.batchBytes.Store(int64(budget.heldSize()))
+		now := time.Now()
+		if key := c.cfg.QueueLatencyMetadata; key != "" {
+			for i := range batch {
+				if latencySampled++; latencySampled >= c.cfg.QueueLatencySampleEvery {
+					latencySampled = 0
+					batch[i] = withLatencyMetadata(batch[i], key, now.Sub(enqueued[i]))
+				}
+			}
+		}
+		flushCtx, done := c.flushContext(flushCtx)
+		if err := c.flushBatch(withWorker(withQueueLatency(flushCtx, queueLatency(now, enqueued))), batch); err != nil {
+			c.setErr(err, true)
+			if collected != nil {
+				*collected = append(*collected, err)
+			}
 		}
-		if cap(batch) > baselineCap*batchReuseShrinkFactor {
+		done()
+		lastFlush = time.Since(now)
+		batcher.Reset()
+		// Zero the flushed entries so reused capacity does not keep their
+		// lines and label maps alive until overwritten.
+		clear(batch)
+		// If a spike grew the backing arrays far beyond the normal target,
+		// shrink them so long-lived clients don't retain oversized memory
+		// indefinitely.
+		policy := c.cfg.MemoryReleasePolicy
+		if cap(batch) > baselineCap*policy.ShrinkFactor || cap(batch)*int(unsafe.Sizeof(Entry{})) > policy.MaxRetainedBytes {
 			batch = make([]Entry, 0, baselineCap)
+			enqueued = make([]time.Time, 0, baselineCap)
 		} else {
 			batch = batch[:0]
+			enqueued = enqueued[:0]
 		}
 		batchBytes = 0
+		if flushes++; flushes%releaseEveryFlushes == 0 {
+			if b, ok := batcher.(*defaultBatcher); ok {
+				b.streams = nil
+			}
+			budget.release()
+		}
+		if budget != nil {
+			// Entries spilled by MaxBytesPerStreamPerBatch open the next
+			// batch. They were counted in streamOverflows when first held.
+			prev := respilling
+			respilling = true
+			for _, e := range budget.next() {
+				add(flushCtx, e)
+			}
+			respilling = prev
+		}
 	}
 
+	add = func(flushCtx context.Context, e queuedEntry) {
+		lineSize := len(e.Line)
+		alone := false
+		if lineSize > c.cfg.BatchMaxBytes {
+			switch c.cfg.OversizeEntryPolicy {
+			case OversizeDrop:
+				c.workerDrop(DropOversize, 1)
+				return
+			case OversizeTruncate:
+				e.Line = truncateLabelValue(e.Line, c.cfg.BatchMaxBytes)
+				lineSize = len(e.Line)
+				c.truncatedLines.Add(1)
+			default:
+				alone = true
+			}
+		}
+		if len(batch) >= c.cfg.BatchMaxEntries || (batchBytes+lineSize) > c.cfg.BatchMaxBytes {
+			flush(flushCtx)
+		}
+		if budget != nil {
+			if key := c.streamKey(e.Entry); !budget.admit(key, lineSize) {
+				if !respilling {
+					c.streamOverflows.add(key, 1)
+				}
+				if c.cfg.StreamOverflowPolicy == StreamOverflowDrop {
+					c.workerDrop(DropStreamOverflow, 1)
+					return
+				}
+				budget.hold(key, e)
+				c.batchLen.Store(int64(len(batch) + budget.heldLen()))
+				c.batchBytes.Store(int64(batchBytes + budget.heldSize()))
+				// Bound what is held back: flushing admits at least one
+				// held entry of every stream into the next batch.
+				if budget.heldLen() >= c.cfg.QueueSize {
+					flush(flushCtx)
+				}
+				return
+			}
+		}
+		if len(batch) == 0 {
+			if c.cfg.StaleBatchSlack > 0 {
+				batchStart = c.clock.Now()
+			}
+			if c.cfg.MaxBatchAge > 0 {
+				ageTimer.Reset(c.cfg.MaxBatchAge)
+				ageC = ageTimer.C()
+			}
+		}
+		batch = append(batch, e.Entry)
+		enqueued = append(enqueued, e.enqueued)
+		batchBytes += lineSize
+		c.batchLen.Store(int64(len(batch) + budget.heldLen()))
+		c.batchBytes.Store(int64(batchBytes + budget.heldSize()))
+		if batcher.Add(e.Entry) || alone {
+			flush(flushCtx)
+		}
+	}
+
+	dedupe := newDeduper(c.cfg.DedupeWindow)
+	var pending []Entry
+	// Entries released by the deduper count their queue latency from the
+	// Send that released them.
+	ingest := func(flushCtx context.Context, e queuedEntry) {
+		if dedupe == nil {
+			add(flushCtx, e)
+			return
+		}
+		var folded bool
+		pending, folded = dedupe.offer(toLokiLabelSet(c.mergedLabels(e.Entry)), e.Entry, pending[:0])
+		if folded {
+			c.deduplicated.Add(1)
+		}
+		for _, p := range pending {
+			add(flushCtx, queuedEntry{Entry: p, enqueued: e.enqueued})
+		}
+	}
+
+	ingestBulk := func(flushCtx context.Context, h bulkHandoff) {
+		c.bulkTaken(h)
+		for _, e := range h.entries {
+			ingest(flushCtx, queuedEntry{Entry: e, enqueued: h.enqueued})
+		}
+	}
+
+	// flushNow serves an explicit flush: it takes what is queued at that
+	// point, releases entries held by the deduper and pushes everything,
+	// returning the push errors.
+	flushNow := func(reqCtx context.Context) error {
+		ctx, cancel := context.WithCancel(reqCtx)
+		defer cancel()
+		defer context.AfterFunc(pushCtx, cancel)()
+		var errs []error
+		collected = &errs
+		defer func() { collected = nil }()
+		// Bounded by what is queued now, so a steady stream of Sends
+		// cannot keep the flush going.
+	take:
+		for n := len(c.queue) + c.classedLen() + cap(c.bulk); n > 0; n-- {
+			if e, ok := c.nextClassed(); ok {
+				c.dequeued(e)
+				ingest(ctx, e)
+				continue
+			}
+			select {
+			case e := <-c.queue:
+				c.dequeued(e)
+				ingest(ctx, e)
+			case h := <-c.bulk:
+				ingestBulk(ctx, h)
+			default:
+				break take
+			}
+		}
+		if dedupe != nil {
+			now := time.Now()
+			for _, p := range dedupe.drain(pending[:0]) {
+				add(ctx, queuedEntry{Entry: p, enqueued: now})
+			}
+		}
+		// Held back entries may need more than one batch.
+		for len(batch) > 0 {
+			flush(ctx)
+		}
+		return errors.Join(errs...)
+	}
+
+	// A panic drops what the loop holds; run decides whether to restart.
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		panicked = &WorkerPanicError{Value: v, Stack: debug.Stack(), Lost: len(batch) + budget.heldLen()}
+		if dedupe != nil {
+			panicked.Lost += len(dedupe.drain(pending[:0]))
+		}
+		batcher.Reset()
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
-			// Drain any buffered entries that were accepted before shutdown.
+			// Drain any buffered entries that were accepted before shutdown,
+			// up to ShutdownMaxDrainEntries. Once the Close deadline is
+			// nearer than the last push took, the rest is discarded.
+			drained := 0
+			discard := func() {
+				c.stopped.Store(true)
+				n := len(batch) + budget.heldLen() + c.discardQueued()
+				if dedupe != nil {
+					n += len(dedupe.drain(pending[:0]))
+				}
+				c.batchLen.Store(0)
+				c.batchBytes.Store(0)
+				c.discardOnShutdown(n)
+			}
+		drain:
 			for {
+				if c.drainTimeUp(pushCtx, lastFlush) {
+					discard()
+					return
+				}
+				if limit := c.cfg.ShutdownMaxDrainEntries; limit > 0 && drained >= limit {
+					c.stopped.Store(true)
+					c.discardOnShutdown(c.discardQueued())
+					break
+				}
+				if e, ok := c.nextClassed(); ok {
+					c.dequeued(e)
+					drained++
+					ingest(pushCtx, e)
+					continue
+				}
 				select {
 				case e := <-c.queue:
-					lineSize := len(e.Line)
-					if len(batch) >= c.cfg.BatchMaxEntries || (batchBytes+lineSize) > c.cfg.BatchMaxBytes {
-						flush(context.Background())
-					}
-					batch = append(batch, e)
-					batchBytes += lineSize
-					if len(batch) >= c.cfg.BatchMaxEntries {
-						flush(context.Background())
-					}
+					c.dequeued(e)
+					drained++
+					ingest(pushCtx, e)
+				case h := <-c.bulk:
+					drained += len(h.entries)
+					ingestBulk(pushCtx, h)
 				default:
-					flush(context.Background())
+					// Stop accepting entries, then wait for Sends that
+					// got in before.
+					c.stopped.Store(true)
+					if c.sending.Load() > 0 {
+						runtime.Gosched()
+						continue
+					}
+					break drain
+				}
+			}
+			if dedupe != nil {
+				now := time.Now()
+				for _, p := range dedupe.drain(pending[:0]) {
+					add(pushCtx, queuedEntry{Entry: p, enqueued: now})
+				}
+			}
+			// Held back entries may need more than one batch.
+			for len(batch) > 0 {
+				if c.drainTimeUp(pushCtx, lastFlush) {
+					discard()
 					return
 				}
+				flush(pushCtx)
+			}
+			return
+		case <-flushTimer.C():
+			// Re-arm before flushing so the interval is measured tick to tick,
+			// like a ticker, regardless of how long the push takes.
+			flushTimer.Reset(flushInterval(c.cfg, rng))
+			if dedupe != nil {
+				now := time.Now()
+				pending = dedupe.expire(now, pending[:0])
+				for _, p := range pending {
+					add(pushCtx, queuedEntry{Entry: p, enqueued: now})
+				}
+			}
+			if batcher.OnTick() {
+				flush(pushCtx)
 			}
-		case <-ticker.C:
-			flush(context.Background())
+		case <-ageC:
+			flush(pushCtx)
+		case req := <-c.flushReq:
+			req.done <- flushNow(req.ctx)
 		case e := <-c.queue:
-			lineSize := len(e.Line)
-			if len(batch) >= c.cfg.BatchMaxEntries || (batchBytes+lineSize) > c.cfg.BatchMaxBytes {
-				flush(context.Background())
+			c.dequeued(e)
+			ingest(pushCtx, e)
+		case h := <-c.bulk:
+			ingestBulk(pushCtx, h)
+		case <-c.classWake:
+			// Take at most a batch worth per wake-up so timers and Close
+			// are still served under a constant stream of entries.
+			for range c.cfg.BatchMaxEntries {
+				e, ok := c.nextClassed()
+				if !ok {
+					break
+				}
+				c.dequeued(e)
+				ingest(pushCtx, e)
 			}
-			batch = append(batch, e)
-			batchBytes += lineSize
-			if len(batch) >= c.cfg.BatchMaxEntries {
-				flush(context.Background())
+			if c.classedLen() > 0 {
+				c.wakeWorker()
 			}
 		}
+		if c.cfg.StaleBatchSlack > 0 && len(batch) > 0 && c.clock.Now().Sub(batchStart) > staleAfter {
+			c.forcedFlushes.Add(1)
+			flush(pushCtx)
+		}
 	}
 }
 
-func (c *Client) pushWithRetry(ctx context.Context, entries []Entry) error {
-	payload, contentType, contentEncoding, err := c.buildPayload(entries)
-	if err != nil {
-		return err
+// flushContext returns the context of a worker flush: Config.FlushContext,
+// when set, additionally canceled along with pushCtx.
+func (c *Client) flushContext(pushCtx context.Context) (context.Context, context.CancelFunc) {
+	if c.cfg.FlushContext == nil {
+		return pushCtx, func() {}
 	}
-	return doRetry(ctx, c.cfg.Retry, func(attempt int) error {
-		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Endpoint, bytes.NewReader(payload))
-		if err != nil {
-			c.pushErrors.Add(uint64(len(entries)))
-			if attempt > 0 {
-				c.retries.Add(1)
+	base := c.cfg.FlushContext()
+	if base == nil {
+		base = context.Background()
+	}
+	ctx, cancel := context.WithCancel(base)
+	stop := context.AfterFunc(pushCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func (c *Client) pushWithRetry(ctx context.Context, target pushTarget, entries []Entry) error {
+	original := entries
+	entries = c.tagBatch(entries)
+	c.inFlight.add(len(entries))
+	defer c.inFlight.done(len(entries))
+	start := time.Now()
+	enc := c.batchEncoding(ctx, target, entries)
+	p, err := c.encodePayload(enc, entries)
+	c.reportCardinalityAlert(onWorker(ctx))
+	if err == nil {
+		c.batchesBy.add(enc, 1)
+		c.mirrorToShadow(p)
+		var rejected []*PartialSuccess
+		rejected, err = c.pushFanout(ctx, target, entries, p, start)
+		if next, ok := c.renegotiate(ctx, target, enc, err); ok {
+			// The endpoint stopped accepting enc; push again in the newly
+			// negotiated encoding rather than losing the batch.
+			p, err = c.encodePayload(next, entries)
+			c.reportCardinalityAlert(onWorker(ctx))
+			if err == nil {
+				c.batchesBy.add(next, 1)
+				start = time.Now()
+				rejected, err = c.pushFanout(ctx, target, entries, p, start)
 			}
-			c.reportFlushMetrics()
-			return err
 		}
-		req.Header.Set("Content-Type", contentType)
-		if contentEncoding != "" {
-			req.Header.Set("Content-Encoding", contentEncoding)
+		c.deadLetterRejected(onWorker(ctx), original, entries, rejected)
+	} else {
+		c.reportFlushStats(onWorker(ctx), FlushStats{Endpoint: target.endpoint, TenantID: target.tenantID, Entries: len(entries), Bytes: lineBytes(entries), Duration: time.Since(start), Err: err})
+	}
+	c.shutdown.record(len(entries), err, ctx.Err() != nil)
+	if err != nil {
+		c.writeFallback(original)
+		if c.cfg.OnDeadLetter != nil {
+			c.deadLetter(onWorker(ctx), original, err)
 		}
-		for k, v := range c.cfg.Headers {
-			req.Header.Set(k, v)
+	}
+	return err
+}
+
+// pushFanout pushes p to target or, when TenantFanout applies to target,
+// once per fanout tenant. Tenants are retried and fail independently. It
+// also returns the partial successes of accepted pushes.
+func (c *Client) pushFanout(ctx context.Context, target pushTarget, entries []Entry, p encodedPayload, start time.Time) ([]*PartialSuccess, error) {
+	if len(c.cfg.TenantFanout) == 0 || target.tenantID != c.cfg.TenantID {
+		ps, err := c.push(ctx, target, entries, p, start)
+		if ps != nil {
+			return []*PartialSuccess{ps}, err
+		}
+		return nil, err
+	}
+	var rejected []*PartialSuccess
+	var errs []error
+	for i, tenant := range c.cfg.TenantFanout {
+		if i > 0 {
+			start = time.Now()
 		}
-		if c.cfg.TenantID != "" {
-			req.Header.Set("X-Scope-OrgID", c.cfg.TenantID)
+		t := target
+		t.tenantID = tenant
+		ps, err := c.push(ctx, t, entries, p, start)
+		if err != nil {
+			errs = append(errs, &TenantPushError{TenantID: tenant, Err: err})
+		}
+		if ps != nil {
+			rejected = append(rejected, ps)
 		}
-		resp, err := c.cfg.HTTPClient.Do(req)
+	}
+	return rejected, errors.Join(errs...)
+}
+
+// push sends an encoded batch to target with retries and reports its
+// FlushStats. It returns the partial success of an accepted push, if any.
+func (c *Client) push(ctx context.Context, target pushTarget, entries []Entry, p encodedPayload, start time.Time) (*PartialSuccess, error) {
+	stats := FlushStats{Endpoint: target.endpoint, TenantID: target.tenantID, Entries: len(entries), Bytes: lineBytes(entries), QueueLatency: queueLatencyFrom(ctx)}
+	if c.cfg.DetailedFlushStats {
+		stats.Streams = c.streamStats(entries)
+	}
+	var err error
+	stats.RateLimitWait, err = c.limiter.wait(ctx, stats.Entries, stats.Bytes)
+	if err == nil {
+		err = c.send(ctx, target, p, &stats)
+	}
+	stats.Duration = time.Since(start)
+	stats.Err = err
+	if stats.PartialSuccess != nil {
+		c.rejectedByServer.Add(uint64(stats.PartialSuccess.Rejected))
+	}
+	c.health.record(err)
+	c.reportFlushStats(onWorker(ctx), stats)
+	return stats.PartialSuccess, err
+}
+
+// send runs the push attempts of p through PushInterceptors and the retry
+// loop.
+func (c *Client) send(ctx context.Context, target pushTarget, p encodedPayload, stats *FlushStats) error {
+	if c.cfg.IdempotencyKeyHeader != "" {
+		stats.IdempotencyKey = newIdempotencyKey()
+	}
+	header, err := buildHeaders(c.cfg, batchHeaders{encoder: p.header, tenantID: target.tenantID, idempotencyKey: stats.IdempotencyKey})
+	if err != nil {
+		return err
+	}
+	info := &PushRequestInfo{
+		Endpoint: target.endpoint,
+		TenantID: target.tenantID,
+		Header:   header,
+		Payload:  p.payload,
+		stream:   p.stream,
+		Entries:  stats.Entries,
+	}
+	send := PushFunc(c.pushOnce)
+	if !c.cfg.PushInterceptorsOutsideRetry {
+		send = c.intercept(send)
+	}
+	retrying := PushFunc(func(ctx context.Context, info *PushRequestInfo) error {
+		return c.retryPush(ctx, info, send, stats)
+	})
+	if c.cfg.PushInterceptorsOutsideRetry {
+		retrying = c.intercept(retrying)
+	}
+	return retrying(withPushStats(ctx, stats), info)
+}
+
+// newIdempotencyKey returns 128 random bits in hex. Unlike a payload hash,
+// it tells apart identical batches, such as those of TenantFanout.
+func newIdempotencyKey() string {
+	var b [16]byte
+	_, _ = crand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// retryPush runs send under the retry policy, keeping the per-attempt
+// counters.
+func (c *Client) retryPush(ctx context.Context, info *PushRequestInfo, send PushFunc, stats *FlushStats) error {
+	entries := info.Entries
+	return doRetry(ctx, c.cfg.Retry, func(attempt int) error {
+		stats.Attempts++
+		c.rotateConnections()
+		// Each attempt gets its own copy, so interceptor changes do not
+		// accumulate across retries.
+		req := *info
+		req.Header = info.Header.Clone()
+		req.Attempt = attempt
+		err := send(ctx, &req)
+		var delta Rates
 		if err != nil {
-			c.pushErrors.Add(uint64(len(entries)))
-			if attempt > 0 {
-				c.retries.Add(1)
+			delta.PushErrors = uint64(entries)
+			c.pushErrors.Add(delta.PushErrors)
+			if info.TenantID != "" {
+				c.pushErrorsByTenant.add(info.TenantID, delta.PushErrors)
 			}
-			c.reportFlushMetrics()
-			return &NetworkPushError{Err: err}
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode/100 != 2 {
-			b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-			c.pushErrors.Add(uint64(len(entries)))
-			if attempt > 0 {
-				c.retries.Add(1)
+			var netErr *NetworkPushError
+			if errors.As(err, &netErr) {
+				c.netErrorBy.add(netErr.Kind, 1)
+			}
+		} else {
+			delta.Pushed = uint64(entries)
+			c.pushed.Add(delta.Pushed)
+			if info.TenantID != "" {
+				c.pushedByTenant.add(info.TenantID, delta.Pushed)
 			}
-			c.reportFlushMetrics()
-			return &HTTPStatusPushError{StatusCode: resp.StatusCode, Body: string(b)}
 		}
-		c.pushed.Add(uint64(len(entries)))
 		if attempt > 0 {
+			delta.Retries = 1
 			c.retries.Add(1)
 		}
-		c.reportFlushMetrics()
-		return nil
+		c.rates.add(delta)
+		c.reportFlushMetrics(onWorker(ctx))
+		return err
 	})
 }
 
-func (c *Client) reportFlushMetrics() {
+// pushOnce performs a single push attempt, following at most one redirect.
+func (c *Client) pushOnce(ctx context.Context, info *PushRequestInfo) error {
+	req, err := newPushRequest(ctx, info)
+	if err != nil {
+		return err
+	}
+	req.Header = info.Header.Clone()
+	if c.oauth != nil {
+		token, err := c.oauth.Token(ctx)
+		if err != nil {
+			req.Body.Close()
+			return newNetworkPushError(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return newNetworkPushError(err)
+	}
+	c.skew.observe(resp, time.Now())
+	if resp.StatusCode == http.StatusUnauthorized && c.oauth != nil {
+		c.oauth.invalidate()
+	}
+	if c.shouldFollowRedirect(resp.StatusCode) {
+		resp, err = c.followRedirect(ctx, req, resp)
+		if err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &HTTPStatusPushError{StatusCode: resp.StatusCode, Body: c.readErrorBody(resp), RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	// Loki and gateways report some warnings in the body of an accepted
+	// push. It does not change the outcome, but a body reporting rejected
+	// entries in a known format makes the push a partial success.
+	if body := readAcceptedBody(resp); body != "" {
+		warning := body[:min(len(body), maxWarningBytes)]
+		setPushWarning(ctx, warning)
+		c.debug("push accepted with a warning", "endpoint", info.Endpoint, "warning", warning)
+		if ps := parsePartialSuccess(body); ps != nil {
+			setPartialSuccess(ctx, ps)
+		}
+	}
+	return nil
+}
+
+// newPushRequest returns the POST request of info. A streamed payload is
+// encoded into the body as the transport reads it, and again by GetBody.
+func newPushRequest(ctx context.Context, info *PushRequestInfo) (*http.Request, error) {
+	if info.stream == nil {
+		return http.NewRequestWithContext(ctx, http.MethodPost, info.Endpoint, bytes.NewReader(info.Payload))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, info.Endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = streamBody(info.stream)
+	req.GetBody = func() (io.ReadCloser, error) { return streamBody(info.stream), nil }
+	req.ContentLength = -1
+	return req, nil
+}
+
+// readAcceptedBody captures up to maxAcceptedBodyBytes of an accepted
+// response, trimmed of surrounding space, without reading bodies known to
+// be empty.
+func readAcceptedBody(resp *http.Response) string {
+	if resp.ContentLength == 0 || resp.Body == http.NoBody {
+		return ""
+	}
+	return strings.TrimSpace(readBody(resp, maxAcceptedBodyBytes))
+}
+
+type pushStatsKey struct{}
+
+// withPushStats makes a successful push attempt under ctx record what its
+// response body reports in stats.
+func withPushStats(ctx context.Context, stats *FlushStats) context.Context {
+	return context.WithValue(ctx, pushStatsKey{}, stats)
+}
+
+func setPushWarning(ctx context.Context, body string) {
+	if s, ok := ctx.Value(pushStatsKey{}).(*FlushStats); ok {
+		s.Warning = body
+	}
+}
+
+func setPartialSuccess(ctx context.Context, ps *PartialSuccess) {
+	if s, ok := ctx.Value(pushStatsKey{}).(*FlushStats); ok {
+		s.PartialSuccess = ps
+	}
+}
+
+// readErrorBody captures up to MaxErrorBodyBytes of a failed response,
+// decompressing gzip bodies the transport did not already decode.
+func (c *Client) readErrorBody(resp *http.Response) string {
+	limit := int64(c.cfg.MaxErrorBodyBytes)
+	if limit < 0 {
+		limit = maxErrorBodyHardCap
+	}
+	return readBody(resp, limit)
+}
+
+// readBody reads up to limit bytes of the response body, decompressing it
+// when it is gzip-encoded.
+func readBody(resp *http.Response, limit int64) string {
+	var r io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(io.LimitReader(resp.Body, maxErrorBodyHardCap))
+		if err == nil {
+			defer zr.Close()
+			r = zr
+		}
+	}
+	b, _ := io.ReadAll(io.LimitReader(r, limit))
+	return string(b)
+}
+
+// Metrics returns a snapshot of the client's counters.
+func (c *Client) Metrics() Metrics {
+	return Metrics{
+		Dropped:              c.dropped.Load(),
+		DroppedByReason:      c.droppedBy.snapshot(false),
+		DroppedByClass:       c.droppedByClass.snapshot(false),
+		NetworkErrorsByKind:  c.netErrorBy.snapshot(false),
+		PushedByTenant:       c.pushedByTenant.snapshot(false),
+		PushErrorsByTenant:   c.pushErrorsByTenant.snapshot(false),
+		Pushed:               c.pushed.Load(),
+		PushErrors:           c.pushErrors.Load(),
+		Retries:              c.retries.Load(),
+		Redirects:            c.redirects.Load(),
+		Deduplicated:         c.deduplicated.Load(),
+		Sampled:              c.sampled.Load(),
+		ClockSkew:            c.skew.get(),
+		RejectedByServer:     c.rejectedByServer.Load(),
+		ShadowErrors:         c.shadowErrors.Load(),
+		EmptyLabelsDropped:   c.emptyLabels.Load(),
+		CallbackPanics:       c.callbackPanics.Load(),
+		StreamOverflows:      topStreams(c.streamOverflows.snapshot(false), maxStreamOverflows),
+		TruncatedLabelValues: c.truncatedLabels.snapshot(false),
+		TruncatedLines:       c.truncatedLines.Load(),
+		WorkerPanics:         c.workerPanics.Load(),
+		BatchesByEncoding:    c.batchesBy.snapshot(false),
+		InFlight:             c.inFlight.count(),
+		ActiveStreams:        c.active.count(),
+		QueueHighWater:       int(c.queueHighWater.Load()),
+		ForcedFlushes:        c.forcedFlushes.Load(),
+		FallbackErrors:       c.fallbackErrors.Load(),
+	}
+}
+
+// MetricsReset returns a snapshot of the counters and zeroes them. Each
+// counter is swapped atomically, so increments racing with the reset are
+// counted either in the returned snapshot or after it, never lost. The
+// InFlight, ActiveStreams and ClockSkew gauges and Rates are not affected.
+func (c *Client) MetricsReset() Metrics {
+	return Metrics{
+		Dropped:              c.dropped.Swap(0),
+		DroppedByReason:      c.droppedBy.snapshot(true),
+		DroppedByClass:       c.droppedByClass.snapshot(true),
+		NetworkErrorsByKind:  c.netErrorBy.snapshot(true),
+		PushedByTenant:       c.pushedByTenant.snapshot(true),
+		PushErrorsByTenant:   c.pushErrorsByTenant.snapshot(true),
+		Pushed:               c.pushed.Swap(0),
+		PushErrors:           c.pushErrors.Swap(0),
+		Retries:              c.retries.Swap(0),
+		Redirects:            c.redirects.Swap(0),
+		Deduplicated:         c.deduplicated.Swap(0),
+		Sampled:              c.sampled.Swap(0),
+		ClockSkew:            c.skew.get(),
+		RejectedByServer:     c.rejectedByServer.Swap(0),
+		ShadowErrors:         c.shadowErrors.Swap(0),
+		EmptyLabelsDropped:   c.emptyLabels.Swap(0),
+		CallbackPanics:       c.callbackPanics.Swap(0),
+		StreamOverflows:      topStreams(c.streamOverflows.snapshot(true), maxStreamOverflows),
+		TruncatedLabelValues: c.truncatedLabels.snapshot(true),
+		TruncatedLines:       c.truncatedLines.Swap(0),
+		WorkerPanics:         c.workerPanics.Swap(0),
+		BatchesByEncoding:    c.batchesBy.snapshot(true),
+		InFlight:             c.inFlight.count(),
+		ActiveStreams:        c.active.count(),
+		QueueHighWater:       int(c.queueHighWater.Swap(0)),
+		ForcedFlushes:        c.forcedFlushes.Swap(0),
+		FallbackErrors:       c.fallbackErrors.Swap(0),
+	}
+}
+
+// Rates returns the Dropped, Pushed, PushErrors and Retries increments of
+// the last window, tracked in one-second buckets for up to five minutes.
+func (c *Client) Rates(window time.Duration) Rates {
+	return c.rates.sum(window)
+}
+
+// reportFlushMetrics calls OnFlush, if set. onWorker tells whether it runs
+// on the worker goroutine.
+func (c *Client) reportFlushMetrics(onWorker bool) {
 	if c.cfg.OnFlush == nil {
 		return
 	}
-	c.cfg.OnFlush(Metrics{
-		Dropped:    c.dropped.Load(),
-		Pushed:     c.pushed.Load(),
-		PushErrors: c.pushErrors.Load(),
-		Retries:    c.retries.Load(),
-	})
+	c.callback("OnFlush", onWorker, func() { c.cfg.OnFlush(c.Metrics()) })
+}
+
+// deadLetter passes a copy of the failed batch entries to OnDeadLetter.
+func (c *Client) deadLetter(onWorker bool, entries []Entry, err error) {
+	entries = slices.Clone(entries)
+	c.callback("OnDeadLetter", onWorker, func() { c.cfg.OnDeadLetter(entries, err) })
+}
+
+func (c *Client) reportFlushStats(onWorker bool, stats FlushStats) {
+	if c.cfg.OnFlushStats == nil {
+		return
+	}
+	c.callback("OnFlushStats", onWorker, func() { c.cfg.OnFlushStats(stats) })
+}
+
+func lineBytes(entries []Entry) int {
+	n := 0
+	for _, e := range entries {
+		n += len(e.Line)
+	}
+	return n
+}
+
+// streamStats returns the FlushStats.Streams of entries.
+func (c *Client) streamStats(entries []Entry) []StreamStats {
+	summaries := c.summarizeStreams(entries)
+	out := make([]StreamStats, len(summaries))
+	for i, s := range summaries {
+		out[i] = StreamStats{Labels: s.Labels, Entries: s.Entries, Bytes: s.Bytes}
+	}
+	return out
+}
+
+// summarizeStreams partitions entries into streams as the encoders do, in
+// first-seen order. Labels are copies owned by the caller.
+func (c *Client) summarizeStreams(entries []Entry) []StreamSummary {
+	var out []StreamSummary
+	index := map[string]int{}
+	for _, e := range entries {
+		labels, key := c.jsonStream(e)
+		i, ok := index[key]
+		if !ok {
+			i = len(out)
+			index[key] = i
+			out = append(out, StreamSummary{Stream: key, Labels: maps.Clone(labels)})
+		}
+		out[i].Entries++
+		out[i].Bytes += len(e.Line)
+	}
+	return out
+}
+
+// encodedPayload is a batch encoded for a push request.
+type encodedPayload struct {
+	payload []byte
+	// stream, when set, writes the payload in place of payload. See
+	// Config.StreamLargePayloads.
+	stream func(io.Writer) error
+	// header holds the encoder headers, Content-Type and Content-Encoding.
+	header http.Header
+}
+
+// encodePayload encodes entries in enc for a push request.
+func (c *Client) encodePayload(enc Encoding, entries []Entry) (encodedPayload, error) {
+	if c.streamsPayload(enc, entries) {
+		return encodedPayload{stream: c.jsonPayloadWriter(entries), header: c.encoderHeader(enc, "application/json", "")}, nil
+	}
+	payload, contentType, contentEncoding, err := c.buildPayloadAs(enc, entries)
+	if err != nil {
+		return encodedPayload{}, err
+	}
+	return encodedPayload{payload: payload, header: c.encoderHeader(enc, contentType, contentEncoding)}, nil
+}
+
+// encoderHeader returns the encoder headers of a payload in enc, applying
+// ContentTypeOverride.
+func (c *Client) encoderHeader(enc Encoding, contentType, contentEncoding string) http.Header {
+	if ct, ok := c.cfg.ContentTypeOverride[enc]; ok {
+		contentType = ct
+	}
+	h := http.Header{"Content-Type": {contentType}}
+	if contentEncoding != "" {
+		h.Set("Content-Encoding", contentEncoding)
+	}
+	return h
 }
 
 func (c *Client) buildPayload(entries []Entry) ([]byte, string, string, error) {
-	switch c.cfg.Encoding {
+	return c.buildPayloadAs(c.cfg.Encoding, entries)
+}
+
+func (c *Client) buildPayloadAs(enc Encoding, entries []Entry) ([]byte, string, string, error) {
+	switch enc {
 	case EncodingJSON:
 		payload, err := c.buildJSONPayload(entries)
 		return payload, "application/json", "", err
+	case EncodingJSONLegacy:
+		payload, err := c.buildJSONLegacyPayload(entries)
+		return payload, "application/json", "", err
 	case EncodingProtobufSnappy:
 		payload, err := c.buildProtobufSnappyPayload(entries)
-		return payload, "application/x-protobuf", "snappy", err
+		return payload, "application/x-protobuf", protobufContentEncoding(c.cfg.ProtobufCompression), err
 	default:
-		return nil, "", "", fmt.Errorf("unsupported encoding %q", c.cfg.Encoding)
+		return nil, "", "", fmt.Errorf("unsupported encoding %q", enc)
 	}
 }
 
 func (c *Client) buildJSONPayload(entries []Entry) ([]byte, error) {
+	if slices.ContainsFunc(entries, func(e Entry) bool { return len(e.Metadata) > 0 }) {
+		return c.buildJSONMetadataPayload(entries)
+	}
+	s := jsonScratchPool.Get().(*jsonScratch)
+	defer s.release(c.retainLimits())
+	for _, e := range entries {
+		labels, key := c.jsonStream(e)
+		if s.slot(key) {
+			c.sawStream(key)
+			s.streams = append(s.streams, jsonStreamValues{Stream: labels})
+		}
+	}
+	s.values = slices.Grow(s.values, len(entries))[:len(entries)]
+	off := 0
+	for i, n := range s.counts {
+		s.streams[i].Values = s.values[off : off : off+n]
+		off += n
+	}
+	for i, e := range entries {
+		st := &s.streams[s.slots[i]]
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(e.Timestamp.UnixNano(), 10), e.Line})
+	}
+	return json.Marshal(struct {
+		Streams []jsonStreamValues `json:"streams"`
+	}{Streams: s.streams})
+}
+
+// buildJSONMetadataPayload encodes a batch carrying structured metadata,
+// which Loki takes as a third element of each value. It bypasses the pooled
+// [2]string path used for plain batches.
+func (c *Client) buildJSONMetadataPayload(entries []Entry) ([]byte, error) {
 	type stream struct {
 		Stream map[string]string `json:"stream"`
-		Values [][2]string       `json:"values"`
+		Values [][]any           `json:"values"`
 	}
-	groups := map[string]*stream{}
+	var streams []*stream
+	index := map[string]int{}
 	for _, e := range entries {
-		labels := mergeLabels(c.cfg.StaticLabels, e.Labels)
-		keyBytes, _ := json.Marshal(labels)
-		key := string(keyBytes)
-		s, ok := groups[key]
+		labels, key := c.jsonStream(e)
+		i, ok := index[key]
 		if !ok {
-			s = &stream{Stream: labels}
-			groups[key] = s
+			i = len(streams)
+			index[key] = i
+			c.sawStream(key)
+			streams = append(streams, &stream{Stream: labels})
 		}
-		ts := fmt.Sprintf("%d", e.Timestamp.UnixNano())
-		s.Values = append(s.Values, [2]string{ts, e.Line})
-	}
-	out := struct {
-		Streams []stream `json:"streams"`
-	}{Streams: make([]stream, 0, len(groups))}
-	for _, s := range groups {
-		out.Streams = append(out.Streams, *s)
+		v := []any{strconv.FormatInt(e.Timestamp.UnixNano(), 10), e.Line}
+		if len(e.Metadata) > 0 {
+			v = append(v, e.Metadata)
+		}
+		streams[i].Values = append(streams[i].Values, v)
 	}
-	return json.Marshal(out)
+	return json.Marshal(struct {
+		Streams []*stream `json:"streams"`
+	}{Streams: streams})
 }
 
 func (c *Client) buildProtobufSnappyPayload(entries []Entry) ([]byte, error) {
-	groups := map[string]*push.Stream{}
+	s := protoScratchPool.Get().(*protoScratch)
+	defer s.release(c.retainLimits())
 	for _, e := range entries {
-		labels := mergeLabels(c.cfg.StaticLabels, e.Labels)
-		labelSet := toLokiLabelSet(labels)
-		s, ok := groups[labelSet]
-		if !ok {
-			s = &push.Stream{Labels: labelSet}
-			groups[labelSet] = s
+		labels := c.protoStream(e)
+		if s.slot(labels) {
+			c.sawStream(labels)
+			s.req.Streams = append(s.req.Streams, push.Stream{Labels: labels})
 		}
-		s.Entries = append(s.Entries, push.Entry{Timestamp: e.Timestamp, Line: e.Line})
 	}
-	req := push.PushRequest{Streams: make([]push.Stream, 0, len(groups))}
-	for _, s := range groups {
-		req.Streams = append(req.Streams, *s)
+	s.entries = slices.Grow(s.entries, len(entries))[:len(entries)]
+	off := 0
+	for i, n := range s.counts {
+		s.req.Streams[i].Entries = s.entries[off : off : off+n]
+		off += n
 	}
-	raw, err := req.Marshal()
-	if err != nil {
-		return nil, err
+	for i, e := range entries {
+		st := &s.req.Streams[s.slots[i]]
+		st.Entries = append(st.Entries, push.Entry{Timestamp: e.Timestamp, Line: e.Line, StructuredMetadata: metadataPairs(e.Metadata)})
 	}
-	return snappy.Encode(nil, raw), nil
+	s.req.Format = c.cfg.PushFormat
+	s.raw = s.req.MarshalAppend(s.raw)
+	return compressProtobuf(c.cfg.ProtobufCompression, s.raw)
 }
 
+// compressProtobuf compresses an encoded PushRequest as configured. The
+// result never aliases raw, which goes back to its pool.
+func compressProtobuf(compression ProtobufCompression, raw []byte) ([]byte, error) {
+	switch compression {
+	case ProtobufSnappyFramed:
+		var buf bytes.Buffer
+		w := snappy.NewBufferedWriter(&buf)
+		if _, err := w.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case ProtobufUncompressed:
+		return bytes.Clone(raw), nil
+	default:
+		return snappy.Encode(nil, raw), nil
+	}
+}
+
+// protobufContentEncoding returns the Content-Encoding of a compression.
+func protobufContentEncoding(compression ProtobufCompression) string {
+	switch compression {
+	case ProtobufSnappyFramed:
+		return "x-snappy-framed"
+	case ProtobufUncompressed:
+		return ""
+	default:
+		return "snappy"
+	}
+}
+
+// metadataPairs returns metadata as name-sorted pairs, or nil if empty.
+func metadataPairs(metadata map[string]string) []push.LabelAdapter {
+	if len(metadata) == 0 {
+		return nil
+	}
+	pairs := make([]push.LabelAdapter, 0, len(metadata))
+	for k, v := range metadata {
+		pairs = append(pairs, push.LabelAdapter{Name: k, Value: v})
+	}
+	slices.SortFunc(pairs, func(a, b push.LabelAdapter) int { return strings.Compare(a.Name, b.Name) })
+	return pairs
+}
+
+// toLokiLabelSet formats labels as a Loki label-set string, keys sorted and
+// values quoted by strconv.Quote. It is the stream key of both encoders.
 func toLokiLabelSet(labels map[string]string) string {
 	if len(labels) == 0 {
 		return "{}"
 	}
 	keys := make([]string, 0, len(labels))
-	for k := range labels {
+	size := 2
+	for k, v := range labels {
 		keys = append(keys, k)
+		size += len(k) + len(v) + 4
 	}
 	sort.Strings(keys)
-	parts := make([]string, 0, len(keys))
-	for _, k := range keys {
-		parts = append(parts, fmt.Sprintf("%s=%q", k, labels[k]))
+	b := make([]byte, 0, size)
+	b = append(b, '{')
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, k...)
+		b = append(b, '=')
+		b = strconv.AppendQuote(b, labels[k])
 	}
-	return "{" + strings.Join(parts, ",") + "}"
+	return string(append(b, '}'))
 }
 
+// mergeLabels returns a new map holding a overlaid with b.
 func mergeLabels(a, b map[string]string) map[string]string {
-	if len(a) == 0 && len(b) == 0 {
-		return map[string]string{}
-	}
 	out := make(map[string]string, len(a)+len(b))
 	for k, v := range a {
 		out[k] = v
@@ -331,12 +1529,20 @@ func mergeLabels(a, b map[string]string) map[string]string {
 	return out
 }
 
-func (c *Client) setErr(err error) {
+// debug logs internal diagnostics to Config.DebugLogger, if set.
+func (c *Client) debug(msg string, args ...any) {
+	if c.cfg.DebugLogger == nil {
+		return
+	}
+	c.cfg.DebugLogger.Debug("lokigo: "+msg, args...)
+}
+
+func (c *Client) setErr(err error, onWorker bool) {
 	c.errMu.Lock()
 	c.lastErr = err
 	onError := c.cfg.OnError
 	c.errMu.Unlock()
 	if onError != nil {
-		onError(err)
+		c.callback("OnError", onWorker, func() { onError(err) })
 	}
 }
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -385,6 +387,63 @@ func TestCloseRespectsDeadlineDuringRetry(t *testing.T) {
 	}
 }
 
+func TestCloseDeadlineStopsRetryLoop(t *testing.T) {
+	// The first attempts fail fast so the retry loop runs; the fourth
+	// blocks until the client gives up on it.
+	const blockingAttempt = 4
+	var requests atomic.Int32
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == blockingAttempt {
+			close(blocked)
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		http.Error(w, "retry", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		BatchMaxEntries: 1,
+		Retry:           RetryConfig{MaxAttempts: 1000, MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond},
+		ShutdownTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"in flight", "queued"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-blocked
+
+	// context.Background has no deadline, so ShutdownTimeout bounds Close.
+	if err := c.Close(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ShutdownTimeout to bound Close, got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the worker to stop retrying once the Close context was done")
+	}
+	// The blocked attempt was the last one: the client started no other
+	// request once Close gave up on it.
+	if n := requests.Load(); n != blockingAttempt {
+		t.Fatalf("requests = %d, want no push after the blocked attempt %d", n, blockingAttempt)
+	}
+}
+
 func TestCloseRespectsCanceledContext(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "retry", http.StatusInternalServerError)
@@ -464,6 +523,9 @@ func TestOnFlushCallbackReportsRunningTotals(t *testing.T) {
 	if m.Dropped == 0 {
 		t.Fatalf("expected dropped > 0, got %+v", m)
 	}
+	if m.DroppedByReason[DropQueueFull] != m.Dropped {
+		t.Fatalf("expected all drops attributed to %s, got %+v", DropQueueFull, m.DroppedByReason)
+	}
 	if m.PushErrors == 0 {
 		t.Fatalf("expected push errors > 0, got %+v", m)
 	}
@@ -514,3 +576,452 @@ func TestPushErrorTaxonomySupportsErrorsAs(t *testing.T) {
 		}
 	})
 }
+
+func TestMaxBatchAgeFlushesLoneEntryWithLongBatchMaxWait(t *testing.T) {
+	pushed := make(chan int, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Streams []jsonStreamValues `json:"streams"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		n := 0
+		for _, s := range req.Streams {
+			n += len(s.Values)
+		}
+		pushed <- n
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	const age = 100 * time.Millisecond
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	c, err := newClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Minute, MaxBatchAge: age}, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = c.Close(context.Background()) }()
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal(what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// The second entry must not push the deadline out: age is measured from
+	// the oldest entry in the batch.
+	flushAt := clock.Now().Add(age)
+	if err := c.Send(context.Background(), Entry{Line: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("expected the first entry to arm MaxBatchAge", func() bool { return clock.armedAt(flushAt) })
+	clock.Advance(age / 2)
+	if err := c.Send(context.Background(), Entry{Line: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("expected both entries batched", func() bool { return c.QueueLen() == 2 })
+	if !clock.armedAt(flushAt) {
+		t.Fatal("expected the second entry to keep the MaxBatchAge deadline")
+	}
+
+	clock.Advance(age/2 - time.Nanosecond)
+	if c.QueueLen() != 2 || !clock.armedAt(flushAt) {
+		t.Fatal("expected no flush before MaxBatchAge")
+	}
+	clock.Advance(time.Nanosecond)
+	select {
+	case n := <-pushed:
+		if n != 2 {
+			t.Fatalf("expected both entries in a single push at MaxBatchAge, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected lone batch to be flushed exactly at MaxBatchAge")
+	}
+}
+
+func TestDisableBatchingSendsInlineWithoutWorker(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			http.Error(w, "retry", http.StatusServiceUnavailable)
+			return
+		}
+		if got := r.Header.Get("X-Scope-OrgID"); got != "tenant" {
+			t.Errorf("expected tenant header, got %q", got)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	before := runtime.NumGoroutine()
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		TenantID:        "tenant",
+		DisableBatching: true,
+		Retry:           RetryConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(context.Background(), Entry{Line: "inline"}); err != nil {
+		t.Fatalf("expected inline send to succeed after retry, got %v", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Fatalf("expected push to complete before Send returned, got %d attempts", got)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutine leak: %d before, %d after", before, after)
+	}
+}
+
+func TestDisableBatchingReturnsPushError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, DisableBatching: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Send(context.Background(), Entry{Line: "x"})
+	var statusErr *HTTPStatusPushError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected HTTPStatusPushError 400 from Send, got %v", err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("expected Close to have nothing to report, got %v", err)
+	}
+}
+
+func TestQueueLenAndPendingBytesAroundFlush(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxEntries: 3, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor := func(wantLen, wantBytes int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for c.QueueLen() != wantLen || c.PendingBytes() != wantBytes {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected QueueLen=%d PendingBytes=%d, got %d/%d", wantLen, wantBytes, c.QueueLen(), c.PendingBytes())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for _, line := range []string{"aa", "bbbb"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(2, 6)
+
+	// The third entry fills the batch; once its push is on the wire nothing
+	// is pending, and the next entry waits in the queue behind the push.
+	if err := c.Send(context.Background(), Entry{Line: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	<-arrived
+	waitFor(0, 0)
+	if err := c.Send(context.Background(), Entry{Line: "ddddd"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(1, 5)
+
+	close(release)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(0, 0)
+}
+
+func TestDroppedByReasonDropOldest(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, QueueSize: 1, BatchMaxEntries: 1, BackpressureMode: BackpressureDropOldest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first entry blocks the worker in its push; the rest compete for
+	// the single queue slot.
+	for i := 0; i < 5; i++ {
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprintf("line %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			for c.QueueLen() != 0 || c.Metrics().InFlight != 1 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	m := c.MetricsReset()
+	if m.Dropped != 3 || m.DroppedByReason[DropQueueEvicted] != 3 || len(m.DroppedByReason) != 1 {
+		t.Fatalf("expected 3 evictions, got %+v", m)
+	}
+	if m := c.Metrics(); m.Dropped != 0 || len(m.DroppedByReason) != 0 {
+		t.Fatalf("expected reset drop counts, got %+v", m)
+	}
+	close(release)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushStatsWarningFromAcceptedPush(t *testing.T) {
+	const warning = `{"warnings":["stream limit nearly reached"]}`
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, warning)
+	}))
+	defer srv.Close()
+
+	stats := make(chan FlushStats, 2)
+	c, err := NewClient(Config{Endpoint: srv.URL, DisableBatching: true, OnFlushStats: func(s FlushStats) { stats <- s }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	for _, line := range []string{"a", "b"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := <-stats; s.Warning != "" || s.Err != nil {
+		t.Fatalf("204 push: Warning = %q, Err = %v; want neither", s.Warning, s.Err)
+	}
+	if s := <-stats; s.Warning != warning || s.Err != nil {
+		t.Fatalf("200 push: Warning = %q, Err = %v; want %q", s.Warning, s.Err, warning)
+	}
+}
+
+func TestFlushContextBoundsPushAttempts(t *testing.T) {
+	type key struct{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	var seen atomic.Value
+	var mu sync.Mutex
+	var cancels []context.CancelFunc
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+	stats := make(chan FlushStats, 1)
+	c, err := NewClient(Config{
+		Endpoint:     srv.URL,
+		BatchMaxWait: 10 * time.Millisecond,
+		Retry:        RetryConfig{MaxAttempts: 100, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		FlushContext: func() context.Context {
+			ctx := context.WithValue(context.Background(), key{}, "flush")
+			ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			mu.Lock()
+			cancels = append(cancels, cancel)
+			mu.Unlock()
+			return ctx
+		},
+		PushInterceptors: []func(PushFunc) PushFunc{func(next PushFunc) PushFunc {
+			return func(ctx context.Context, info *PushRequestInfo) error {
+				seen.Store(ctx.Value(key{}))
+				return next(ctx, info)
+			}
+		}},
+		OnFlushStats: func(s FlushStats) { stats <- s },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-stats:
+		if s.Err == nil || s.Duration > time.Second {
+			t.Fatalf("flush = %v after %v, want it bounded by the 50ms FlushContext deadline", s.Err, s.Duration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush not bounded by FlushContext")
+	}
+	if v := seen.Load(); v != "flush" {
+		t.Fatalf("interceptor saw context value %v, want the FlushContext value", v)
+	}
+}
+
+func TestDetailedFlushStatsAttributesBytesPerStream(t *testing.T) {
+	lines := map[string][]string{
+		"api":    {"GET /items 200", "GET /items/1 404"},
+		"worker": {"job done", "job failed: timeout", "retrying"},
+	}
+	for _, enc := range []Encoding{EncodingJSON, EncodingProtobufSnappy} {
+		t.Run(string(enc), func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			stats := make(chan FlushStats, 1)
+			c, err := NewClient(Config{
+				Endpoint:           srv.URL,
+				Encoding:           enc,
+				StaticLabels:       map[string]string{"env": "prod"},
+				BatchMaxWait:       time.Minute,
+				DetailedFlushStats: true,
+				OnFlushStats:       func(s FlushStats) { stats <- s },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				for _, service := range []string{"api", "worker"} {
+					if i < len(lines[service]) {
+						if err := c.Send(context.Background(), Entry{Line: lines[service][i], Labels: map[string]string{"service": service}}); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+			}
+			if err := c.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			s := <-stats
+			if len(s.Streams) != 2 {
+				t.Fatalf("Streams = %+v, want 2 streams", s.Streams)
+			}
+			total := 0
+			for _, st := range s.Streams {
+				service := st.Labels["service"]
+				want := 0
+				for _, l := range lines[service] {
+					want += len(l)
+				}
+				if st.Labels["env"] != "prod" || st.Entries != len(lines[service]) || st.Bytes != want {
+					t.Fatalf("stream %v: %d entries, %d bytes; want %d and %d", st.Labels, st.Entries, st.Bytes, len(lines[service]), want)
+				}
+				total += st.Bytes
+			}
+			if total != s.Bytes {
+				t.Fatalf("stream bytes sum to %d, FlushStats.Bytes = %d", total, s.Bytes)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyStableAcrossAttempts(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		attempt := len(keys) % 3
+		mu.Unlock()
+		if attempt != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	var statsKeys []string
+	c, err := NewClient(Config{
+		Endpoint:             srv.URL,
+		DisableBatching:      true,
+		Retry:                RetryConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		IdempotencyKeyHeader: "Idempotency-Key",
+		OnFlushStats:         func(s FlushStats) { statsKeys = append(statsKeys, s.IdempotencyKey) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	for range 2 {
+		if err := c.Send(context.Background(), Entry{Line: "same"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(keys) != 6 || keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] || keys[3] != keys[4] || keys[4] != keys[5] {
+		t.Fatalf("keys = %q, want one key per batch repeated on its 3 attempts", keys)
+	}
+	if keys[0] == keys[3] {
+		t.Fatalf("two batches share the key %q", keys[0])
+	}
+	if len(statsKeys) != 2 || statsKeys[0] != keys[0] || statsKeys[1] != keys[3] {
+		t.Fatalf("FlushStats keys = %q, want %q and %q", statsKeys, keys[0], keys[3])
+	}
+}
+
+func TestSendCopiesCallerMaps(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 10, BatchMaxWait: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One map reused and rewritten right after every Send, as loops often
+	// do. Run under -race.
+	labels := map[string]string{}
+	metadata := map[string]string{}
+	for i := range 100 {
+		labels["i"] = fmt.Sprint(i)
+		metadata["m"] = fmt.Sprint(i)
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprint(i), Labels: labels, Metadata: metadata}); err != nil {
+			t.Fatal(err)
+		}
+		labels["i"] = "mutated"
+		delete(metadata, "m")
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, s := range streams() {
+		if s.labels["i"] == "mutated" || s.entries != 1 {
+			t.Fatalf("stream %v holds %d entries, want the labels at Send time", s.labels, s.entries)
+		}
+		seen[s.labels["i"]] = true
+	}
+	if len(seen) != 100 {
+		t.Fatalf("%d distinct streams pushed, want 100", len(seen))
+	}
+}
@@ -0,0 +1,31 @@
+package lokigo
+
+import "time"
+
+// workerClock is the time source of the worker's timers. Tests swap it for
+// a fake one to check flush deadlines exactly.
+type workerClock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) clockTimer
+}
+
+// clockTimer is the part of time.Timer the worker uses.
+type clockTimer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) clockTimer {
+	return systemTimer{t: time.NewTimer(d)}
+}
+
+type systemTimer struct{ t *time.Timer }
+
+func (s systemTimer) C() <-chan time.Time        { return s.t.C }
+func (s systemTimer) Reset(d time.Duration) bool { return s.t.Reset(d) }
+func (s systemTimer) Stop() bool                 { return s.t.Stop() }
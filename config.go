@@ -1,9 +1,19 @@
 package lokigo
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"time"
+
+	"github.com/zabihimohsen/lokigo/internal/push"
 )
 
 type BackpressureMode string
@@ -17,6 +27,119 @@ const (
 
 	EncodingProtobufSnappy Encoding = "protobuf-snappy"
 	EncodingJSON           Encoding = "json"
+	// EncodingJSONLegacy is the JSON schema of Loki 1.x's /api/prom/push,
+	// with labels as a label-set string and RFC 3339 timestamps. It cannot
+	// carry structured metadata, which is dropped.
+	EncodingJSONLegacy Encoding = "json-legacy"
+)
+
+// ProtobufCompression is the compression of EncodingProtobufSnappy push
+// bodies.
+type ProtobufCompression string
+
+const (
+	// ProtobufSnappyBlock is snappy block format, sent with
+	// "Content-Encoding: snappy", as Loki expects (default).
+	ProtobufSnappyBlock ProtobufCompression = "snappy-block"
+	// ProtobufSnappyFramed is the snappy framing format, sent with
+	// "Content-Encoding: x-snappy-framed".
+	ProtobufSnappyFramed ProtobufCompression = "snappy-framed"
+	// ProtobufUncompressed sends the raw protobuf without Content-Encoding.
+	ProtobufUncompressed ProtobufCompression = "none"
+)
+
+// DropReason says why entries were dropped, as broken down in
+// Metrics.DroppedByReason.
+type DropReason string
+
+const (
+	// DropQueueFull is a new entry rejected with ErrDropped by
+	// BackpressureDropNew, once DropNewGrace elapsed.
+	DropQueueFull DropReason = "queue_full"
+	// DropQueueEvicted is a queued entry evicted by BackpressureDropOldest
+	// to make room for a new one.
+	DropQueueEvicted DropReason = "queue_evicted"
+	// DropCallbackReentry is an entry sent from within a client callback
+	// running on the worker goroutine, such as OnError logging through a
+	// handler backed by the same client, that found the queue full.
+	// Blocking there would deadlock the worker, so the entry is dropped with
+	// ErrDropped even under BackpressureBlock. Only Sends made from within
+	// the callback are affected: other goroutines block as usual while it
+	// runs.
+	DropCallbackReentry DropReason = "callback_reentry"
+	// DropStreamOverflow is an entry of a stream that already used its
+	// MaxBytesPerStreamPerBatch share, under StreamOverflowDrop.
+	DropStreamOverflow DropReason = "stream_overflow"
+	// DropShutdown is a queued entry the Close drain gave up on without
+	// pushing it, because of ShutdownMaxDrainEntries or the Close deadline.
+	DropShutdown DropReason = "shutdown"
+	// DropOversize is an entry whose line alone exceeds BatchMaxBytes,
+	// under OversizeDrop.
+	DropOversize DropReason = "oversize"
+	// DropClosed is an entry sent after Close was called, rejected with
+	// ErrClosed.
+	DropClosed DropReason = "closed"
+	// DropWorkerPanic is an entry held by the worker when it panicked.
+	DropWorkerPanic DropReason = "worker_panic"
+	// DropWorkerDown is an entry queued or sent after the worker stopped
+	// restarting, rejected with ErrWorkerDown.
+	DropWorkerDown DropReason = "worker_down"
+)
+
+// StreamOverflowPolicy controls entries of a stream over its
+// Config.MaxBytesPerStreamPerBatch share of the current batch.
+type StreamOverflowPolicy string
+
+const (
+	// StreamOverflowSpill holds the entries back for the next batch
+	// (default). Entries of the stream keep their order.
+	StreamOverflowSpill StreamOverflowPolicy = "spill"
+	// StreamOverflowDrop drops the entries with DropStreamOverflow.
+	StreamOverflowDrop StreamOverflowPolicy = "drop"
+)
+
+// MemoryReleasePolicy bounds the memory the client keeps for reuse between
+// flushes: the worker's batch buffers and the pooled payload encode buffers
+// are released instead of reused once they grew past either limit, and the
+// worker rebuilds its per-stream maps every 64 flushes.
+type MemoryReleasePolicy struct {
+	// ShrinkFactor is the multiple of BatchMaxEntries entries a buffer may
+	// hold and still be reused. Defaults to 4.
+	ShrinkFactor int
+	// MaxRetainedBytes is the size a buffer may have and still be reused.
+	// Defaults to 8MiB.
+	MaxRetainedBytes int
+}
+
+// OversizeEntryPolicy controls entries whose line alone exceeds
+// Config.BatchMaxBytes.
+type OversizeEntryPolicy string
+
+const (
+	// OversizeSendAlone pushes the entry as a batch of its own, exceeding
+	// BatchMaxBytes (default).
+	OversizeSendAlone OversizeEntryPolicy = "send_alone"
+	// OversizeTruncate cuts the line to BatchMaxBytes at a rune boundary,
+	// ending in "…", and counts it in Metrics.TruncatedLines.
+	OversizeTruncate OversizeEntryPolicy = "truncate"
+	// OversizeDrop drops the entry with DropOversize.
+	OversizeDrop OversizeEntryPolicy = "drop"
+)
+
+// ReservedLabelPolicy controls labels whose name begins with "__", which
+// Loki and Prometheus reserve for internal use.
+type ReservedLabelPolicy string
+
+const (
+	// ReservedLabelStrip removes reserved labels from the stream (default).
+	ReservedLabelStrip ReservedLabelPolicy = "strip"
+	// ReservedLabelRename prefixes reserved label names with
+	// Config.ReservedLabelPrefix.
+	ReservedLabelRename ReservedLabelPolicy = "rename"
+	// ReservedLabelReject makes Send return a *ReservedLabelError.
+	ReservedLabelReject ReservedLabelPolicy = "reject"
+	// ReservedLabelKeep sends reserved labels unchanged.
+	ReservedLabelKeep ReservedLabelPolicy = "keep"
 )
 
 type RetryConfig struct {
@@ -24,6 +147,88 @@ type RetryConfig struct {
 	MinBackoff  time.Duration
 	MaxBackoff  time.Duration
 	JitterFrac  float64
+	// InitialDelay, when set, replaces the wait before the first retry if
+	// the first attempt could not connect, with a connection refused or DNS
+	// NetworkPushError, as when the endpoint is still starting. The wait is
+	// drawn between half of InitialDelay and InitialDelay, so replicas
+	// started together spread their retries, and does not advance the
+	// backoff: the next wait is still MinBackoff.
+	InitialDelay time.Duration
+}
+
+// RedirectConfig controls how push redirects are followed.
+//
+// 307 and 308 responses are always followed once per attempt, re-sending the
+// payload and all request headers.
+type RedirectConfig struct {
+	// FollowMoved also follows 301 and 302 responses, keeping the POST method.
+	FollowMoved bool
+	// StripAuthCrossHost drops Authorization and Cookie headers when the
+	// redirect target is on a different host.
+	StripAuthCrossHost bool
+}
+
+// RateLimitConfig paces outbound pushes with token buckets. Tokens are taken
+// once per batch, before its first push attempt.
+type RateLimitConfig struct {
+	// EntriesPerSecond limits pushed entries per second. Zero disables it.
+	EntriesPerSecond float64
+	// BytesPerSecond limits pushed line bytes per second. Zero disables it.
+	// The byte bucket holds at most one second worth of tokens.
+	BytesPerSecond float64
+	// Burst is the number of entries that may be pushed at once after an idle
+	// period. Zero defaults to one second of EntriesPerSecond.
+	Burst int
+}
+
+// ShardStreamsConfig spreads each logical stream over several physical Loki
+// streams by adding a shard label, to get past per-stream rate limits.
+type ShardStreamsConfig struct {
+	Enabled bool
+	// Label is the shard label name. Defaults to "__stream_shard__".
+	Label string
+	// Shards is the number of physical streams per logical stream (>= 2).
+	Shards int
+}
+
+// FlushStats describes the outcome of a single batch flush.
+type FlushStats struct {
+	// Endpoint and TenantID are the push target (see Config.Routes).
+	Endpoint string
+	TenantID string
+	Entries  int
+	Bytes    int
+	Attempts int
+	Duration time.Duration
+	// RateLimitWait is the time spent waiting for RateLimit tokens.
+	RateLimitWait time.Duration
+	Err           error
+	// QueueLatency is how long the entries of the flush waited between
+	// Send and the flush starting. It is zero with DisableBatching.
+	QueueLatency QueueLatency
+	// Warning is the non-empty body of the accepted push response, which
+	// Loki and gateways use for warnings, decompressed if gzip-encoded and
+	// capped at 1KiB.
+	Warning string
+	// Streams breaks Entries and Bytes down by stream, in first-seen order.
+	// It is only set with Config.DetailedFlushStats.
+	Streams []StreamStats
+	// IdempotencyKey is the key sent in Config.IdempotencyKeyHeader with
+	// every attempt of the flush, or empty when it is unset.
+	IdempotencyKey string
+	// PartialSuccess is set when the push was accepted with a response
+	// body reporting some entries rejected. Err stays nil.
+	PartialSuccess *PartialSuccess
+}
+
+// StreamStats is the share of one stream in a flush.
+type StreamStats struct {
+	// Labels are the stream labels as pushed. The map is owned by the
+	// caller.
+	Labels  map[string]string
+	Entries int
+	// Bytes is the length of the stream's lines, like FlushStats.Bytes.
+	Bytes int
 }
 
 type Metrics struct {
@@ -31,8 +236,82 @@ type Metrics struct {
 	Pushed     uint64
 	PushErrors uint64
 	Retries    uint64
+	Redirects  uint64
+	// Deduplicated counts repeated lines folded into a DedupeWindow summary.
+	Deduplicated uint64
+	// Sampled counts entries discarded by Config.Sampler. They are not
+	// counted in Dropped.
+	Sampled uint64
+	// ClockSkew estimates how far the Loki server clock is ahead of the
+	// local one, negative when behind, smoothed over the Date headers of
+	// push responses. It is accurate to about a second and zero until a
+	// response carried a Date. Like InFlight it is a gauge.
+	ClockSkew time.Duration
+	// RejectedByServer counts entries of accepted pushes that the response
+	// reported rejected; see FlushStats.PartialSuccess. They are counted in
+	// Pushed too.
+	RejectedByServer uint64
+	// ShadowErrors counts shadow pushes that failed or were skipped.
+	ShadowErrors uint64
+	// EmptyLabelsDropped counts empty-valued labels stripped from entries.
+	EmptyLabelsDropped uint64
+	// InFlight is the number of batch pushes currently running, retries
+	// included. Unlike the other fields it is a gauge, not a counter.
+	InFlight int
+	// DroppedByReason breaks Dropped down by reason. It is a copy owned by
+	// the caller.
+	DroppedByReason map[DropReason]uint64
+	// NetworkErrorsByKind counts failed push attempts that returned a
+	// NetworkPushError, by Kind. It is a copy owned by the caller.
+	NetworkErrorsByKind map[NetworkErrorKind]uint64
+	// PushedByTenant and PushErrorsByTenant break Pushed and PushErrors
+	// down by tenant, for pushes with a tenant. They are copies owned by
+	// the caller.
+	PushedByTenant     map[string]uint64
+	PushErrorsByTenant map[string]uint64
+	// DroppedByClass breaks Dropped down by QueueClass name. It is a copy
+	// owned by the caller.
+	DroppedByClass map[string]uint64
+	// CallbackPanics counts panics recovered from OnError, OnFlush and
+	// OnFlushStats. See Config.OnError.
+	CallbackPanics uint64
+	// StreamOverflows lists the streams with the most entries spilled or
+	// dropped by MaxBytesPerStreamPerBatch, at most ten, most first. It is a
+	// copy owned by the caller.
+	StreamOverflows []StreamCount
+	// TruncatedLabelValues counts label values cut to MaxLabelValueBytes or
+	// LabelValueLimits, by label key. Past 64 keys, further keys are counted
+	// under "_other". It is a copy owned by the caller.
+	TruncatedLabelValues map[string]uint64
+	// TruncatedLines counts lines cut to BatchMaxBytes by OversizeTruncate.
+	TruncatedLines uint64
+	// WorkerPanics counts panics recovered from the background worker. See
+	// WorkerPanicError.
+	WorkerPanics uint64
+	// BatchesByEncoding counts encoded batches by push encoding. It is a
+	// copy owned by the caller.
+	BatchesByEncoding map[Encoding]uint64
+	// ActiveStreams is the number of distinct streams pushed within
+	// Config.ActiveStreams.Window. Like InFlight it is a gauge.
+	ActiveStreams int
+	// QueueHighWater is the most entries seen waiting for the worker right
+	// after a Send or SendBatchOwned, since the client started or the last
+	// MetricsReset. Compare it with Client.QueueCap to alert before entries
+	// are dropped.
+	QueueHighWater int
+	// ForcedFlushes counts batches flushed by the StaleBatchSlack watchdog.
+	ForcedFlushes uint64
+	// FallbackErrors counts failed batches that could not be written to
+	// Config.FallbackWriter: the write failed, timed out, or found the
+	// writer still blocked in an earlier write.
+	FallbackErrors uint64
 }
 
+// Config configures a Client. NewClient takes it by value and copies the
+// maps and slices it holds, Routes and nested label maps included, so
+// changing them afterwards affects neither the client nor other clients
+// built from the same Config. HTTPClient, DebugLogger and function fields
+// are shared as given.
 type Config struct {
 	Endpoint         string
 	TenantID         string
@@ -47,20 +326,363 @@ type Config struct {
 	BackpressureMode BackpressureMode
 	Retry            RetryConfig
 	// OnError is called when async background flush/push fails.
-	// It is optional and must be safe for concurrent use.
+	// It is optional and must be safe for concurrent use. A panic in OnError,
+	// OnFlush or OnFlushStats is recovered, counted in
+	// Metrics.CallbackPanics and logged to DebugLogger; the client keeps
+	// running.
 	OnError func(error)
 	// OnFlush is called after each batch attempt/update with running totals.
 	// It is optional and must be safe for concurrent use.
 	OnFlush func(Metrics)
+	// DialContext, when set, is used by the default transport to open
+	// connections. It is ignored when HTTPClient is provided.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// MaxConnLifetime caps how long pooled keep-alive connections are reused.
+	// Idle connections older than this are closed before the next push so
+	// the endpoint is re-dialed (and DNS re-resolved). Zero disables it.
+	MaxConnLifetime time.Duration
+	// Redirect configures redirect handling on push requests.
+	Redirect RedirectConfig
+	// RateLimit paces outbound pushes. The zero value disables it.
+	RateLimit RateLimitConfig
+	// OnFlushStats is called once per batch after its final push attempt.
+	// It is optional and must be safe for concurrent use.
+	OnFlushStats func(FlushStats)
+	// FlushJitterFrac randomizes each BatchMaxWait flush interval within
+	// ±FlushJitterFrac, so replicas started together do not flush in lockstep.
+	// Must be in [0, 1). Zero keeps a fixed interval.
+	FlushJitterFrac float64
+	// FlushStagger delays the first periodic flush by a random offset within
+	// BatchMaxWait instead of a full interval.
+	FlushStagger bool
+	// MaxBatchAge bounds how long the oldest entry of a batch waits before a
+	// push attempt. The timer starts when an entry enters an empty batch.
+	// When BatchMaxWait fires first, it still flushes. Zero disables it.
+	MaxBatchAge time.Duration
+	// DisableBatching makes Send push each entry inline on the caller's
+	// goroutine (with retries) and return the final push error. No background
+	// worker is started, so batching, queue and backpressure settings are
+	// unused and OnError is not called.
+	DisableBatching bool
+	// MaxStreamsPerBatch splits a flushed batch into several push requests
+	// along stream boundaries so no request carries more streams than this.
+	// Zero means unlimited.
+	MaxStreamsPerBatch int
+	// MaxEntriesPerStream forces a flush as soon as any single stream in the
+	// accumulating batch reaches this many entries. Zero means unlimited.
+	MaxEntriesPerStream int
+	// ShardStreams adds a shard label to every stream when enabled.
+	ShardStreams ShardStreamsConfig
+	// DedupeWindow collapses consecutive identical lines of the same stream.
+	// The first occurrence is sent as is; repeats within the window are sent
+	// as one "<line> (repeated N times)" entry when the window closes or a
+	// different line arrives on that stream. Zero disables it.
+	DedupeWindow time.Duration
+	// Routes send matching entries to other endpoints or tenants. Each
+	// flushed batch is partitioned by route and every partition is pushed
+	// as its own request.
+	Routes []Route
+	// ShadowEndpoint, when set, receives a best-effort copy of every encoded
+	// payload (at most one retry). Shadow failures are counted in
+	// Metrics.ShadowErrors and logged to DebugLogger, never passed to OnError.
+	ShadowEndpoint string
+	// ShadowHeaders replaces Headers for shadow pushes when non-nil.
+	ShadowHeaders map[string]string
+	// ShadowTenantID replaces TenantID for shadow pushes when non-empty.
+	ShadowTenantID string
+	// DebugLogger receives internal diagnostics at debug level. Optional.
+	DebugLogger *slog.Logger
+	// OAuth2 enables the client credentials grant for push requests. Token
+	// fetch failures surface as retryable NetworkPushError.
+	OAuth2 OAuth2Config
+	// MaxErrorBodyBytes caps how much of a failed push response body is kept
+	// in HTTPStatusPushError. Defaults to 4096; negative means unlimited up
+	// to a 1MiB hard cap.
+	MaxErrorBodyBytes int
+	// KeepEmptyLabels disables stripping labels whose value is empty. Loki
+	// rejects such streams, so they are removed by default and counted in
+	// Metrics.EmptyLabelsDropped.
+	KeepEmptyLabels bool
+	// EmptyStreamLabels replaces the labels of a stream that stripping empty
+	// values left with no labels at all, for example {"job": "lokigo"}. When
+	// nil such entries are sent as the empty stream {}.
+	EmptyStreamLabels map[string]string
+	// ReservedLabelPolicy controls labels named "__*". Defaults to
+	// ReservedLabelStrip. The ShardStreams label is always allowed.
+	ReservedLabelPolicy ReservedLabelPolicy
+	// ReservedLabelPrefix is prepended to reserved names under
+	// ReservedLabelRename. Defaults to "user", so "__name__" becomes
+	// "user__name__".
+	ReservedLabelPrefix string
+	// ReservedLabelAllowList names reserved labels that are sent unchanged
+	// under every policy.
+	ReservedLabelAllowList []string
+	// ShutdownTimeout bounds Close when its context has no deadline. When
+	// the Close context is done, in-flight retries and the shutdown drain
+	// are interrupted and the remaining entries are abandoned. Zero means
+	// no bound.
+	ShutdownTimeout time.Duration
+	// PushInterceptors wrap every push, in order: the first one is the
+	// outermost. They run inside the retry loop and so see each attempt,
+	// unless PushInterceptorsOutsideRetry is set, in which case they wrap
+	// the whole retry loop once per batch.
+	PushInterceptors []func(next PushFunc) PushFunc
+	// PushInterceptorsOutsideRetry moves PushInterceptors outside the retry
+	// loop.
+	PushInterceptorsOutsideRetry bool
+	// Batcher decides when the batch being assembled is flushed. Nil uses
+	// the default size/time policy. BatchMaxEntries and BatchMaxBytes stay
+	// hard caps either way. A Batcher must not be shared between clients.
+	Batcher Batcher
+	// TenantFanout, when non-empty, pushes every batch once per listed
+	// tenant instead of once for TenantID, for example to write to an old
+	// and a new tenant during a rename. The encoded payload is reused and
+	// only X-Scope-OrgID differs; each tenant is retried and fails
+	// independently, with failures returned as *TenantPushError. Routes
+	// with their own TenantID are not fanned out.
+	TenantFanout []string
+	// Severity extracts an entry's level for QueueClasses, for example
+	// SeverityFromLabel("level").
+	Severity func(Entry) slog.Level
+	// QueueClasses splits the Send queue by Severity into classes with their
+	// own capacity and backpressure. An entry goes to the class with the
+	// highest MinLevel at or below its level, or to the lowest class if none
+	// qualifies. The worker drains classes highest MinLevel first into the
+	// same batches, so a saturated debug class never holds up errors.
+	// Requires Severity; QueueSize and BackpressureMode then only serve as
+	// class defaults.
+	QueueClasses []QueueClass
+	// ValidateOnSend makes Send check each entry as it will be pushed and
+	// return a *ValidationError instead of enqueueing one Loki would reject:
+	// a line longer than BatchMaxBytes, a timestamp before 1970 or more than
+	// 10 minutes ahead, a stream left without labels, or an invalid label
+	// name. Labels are checked after the same sanitizing the encoders apply.
+	ValidateOnSend bool
+	// UserAgent is sent as the User-Agent of push requests. Defaults to
+	// "lokigo/<version>" (see BuildInfo); a User-Agent in Headers wins.
+	UserAgent string
+	// MaxBytesPerStreamPerBatch caps the line bytes one stream may add to a
+	// batch, so a flooding stream cannot crowd the others out of it. An
+	// entry is always admitted into a batch without entries of its stream.
+	// Further entries over the cap are handled by StreamOverflowPolicy.
+	// Held back entries count in QueueLen; once QueueSize of them are held,
+	// the batch is flushed early. Zero disables the cap.
+	MaxBytesPerStreamPerBatch int
+	// StreamOverflowPolicy defaults to StreamOverflowSpill.
+	StreamOverflowPolicy StreamOverflowPolicy
+	// PreconnectOnStart makes NewClient send a HEAD request to Endpoint in
+	// the background, through HTTPClient with Headers, so the first flush
+	// finds a pooled connection instead of paying DNS, TCP and TLS setup.
+	// Failures are only logged to DebugLogger.
+	PreconnectOnStart bool
+	// QueueLatencyMetadata, when set, attaches how long sampled entries
+	// waited between Send and their flush as structured metadata under
+	// this name, formatted like time.Duration.String.
+	QueueLatencyMetadata string
+	// QueueLatencySampleEvery samples every Nth entry for
+	// QueueLatencyMetadata. Defaults to 100.
+	QueueLatencySampleEvery int
+	// ProtobufCompression selects how EncodingProtobufSnappy bodies are
+	// compressed, for Loki-compatible receivers expecting another format.
+	// Defaults to ProtobufSnappyBlock; it must be unset with EncodingJSON
+	// unless NegotiateEncoding is set.
+	ProtobufCompression ProtobufCompression
+	// NegotiateEncoding probes the endpoint before the first flush with an
+	// empty protobuf push, carrying the usual headers, tenant and
+	// credentials, and uses EncodingProtobufSnappy if it is accepted or
+	// EncodingJSON if it is refused with 415 or 400. Encoding is used until
+	// a probe is conclusive. A push later refused with 415 triggers a new
+	// probe and is repeated in the new encoding.
+	NegotiateEncoding bool
+	// MaxLabelValueBytes cuts stream label values, static labels included,
+	// to this many bytes at a UTF-8 boundary, ending in "…", rather than
+	// have Loki reject the stream; Loki's default limit is 2048. Zero
+	// disables the cut.
+	MaxLabelValueBytes int
+	// LabelValueLimits overrides MaxLabelValueBytes per label key; a zero
+	// limit leaves that key uncut.
+	LabelValueLimits map[string]int
+	// Heartbeat emits a periodic synthetic entry carrying the client
+	// metrics. See HeartbeatConfig.
+	Heartbeat HeartbeatConfig
+	// ShutdownMaxDrainEntries caps how many queued entries Close takes in
+	// and pushes; the rest are discarded as DropShutdown. Zero means no cap.
+	// Independently, the drain discards what is left once the Close
+	// deadline is nearer than its last push took.
+	ShutdownMaxDrainEntries int
+	// FlushContext, when set, is called for every batch the worker flushes
+	// and its context is used for the push attempts, retries and backoff
+	// included, for example to attach values read by PushInterceptors or to
+	// bound every flush with a deadline. A batch mixes entries from many
+	// Send calls, so the contexts passed to Send never reach the push; Close
+	// still cancels flushes as usual. It does not apply with DisableBatching,
+	// where the push runs under the Send context.
+	FlushContext func() context.Context
+	// OversizeEntryPolicy handles an entry whose line alone exceeds
+	// BatchMaxBytes when the worker adds it to a batch. Defaults to
+	// OversizeSendAlone.
+	OversizeEntryPolicy OversizeEntryPolicy
+	// ContentTypeOverride replaces the Content-Type sent with payloads of
+	// an encoding, for endpoints that expect, say, a charset parameter. A
+	// Content-Type in Headers still takes precedence.
+	ContentTypeOverride map[Encoding]string
+	// MemoryReleasePolicy bounds the buffers kept for reuse after a burst.
+	MemoryReleasePolicy MemoryReleasePolicy
+	// DetailedFlushStats sets FlushStats.Streams, which copies the labels of
+	// every stream of a flush.
+	DetailedFlushStats bool
+	// CompleteEndpointPath appends the push path to an Endpoint given
+	// without one, such as "http://loki:3100": /api/prom/push with
+	// EncodingJSONLegacy, /loki/api/v1/push otherwise.
+	CompleteEndpointPath bool
+	// HealthRecovery sets when Health turns healthy again after a failed
+	// flush.
+	HealthRecovery HealthRecovery
+	// IdempotencyKeyHeader, when set, names a header carrying a random key
+	// generated per flush and repeated on each of its attempts, so a gateway
+	// can drop a retry of a push it already ingested. A header of the same
+	// name in Headers is replaced.
+	IdempotencyKeyHeader string
+	// DropNewGrace makes Send under BackpressureDropNew wait up to this long
+	// for queue space before dropping, still bounded by its context, to
+	// ride out a worker briefly busy flushing. Sends from client callbacks
+	// never wait. Zero, the default, drops at once.
+	DropNewGrace time.Duration
+	// SelfDiagnostics emits a periodic entry carrying the client metrics
+	// and Go runtime stats. See SelfDiagnosticsConfig.
+	SelfDiagnostics SelfDiagnosticsConfig
+	// AdaptiveEncoding picks the encoding per batch by size, overriding
+	// Encoding.
+	AdaptiveEncoding AdaptiveEncoding
+	// StreamLargePayloads encodes JSON batches of at least
+	// StreamPayloadAboveBytes straight into the request body, sent with
+	// chunked transfer encoding, instead of holding the encoded batch in
+	// memory. Each attempt and redirect encodes the batch again. It does not
+	// apply with PushInterceptors or a ShadowEndpoint, which need the
+	// encoded bytes.
+	StreamLargePayloads bool
+	// StreamPayloadAboveBytes is the batch size, estimated from line bytes,
+	// from which StreamLargePayloads streams. Defaults to 1MiB.
+	StreamPayloadAboveBytes int
+	// PushFormat sets the format field of protobuf push requests, "loki"
+	// or "otlp". Empty, the default, omits the field, which some proxies
+	// require. JSON payloads have no such field.
+	PushFormat string
+	// ActiveStreams tunes Metrics.ActiveStreams. See ActiveStreamsConfig.
+	ActiveStreams ActiveStreamsConfig
+	// OnStreamCardinalityAlert is called with Metrics.ActiveStreams when it
+	// reaches ActiveStreams.AlertThreshold, to catch a label cardinality
+	// regression early. It fires once per crossing: again only after the
+	// count fell below the threshold.
+	OnStreamCardinalityAlert func(count int)
+	// Sampler, when set, is asked about each entry of Send, SendBatchOwned
+	// and the slog handler after the entry checks. Entries it returns false
+	// for are discarded without error and counted in Metrics.Sampled.
+	// Heartbeat and self-diagnostics entries are not sampled. It is called
+	// from the sending goroutine. See RateSampler.
+	Sampler func(Entry) bool
+	// CorrectClockSkew moves the timestamp Send gives entries without one
+	// by Metrics.ClockSkew, so they follow the server clock, for example to
+	// stay within Loki's reject_old_samples window from a host whose clock
+	// lags. Timestamps set by the caller are never changed.
+	CorrectClockSkew bool
+	// BatchSequenceLabel, when set, names a label added to every stream of
+	// a pushed batch with the batch sequence number: 1 for the client's
+	// first batch, incremented per batch and kept across its retries, so a
+	// gap in Loki reveals a batch that never arrived.
+	BatchSequenceLabel string
+	// InstanceLabel, when set, names a label added to every stream of a
+	// pushed batch with the client instance ID (see Client.InstanceID), a
+	// ULID generated by NewClient.
+	InstanceLabel string
+	// BatchLabelsAsMetadata sends BatchSequenceLabel and InstanceLabel as
+	// structured metadata of each entry instead of stream labels. Labels
+	// create a new stream per batch; metadata does not.
+	BatchLabelsAsMetadata bool
+	// OnDrop, when set, is called with each entry the queue drops: a new
+	// entry rejected as DropQueueFull or DropCallbackReentry, and a queued
+	// entry evicted as DropQueueEvicted by BackpressureDropOldest, including
+	// those of SendBatchOwned. It is called on the sending goroutine after
+	// the drop is counted, holding no client lock. The entry is no longer
+	// used by the client.
+	OnDrop func(Entry, DropReason)
+	// OnDeadLetter, when set, is called once per batch that failed for
+	// good, after its final attempt, with the batch entries and the error:
+	// a non-retryable response, exhausted retries, or the Close deadline.
+	// Entries are as sent to Send, without BatchSequenceLabel and
+	// InstanceLabel, and the slice is the callback's to keep, for example to
+	// write them to a local file or a secondary sink. It is called for every
+	// push path, Push and DisableBatching included, on the goroutine that
+	// pushed. Under TenantFanout it is called when any tenant failed.
+	//
+	// Entries the server rejected within an accepted push are dead-lettered
+	// too, when PartialSuccess.Streams names their streams: once per
+	// stream, with all the batch entries of that stream, since the server
+	// does not say which of them it rejected, and a *StreamRejectedError.
+	OnDeadLetter func(entries []Entry, err error)
+	// StaleBatchSlack, when positive, enables a watchdog in the worker: a
+	// batch whose oldest entry joined it longer than MaxBatchAge (or
+	// BatchMaxWait when MaxBatchAge is zero) plus StaleBatchSlack ago is
+	// flushed, whatever the Batcher says, and counted in
+	// Metrics.ForcedFlushes. It is checked after every entry and tick the
+	// worker handles, so it bounds batches a slow flush or a Batcher that
+	// ignores ticks would keep waiting. Zero disables it.
+	StaleBatchSlack time.Duration
+	// FallbackWriter, when set, receives each batch that failed for good,
+	// and the entries of streams rejected within an accepted push, like
+	// OnDeadLetter, one line per entry: the RFC 3339 timestamp, the
+	// labels as logfmt and the line. The batch is written with a single
+	// Write call. A write is abandoned after a second, and batches failing
+	// while it is still blocked are not written; both count in
+	// Metrics.FallbackErrors. It must be safe for use from the goroutines
+	// that push.
+	FallbackWriter io.Writer
+}
+
+// AdaptiveEncoding encodes small batches as JSON, easy to inspect, and
+// large ones as protobuf, compact on the wire. Batch size is estimated from
+// line bytes, as for BatchMaxBytes.
+type AdaptiveEncoding struct {
+	Enabled bool
+	// ProtobufAboveBytes is the size above which a batch is encoded as
+	// EncodingProtobufSnappy. Defaults to 64KiB.
+	ProtobufAboveBytes int
 }
 
 func (c *Config) setDefaults() {
+	c.cloneReferences()
 	if c.HTTPClient == nil {
 		c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+		if c.DialContext != nil {
+			tr := http.DefaultTransport.(*http.Transport).Clone()
+			tr.DialContext = c.DialContext
+			c.HTTPClient.Transport = tr
+		}
 	}
 	if c.Encoding == "" {
 		c.Encoding = EncodingProtobufSnappy
 	}
+	if c.CompleteEndpointPath {
+		c.Endpoint = completePushPath(c.Endpoint, c.Encoding)
+	}
+	if c.AdaptiveEncoding.Enabled && c.AdaptiveEncoding.ProtobufAboveBytes <= 0 {
+		c.AdaptiveEncoding.ProtobufAboveBytes = 64 << 10
+	}
+	if c.ActiveStreams.Window <= 0 {
+		c.ActiveStreams.Window = time.Hour
+	}
+	if c.ActiveStreams.MaxTracked <= 0 {
+		c.ActiveStreams.MaxTracked = 100000
+	}
+	if c.StreamLargePayloads && c.StreamPayloadAboveBytes <= 0 {
+		c.StreamPayloadAboveBytes = 1 << 20
+	}
+	if (c.Encoding == EncodingProtobufSnappy || c.AdaptiveEncoding.Enabled) && c.ProtobufCompression == "" {
+		c.ProtobufCompression = ProtobufSnappyBlock
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = defaultUserAgent()
+	}
 	if c.QueueSize <= 0 {
 		c.QueueSize = 1024
 	}
@@ -76,6 +698,49 @@ func (c *Config) setDefaults() {
 	if c.BackpressureMode == "" {
 		c.BackpressureMode = BackpressureBlock
 	}
+	if c.ShardStreams.Enabled && c.ShardStreams.Label == "" {
+		c.ShardStreams.Label = defaultShardLabel
+	}
+	if c.MaxErrorBodyBytes == 0 {
+		c.MaxErrorBodyBytes = 4096
+	}
+	if c.QueueLatencySampleEvery == 0 {
+		c.QueueLatencySampleEvery = 100
+	}
+	if c.StreamOverflowPolicy == "" {
+		c.StreamOverflowPolicy = StreamOverflowSpill
+	}
+	if c.MemoryReleasePolicy.ShrinkFactor == 0 {
+		c.MemoryReleasePolicy.ShrinkFactor = 4
+	}
+	if c.MemoryReleasePolicy.MaxRetainedBytes == 0 {
+		c.MemoryReleasePolicy.MaxRetainedBytes = 8 << 20
+	}
+	if c.OversizeEntryPolicy == "" {
+		c.OversizeEntryPolicy = OversizeSendAlone
+	}
+	if c.ReservedLabelPolicy == "" {
+		c.ReservedLabelPolicy = ReservedLabelStrip
+	}
+	if c.ReservedLabelPrefix == "" {
+		c.ReservedLabelPrefix = "user"
+	}
+	if len(c.QueueClasses) > 0 {
+		// Filled on a copy, so the caller's slice is never written.
+		c.QueueClasses = slices.Clone(c.QueueClasses)
+		for i := range c.QueueClasses {
+			qc := &c.QueueClasses[i]
+			if qc.Name == "" {
+				qc.Name = qc.MinLevel.String()
+			}
+			if qc.QueueSize <= 0 {
+				qc.QueueSize = c.QueueSize
+			}
+			if qc.BackpressureMode == "" {
+				qc.BackpressureMode = c.BackpressureMode
+			}
+		}
+	}
 	if c.Retry.MaxAttempts <= 0 {
 		c.Retry.MaxAttempts = 5
 	}
@@ -88,12 +753,33 @@ func (c *Config) setDefaults() {
 	if c.Retry.JitterFrac <= 0 {
 		c.Retry.JitterFrac = 0.2
 	}
+	if c.HealthRecovery.Successes <= 0 {
+		c.HealthRecovery.Successes = 3
+	}
+}
+
+// Validate reports whether NewClient would accept c, without starting a
+// client. Defaults are applied to a copy first, so unset fields are checked
+// as NewClient would see them.
+func (c Config) Validate() error {
+	c.setDefaults()
+	if err := c.validate(); err != nil {
+		return err
+	}
+	_, err := compileRoutes(c)
+	return err
 }
 
 func (c Config) validate() error {
 	if c.Endpoint == "" {
 		return errors.New("endpoint is required")
 	}
+	if !validPushURL(c.Endpoint) {
+		return errors.New("endpoint must be an absolute http or https URL")
+	}
+	if c.ShadowEndpoint != "" && !validPushURL(c.ShadowEndpoint) {
+		return errors.New("shadowEndpoint must be an absolute http or https URL")
+	}
 	switch c.BackpressureMode {
 	case BackpressureBlock, BackpressureDropNew, BackpressureDropOldest:
 	default:
@@ -101,11 +787,292 @@ func (c Config) validate() error {
 	}
 	switch c.Encoding {
 	case EncodingJSON, EncodingProtobufSnappy:
+	case EncodingJSONLegacy:
+		if c.NegotiateEncoding {
+			return errors.New("negotiateEncoding cannot select json-legacy encoding")
+		}
 	default:
 		return errors.New("invalid encoding")
 	}
+	switch c.ProtobufCompression {
+	case "":
+	case ProtobufSnappyBlock, ProtobufSnappyFramed, ProtobufUncompressed:
+		if c.Encoding != EncodingProtobufSnappy && !c.NegotiateEncoding && !c.AdaptiveEncoding.Enabled {
+			return errors.New("protobufCompression requires protobuf encoding")
+		}
+	default:
+		return errors.New("invalid protobuf compression")
+	}
+	switch c.ReservedLabelPolicy {
+	case ReservedLabelStrip, ReservedLabelRename, ReservedLabelKeep:
+	case ReservedLabelReject:
+		for k := range c.StaticLabels {
+			if isReservedLabel(k) && !slices.Contains(c.ReservedLabelAllowList, k) {
+				return &ReservedLabelError{Key: k}
+			}
+		}
+	default:
+		return errors.New("invalid reserved label policy")
+	}
+	if c.MaxConnLifetime < 0 {
+		return errors.New("maxConnLifetime must be >= 0")
+	}
+	if c.ShardStreams.Enabled && c.ShardStreams.Shards < 2 {
+		return errors.New("shardStreams.shards must be >= 2")
+	}
+	if c.OAuth2.enabled() && (c.OAuth2.TokenURL == "" || c.OAuth2.ClientID == "") {
+		return errors.New("oauth2 requires tokenURL and clientID")
+	}
+	if err := c.validateHeaders(); err != nil {
+		return err
+	}
+	if c.MaxStreamsPerBatch < 0 || c.MaxEntriesPerStream < 0 {
+		return errors.New("maxStreamsPerBatch and maxEntriesPerStream must be >= 0")
+	}
+	if c.DedupeWindow < 0 {
+		return errors.New("dedupeWindow must be >= 0")
+	}
+	if c.QueueLatencySampleEvery < 0 {
+		return errors.New("queueLatencySampleEvery must be >= 0")
+	}
+	if c.SelfDiagnostics.Interval != 0 && c.SelfDiagnostics.Interval < minSelfDiagnosticsInterval {
+		return errors.New("selfDiagnostics.interval must be 0 or at least 1s")
+	}
+	if c.Heartbeat.Interval < 0 {
+		return errors.New("heartbeat.interval must be >= 0")
+	}
+	if c.MaxLabelValueBytes < 0 {
+		return errors.New("maxLabelValueBytes must be >= 0")
+	}
+	for _, n := range c.LabelValueLimits {
+		if n < 0 {
+			return errors.New("labelValueLimits must be >= 0")
+		}
+	}
+	if c.MaxBytesPerStreamPerBatch < 0 {
+		return errors.New("maxBytesPerStreamPerBatch must be >= 0")
+	}
+	switch c.StreamOverflowPolicy {
+	case StreamOverflowSpill, StreamOverflowDrop:
+	default:
+		return errors.New("invalid stream overflow policy")
+	}
+	if c.MemoryReleasePolicy.ShrinkFactor < 1 || c.MemoryReleasePolicy.MaxRetainedBytes < 0 {
+		return errors.New("memoryReleasePolicy: shrinkFactor must be >= 1 and maxRetainedBytes >= 0")
+	}
+	switch c.OversizeEntryPolicy {
+	case OversizeSendAlone, OversizeTruncate, OversizeDrop:
+	default:
+		return errors.New("invalid oversize entry policy")
+	}
+	for enc, ct := range c.ContentTypeOverride {
+		if enc != EncodingJSON && enc != EncodingProtobufSnappy && enc != EncodingJSONLegacy {
+			return fmt.Errorf("contentTypeOverride: unsupported encoding %q", enc)
+		}
+		if ct == "" {
+			return fmt.Errorf("contentTypeOverride: empty content type for %q", enc)
+		}
+	}
+	if c.MaxBatchAge < 0 {
+		return errors.New("maxBatchAge must be >= 0")
+	}
+	if c.StaleBatchSlack < 0 {
+		return errors.New("staleBatchSlack must be >= 0")
+	}
+	if slices.Contains(c.TenantFanout, "") {
+		return errors.New("tenantFanout entries must not be empty")
+	}
+	if c.AdaptiveEncoding.Enabled && c.NegotiateEncoding {
+		return errors.New("adaptiveEncoding and negotiateEncoding are mutually exclusive")
+	}
+	if c.ActiveStreams.AlertThreshold < 0 {
+		return errors.New("activeStreams.alertThreshold must be >= 0")
+	}
+	if c.OnStreamCardinalityAlert != nil && c.ActiveStreams.AlertThreshold == 0 {
+		return errors.New("onStreamCardinalityAlert requires activeStreams.alertThreshold")
+	}
+	for _, name := range []string{c.BatchSequenceLabel, c.InstanceLabel} {
+		if name != "" && !validLabelName(name) {
+			return fmt.Errorf("invalid batch label name %q", name)
+		}
+	}
+	if c.BatchSequenceLabel != "" && c.BatchSequenceLabel == c.InstanceLabel {
+		return errors.New("batchSequenceLabel and instanceLabel must differ")
+	}
+	if !push.ValidFormat(c.PushFormat) {
+		return fmt.Errorf("pushFormat must be empty, %q or %q", push.FormatLoki, push.FormatOTLP)
+	}
+	if c.StreamPayloadAboveBytes < 0 {
+		return errors.New("streamPayloadAboveBytes must be >= 0")
+	}
+	if c.DropNewGrace < 0 {
+		return errors.New("dropNewGrace must be >= 0")
+	}
+	if c.HealthRecovery.Window < 0 {
+		return errors.New("healthRecovery.window must be >= 0")
+	}
+	if c.ShutdownMaxDrainEntries < 0 {
+		return errors.New("shutdownMaxDrainEntries must be >= 0")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdownTimeout must be >= 0")
+	}
+	if c.FlushJitterFrac < 0 || c.FlushJitterFrac >= 1 {
+		return errors.New("flushJitterFrac must be in [0, 1)")
+	}
+	if c.RateLimit.EntriesPerSecond < 0 || c.RateLimit.BytesPerSecond < 0 || c.RateLimit.Burst < 0 {
+		return errors.New("rateLimit values must be >= 0")
+	}
 	if c.Retry.MaxAttempts < 1 {
 		return errors.New("retry.maxAttempts must be >= 1")
 	}
+	if c.Retry.InitialDelay < 0 {
+		return errors.New("retry.initialDelay must be >= 0")
+	}
+	if len(c.QueueClasses) > 0 && c.Severity == nil {
+		return errors.New("queueClasses requires severity")
+	}
+	for i, qc := range c.QueueClasses {
+		switch qc.BackpressureMode {
+		case BackpressureBlock, BackpressureDropNew, BackpressureDropOldest:
+		default:
+			return errors.New("invalid queueClasses backpressure mode")
+		}
+		for _, other := range c.QueueClasses[:i] {
+			if other.MinLevel == qc.MinLevel || other.Name == qc.Name {
+				return errors.New("queueClasses must have distinct minLevel and name")
+			}
+		}
+	}
 	return nil
 }
+
+// redactedValue replaces credentials in Config.Redacted.
+const redactedValue = "<set>"
+
+// sensitiveHeaders are header names whose values Config.Redacted hides.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// Redacted returns a copy of c that is safe to log: values of Authorization,
+// Proxy-Authorization, Cookie and X-Api-Key headers and the OAuth2 client
+// secret are replaced with "<set>", and passwords in endpoint URLs are
+// masked. Everything else, including any defaults already applied, is kept.
+// c is not modified.
+func (c Config) Redacted() Config {
+	c.Endpoint = redactURL(c.Endpoint)
+	c.ShadowEndpoint = redactURL(c.ShadowEndpoint)
+	c.OAuth2.TokenURL = redactURL(c.OAuth2.TokenURL)
+	if c.OAuth2.ClientSecret != "" {
+		c.OAuth2.ClientSecret = redactedValue
+	}
+	c.Headers = redactHeaders(c.Headers)
+	c.ShadowHeaders = redactHeaders(c.ShadowHeaders)
+	if len(c.Routes) > 0 {
+		routes := make([]Route, len(c.Routes))
+		copy(routes, c.Routes)
+		for i := range routes {
+			routes[i].Endpoint = redactURL(routes[i].Endpoint)
+		}
+		c.Routes = routes
+	}
+	return c
+}
+
+// String formats the redacted configuration.
+func (c Config) String() string {
+	type plain Config // drops the String method to avoid recursion
+	return fmt.Sprintf("%+v", plain(c.Redacted()))
+}
+
+// Warnings reports settings NewClient accepts that likely do not behave as
+// intended. NewClient logs them to DebugLogger.
+func (c Config) Warnings() []string {
+	c.setDefaults()
+	var out []string
+	if c.Encoding == EncodingJSONLegacy {
+		out = append(out, "json-legacy encoding does not support structured metadata; Entry.Metadata and QueueLatencyMetadata are dropped")
+	}
+	if c.PushFormat != "" && c.Encoding != EncodingProtobufSnappy && !c.NegotiateEncoding && !c.AdaptiveEncoding.Enabled {
+		out = append(out, "PushFormat only applies to protobuf batches and has no effect with encoding "+string(c.Encoding))
+	}
+	if c.StreamLargePayloads && c.Encoding != EncodingJSON && !c.NegotiateEncoding && !c.AdaptiveEncoding.Enabled {
+		out = append(out, "StreamLargePayloads only applies to json batches and has no effect with encoding "+string(c.Encoding))
+	}
+	if c.StreamLargePayloads && (len(c.PushInterceptors) > 0 || c.ShadowEndpoint != "") {
+		out = append(out, "StreamLargePayloads has no effect with PushInterceptors or a ShadowEndpoint, which need the encoded batch")
+	}
+	if c.BatchSequenceLabel != "" && !c.BatchLabelsAsMetadata {
+		out = append(out, "BatchSequenceLabel as a stream label creates new streams on every batch; consider BatchLabelsAsMetadata")
+	}
+	if c.BatchLabelsAsMetadata && c.Encoding == EncodingJSONLegacy {
+		out = append(out, "BatchLabelsAsMetadata has no effect with json-legacy encoding, which drops structured metadata")
+	}
+	return out
+}
+
+// cloneReferences gives c its own copies of the maps and slices it holds,
+// leaving the caller's untouched by later defaulting and unaffected by
+// later caller edits.
+func (c *Config) cloneReferences() {
+	c.Headers = maps.Clone(c.Headers)
+	c.StaticLabels = maps.Clone(c.StaticLabels)
+	c.ShadowHeaders = maps.Clone(c.ShadowHeaders)
+	c.EmptyStreamLabels = maps.Clone(c.EmptyStreamLabels)
+	c.LabelValueLimits = maps.Clone(c.LabelValueLimits)
+	c.ContentTypeOverride = maps.Clone(c.ContentTypeOverride)
+	c.Heartbeat.Labels = maps.Clone(c.Heartbeat.Labels)
+	c.SelfDiagnostics.Labels = maps.Clone(c.SelfDiagnostics.Labels)
+	c.ReservedLabelAllowList = slices.Clone(c.ReservedLabelAllowList)
+	c.PushInterceptors = slices.Clone(c.PushInterceptors)
+	c.TenantFanout = slices.Clone(c.TenantFanout)
+	c.QueueClasses = slices.Clone(c.QueueClasses)
+	c.OAuth2.Scopes = slices.Clone(c.OAuth2.Scopes)
+	c.Routes = slices.Clone(c.Routes)
+	for i := range c.Routes {
+		c.Routes[i].Match = maps.Clone(c.Routes[i].Match)
+		c.Routes[i].MatchRegex = maps.Clone(c.Routes[i].MatchRegex)
+	}
+}
+
+// completePushPath appends the push path of enc to raw when raw is a URL
+// without a path.
+func completePushPath(raw string, enc Encoding) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return raw
+	}
+	u.Path = "/loki/api/v1/push"
+	if enc == EncodingJSONLegacy {
+		u.Path = "/api/prom/push"
+	}
+	return u.String()
+}
+
+// validPushURL reports whether raw is an absolute http(s) URL with a host.
+// Callers must not echo raw in errors, since it may carry credentials.
+func validPushURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func redactHeaders(h map[string]string) map[string]string {
+	if h == nil {
+		return nil
+	}
+	out := make(map[string]string, len(h))
+	for k, v := range h {
+		if slices.Contains(sensitiveHeaders, http.CanonicalHeaderKey(k)) {
+			v = redactedValue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	return u.Redacted()
+}
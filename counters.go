@@ -0,0 +1,47 @@
+package lokigo
+
+import "sync"
+
+// keyedCounts is a set of counters keyed by a small enum, such as
+// DropReason. The zero value is ready to use.
+type keyedCounts[K comparable] struct {
+	mu sync.Mutex
+	m  map[K]uint64
+}
+
+func (k *keyedCounts[K]) add(key K, n uint64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.m == nil {
+		k.m = map[K]uint64{}
+	}
+	k.m[key] += n
+}
+
+// snapshot returns a copy of the counts, zeroing them when reset is true.
+func (k *keyedCounts[K]) snapshot(reset bool) map[K]uint64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	out := make(map[K]uint64, len(k.m))
+	for key, v := range k.m {
+		out[key] = v
+	}
+	if reset {
+		k.m = nil
+	}
+	return out
+}
+
+// addBounded is add, except that once the set holds max keys, counts for
+// new keys go to overflow instead.
+func (k *keyedCounts[K]) addBounded(key K, n uint64, max int, overflow K) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.m == nil {
+		k.m = map[K]uint64{}
+	}
+	if _, ok := k.m[key]; !ok && len(k.m) >= max {
+		key = overflow
+	}
+	k.m[key] += n
+}
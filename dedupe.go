@@ -0,0 +1,83 @@
+package lokigo
+
+import (
+	"fmt"
+	"time"
+)
+
+// dedupeMaxStreams bounds the number of streams tracked by the deduper. When
+// exceeded, all pending summaries are emitted and tracking starts over.
+const dedupeMaxStreams = 1024
+
+// deduper collapses consecutive identical lines within a stream. The first
+// occurrence is passed through immediately; later identical lines within the
+// window are counted and emitted as a single "<line> (repeated N times)"
+// entry once the window closes or a different line arrives on that stream.
+//
+// It is owned by the worker goroutine and is not safe for concurrent use.
+type deduper struct {
+	window time.Duration
+	states map[string]*dedupeState
+}
+
+type dedupeState struct {
+	first   Entry
+	last    Entry
+	repeats int
+}
+
+func newDeduper(window time.Duration) *deduper {
+	if window <= 0 {
+		return nil
+	}
+	return &deduper{window: window, states: map[string]*dedupeState{}}
+}
+
+// offer appends the entries to batch in place of e to out. It returns the
+// extended slice and whether e was folded into a pending summary.
+func (d *deduper) offer(key string, e Entry, out []Entry) ([]Entry, bool) {
+	st, ok := d.states[key]
+	if ok && st.first.Line == e.Line && e.Timestamp.Sub(st.first.Timestamp) < d.window {
+		st.repeats++
+		st.last = e
+		return out, true
+	}
+	if ok {
+		out = st.appendSummary(out)
+	} else if len(d.states) >= dedupeMaxStreams {
+		out = d.drain(out)
+	}
+	d.states[key] = &dedupeState{first: e}
+	return append(out, e), false
+}
+
+// expire emits summaries of streams whose window has closed by now.
+func (d *deduper) expire(now time.Time, out []Entry) []Entry {
+	for key, st := range d.states {
+		if now.Sub(st.first.Timestamp) >= d.window {
+			out = st.appendSummary(out)
+			delete(d.states, key)
+		}
+	}
+	return out
+}
+
+// drain emits all pending summaries and forgets every stream.
+func (d *deduper) drain(out []Entry) []Entry {
+	for key, st := range d.states {
+		out = st.appendSummary(out)
+		delete(d.states, key)
+	}
+	return out
+}
+
+func (st *dedupeState) appendSummary(out []Entry) []Entry {
+	if st.repeats == 0 {
+		return out
+	}
+	return append(out, Entry{
+		Timestamp: st.last.Timestamp,
+		Line:      fmt.Sprintf("%s (repeated %d times)", st.first.Line, st.repeats),
+		Labels:    st.first.Labels,
+	})
+}
@@ -0,0 +1,136 @@
+package lokigo
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func offerAll(d *deduper, entries []Entry) []Entry {
+	var out []Entry
+	for _, e := range entries {
+		out, _ = d.offer(toLokiLabelSet(e.Labels), e, out)
+	}
+	return out
+}
+
+func lines(entries []Entry) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Labels["s"]+":"+e.Line)
+	}
+	return out
+}
+
+func TestDeduperOnlyCollapsesWithinSameStream(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	a := map[string]string{"s": "a"}
+	b := map[string]string{"s": "b"}
+	d := newDeduper(time.Minute)
+
+	out := offerAll(d, []Entry{
+		{Timestamp: base, Line: "boom", Labels: a},
+		{Timestamp: base.Add(1 * time.Second), Line: "boom", Labels: b},
+		{Timestamp: base.Add(2 * time.Second), Line: "boom", Labels: a},
+		{Timestamp: base.Add(3 * time.Second), Line: "boom", Labels: b},
+		{Timestamp: base.Add(4 * time.Second), Line: "boom", Labels: a},
+	})
+	if got := lines(out); len(got) != 2 || got[0] != "a:boom" || got[1] != "b:boom" {
+		t.Fatalf("expected only first occurrences to pass through, got %v", got)
+	}
+
+	summaries := d.drain(nil)
+	got := lines(summaries)
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "a:boom (repeated 2 times)" || got[1] != "b:boom (repeated 1 times)" {
+		t.Fatalf("unexpected summaries: %v", got)
+	}
+	for _, s := range summaries {
+		if s.Labels["s"] == "a" && !s.Timestamp.Equal(base.Add(4*time.Second)) {
+			t.Fatalf("expected summary to carry the last repeat's timestamp, got %v", s.Timestamp)
+		}
+	}
+}
+
+func TestDeduperDifferentLineEmitsSummaryFirst(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	a := map[string]string{"s": "a"}
+	d := newDeduper(time.Minute)
+
+	out := offerAll(d, []Entry{
+		{Timestamp: base, Line: "x", Labels: a},
+		{Timestamp: base, Line: "x", Labels: a},
+		{Timestamp: base, Line: "x", Labels: a},
+		{Timestamp: base, Line: "y", Labels: a},
+	})
+	got := lines(out)
+	want := []string{"a:x", "a:x (repeated 2 times)", "a:y"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+	if rest := d.drain(nil); len(rest) != 0 {
+		t.Fatalf("expected no pending summaries, got %v", lines(rest))
+	}
+}
+
+func TestDeduperWindowExpiry(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	a := map[string]string{"s": "a"}
+	d := newDeduper(time.Second)
+
+	out := offerAll(d, []Entry{
+		{Timestamp: base, Line: "x", Labels: a},
+		{Timestamp: base.Add(500 * time.Millisecond), Line: "x", Labels: a},
+		{Timestamp: base.Add(2 * time.Second), Line: "x", Labels: a},
+	})
+	if got := lines(out); len(got) != 3 || got[1] != "a:x (repeated 1 times)" {
+		t.Fatalf("expected window expiry to start a new run, got %v", got)
+	}
+
+	if got := d.expire(base.Add(2500*time.Millisecond), nil); len(got) != 0 {
+		t.Fatalf("expected window still open, got %v", lines(got))
+	}
+	_, _ = d.offer(toLokiLabelSet(a), Entry{Timestamp: base.Add(2600 * time.Millisecond), Line: "x", Labels: a}, nil)
+	if got := lines(d.expire(base.Add(3*time.Second), nil)); len(got) != 1 || got[0] != "a:x (repeated 1 times)" {
+		t.Fatalf("expected summary on window close, got %v", got)
+	}
+}
+
+func TestDedupeWindowCountsSuppressedEntries(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	var deduplicated atomic.Uint64
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		BatchMaxWait:    time.Minute,
+		BatchMaxEntries: 100,
+		DedupeWindow:    time.Minute,
+		OnFlush:         func(m Metrics) { deduplicated.Store(m.Deduplicated) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := c.Send(context.Background(), Entry{Line: "stuck"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := streams()
+	if len(got) != 1 || got[0].entries != 2 {
+		t.Fatalf("expected first line plus one summary, got %+v", got)
+	}
+	if n := deduplicated.Load(); n != 49 {
+		t.Fatalf("expected 49 deduplicated entries, got %d", n)
+	}
+}
@@ -0,0 +1,53 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrNoDefaultClient is returned by the package-level functions when no
+// default client is set.
+var ErrNoDefaultClient = errors.New("lokigo: no default client set; call SetDefault first")
+
+var defaultClient atomic.Pointer[Client]
+
+// SetDefault makes c the client used by the package-level Send and by
+// adapters created without a client, such as NewDefaultSlogHandler. It is
+// safe to call concurrently with them; nil unsets the default.
+func SetDefault(c *Client) {
+	defaultClient.Store(c)
+}
+
+// Default returns the default client, or nil when none is set.
+func Default() *Client {
+	return defaultClient.Load()
+}
+
+// Send sends e through the default client.
+func Send(ctx context.Context, e Entry) error {
+	c := Default()
+	if c == nil {
+		return ErrNoDefaultClient
+	}
+	return c.Send(ctx, e)
+}
+
+// Flush flushes the default client. See Client.Flush.
+func Flush(ctx context.Context) error {
+	c := Default()
+	if c == nil {
+		return ErrNoDefaultClient
+	}
+	return c.Flush(ctx)
+}
+
+// CloseDefault unsets the default client and closes it. Sends racing it
+// either reach the closing client or return ErrNoDefaultClient.
+func CloseDefault(ctx context.Context) error {
+	c := defaultClient.Swap(nil)
+	if c == nil {
+		return ErrNoDefaultClient
+	}
+	return c.Close(ctx)
+}
@@ -0,0 +1,97 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDefaultClientSendAndClose(t *testing.T) {
+	t.Cleanup(func() { SetDefault(nil) })
+	SetDefault(nil)
+	if err := Send(context.Background(), Entry{Line: "x"}); !errors.Is(err, ErrNoDefaultClient) {
+		t.Fatalf("Send without a default = %v, want ErrNoDefaultClient", err)
+	}
+	logger := slog.New(NewDefaultSlogHandler())
+	if err := logger.Handler().Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "early", 0)); !errors.Is(err, ErrNoDefaultClient) {
+		t.Fatalf("Handle without a default = %v, want ErrNoDefaultClient", err)
+	}
+
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefault(c)
+	if Default() != c {
+		t.Fatal("Default did not return the client set")
+	}
+	if err := Send(context.Background(), Entry{Line: "direct"}); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("through slog")
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(streams()); n == 0 {
+		t.Fatal("package-level Flush pushed nothing")
+	}
+	if err := CloseDefault(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if Default() != nil {
+		t.Fatal("CloseDefault left the default set")
+	}
+	var n int
+	for _, s := range streams() {
+		n += s.entries
+	}
+	if n != 2 {
+		t.Fatalf("%d entries pushed, want 2", n)
+	}
+	if err := CloseDefault(context.Background()); !errors.Is(err, ErrNoDefaultClient) {
+		t.Fatalf("second CloseDefault = %v, want ErrNoDefaultClient", err)
+	}
+}
+
+// TestSetDefaultRacesSend swaps the default client while sending through it.
+// Run it under -race.
+func TestSetDefaultRacesSend(t *testing.T) {
+	t.Cleanup(func() { SetDefault(nil) })
+	srv, _ := captureJSONStreams(t)
+	var clients []*Client
+	for range 2 {
+		c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close(context.Background())
+		clients = append(clients, c)
+	}
+	SetDefault(nil)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				err := Send(context.Background(), Entry{Line: "x"})
+				if err != nil && !errors.Is(err, ErrNoDefaultClient) {
+					t.Errorf("Send: %v", err)
+				}
+			}
+		}()
+	}
+	for i := range 200 {
+		if i%3 == 2 {
+			SetDefault(nil)
+		} else {
+			SetDefault(clients[i%2])
+		}
+	}
+	wg.Wait()
+}
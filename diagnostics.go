@@ -0,0 +1,87 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"runtime/metrics"
+	"time"
+)
+
+// SelfDiagnosticsLabel marks self-diagnostics entries unless
+// SelfDiagnostics.Labels sets it.
+const SelfDiagnosticsLabel = "lokigo_diagnostics"
+
+// minSelfDiagnosticsInterval is the shortest SelfDiagnostics.Interval.
+const minSelfDiagnosticsInterval = time.Second
+
+// SelfDiagnosticsConfig makes the client emit an entry every Interval on a
+// stream of its own, carrying its Metrics and Go runtime stats as a JSON
+// line, to debug the logging pipeline itself. Like heartbeats, these
+// entries skip Send and its checks. The zero value disables it.
+type SelfDiagnosticsConfig struct {
+	// Interval must be at least one second. Ticks delivered sooner than
+	// Interval after the last entry are skipped.
+	Interval time.Duration
+	// Labels are the diagnostics stream labels, on top of StaticLabels.
+	// SelfDiagnosticsLabel="true" is added unless Labels sets it.
+	Labels map[string]string
+}
+
+// diagnosticsLine is the JSON line of a self-diagnostics entry.
+type diagnosticsLine struct {
+	Metrics  Metrics      `json:"metrics"`
+	QueueLen int          `json:"queue_len"`
+	Runtime  runtimeStats `json:"runtime"`
+}
+
+type runtimeStats struct {
+	Goroutines     uint64 `json:"goroutines"`
+	HeapInUseBytes uint64 `json:"heap_in_use_bytes"`
+	HeapObjects    uint64 `json:"heap_objects"`
+	GCCycles       uint64 `json:"gc_cycles"`
+}
+
+// runtimeSamples are read with runtime/metrics, which unlike
+// runtime.ReadMemStats does not stop the world.
+var runtimeSamples = []string{
+	"/sched/goroutines:goroutines",
+	"/memory/classes/heap/objects:bytes",
+	"/gc/heap/objects:objects",
+	"/gc/cycles/total:gc-cycles",
+}
+
+func readRuntimeStats() runtimeStats {
+	samples := make([]metrics.Sample, len(runtimeSamples))
+	for i, name := range runtimeSamples {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+	v := func(i int) uint64 {
+		if samples[i].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return samples[i].Value.Uint64()
+	}
+	return runtimeStats{Goroutines: v(0), HeapInUseBytes: v(1), HeapObjects: v(2), GCCycles: v(3)}
+}
+
+// startSelfDiagnostics emits a diagnostics entry for every tick at least
+// sd.Interval after the last one, until ctx is done.
+func (c *Client) startSelfDiagnostics(ctx context.Context, sd SelfDiagnosticsConfig, ticks <-chan time.Time, stop func()) {
+	labels := mergeLabels(sd.Labels, nil)
+	if _, ok := labels[SelfDiagnosticsLabel]; !ok {
+		labels[SelfDiagnosticsLabel] = "true"
+	}
+	var last time.Time
+	c.startTicking(ctx, ticks, stop, func(now time.Time) (Entry, bool) {
+		if !last.IsZero() && now.Sub(last) < sd.Interval {
+			return Entry{}, false
+		}
+		last = now
+		line, err := json.Marshal(diagnosticsLine{Metrics: c.Metrics(), QueueLen: c.QueueLen(), Runtime: readRuntimeStats()})
+		if err != nil {
+			return Entry{}, false
+		}
+		return Entry{Timestamp: now.UTC(), Line: string(line), Labels: labels}, true
+	})
+}
@@ -0,0 +1,66 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSelfDiagnosticsCadenceAndLine(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := make(chan time.Time)
+	c.startSelfDiagnostics(ctx, SelfDiagnosticsConfig{Interval: time.Minute, Labels: map[string]string{"job": "diag"}}, ticks, func() {})
+
+	// Ticks closer than the interval to the last entry are skipped.
+	base := time.Unix(1700000000, 0)
+	for _, d := range []time.Duration{0, 30 * time.Second, time.Minute, 61 * time.Second, 2 * time.Minute} {
+		ticks <- base.Add(d)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Metrics().Pushed != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pushed %d diagnostics entries, want 3", c.Metrics().Pushed)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := rec.entries()
+	if len(got) != 3 {
+		t.Fatalf("got %d entries, want 3", len(got))
+	}
+	for i, want := range []time.Duration{0, time.Minute, 2 * time.Minute} {
+		e := got[i]
+		if e.labels["job"] != "diag" || e.labels[SelfDiagnosticsLabel] != "true" {
+			t.Fatalf("entry %d labels = %v", i, e.labels)
+		}
+		if e.ts != strconv.FormatInt(base.Add(want).UnixNano(), 10) {
+			t.Fatalf("entry %d at %s, want %d", i, e.ts, base.Add(want).UnixNano())
+		}
+		var line diagnosticsLine
+		if err := json.Unmarshal([]byte(e.line), &line); err != nil {
+			t.Fatalf("entry %d line %q: %v", i, e.line, err)
+		}
+		if line.Runtime.Goroutines == 0 || line.Runtime.HeapInUseBytes == 0 || line.Metrics.Pushed > uint64(i) {
+			t.Fatalf("entry %d line = %+v", i, line)
+		}
+	}
+}
+
+func TestSelfDiagnosticsIntervalBounded(t *testing.T) {
+	err := Config{Endpoint: "http://loki", SelfDiagnostics: SelfDiagnosticsConfig{Interval: time.Millisecond}}.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted a 1ms self-diagnostics interval")
+	}
+}
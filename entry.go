@@ -0,0 +1,89 @@
+package lokigo
+
+import "time"
+
+// EntryOption sets a field of an Entry built by NewEntry. Unlike
+// SlogHandlerOption it is a plain value rather than a closure, so building
+// an entry allocates nothing beyond its maps.
+type EntryOption struct {
+	kind entryOptionKind
+	k, v string
+	t    time.Time
+	m    map[string]string
+}
+
+type entryOptionKind uint8
+
+const (
+	optTime entryOptionKind = iota + 1
+	optLabel
+	optLabels
+	optMetadata
+	optTenant
+)
+
+// smallLabelMap is the size hint of label and metadata maps created by
+// WithLabel and WithMetadata, which covers most entries without regrowth.
+const smallLabelMap = 4
+
+// NewEntry returns an Entry with line and opts applied in order. Maps are
+// only allocated once an option adds to them. A zero timestamp is set by
+// Send, as for hand-built entries.
+func NewEntry(line string, opts ...EntryOption) Entry {
+	e := Entry{Line: line}
+	for i := range opts {
+		opt := &opts[i]
+		switch opt.kind {
+		case optTime:
+			e.Timestamp = opt.t
+		case optLabel:
+			if e.Labels == nil {
+				e.Labels = make(map[string]string, smallLabelMap)
+			}
+			e.Labels[opt.k] = opt.v
+		case optLabels:
+			if e.Labels == nil {
+				e.Labels = opt.m
+				continue
+			}
+			for k, v := range opt.m {
+				e.Labels[k] = v
+			}
+		case optMetadata:
+			if e.Metadata == nil {
+				e.Metadata = make(map[string]string, smallLabelMap)
+			}
+			e.Metadata[opt.k] = opt.v
+		case optTenant:
+			e.TenantID = opt.v
+		}
+	}
+	return e
+}
+
+// WithTime sets the entry timestamp.
+func WithTime(t time.Time) EntryOption {
+	return EntryOption{kind: optTime, t: t}
+}
+
+// WithLabel sets the label k to v.
+func WithLabel(k, v string) EntryOption {
+	return EntryOption{kind: optLabel, k: k, v: v}
+}
+
+// WithLabels sets the labels of m. When the entry has no labels yet, m
+// itself becomes its label map, without a copy, and later WithLabel options
+// write to it; the entry owns m from then on.
+func WithLabels(m map[string]string) EntryOption {
+	return EntryOption{kind: optLabels, m: m}
+}
+
+// WithMetadata sets the structured metadata k to v.
+func WithMetadata(k, v string) EntryOption {
+	return EntryOption{kind: optMetadata, k: k, v: v}
+}
+
+// WithTenant sets Entry.TenantID.
+func WithTenant(id string) EntryOption {
+	return EntryOption{kind: optTenant, v: id}
+}
@@ -0,0 +1,73 @@
+package lokigo
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewEntry(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	if e := NewEntry("bare"); e.Labels != nil || e.Metadata != nil || !e.Timestamp.IsZero() {
+		t.Fatalf("NewEntry without options = %+v, want no maps and a zero time", e)
+	}
+
+	shared := map[string]string{"service": "api"}
+	e := NewEntry("hello",
+		WithTime(ts),
+		WithLabels(shared),
+		WithLabel("env", "prod"),
+		WithMetadata("trace_id", "abc"),
+		WithLabels(map[string]string{"env": "dev", "zone": "a"}),
+		WithTenant("team-a"),
+	)
+	want := Entry{
+		Timestamp: ts,
+		Line:      "hello",
+		Labels:    map[string]string{"service": "api", "env": "dev", "zone": "a"},
+		Metadata:  map[string]string{"trace_id": "abc"},
+		TenantID:  "team-a",
+	}
+	if !reflect.DeepEqual(e, want) {
+		t.Fatalf("NewEntry = %+v, want %+v", e, want)
+	}
+	// The first WithLabels map is adopted, not copied.
+	if !maps.Equal(shared, e.Labels) {
+		t.Fatalf("adopted map = %v, want the entry labels %v", shared, e.Labels)
+	}
+}
+
+func TestEntryTenantIDOverridesPushTenant(t *testing.T) {
+	var mu sync.Mutex
+	tenants := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tenants[r.Header.Get("X-Scope-OrgID")]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, TenantID: "base", BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []Entry{NewEntry("a"), NewEntry("b", WithTenant("team-a")), NewEntry("c", WithTenant("team-a"))} {
+		if err := c.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[string]int{"base": 1, "team-a": 1}; !maps.Equal(tenants, want) {
+		t.Fatalf("pushes by tenant = %v, want %v", tenants, want)
+	}
+}
@@ -60,3 +60,15 @@ func ExampleNewClient_hostedAuthHeader() {
 
 	slog.New(slog.NewTextHandler(os.Stdout, nil)).Info("configured client for hosted Loki")
 }
+
+func ExampleExportNDJSON() {
+	entries := []lokigo.Entry{{
+		Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Labels:    map[string]string{"app": "api"},
+		Line:      "request failed",
+	}}
+	// Dump entries Loki could not take, to ship them later with
+	// ImportNDJSON and SendBatchOwned.
+	_ = lokigo.ExportNDJSON(os.Stdout, entries)
+	// Output: {"ts":"2024-01-01T12:00:00Z","labels":{"app":"api"},"line":"request failed"}
+}
@@ -0,0 +1,102 @@
+package lokigo
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fallbackWriteTimeout bounds how long a push waits for FallbackWriter.
+const fallbackWriteTimeout = time.Second
+
+var (
+	errFallbackTimeout = errors.New("fallback write timed out")
+	errFallbackBusy    = errors.New("fallback writer still blocked in an earlier write")
+)
+
+// fallbackSink writes failed batches to Config.FallbackWriter without
+// letting a blocked writer hold up pushes: a write taking longer than
+// fallbackWriteTimeout is abandoned, and batches failing while it is still
+// blocked are not written.
+type fallbackSink struct {
+	w    io.Writer
+	busy atomic.Bool
+}
+
+// newFallbackSink returns the sink of w, or nil when w is nil.
+func newFallbackSink(w io.Writer) *fallbackSink {
+	if w == nil {
+		return nil
+	}
+	return &fallbackSink{w: w}
+}
+
+// write writes p with a single Write call.
+func (s *fallbackSink) write(p []byte) error {
+	if !s.busy.CompareAndSwap(false, true) {
+		return errFallbackBusy
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.w.Write(p)
+		s.busy.Store(false)
+		done <- err
+	}()
+	timer := time.NewTimer(fallbackWriteTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errFallbackTimeout
+	}
+}
+
+// writeFallback writes entries to FallbackWriter, one line each, counting a
+// failed write in Metrics.FallbackErrors.
+func (c *Client) writeFallback(entries []Entry) {
+	if c.fallback == nil {
+		return
+	}
+	if err := c.fallback.write(c.appendFallback(nil, entries)); err != nil {
+		c.fallbackErrors.Add(1)
+		c.debug("fallback write failed", "entries", len(entries), "error", err)
+	}
+}
+
+// appendFallback appends the fallback lines of entries to b: the RFC 3339
+// timestamp, the labels as logfmt in key order and the line, quoted when
+// it spans several lines.
+func (c *Client) appendFallback(b []byte, entries []Entry) []byte {
+	for _, e := range entries {
+		b = e.Timestamp.UTC().AppendFormat(b, time.RFC3339Nano)
+		labels := c.mergedLabels(e)
+		for _, k := range slices.Sorted(maps.Keys(labels)) {
+			b = append(b, ' ')
+			b = append(b, k...)
+			b = append(b, '=')
+			b = appendLogfmtValue(b, labels[k])
+		}
+		b = append(b, ' ')
+		if strings.ContainsAny(e.Line, "\r\n") {
+			b = strconv.AppendQuote(b, e.Line)
+		} else {
+			b = append(b, e.Line...)
+		}
+		b = append(b, '\n')
+	}
+	return b
+}
+
+// appendLogfmtValue appends v, quoted when logfmt requires it.
+func appendLogfmtValue(b []byte, v string) []byte {
+	if v == "" || strings.ContainsFunc(v, func(r rune) bool { return r <= ' ' || r == '=' || r == '"' || r >= 0x7f }) {
+		return strconv.AppendQuote(b, v)
+	}
+	return append(b, v...)
+}
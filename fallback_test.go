@@ -0,0 +1,84 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFallbackWriterReceivesBatchWhenServerIsDown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	var buf bytes.Buffer
+	c, err := NewClient(Config{
+		Endpoint:       url,
+		StaticLabels:   map[string]string{"job": "api"},
+		BatchMaxWait:   time.Hour,
+		Retry:          RetryConfig{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		FallbackWriter: &buf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	for _, e := range []Entry{
+		{Timestamp: ts, Labels: map[string]string{"level": "error"}, Line: "request failed"},
+		{Timestamp: ts, Labels: map[string]string{"msg": "two words"}, Line: "first\nsecond"},
+	} {
+		if err := c.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err == nil {
+		t.Fatal("expected Close to report the failed push")
+	}
+	want := "2026-01-02T03:04:05.0000006Z job=api level=error request failed\n" +
+		"2026-01-02T03:04:05.0000006Z job=api msg=\"two words\" \"first\\nsecond\"\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("fallback got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := c.Metrics().FallbackErrors; got != 0 {
+		t.Fatalf("FallbackErrors = %d, want 0", got)
+	}
+}
+
+// blockingWriter blocks every Write until release is closed.
+type blockingWriter struct{ release chan struct{} }
+
+func (w blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestFallbackWriterDoesNotBlockPushes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	w := blockingWriter{release: make(chan struct{})}
+	defer close(w.release)
+	c, err := NewClient(Config{Endpoint: srv.URL, DisableBatching: true, FallbackWriter: w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	start := time.Now()
+	for range 2 {
+		if err := c.Send(context.Background(), Entry{Line: "x"}); err == nil {
+			t.Fatal("expected the push error")
+		}
+	}
+	// The first write times out; the second finds the writer still blocked.
+	if elapsed := time.Since(start); elapsed > fallbackWriteTimeout+time.Second {
+		t.Fatalf("pushes took %v with a blocked fallback writer", elapsed)
+	}
+	if got := c.Metrics().FallbackErrors; got != 2 {
+		t.Fatalf("FallbackErrors = %d, want 2", got)
+	}
+}
@@ -0,0 +1,78 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTenantFanoutPushesOncePerTenant(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get("X-Scope-OrgID")
+		mu.Lock()
+		requests[tenant]++
+		mu.Unlock()
+		if tenant == "broken" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var statsMu sync.Mutex
+	var stats []FlushStats
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		TenantID:        "old",
+		TenantFanout:    []string{"old", "new", "broken"},
+		BatchMaxEntries: 2,
+		BatchMaxWait:    time.Minute,
+		Retry:           RetryConfig{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		OnFlushStats: func(s FlushStats) {
+			statsMu.Lock()
+			stats = append(stats, s)
+			statsMu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = c.Close(context.Background())
+	var tenantErr *TenantPushError
+	if !errors.As(err, &tenantErr) || tenantErr.TenantID != "broken" {
+		t.Fatalf("expected only the broken tenant to fail, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests["old"] != 2 || requests["new"] != 2 || requests["broken"] != 4 || len(requests) != 3 {
+		t.Fatalf("expected one request per tenant per batch (retried for broken), got %v", requests)
+	}
+	m := c.Metrics()
+	if m.PushedByTenant["old"] != 4 || m.PushedByTenant["new"] != 4 || m.PushErrorsByTenant["broken"] != 8 {
+		t.Fatalf("unexpected per-tenant metrics: %+v", m)
+	}
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	var tenants []string
+	for _, s := range stats {
+		tenants = append(tenants, s.TenantID)
+	}
+	sort.Strings(tenants)
+	if len(tenants) != 6 || tenants[0] != "broken" || tenants[2] != "new" || tenants[4] != "old" {
+		t.Fatalf("expected FlushStats per tenant and batch, got %v", tenants)
+	}
+}
@@ -0,0 +1,72 @@
+package lokigo
+
+import (
+	"cmp"
+	"context"
+	"time"
+)
+
+// defaultSyncTimeout bounds Sync when ShutdownTimeout is unset.
+const defaultSyncTimeout = 10 * time.Second
+
+// flushRequest asks the worker for an explicit flush; the worker sends its
+// result on done, which has room for it.
+type flushRequest struct {
+	ctx  context.Context
+	done chan error
+}
+
+// flush has the worker push the entries queued so far and the batch it is
+// assembling, and returns their push errors. Pushes run under ctx, on top
+// of the worker's own context, so they also stop when Close gives up. It
+// returns nil at once with DisableBatching or once the worker returned, as
+// nothing is left to push then.
+func (c *Client) flush(ctx context.Context) error {
+	if c.cfg.DisableBatching {
+		return nil
+	}
+	req := flushRequest{ctx: ctx, done: make(chan error, 1)}
+	select {
+	case c.flushReq <- req:
+	case <-c.workerDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush pushes the entries queued so far and the batch being assembled,
+// with the usual retries, and returns their push errors joined, without
+// stopping the client; for example before a long sleep or at a checkpoint.
+// The flush runs on the background worker, between its own flushes, so
+// entries are pushed once and in order. When ctx has no deadline,
+// ShutdownTimeout bounds it as for Close; once ctx is done, pushes in
+// progress are interrupted and ctx's error is returned. Entries sent while
+// Flush runs may be left for a later flush. It is safe for concurrent use
+// and returns ErrClosed once Close was called.
+func (c *Client) Flush(ctx context.Context) error {
+	if c.shutdown.closing.Load() {
+		return ErrClosed
+	}
+	if _, ok := ctx.Deadline(); !ok && c.cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	return c.flush(ctx)
+}
+
+// Sync is Flush for frameworks that flush loggers through a Sync() error
+// method, such as zap's WriteSyncer. It is bounded by ShutdownTimeout, or
+// 10s when that is unset.
+func (c *Client) Sync() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(c.cfg.ShutdownTimeout, defaultSyncTimeout))
+	defer cancel()
+	return c.Flush(ctx)
+}
@@ -0,0 +1,29 @@
+package lokigo
+
+import (
+	"math/rand"
+	"time"
+)
+
+// flushInterval returns the next periodic flush interval: BatchMaxWait
+// scaled by a uniform factor in [1-FlushJitterFrac, 1+FlushJitterFrac].
+func flushInterval(cfg Config, rng *rand.Rand) time.Duration {
+	if cfg.FlushJitterFrac <= 0 {
+		return cfg.BatchMaxWait
+	}
+	factor := 1 + (rng.Float64()*2-1)*cfg.FlushJitterFrac
+	d := time.Duration(float64(cfg.BatchMaxWait) * factor)
+	if d <= 0 {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// firstFlushDelay returns the delay before the first periodic flush. With
+// FlushStagger it is a uniform offset in (0, BatchMaxWait].
+func firstFlushDelay(cfg Config, rng *rand.Rand) time.Duration {
+	if !cfg.FlushStagger {
+		return flushInterval(cfg, rng)
+	}
+	return time.Duration(rng.Int63n(int64(cfg.BatchMaxWait))) + 1
+}
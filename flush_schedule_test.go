@@ -0,0 +1,72 @@
+package lokigo
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestFlushIntervalJitterStaysWithinBounds(t *testing.T) {
+	cfg := Config{BatchMaxWait: time.Second, FlushJitterFrac: 0.2}
+	rng := rand.New(rand.NewSource(42))
+
+	const n = 10000
+	var sum time.Duration
+	minSeen, maxSeen := time.Hour, time.Duration(0)
+	distinct := map[time.Duration]struct{}{}
+	for i := 0; i < n; i++ {
+		d := flushInterval(cfg, rng)
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("interval %v outside ±20%% of 1s", d)
+		}
+		sum += d
+		minSeen = min(minSeen, d)
+		maxSeen = max(maxSeen, d)
+		distinct[d] = struct{}{}
+	}
+	if mean := sum / n; mean < 990*time.Millisecond || mean > 1010*time.Millisecond {
+		t.Fatalf("expected mean interval near 1s, got %v", mean)
+	}
+	if minSeen > 820*time.Millisecond || maxSeen < 1180*time.Millisecond {
+		t.Fatalf("expected jitter to span the range, got [%v, %v]", minSeen, maxSeen)
+	}
+	if len(distinct) < n/2 {
+		t.Fatalf("expected interval to be re-randomized per tick, got %d distinct values", len(distinct))
+	}
+}
+
+func TestFlushIntervalWithoutJitterIsFixed(t *testing.T) {
+	cfg := Config{BatchMaxWait: time.Second}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		if d := flushInterval(cfg, rng); d != time.Second {
+			t.Fatalf("expected fixed interval, got %v", d)
+		}
+	}
+}
+
+func TestFirstFlushDelayStagger(t *testing.T) {
+	cfg := Config{BatchMaxWait: time.Second, FlushStagger: true}
+	rng := rand.New(rand.NewSource(7))
+
+	const n = 10000
+	var sum time.Duration
+	for i := 0; i < n; i++ {
+		d := firstFlushDelay(cfg, rng)
+		if d <= 0 || d > time.Second {
+			t.Fatalf("stagger %v outside (0, 1s]", d)
+		}
+		sum += d
+	}
+	if mean := sum / n; mean < 480*time.Millisecond || mean > 520*time.Millisecond {
+		t.Fatalf("expected uniform stagger with mean near 500ms, got %v", mean)
+	}
+}
+
+func TestFlushJitterFracValidation(t *testing.T) {
+	for _, frac := range []float64{-0.1, 1, 1.5} {
+		if _, err := NewClient(Config{Endpoint: "http://127.0.0.1", FlushJitterFrac: frac}); err == nil {
+			t.Fatalf("expected validation error for FlushJitterFrac=%v", frac)
+		}
+	}
+}
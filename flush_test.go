@@ -0,0 +1,168 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A Client can serve as the sync target of a zap WriteSyncer.
+var _ interface{ Sync() error } = (*Client)(nil)
+
+func TestSyncPushesQueuedEntries(t *testing.T) {
+	srv, captured := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"app": "api"}}); err != nil {
+					t.Error(err)
+				}
+				if err := c.Sync(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	entries := 0
+	for _, s := range captured() {
+		entries += s.entries
+	}
+	if entries != 40 {
+		t.Fatalf("%d entries pushed after Sync, want 40", entries)
+	}
+}
+
+func TestSyncReturnsPushErrorsAndErrClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatalf("Sync with nothing queued = %v, want nil", err)
+	}
+	if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	var statusErr *HTTPStatusPushError
+	if err := c.Sync(); !errors.As(err, &statusErr) {
+		t.Fatalf("Sync = %v, want the push error", err)
+	}
+	_ = c.Close(context.Background())
+	if err := c.Sync(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Sync after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestFlushKeepsClientRunning(t *testing.T) {
+	srv, captured := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Minute, BatchMaxEntries: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	total := func() (n int) {
+		for _, s := range captured() {
+			n += s.entries
+		}
+		return n
+	}
+	for round := 1; round <= 3; round++ {
+		// 6 entries: one full batch pushed by the worker, two left in the
+		// batch being assembled or the queue.
+		for range 6 {
+			if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := c.Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if n := total(); n != 6*round {
+			t.Fatalf("round %d: %d entries pushed after Flush, want %d", round, n, 6*round)
+		}
+	}
+}
+
+func TestFlushRespectsContextDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	defer close(release)
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Flush = %v, want context.DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Flush returned after %v, want about the 50ms deadline", d)
+	}
+}
+
+// TestFlushRacesTimerFlushes mixes explicit flushes with BatchMaxWait ones
+// and checks each entry is pushed exactly once. Run it under -race.
+func TestFlushRacesTimerFlushes(t *testing.T) {
+	srv, captured := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Millisecond, BatchMaxEntries: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+					t.Error(err)
+				}
+				if err := c.Flush(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, s := range captured() {
+		n += s.entries
+	}
+	if n != 200 || c.Metrics().Pushed != 200 {
+		t.Fatalf("%d entries received, %d pushed; want 200", n, c.Metrics().Pushed)
+	}
+}
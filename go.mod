@@ -6,3 +6,5 @@ require (
 	github.com/golang/snappy v1.0.0
 	google.golang.org/protobuf v1.36.10
 )
+
+require gopkg.in/yaml.v3 v3.0.1
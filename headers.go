@@ -0,0 +1,108 @@
+package lokigo
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// batchHeaders carries the per-batch inputs of buildHeaders.
+type batchHeaders struct {
+	// encoder holds the Content-Type and Content-Encoding of the payload.
+	encoder http.Header
+	// tenantID is the batch tenant, sent as X-Scope-OrgID when non-empty.
+	tenantID string
+	// idempotencyKey is sent in Config.IdempotencyKeyHeader when both are
+	// non-empty.
+	idempotencyKey string
+}
+
+// buildHeaders returns the headers of a push request. Later layers replace
+// earlier ones, compared case-insensitively:
+//
+//  1. defaults: the encoder's Content-Type and Content-Encoding (after
+//     ContentTypeOverride) and Config.UserAgent;
+//  2. Config.Headers, applied in sorted key order so the result does not
+//     depend on map iteration;
+//  3. specialized fields: X-Scope-OrgID from the batch tenant, then
+//     Config.IdempotencyKeyHeader.
+//
+// The OAuth2 Authorization header is added per attempt by pushOnce. It fails
+// when a name or value cannot be sent, such as a tenant containing a newline.
+func buildHeaders(cfg Config, b batchHeaders) (http.Header, error) {
+	h := make(http.Header, len(b.encoder)+len(cfg.Headers)+3)
+	for k, v := range b.encoder {
+		h[k] = slices.Clone(v)
+	}
+	if cfg.UserAgent != "" {
+		h.Set("User-Agent", cfg.UserAgent)
+	}
+	for _, k := range slices.Sorted(maps.Keys(cfg.Headers)) {
+		h.Set(k, cfg.Headers[k])
+	}
+	if b.tenantID != "" {
+		h.Set("X-Scope-OrgID", b.tenantID)
+	}
+	if cfg.IdempotencyKeyHeader != "" && b.idempotencyKey != "" {
+		h.Set(cfg.IdempotencyKeyHeader, b.idempotencyKey)
+	}
+	for k, vs := range h {
+		for _, v := range vs {
+			if err := checkHeader(k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return h, nil
+}
+
+// checkHeader reports a header that net/http would refuse to send.
+func checkHeader(name, value string) error {
+	if name == "" || strings.ContainsFunc(name, func(r rune) bool { return r <= ' ' || r >= 0x7f || r == ':' }) {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for header %q", name)
+	}
+	return nil
+}
+
+// validateHeaders rejects header settings that are certainly mistakes: a
+// name set twice with different values in one map, and an Authorization
+// header alongside OAuth2, which would always replace it.
+func (c Config) validateHeaders() error {
+	for _, m := range []struct {
+		field   string
+		headers map[string]string
+	}{{"headers", c.Headers}, {"shadowHeaders", c.ShadowHeaders}} {
+		seen := make(map[string]string, len(m.headers))
+		for _, k := range slices.Sorted(maps.Keys(m.headers)) {
+			v := m.headers[k]
+			if err := checkHeader(k, v); err != nil {
+				return fmt.Errorf("%s: %w", m.field, err)
+			}
+			ck := http.CanonicalHeaderKey(k)
+			if prev, ok := seen[ck]; ok && prev != v {
+				return fmt.Errorf("%s sets %s twice with different values", m.field, ck)
+			}
+			seen[ck] = v
+		}
+	}
+	if c.OAuth2.enabled() && hasHeader(c.Headers, "Authorization") {
+		return errors.New("headers Authorization conflicts with oauth2")
+	}
+	return nil
+}
+
+// hasHeader reports whether headers sets name, compared case-insensitively.
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package lokigo
+
+import (
+	"sync"
+	"time"
+)
+
+// HealthState is the push health of a client.
+type HealthState string
+
+const (
+	// HealthHealthy means no flush failed since the client last recovered.
+	HealthHealthy HealthState = "healthy"
+	// HealthDegraded means a flush failed and the client has not yet met
+	// HealthRecovery.
+	HealthDegraded HealthState = "degraded"
+	// HealthDown means the background worker stopped after repeated panics
+	// and the client no longer pushes; see ErrWorkerDown. It is final.
+	HealthDown HealthState = "down"
+)
+
+// Health is a snapshot of a client's push health, returned by
+// Client.Health.
+type Health struct {
+	State HealthState
+	// Since is when State was entered; zero while the client has been
+	// healthy since NewClient.
+	Since time.Time
+	// ConsecutiveFailures counts failed flushes since the last successful
+	// one.
+	ConsecutiveFailures int
+	// ConsecutiveSuccesses counts successful flushes since the last failed
+	// one.
+	ConsecutiveSuccesses int
+	// LastError is the error of the last failed flush, kept after recovery.
+	LastError error
+}
+
+// HealthRecovery sets when a degraded client is healthy again: after
+// Successes consecutive successful flushes, or once Window passed without a
+// failed flush, whichever comes first.
+type HealthRecovery struct {
+	// Successes defaults to 3.
+	Successes int
+	// Window is unset by default, so only Successes applies.
+	Window time.Duration
+}
+
+// healthTracker follows flush outcomes for Client.Health. It is safe for
+// concurrent use.
+type healthTracker struct {
+	cfg HealthRecovery
+	now func() time.Time
+
+	mu          sync.Mutex
+	h           Health
+	lastFailure time.Time
+}
+
+func newHealthTracker(cfg HealthRecovery) *healthTracker {
+	return &healthTracker{cfg: cfg, now: time.Now, h: Health{State: HealthHealthy}}
+}
+
+// record notes the outcome of a flush.
+func (t *healthTracker) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if t.h.State == HealthDown {
+		return
+	}
+	if err != nil {
+		t.h.ConsecutiveSuccesses = 0
+		t.h.ConsecutiveFailures++
+		t.h.LastError = err
+		t.lastFailure = now
+		if t.h.State != HealthDegraded {
+			t.h.State, t.h.Since = HealthDegraded, now
+		}
+		return
+	}
+	t.h.ConsecutiveFailures = 0
+	t.h.ConsecutiveSuccesses++
+	if t.h.State == HealthDegraded && t.h.ConsecutiveSuccesses >= t.cfg.Successes {
+		t.h.State, t.h.Since = HealthHealthy, now
+	}
+	t.recoverByWindow(now)
+}
+
+// recoverByWindow clears a degraded state once Window passed since the
+// last failure. It must be called with mu held.
+func (t *healthTracker) recoverByWindow(now time.Time) {
+	if t.h.State != HealthDegraded || t.cfg.Window <= 0 {
+		return
+	}
+	if at := t.lastFailure.Add(t.cfg.Window); !now.Before(at) {
+		t.h.State, t.h.Since = HealthHealthy, at
+	}
+}
+
+// down marks the client down for good after err.
+func (t *healthTracker) down(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.h.State, t.h.Since, t.h.LastError = HealthDown, t.now(), err
+}
+
+func (t *healthTracker) snapshot() Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recoverByWindow(t.now())
+	return t.h
+}
+
+// Health reports the client's push health, which turns degraded on a
+// failed flush and healthy again as set by Config.HealthRecovery.
+func (c *Client) Health() Health {
+	return c.health.snapshot()
+}
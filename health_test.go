@@ -0,0 +1,99 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthFailRecoverFail(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	h := newHealthTracker(HealthRecovery{Successes: 2, Window: time.Minute})
+	h.now = clock.Now
+	boom := errors.New("boom")
+	advance := func(d time.Duration) { _ = clock.Sleep(context.Background(), d) }
+	expect := func(want HealthState) Health {
+		t.Helper()
+		got := h.snapshot()
+		if got.State != want {
+			t.Fatalf("state %s, want %s (%+v)", got.State, want, got)
+		}
+		return got
+	}
+
+	h.record(nil)
+	expect(HealthHealthy)
+	advance(time.Second)
+	h.record(boom)
+	if got := expect(HealthDegraded); !got.Since.Equal(clock.Now()) || got.LastError != boom || got.ConsecutiveFailures != 1 {
+		t.Fatalf("after a failure: %+v", got)
+	}
+	// One success is not enough; the second one recovers.
+	h.record(nil)
+	expect(HealthDegraded)
+	h.record(nil)
+	if got := expect(HealthHealthy); got.ConsecutiveSuccesses != 2 || got.LastError != boom {
+		t.Fatalf("after recovering: %+v", got)
+	}
+
+	// Fail again; the window clears it without any further flush.
+	h.record(boom)
+	failedAt := clock.Now()
+	advance(59 * time.Second)
+	expect(HealthDegraded)
+	advance(time.Hour)
+	if got := expect(HealthHealthy); !got.Since.Equal(failedAt.Add(time.Minute)) {
+		t.Fatalf("Since = %v, want the end of the window", got.Since)
+	}
+
+	// A failure resets the success count.
+	h.record(nil)
+	h.record(boom)
+	h.record(nil)
+	if got := expect(HealthDegraded); got.ConsecutiveSuccesses != 1 || got.ConsecutiveFailures != 0 {
+		t.Fatalf("after fail then success: %+v", got)
+	}
+}
+
+func TestClientHealthFollowsFlushes(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		DisableBatching: true,
+		Retry:           RetryConfig{MaxAttempts: 1},
+		HealthRecovery:  HealthRecovery{Successes: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if h := c.Health(); h.State != HealthHealthy || !h.Since.IsZero() {
+		t.Fatalf("initial Health = %+v", h)
+	}
+	_ = c.Send(context.Background(), Entry{Line: "x"})
+	if h := c.Health(); h.State != HealthDegraded || h.LastError == nil {
+		t.Fatalf("Health after a failed push = %+v", h)
+	}
+	failing.Store(false)
+	for i, want := range []HealthState{HealthDegraded, HealthHealthy} {
+		if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		if h := c.Health(); h.State != want {
+			t.Fatalf("Health after %d successful pushes = %+v, want %s", i+1, h, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package lokigo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeartbeatLabel marks heartbeat entries unless Heartbeat.Labels sets it.
+const HeartbeatLabel = "lokigo_heartbeat"
+
+// defaultHeartbeatLine is the default Heartbeat.Line.
+const defaultHeartbeatLine = "lokigo heartbeat"
+
+// HeartbeatConfig makes the client emit a synthetic entry every Interval,
+// so a missing heartbeat stream can alert on a broken pipeline regardless
+// of application traffic. The zero value disables it.
+type HeartbeatConfig struct {
+	Interval time.Duration
+	// Labels are the heartbeat stream labels, on top of StaticLabels.
+	// HeartbeatLabel="true" is added unless Labels sets it.
+	Labels map[string]string
+	// Line starts the entry line, followed by the client Metrics as logfmt.
+	// Defaults to "lokigo heartbeat".
+	Line string
+}
+
+// startHeartbeat emits a heartbeat for every tick until ctx is done.
+func (c *Client) startHeartbeat(ctx context.Context, hb HeartbeatConfig, ticks <-chan time.Time, stop func()) {
+	labels := mergeLabels(hb.Labels, nil)
+	if _, ok := labels[HeartbeatLabel]; !ok {
+		labels[HeartbeatLabel] = "true"
+	}
+	line := hb.Line
+	if line == "" {
+		line = defaultHeartbeatLine
+	}
+	c.startTicking(ctx, ticks, stop, func(now time.Time) (Entry, bool) {
+		return Entry{Timestamp: now.UTC(), Line: line + " " + metricsLogfmt(c.Metrics(), c.QueueLen()), Labels: labels}, true
+	})
+}
+
+// startTicking emits the entry built for every tick until ctx is done,
+// unless build skips the tick. Like Send, it refuses entries once Close was
+// called, but it never waits for queue space: an entry finding the queue
+// full is dropped as DropQueueFull.
+func (c *Client) startTicking(ctx context.Context, ticks <-chan time.Time, stop func(), build func(now time.Time) (Entry, bool)) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticks:
+				if e, ok := build(now); ok {
+					c.emitHeartbeat(ctx, e)
+				}
+			}
+		}
+	}()
+}
+
+// emitHeartbeat sends e for startTicking. A tick racing Close is refused
+// as DropClosed, so it is not left in the queue after the drain.
+func (c *Client) emitHeartbeat(ctx context.Context, e Entry) {
+	defer c.endSend()
+	if !c.beginSend() {
+		_ = c.rejectStopped(1)
+		return
+	}
+	if c.cfg.DisableBatching {
+		if err := c.flushBatch(ctx, []Entry{e}); err != nil {
+			c.setErr(err, false)
+		}
+		return
+	}
+	ch := c.queue
+	var class *queueClass
+	if c.classes != nil {
+		// The highest class, so a flood of low-severity entries cannot
+		// crowd heartbeats out.
+		class = c.classes[0]
+		ch = class.ch
+	}
+	c.queuedBytes.Add(int64(len(e.Line)))
+	select {
+	case ch <- queuedEntry{Entry: e, enqueued: time.Now()}:
+		if class != nil {
+			c.wakeWorker()
+		}
+	default:
+		c.queuedBytes.Add(-int64(len(e.Line)))
+		c.drop(DropQueueFull, 1)
+		if class != nil {
+			c.droppedByClass.add(class.name, 1)
+		}
+	}
+}
+
+// metricsLogfmt formats the main counters of m, and the queue length, as
+// logfmt.
+func metricsLogfmt(m Metrics, queueLen int) string {
+	var b strings.Builder
+	for i, kv := range []struct {
+		k string
+		v uint64
+	}{
+		{"pushed", m.Pushed},
+		{"push_errors", m.PushErrors},
+		{"dropped", m.Dropped},
+		{"retries", m.Retries},
+		{"in_flight", uint64(m.InFlight)},
+		{"queue_len", uint64(queueLen)},
+	} {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(kv.k)
+		b.WriteByte('=')
+		b.WriteString(strconv.FormatUint(kv.v, 10))
+	}
+	return b.String()
+}
@@ -0,0 +1,107 @@
+package lokigo
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatCadenceAndContent(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 1, StaticLabels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ticks := make(chan time.Time)
+	c.startHeartbeat(ctx, HeartbeatConfig{Interval: time.Minute, Labels: map[string]string{"job": "hb"}}, ticks, func() {})
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var want []time.Time
+	for i := range 3 {
+		want = append(want, clock.Now())
+		ticks <- clock.Now()
+		deadline := time.Now().Add(5 * time.Second)
+		for c.Metrics().Pushed != uint64(i+1) {
+			if time.Now().After(deadline) {
+				t.Fatalf("heartbeat %d not pushed", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		_ = clock.Sleep(ctx, time.Minute)
+	}
+	cancel()
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	wantLabels := map[string]string{"env": "prod", "job": "hb", HeartbeatLabel: "true"}
+	for i, r := range rec.entries() {
+		if !reflect.DeepEqual(r.labels, wantLabels) {
+			t.Fatalf("heartbeat %d labels = %v, want %v", i, r.labels, wantLabels)
+		}
+		if r.ts != strconv.FormatInt(want[i].UnixNano(), 10) {
+			t.Fatalf("heartbeat %d at %s, want the tick time %d", i, r.ts, want[i].UnixNano())
+		}
+		// Each heartbeat reports the ones pushed before it.
+		prefix := "lokigo heartbeat pushed=" + strconv.Itoa(i) + " push_errors=0 dropped=0 "
+		if !strings.HasPrefix(r.line, prefix) || !strings.Contains(r.line, " queue_len=") {
+			t.Fatalf("heartbeat %d line = %q, want prefix %q", i, r.line, prefix)
+		}
+	}
+}
+
+func TestHeartbeatRacingCloseIsRefused(t *testing.T) {
+	for _, disableBatching := range []bool{false, true} {
+		t.Run("DisableBatching="+strconv.FormatBool(disableBatching), func(t *testing.T) {
+			srv, rec := captureJSONPushes(t)
+			c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Hour, DisableBatching: disableBatching})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The tick is being built while Close runs and the drain ends.
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ticks := make(chan time.Time)
+			building := make(chan struct{})
+			release := make(chan struct{})
+			c.startTicking(ctx, ticks, func() {}, func(now time.Time) (Entry, bool) {
+				close(building)
+				<-release
+				return Entry{Timestamp: now, Line: "tick"}, true
+			})
+			ticks <- time.Now()
+			<-building
+
+			closed := make(chan error, 1)
+			go func() { closed <- c.Close(context.Background()) }()
+			deadline := time.Now().Add(5 * time.Second)
+			for !c.shutdown.closing.Load() {
+				if time.Now().After(deadline) {
+					t.Fatal("Close never started")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if !disableBatching {
+				<-c.workerDone
+			}
+			close(release)
+			cancel()
+			if err := <-closed; err != nil {
+				t.Fatal(err)
+			}
+
+			if n := len(rec.received()); n != 0 {
+				t.Fatalf("%d pushes, want none for a tick after Close", n)
+			}
+			m := c.Metrics()
+			if m.DroppedByReason[DropClosed] != 1 || c.QueueLen() != 0 || c.queuedBytes.Load() != 0 {
+				t.Fatalf("closed drops = %d, queue len = %d, queued bytes = %d; want the tick refused", m.DroppedByReason[DropClosed], c.QueueLen(), c.queuedBytes.Load())
+			}
+		})
+	}
+}
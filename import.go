@@ -0,0 +1,213 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// EntryIterator is a source of entries for Import, in timestamp order.
+type EntryIterator interface {
+	// Next returns the next entry, or io.EOF once the source is exhausted.
+	// Any other error stops the import.
+	Next(ctx context.Context) (Entry, error)
+}
+
+// ImportOptions tunes Import. The zero value is usable.
+type ImportOptions struct {
+	// ChunkEntries caps the entries of one push. Every push carries a single
+	// stream. Defaults to the client's BatchMaxEntries.
+	ChunkEntries int
+	// WindowEntries is how many entries are read ahead from the source and
+	// partitioned by stream before pushing. Defaults to 16 × ChunkEntries.
+	WindowEntries int
+	// RateLimitRetries is how many more times a chunk rejected with 429 is
+	// pushed again after the client's own retries gave up. Defaults to 10;
+	// negative disables them.
+	RateLimitRetries int
+	// RateLimitBackoff is the wait before pushing a chunk again after a 429
+	// without Retry-After. Defaults to 1s.
+	RateLimitBackoff time.Duration
+}
+
+// ImportReport summarizes an Import.
+type ImportReport struct {
+	// Read is the number of entries taken from the source.
+	Read int
+	// Sampled is the number of entries Config.Sampler discarded.
+	Sampled int
+	// Imported is the number of entries Loki accepted.
+	Imported int
+	// Failed is the number of entries that were rejected by the entry
+	// checks of Send or whose push failed.
+	Failed int
+	// Pushes counts chunks pushed, including pushes repeated after a 429.
+	Pushes int
+	// RateLimited counts the 429 rejections Import waited out.
+	RateLimited int
+	// Failures lists the streams with failed entries, in order of their
+	// first failure.
+	Failures []StreamImportFailure
+}
+
+// StreamImportFailure is the failed part of one stream in an ImportReport.
+type StreamImportFailure struct {
+	// Stream is the Loki label set of the stream.
+	Stream string
+	// Entries is the number of the stream's entries that failed.
+	Entries int
+	// Err is the stream's last error.
+	Err error
+}
+
+// Import pushes every entry of src through c, for backfilling historical
+// logs. Entries are read a window at a time, partitioned by stream and
+// pushed per stream in timestamp order, oldest first, in chunks of at most
+// ChunkEntries. Pushes are synchronous and bypass the queue, so the report
+// is exact; they share RateLimit, Retry and Routes with the rest of the
+// client. A chunk rejected with 429 after the client's retries is pushed
+// again once the Retry-After delay, which is not capped by MaxBackoff here,
+// or RateLimitBackoff elapsed.
+//
+// Entries get the checks and sampling of Send. Like Push, each chunk is
+// refused with ErrClosed once Close was called, which stops the import, and
+// Close waits for the chunk being pushed.
+//
+// Failed chunks are recorded in the report and the import continues. The
+// returned error is non-nil only when the import stopped early, because ctx
+// was done, src failed or the client stopped accepting entries; the report
+// then covers the entries read so far.
+func Import(ctx context.Context, c *Client, src EntryIterator, opts ImportOptions) (ImportReport, error) {
+	opts = importDefaults(opts, c.cfg)
+	var report ImportReport
+	failures := map[string]int{}
+	fail := func(stream string, n int, err error) {
+		report.Failed += n
+		i, ok := failures[stream]
+		if !ok {
+			i = len(report.Failures)
+			failures[stream] = i
+			report.Failures = append(report.Failures, StreamImportFailure{Stream: stream})
+		}
+		report.Failures[i].Entries += n
+		report.Failures[i].Err = err
+	}
+
+	window := make([]Entry, 0, opts.WindowEntries)
+	for {
+		done := false
+		window = window[:0]
+		for len(window) < opts.WindowEntries {
+			e, err := src.Next(ctx)
+			if errors.Is(err, io.EOF) {
+				done = true
+				break
+			}
+			if err != nil {
+				return report, err
+			}
+			report.Read++
+			if err := c.checkEntry(&e); err != nil {
+				fail(c.streamKey(e), 1, err)
+				continue
+			}
+			if c.sampledOut(e) {
+				report.Sampled++
+				continue
+			}
+			c.countEmptyLabels(e)
+			c.countTruncatedLabels(e)
+			window = append(window, e)
+		}
+		if err := c.importWindow(ctx, window, opts, &report, fail); err != nil {
+			return report, err
+		}
+		if done {
+			return report, nil
+		}
+	}
+}
+
+func importDefaults(opts ImportOptions, cfg Config) ImportOptions {
+	if opts.ChunkEntries <= 0 {
+		opts.ChunkEntries = cfg.BatchMaxEntries
+	}
+	if opts.WindowEntries <= 0 {
+		opts.WindowEntries = 16 * opts.ChunkEntries
+	}
+	if opts.RateLimitRetries == 0 {
+		opts.RateLimitRetries = 10
+	}
+	if opts.RateLimitBackoff <= 0 {
+		opts.RateLimitBackoff = time.Second
+	}
+	return opts
+}
+
+// importWindow pushes window stream by stream, in order of each stream's
+// first entry.
+func (c *Client) importWindow(ctx context.Context, window []Entry, opts ImportOptions, report *ImportReport, fail func(string, int, error)) error {
+	var order []string
+	streams := map[string][]Entry{}
+	for _, e := range window {
+		k := c.streamKey(e)
+		if _, ok := streams[k]; !ok {
+			order = append(order, k)
+		}
+		streams[k] = append(streams[k], e)
+	}
+	for _, k := range order {
+		entries := streams[k]
+		slices.SortStableFunc(entries, func(a, b Entry) int { return a.Timestamp.Compare(b.Timestamp) })
+		for len(entries) > 0 {
+			n := min(len(entries), opts.ChunkEntries)
+			if err := c.importChunk(ctx, entries[:n], opts, report); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				fail(k, n, err)
+				if errors.Is(err, ErrClosed) || errors.Is(err, ErrWorkerDown) {
+					return err
+				}
+			} else {
+				report.Imported += n
+			}
+			entries = entries[n:]
+		}
+	}
+	return nil
+}
+
+// importChunk pushes chunk, pushing it again after 429 rejections.
+func (c *Client) importChunk(ctx context.Context, chunk []Entry, opts ImportOptions, report *ImportReport) error {
+	for retries := 0; ; retries++ {
+		err := c.importPush(ctx, chunk, report)
+		var statusErr *HTTPStatusPushError
+		if err == nil || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || retries >= opts.RateLimitRetries {
+			return err
+		}
+		report.RateLimited++
+		wait := statusErr.RetryAfter
+		if wait <= 0 {
+			wait = opts.RateLimitBackoff
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// importPush pushes chunk once, registered as a Send in progress so that
+// Close refuses it or waits for it, without holding Close up while a 429 is
+// waited out.
+func (c *Client) importPush(ctx context.Context, chunk []Entry, report *ImportReport) error {
+	defer c.endSend()
+	if !c.beginSend() {
+		return c.rejectStopped(len(chunk))
+	}
+	report.Pushes++
+	return c.flushBatch(ctx, chunk)
+}
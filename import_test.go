@@ -0,0 +1,209 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sliceIterator yields entries from a slice.
+type sliceIterator []Entry
+
+func (it *sliceIterator) Next(context.Context) (Entry, error) {
+	if len(*it) == 0 {
+		return Entry{}, io.EOF
+	}
+	e := (*it)[0]
+	*it = (*it)[1:]
+	return e, nil
+}
+
+func TestImportHonorsPerStreamRateLimit(t *testing.T) {
+	const perStreamGap = 30 * time.Millisecond
+	var mu sync.Mutex
+	last := map[string]time.Time{}
+	received := map[string][]int64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		if len(payload.Streams) != 1 {
+			t.Errorf("push carries %d streams, want 1", len(payload.Streams))
+			return
+		}
+		s := payload.Streams[0]
+		name := s.Stream["stream"]
+		if name == "bad" {
+			http.Error(w, "invalid stream", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last[name]) < perStreamGap {
+			http.Error(w, "per-stream rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		last[name] = time.Now()
+		for _, v := range s.Values {
+			ns, _ := strconv.ParseInt(v[0], 10, 64)
+			received[name] = append(received[name], ns)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		Endpoint:     srv.URL,
+		Encoding:     EncodingJSON,
+		BatchMaxWait: time.Minute,
+		Retry:        RetryConfig{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	base := time.Unix(1700000000, 0)
+	var src sliceIterator
+	for i := 0; i < 60; i++ {
+		for _, s := range []string{"a", "b", "c"} {
+			src = append(src, Entry{Timestamp: base.Add(time.Duration(i) * time.Second), Line: fmt.Sprint(i), Labels: map[string]string{"stream": s}})
+		}
+	}
+	src = append(src, Entry{Timestamp: base, Line: "x", Labels: map[string]string{"stream": "bad"}})
+
+	report, err := Import(context.Background(), c, &src, ImportOptions{ChunkEntries: 20, WindowEntries: 100, RateLimitBackoff: perStreamGap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Read != 181 || report.Imported != 180 || report.Failed != 1 || report.RateLimited == 0 {
+		t.Fatalf("report = %+v, want 181 read, 180 imported, 1 failed and some rate limiting", report)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Stream != `{stream="bad"}` || report.Failures[0].Entries != 1 {
+		t.Fatalf("Failures = %+v, want the bad stream only", report.Failures)
+	}
+	var statusErr *HTTPStatusPushError
+	if !errors.As(report.Failures[0].Err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failure error = %v, want a 400 push error", report.Failures[0].Err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range []string{"a", "b", "c"} {
+		ts := received[s]
+		if len(ts) != 60 {
+			t.Fatalf("stream %s: %d entries received, want 60", s, len(ts))
+		}
+		for i := 1; i < len(ts); i++ {
+			if ts[i] <= ts[i-1] {
+				t.Fatalf("stream %s: entries out of order at %d", s, i)
+			}
+		}
+	}
+}
+
+func TestImportStopsOnSourceError(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1:1", BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	boom := errors.New("boom")
+	report, err := Import(context.Background(), c, iteratorFunc(func() (Entry, error) { return Entry{}, boom }), ImportOptions{})
+	if !errors.Is(err, boom) || report.Read != 0 {
+		t.Fatalf("Import = %+v, %v; want nothing read and the source error", report, err)
+	}
+}
+
+func TestImportAfterCloseReturnsErrClosed(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	src := sliceIterator{{Line: "a"}, {Line: "b"}}
+	report, err := Import(context.Background(), c, &src, ImportOptions{})
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Import after Close = %v, want ErrClosed", err)
+	}
+	if report.Failed != 2 || report.Pushes != 0 || requests.Load() != 0 {
+		t.Fatalf("report = %+v with %d requests, want both entries failed and nothing pushed", report, requests.Load())
+	}
+}
+
+func TestImportAppliesSampler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxWait: time.Minute, Sampler: func(e Entry) bool { return e.Line != "noise" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	src := sliceIterator{{Line: "a"}, {Line: "noise"}, {Line: "b"}}
+	report, err := Import(context.Background(), c, &src, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Read != 3 || report.Sampled != 1 || report.Imported != 2 || c.Metrics().Sampled != 1 {
+		t.Fatalf("report = %+v, want 3 read, 1 sampled out and 2 imported", report)
+	}
+}
+
+type iteratorFunc func() (Entry, error)
+
+func (f iteratorFunc) Next(context.Context) (Entry, error) { return f() }
+
+func TestPushErrorCarriesRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, DisableBatching: true, Retry: RetryConfig{MaxAttempts: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	err = c.Send(context.Background(), Entry{Line: "x"})
+	var statusErr *HTTPStatusPushError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != 7*time.Second {
+		t.Fatalf("Send error = %v, want a 429 push error with RetryAfter 7s", err)
+	}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for v, want := range map[string]time.Duration{
+		"":     0,
+		"-3":   0,
+		"soon": 0,
+		now.Add(time.Minute).Format(http.TimeFormat):  time.Minute,
+		now.Add(-time.Minute).Format(http.TimeFormat): 0,
+	} {
+		if got := parseRetryAfter(v, now); got != want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
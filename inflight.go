@@ -0,0 +1,68 @@
+package lokigo
+
+import (
+	"context"
+	"sync"
+)
+
+// inFlight counts batch pushes (and their entries) that have started and not
+// yet finished, retries included, so Close can wait for them. It also covers
+// pushes made on caller goroutines with DisableBatching, which the worker
+// WaitGroup does not see.
+type inFlight struct {
+	mu      sync.Mutex
+	n       int
+	entries int
+	idle    chan struct{} // closed while n == 0
+}
+
+func newInFlight() *inFlight {
+	idle := make(chan struct{})
+	close(idle)
+	return &inFlight{idle: idle}
+}
+
+func (f *inFlight) add(entries int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.n == 0 {
+		f.idle = make(chan struct{})
+	}
+	f.n++
+	f.entries += entries
+}
+
+func (f *inFlight) done(entries int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.n--
+	f.entries -= entries
+	if f.n == 0 {
+		close(f.idle)
+	}
+}
+
+func (f *inFlight) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.n
+}
+
+func (f *inFlight) entryCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.entries
+}
+
+// wait blocks until no push is in flight or ctx is done.
+func (f *inFlight) wait(ctx context.Context) error {
+	f.mu.Lock()
+	idle := f.idle
+	f.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
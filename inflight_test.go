@@ -0,0 +1,57 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInFlightGaugeAndCloseWaits(t *testing.T) {
+	arrived := make(chan struct{}, 2)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, DisableBatching: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+				t.Errorf("send: %v", err)
+			}
+		}()
+	}
+	<-arrived
+	<-arrived
+	if got := c.Metrics().InFlight; got != 2 {
+		t.Fatalf("expected 2 pushes in flight, got %d", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Close to wait for in-flight pushes, got %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+	if got := c.Metrics().InFlight; got != 0 {
+		t.Fatalf("expected no pushes in flight, got %d", got)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
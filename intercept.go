@@ -0,0 +1,43 @@
+package lokigo
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// PushFunc sends one push request. See Config.PushInterceptors.
+type PushFunc func(ctx context.Context, req *PushRequestInfo) error
+
+// PushRequestInfo describes a push passing through Config.PushInterceptors.
+// Interceptors may change Header and Payload; the innermost call sends what
+// they hold at that point. Inside the retry loop every attempt starts from a
+// fresh copy.
+type PushRequestInfo struct {
+	Endpoint string
+	TenantID string
+	// Header holds the request headers, including Content-Type,
+	// Content-Encoding, Config.Headers and X-Scope-OrgID. OAuth2 tokens are
+	// added afterwards and are not visible here.
+	Header http.Header
+	// Payload is the encoded request body.
+	Payload []byte
+	// Entries is the number of log entries in Payload.
+	Entries int
+	// Attempt is the zero-based retry attempt. Interceptors outside the
+	// retry loop are called with 0.
+	Attempt int
+
+	// stream writes the body in place of Payload under
+	// StreamLargePayloads, which interceptors disable.
+	stream func(io.Writer) error
+}
+
+// intercept wraps send in Config.PushInterceptors, the first being the
+// outermost.
+func (c *Client) intercept(send PushFunc) PushFunc {
+	for i := len(c.cfg.PushInterceptors) - 1; i >= 0; i-- {
+		send = c.cfg.PushInterceptors[i](send)
+	}
+	return send
+}
@@ -0,0 +1,119 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// chaosInterceptor fails the first n attempts of every push with a retryable
+// error, without reaching the server.
+func chaosInterceptor(n int, attempts *[]int, mu *sync.Mutex) func(PushFunc) PushFunc {
+	return func(next PushFunc) PushFunc {
+		return func(ctx context.Context, req *PushRequestInfo) error {
+			mu.Lock()
+			*attempts = append(*attempts, req.Attempt)
+			mu.Unlock()
+			if req.Attempt < n {
+				return &NetworkPushError{Err: errors.New("chaos"), Kind: NetworkErrorOther}
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+func TestPushInterceptorsSeeEachAttempt(t *testing.T) {
+	var requests atomic.Int32
+	var gotHeader atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		gotHeader.Store(r.Header.Get("X-Order"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var attempts []int
+	tag := func(v string) func(PushFunc) PushFunc {
+		return func(next PushFunc) PushFunc {
+			return func(ctx context.Context, req *PushRequestInfo) error {
+				req.Header.Set("X-Order", req.Header.Get("X-Order")+v)
+				if req.Entries != 1 || req.TenantID != "team" || len(req.Payload) == 0 {
+					t.Errorf("unexpected request info: %+v", req)
+				}
+				return next(ctx, req)
+			}
+		}
+	}
+	c, err := NewClient(Config{
+		Endpoint:         srv.URL,
+		TenantID:         "team",
+		DisableBatching:  true,
+		Retry:            RetryConfig{MaxAttempts: 5, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		PushInterceptors: []func(next PushFunc) PushFunc{tag("a"), chaosInterceptor(2, &attempts, &mu), tag("b")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("expected only the third attempt to reach the server, got %d requests", requests.Load())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 3 || attempts[2] != 2 {
+		t.Fatalf("expected attempts [0 1 2], got %v", attempts)
+	}
+	if m := c.Metrics(); m.Retries != 2 || m.PushErrors != 2 || m.Pushed != 1 {
+		t.Fatalf("expected injected failures counted as retried attempts, got %+v", m)
+	}
+	if got := gotHeader.Load(); got != "ab" {
+		t.Fatalf("expected interceptors applied in order, got X-Order %q", got)
+	}
+}
+
+func TestPushInterceptorsOutsideRetry(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var calls atomic.Int32
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		DisableBatching: true,
+		Retry:           RetryConfig{MaxAttempts: 5, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		PushInterceptors: []func(next PushFunc) PushFunc{func(next PushFunc) PushFunc {
+			return func(ctx context.Context, req *PushRequestInfo) error {
+				calls.Add(1)
+				return next(ctx, req)
+			}
+		}},
+		PushInterceptorsOutsideRetry: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if calls.Load() != 1 || requests.Load() != 3 {
+		t.Fatalf("expected one interceptor call around 3 attempts, got %d calls, %d requests", calls.Load(), requests.Load())
+	}
+}
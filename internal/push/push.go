@@ -17,7 +17,42 @@ import (
 // PushRequest matches Loki's logproto.PushRequest fields used for ingestion.
 type PushRequest struct {
 	Streams []Stream
-	Format  string
+	// Format names the producer format of the request, one of the Format
+	// constants. Empty omits the field from the encoding.
+	Format string
+}
+
+// Values Loki accepts for PushRequest.Format.
+const (
+	FormatLoki = "loki"
+	FormatOTLP = "otlp"
+)
+
+// ValidFormat reports whether f is empty or a value Loki accepts for
+// PushRequest.Format.
+func ValidFormat(f string) bool {
+	return f == "" || f == FormatLoki || f == FormatOTLP
+}
+
+// Validate reports the first problem of a request Loki would reject: an
+// unknown Format, a stream with an empty labels string or an entry without
+// a timestamp.
+func (m *PushRequest) Validate() error {
+	if !ValidFormat(m.Format) {
+		return fmt.Errorf("push: unknown format %q", m.Format)
+	}
+	for i := range m.Streams {
+		s := &m.Streams[i]
+		if s.Labels == "" {
+			return fmt.Errorf("push: stream %d has empty labels", i)
+		}
+		for j := range s.Entries {
+			if s.Entries[j].Timestamp.IsZero() {
+				return fmt.Errorf("push: stream %d entry %d has no timestamp", i, j)
+			}
+		}
+	}
+	return nil
 }
 
 // Stream matches Loki's stream payload shape.
@@ -28,25 +63,37 @@ type Stream struct {
 
 // Entry matches Loki's log entry shape.
 type Entry struct {
-	Timestamp time.Time
-	Line      string
+	Timestamp          time.Time
+	Line               string
+	StructuredMetadata []LabelAdapter
+}
+
+// LabelAdapter matches Loki's LabelPairAdapter, used for structured
+// metadata.
+type LabelAdapter struct {
+	Name  string
+	Value string
 }
 
 func (m *PushRequest) Marshal() ([]byte, error) {
-	var out []byte
-	for _, s := range m.Streams {
-		b, err := s.marshal()
-		if err != nil {
-			return nil, err
-		}
-		out = protowire.AppendTag(out, 1, protowire.BytesType)
-		out = protowire.AppendBytes(out, b)
+	return m.MarshalAppend(nil), nil
+}
+
+// MarshalAppend appends the wire encoding of m to b. Nested message sizes
+// are computed up front, so no intermediate buffers are allocated and a
+// reused b makes encoding allocation-free.
+func (m *PushRequest) MarshalAppend(b []byte) []byte {
+	for i := range m.Streams {
+		s := &m.Streams[i]
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(s.size()))
+		b = s.appendTo(b)
 	}
 	if m.Format != "" {
-		out = protowire.AppendTag(out, 2, protowire.BytesType)
-		out = protowire.AppendString(out, m.Format)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, m.Format)
 	}
-	return out, nil
+	return b
 }
 
 func (m *PushRequest) Unmarshal(in []byte) error {
@@ -92,21 +139,29 @@ func (m *PushRequest) Unmarshal(in []byte) error {
 	return nil
 }
 
-func (m *Stream) marshal() ([]byte, error) {
-	var out []byte
+func (m *Stream) size() int {
+	n := 0
 	if m.Labels != "" {
-		out = protowire.AppendTag(out, 1, protowire.BytesType)
-		out = protowire.AppendString(out, m.Labels)
+		n += protowire.SizeTag(1) + protowire.SizeBytes(len(m.Labels))
 	}
-	for _, e := range m.Entries {
-		b, err := e.marshal()
-		if err != nil {
-			return nil, err
-		}
-		out = protowire.AppendTag(out, 2, protowire.BytesType)
-		out = protowire.AppendBytes(out, b)
+	for i := range m.Entries {
+		n += protowire.SizeTag(2) + protowire.SizeBytes(m.Entries[i].size())
 	}
-	return out, nil
+	return n
+}
+
+func (m *Stream) appendTo(b []byte) []byte {
+	if m.Labels != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.Labels)
+	}
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(e.size()))
+		b = e.appendTo(b)
+	}
+	return b
 }
 
 func (m *Stream) unmarshal(in []byte) error {
@@ -152,15 +207,84 @@ func (m *Stream) unmarshal(in []byte) error {
 	return nil
 }
 
-func (m *Entry) marshal() ([]byte, error) {
-	var out []byte
-	out = protowire.AppendTag(out, 1, protowire.BytesType)
-	out = protowire.AppendBytes(out, marshalTimestamp(m.Timestamp))
+func (m *Entry) size() int {
+	n := protowire.SizeTag(1) + protowire.SizeBytes(timestampSize(m.Timestamp))
 	if m.Line != "" {
-		out = protowire.AppendTag(out, 2, protowire.BytesType)
-		out = protowire.AppendString(out, m.Line)
+		n += protowire.SizeTag(2) + protowire.SizeBytes(len(m.Line))
+	}
+	for i := range m.StructuredMetadata {
+		n += protowire.SizeTag(3) + protowire.SizeBytes(m.StructuredMetadata[i].size())
+	}
+	return n
+}
+
+func (m *Entry) appendTo(b []byte) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(timestampSize(m.Timestamp)))
+	b = appendTimestamp(b, m.Timestamp)
+	if m.Line != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, m.Line)
+	}
+	for i := range m.StructuredMetadata {
+		l := &m.StructuredMetadata[i]
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(l.size()))
+		b = l.appendTo(b)
+	}
+	return b
+}
+
+func (m *LabelAdapter) size() int {
+	n := 0
+	if m.Name != "" {
+		n += protowire.SizeTag(1) + protowire.SizeBytes(len(m.Name))
+	}
+	if m.Value != "" {
+		n += protowire.SizeTag(2) + protowire.SizeBytes(len(m.Value))
+	}
+	return n
+}
+
+func (m *LabelAdapter) appendTo(b []byte) []byte {
+	if m.Name != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.Name)
+	}
+	if m.Value != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, m.Value)
 	}
-	return out, nil
+	return b
+}
+
+func (m *LabelAdapter) unmarshal(in []byte) error {
+	for len(in) > 0 {
+		num, typ, n := protowire.ConsumeTag(in)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		in = in[n:]
+		if (num == 1 || num == 2) && typ == protowire.BytesType {
+			v, n := protowire.ConsumeString(in)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			in = in[n:]
+			if num == 1 {
+				m.Name = v
+			} else {
+				m.Value = v
+			}
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, in)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		in = in[n:]
+	}
+	return nil
 }
 
 func (m *Entry) unmarshal(in []byte) error {
@@ -195,6 +319,20 @@ func (m *Entry) unmarshal(in []byte) error {
 			}
 			in = in[n:]
 			m.Line = v
+		case 3:
+			if typ != protowire.BytesType {
+				return fmt.Errorf("push: bad wire type %v for structured metadata", typ)
+			}
+			msg, n := protowire.ConsumeBytes(in)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			in = in[n:]
+			var l LabelAdapter
+			if err := l.unmarshal(msg); err != nil {
+				return err
+			}
+			m.StructuredMetadata = append(m.StructuredMetadata, l)
 		default:
 			n := protowire.ConsumeFieldValue(num, typ, in)
 			if n < 0 {
@@ -206,14 +344,19 @@ func (m *Entry) unmarshal(in []byte) error {
 	return nil
 }
 
-func marshalTimestamp(ts time.Time) []byte {
+func timestampSize(ts time.Time) int {
+	ts = ts.UTC()
+	return protowire.SizeTag(1) + protowire.SizeVarint(uint64(ts.Unix())) +
+		protowire.SizeTag(2) + protowire.SizeVarint(uint64(ts.Nanosecond()))
+}
+
+func appendTimestamp(b []byte, ts time.Time) []byte {
 	ts = ts.UTC()
-	var out []byte
-	out = protowire.AppendTag(out, 1, protowire.VarintType)
-	out = protowire.AppendVarint(out, uint64(ts.Unix()))
-	out = protowire.AppendTag(out, 2, protowire.VarintType)
-	out = protowire.AppendVarint(out, uint64(ts.Nanosecond()))
-	return out
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(ts.Unix()))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(ts.Nanosecond()))
+	return b
 }
 
 func unmarshalTimestamp(in []byte) (time.Time, error) {
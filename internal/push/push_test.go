@@ -0,0 +1,63 @@
+package push
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	ts := time.Unix(1700000000, 42).UTC()
+	for _, format := range []string{"", FormatLoki, FormatOTLP} {
+		in := PushRequest{Streams: []Stream{{Labels: `{app="api"}`, Entries: []Entry{{Timestamp: ts, Line: "x"}}}}, Format: format}
+		raw, _ := in.Marshal()
+		var out PushRequest
+		if err := out.Unmarshal(raw); err != nil {
+			t.Fatal(err)
+		}
+		if out.Format != format || len(out.Streams) != 1 || !out.Streams[0].Entries[0].Timestamp.Equal(ts) {
+			t.Fatalf("round trip of format %q = %+v", format, out)
+		}
+	}
+	empty, _ := (&PushRequest{}).Marshal()
+	if len(empty) != 0 {
+		t.Fatalf("empty request encodes to %x, want no bytes with Format omitted", empty)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	for _, tc := range []struct {
+		name string
+		req  PushRequest
+		want string
+	}{
+		{"valid", PushRequest{Streams: []Stream{{Labels: `{a="b"}`, Entries: []Entry{{Timestamp: ts}}}}, Format: FormatLoki}, ""},
+		{"epoch timestamp", PushRequest{Streams: []Stream{{Labels: `{a="b"}`, Entries: []Entry{{Timestamp: time.Unix(0, 0)}}}}}, ""},
+		{"unknown format", PushRequest{Format: "json"}, `unknown format "json"`},
+		{"empty labels", PushRequest{Streams: []Stream{{Entries: []Entry{{Timestamp: ts}}}}}, "stream 0 has empty labels"},
+		{"no timestamp", PushRequest{Streams: []Stream{{Labels: `{a="b"}`, Entries: []Entry{{Timestamp: ts}, {Line: "x"}}}}}, "stream 0 entry 1 has no timestamp"},
+	} {
+		err := tc.req.Validate()
+		if tc.want == "" && err != nil || tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("%s: Validate() = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestUnmarshalMissingTimestampFailsValidate(t *testing.T) {
+	// A stream whose entry carries only a line, field 2, and no timestamp.
+	in := PushRequest{Streams: []Stream{{Labels: `{a="b"}`}}}
+	raw := in.MarshalAppend(nil)
+	entry := []byte{0x12, 0x01, 'x'}
+	raw = append(raw[:1], append([]byte{byte(int(raw[1]) + 2 + len(entry))}, raw[2:]...)...)
+	raw = append(raw, 0x12, byte(len(entry)))
+	raw = append(raw, entry...)
+	var out PushRequest
+	if err := out.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Validate(); err == nil || !strings.Contains(err.Error(), "no timestamp") {
+		t.Fatalf("Validate() = %v, want a missing timestamp", err)
+	}
+}
@@ -0,0 +1,99 @@
+package lokigo
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxLabelVerdicts bounds the memo of a labelMatcher. When it fills up the
+// memo starts over, so it keeps the keys seen recently.
+const maxLabelVerdicts = 1024
+
+// labelMatcher decides which slog attr keys the handler promotes to
+// labels. It is built once by NewSlogHandler from the allow and deny
+// options and shared, read-only apart from its memo, by the handlers
+// derived with WithAttrs and WithGroup.
+type labelMatcher struct {
+	allow    map[string]struct{}
+	deny     map[string]struct{}
+	allowAll bool
+	// globs is the allow-list globs as one anchored regexp, or nil.
+	globs *regexp.Regexp
+	// memo caches the verdicts of keys that needed globs. It is nil without
+	// globs, where the map lookups are cheaper than the memo.
+	memo atomic.Pointer[labelVerdicts]
+}
+
+// labelVerdicts is one generation of a labelMatcher memo.
+type labelVerdicts struct {
+	m sync.Map // key -> bool
+	n atomic.Int32
+}
+
+func newLabelMatcher(allow, deny map[string]struct{}, globs []string, allowAll bool) *labelMatcher {
+	m := &labelMatcher{allow: allow, deny: deny, allowAll: allowAll, globs: compileGlobs(globs)}
+	if m.globs != nil && !allowAll {
+		m.memo.Store(new(labelVerdicts))
+	}
+	return m
+}
+
+// promotes reports whether the attr key is promoted to a label. The deny
+// list wins over WithLabelAllowAll, exact allow entries and globs.
+func (m *labelMatcher) promotes(key string) bool {
+	if _, denied := m.deny[key]; denied {
+		return false
+	}
+	if m.allowAll {
+		return true
+	}
+	if _, allowed := m.allow[key]; allowed {
+		return true
+	}
+	if m.globs == nil {
+		return false
+	}
+	memo := m.memo.Load()
+	if memo == nil {
+		return m.globs.MatchString(key)
+	}
+	if v, ok := memo.m.Load(key); ok {
+		return v.(bool)
+	}
+	ok := m.globs.MatchString(key)
+	if memo.n.Add(1) > maxLabelVerdicts {
+		m.memo.CompareAndSwap(memo, new(labelVerdicts))
+	} else {
+		memo.m.Store(key, ok)
+	}
+	return ok
+}
+
+// promotesBuiltin reports whether the record time or message key is
+// promoted; only an exact allow-list entry does that.
+func (m *labelMatcher) promotesBuiltin(key string) bool {
+	_, denied := m.deny[key]
+	_, allowed := m.allow[key]
+	return allowed && !denied
+}
+
+// denies reports whether key is on the deny list.
+func (m *labelMatcher) denies(key string) bool {
+	_, denied := m.deny[key]
+	return denied
+}
+
+// compileGlobs compiles allow-list globs, where "*" matches any run of
+// characters, into one anchored regexp; nil when there are none.
+func compileGlobs(globs []string) *regexp.Regexp {
+	if len(globs) == 0 {
+		return nil
+	}
+	alts := make([]string, len(globs))
+	for i, g := range globs {
+		alts[i] = strings.ReplaceAll(regexp.QuoteMeta(g), `\*`, ".*")
+	}
+	return regexp.MustCompile("^(?:" + strings.Join(alts, "|") + ")$")
+}
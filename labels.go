@@ -0,0 +1,262 @@
+package lokigo
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const defaultShardLabel = "__stream_shard__"
+
+// mergedLabels returns static labels merged with entry labels (entry wins).
+//
+// The result must be treated as read-only: when the entry has no labels it
+// is the client's own copy of StaticLabels, and when there are no static
+// labels it is the entry's map itself.
+func (c *Client) mergedLabels(e Entry) map[string]string {
+	var labels map[string]string
+	switch {
+	case e.labelSet != nil:
+		return e.labelSet.labels
+	case len(e.Labels) == 0:
+		labels = c.staticLabels
+	case len(c.staticLabels) == 0:
+		labels = e.Labels
+	default:
+		labels = mergeLabels(c.staticLabels, e.Labels)
+	}
+	labels, _ = c.sanitizeLabels(labels)
+	return labels
+}
+
+// sanitizeLabels removes empty-valued labels (unless KeepEmptyLabels is set)
+// and applies ReservedLabelPolicy, returning the labels and how many empty
+// values were removed. labels itself is returned when nothing changes. A
+// stream left without labels gets EmptyStreamLabels, if configured.
+func (c *Client) sanitizeLabels(labels map[string]string) (map[string]string, int) {
+	empty, rewrite := 0, 0
+	for k, v := range labels {
+		switch {
+		case v == "" && !c.cfg.KeepEmptyLabels:
+			empty++
+		case c.rewritesReserved(k), c.limitsLabels && c.tooLong(k, v):
+			rewrite++
+		}
+	}
+	if empty == 0 && rewrite == 0 {
+		return labels, 0
+	}
+	out := make(map[string]string, len(labels)-empty)
+	for k, v := range labels {
+		switch {
+		case v == "" && !c.cfg.KeepEmptyLabels:
+		case c.rewritesReserved(k):
+			if c.cfg.ReservedLabelPolicy != ReservedLabelRename {
+				continue
+			}
+			// An explicitly set label of the new name wins.
+			name := c.cfg.ReservedLabelPrefix + k
+			if _, ok := labels[name]; !ok {
+				out[name] = truncateLabelValue(v, c.labelValueLimit(name))
+			}
+		default:
+			out[k] = truncateLabelValue(v, c.labelValueLimit(k))
+		}
+	}
+	if len(out) == 0 && c.emptyStream != nil {
+		return c.emptyStream, empty
+	}
+	return out, empty
+}
+
+// truncatedMarker ends a label value cut to its limit.
+const truncatedMarker = "…"
+
+// maxTruncatedLabelKeys bounds Metrics.TruncatedLabelValues; further keys
+// are counted under truncatedLabelOther.
+const (
+	maxTruncatedLabelKeys = 64
+	truncatedLabelOther   = "_other"
+)
+
+// truncateLabelValue cuts v to maxBytes (if > 0) at a rune boundary, ending
+// in truncatedMarker.
+func truncateLabelValue(v string, maxBytes int) string {
+	if maxBytes <= 0 || len(v) <= maxBytes {
+		return v
+	}
+	marker := truncatedMarker
+	if maxBytes < len(marker) {
+		marker = ""
+	}
+	cut := maxBytes - len(marker)
+	for cut > 0 && !utf8.RuneStart(v[cut]) {
+		cut--
+	}
+	return v[:cut] + marker
+}
+
+// labelValueLimit returns the byte limit for values of label k, or 0.
+func (c *Client) labelValueLimit(k string) int {
+	if n, ok := c.cfg.LabelValueLimits[k]; ok {
+		return n
+	}
+	return c.cfg.MaxLabelValueBytes
+}
+
+// tooLong reports whether the value v of label k exceeds its limit.
+func (c *Client) tooLong(k, v string) bool {
+	limit := c.labelValueLimit(k)
+	return limit > 0 && len(v) > limit
+}
+
+// rewritesReserved reports whether the label name k is changed by the
+// Strip or Rename ReservedLabelPolicy.
+func (c *Client) rewritesReserved(k string) bool {
+	switch c.cfg.ReservedLabelPolicy {
+	case ReservedLabelStrip, ReservedLabelRename:
+		return isReservedLabel(k) && !c.reservedAllowed(k)
+	default:
+		return false
+	}
+}
+
+func (c *Client) reservedAllowed(k string) bool {
+	return (c.cfg.ShardStreams.Enabled && k == c.cfg.ShardStreams.Label) ||
+		slices.Contains(c.cfg.ReservedLabelAllowList, k)
+}
+
+func isReservedLabel(k string) bool {
+	return strings.HasPrefix(k, "__")
+}
+
+// ReservedLabelError is returned by Send under ReservedLabelReject when an
+// entry carries a reserved "__*" label, and by NewClient when StaticLabels
+// does.
+type ReservedLabelError struct {
+	Key string
+}
+
+func (e *ReservedLabelError) Error() string {
+	return fmt.Sprintf("lokigo: reserved label name %q", e.Key)
+}
+
+// checkReservedLabels enforces ReservedLabelReject for e.
+func (c *Client) checkReservedLabels(e Entry) error {
+	if c.cfg.ReservedLabelPolicy != ReservedLabelReject {
+		return nil
+	}
+	if e.labelSet != nil {
+		if e.labelSet.reserved != "" {
+			return &ReservedLabelError{Key: e.labelSet.reserved}
+		}
+		return nil
+	}
+	for k := range e.Labels {
+		if isReservedLabel(k) && !c.reservedAllowed(k) {
+			return &ReservedLabelError{Key: k}
+		}
+	}
+	return nil
+}
+
+// countEmptyLabels adds the empty-valued labels that will be stripped from
+// e to Metrics.EmptyLabelsDropped. It runs once per Send, since the merge
+// itself may run several times per entry.
+func (c *Client) countEmptyLabels(e Entry) {
+	if c.cfg.KeepEmptyLabels {
+		return
+	}
+	n := 0
+	if e.labelSet != nil {
+		n = e.labelSet.emptyDropped
+	} else {
+		for _, v := range e.Labels {
+			if v == "" {
+				n++
+			}
+		}
+		if c.staticEmpty > 0 {
+			for k, v := range c.staticLabels {
+				if _, ok := e.Labels[k]; v == "" && !ok {
+					n++
+				}
+			}
+		}
+	}
+	if n > 0 {
+		c.emptyLabels.Add(uint64(n))
+	}
+}
+
+// countTruncatedLabels adds the label values of e that will be truncated
+// to Metrics.TruncatedLabelValues. Like countEmptyLabels it runs once per
+// Send.
+func (c *Client) countTruncatedLabels(e Entry) {
+	if !c.limitsLabels {
+		return
+	}
+	if e.labelSet != nil {
+		for _, k := range e.labelSet.truncated {
+			c.addTruncated(k)
+		}
+		return
+	}
+	for k, v := range e.Labels {
+		if c.tooLong(k, v) {
+			c.addTruncated(k)
+		}
+	}
+	for k, v := range c.staticLabels {
+		if _, ok := e.Labels[k]; !ok && c.tooLong(k, v) {
+			c.addTruncated(k)
+		}
+	}
+}
+
+// truncatedKeys returns the keys of labels whose values exceed their limit.
+func (c *Client) truncatedKeys(labels map[string]string) []string {
+	if !c.limitsLabels {
+		return nil
+	}
+	var keys []string
+	for k, v := range labels {
+		if c.tooLong(k, v) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func (c *Client) addTruncated(k string) {
+	c.truncatedLabels.addBounded(k, 1, maxTruncatedLabelKeys, truncatedLabelOther)
+}
+
+// entryLabels returns the final, read-only stream labels of e: the merged
+// labels plus the shard label when ShardStreams is on.
+func (c *Client) entryLabels(e Entry) map[string]string {
+	if !c.cfg.ShardStreams.Enabled {
+		return c.mergedLabels(e)
+	}
+	labels := mergeLabels(c.mergedLabels(e), nil)
+	labels[c.cfg.ShardStreams.Label] = strconv.Itoa(shardFor(e, c.cfg.ShardStreams.Shards))
+	return labels
+}
+
+// shardFor picks a shard by hashing the entry timestamp and line, so entries
+// of one stream spread evenly while a given entry always maps to the same
+// shard (retries and re-builds stay stable).
+func shardFor(e Entry, shards int) int {
+	h := fnv.New32a()
+	var ts [8]byte
+	n := e.Timestamp.UnixNano()
+	for i := range ts {
+		ts[i] = byte(n >> (8 * i))
+	}
+	_, _ = h.Write(ts[:])
+	_, _ = h.Write([]byte(e.Line))
+	return int(h.Sum32() % uint32(shards))
+}
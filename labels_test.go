@@ -0,0 +1,337 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+type capturedStream struct {
+	labels  map[string]string
+	entries int
+}
+
+// captureJSONStreams returns a server that records every stream of every
+// JSON push it receives.
+func captureJSONStreams(t *testing.T) (*httptest.Server, func() []capturedStream) {
+	t.Helper()
+	srv, rec := captureJSONPushes(t)
+	return srv, func() []capturedStream {
+		var streams []capturedStream
+		for _, s := range rec.streams() {
+			streams = append(streams, capturedStream{labels: s.Stream, entries: len(s.Values)})
+		}
+		return streams
+	}
+}
+
+func TestShardStreamsDistributesAcrossShards(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		StaticLabels:    map[string]string{"service": "api"},
+		BatchMaxEntries: 200,
+		BatchMaxWait:    time.Minute,
+		ShardStreams:    ShardStreamsConfig{Enabled: true, Shards: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Unix(1700000000, 0)
+	for i := 0; i < 200; i++ {
+		e := Entry{Timestamp: base.Add(time.Duration(i)), Line: fmt.Sprintf("line %d", i)}
+		if err := c.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	shards := map[string]int{}
+	for _, s := range streams() {
+		if s.labels["service"] != "api" {
+			t.Fatalf("expected original labels kept, got %#v", s.labels)
+		}
+		shards[s.labels[defaultShardLabel]] += s.entries
+	}
+	if len(shards) != 4 {
+		t.Fatalf("expected 4 shard label sets, got %v", shards)
+	}
+	for shard, n := range shards {
+		if n < 20 {
+			t.Fatalf("expected roughly even distribution, shard %s has %d entries", shard, n)
+		}
+	}
+}
+
+func TestShardStreamsDisabledLeavesLabelsUntouched(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 10, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprintf("line %d", i), Labels: map[string]string{"app": "x"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := streams()
+	if len(got) != 1 || len(got[0].labels) != 1 || got[0].labels["app"] != "x" || got[0].entries != 10 {
+		t.Fatalf("expected a single untouched stream, got %+v", got)
+	}
+}
+
+func TestShardStreamsCustomLabelAndValidation(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", ShardStreams: ShardStreamsConfig{Enabled: true, Label: "shard", Shards: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+	labels := c.entryLabels(Entry{Line: "x"})
+	if v := labels["shard"]; v != "0" && v != "1" && v != "2" {
+		t.Fatalf("expected shard label in [0,3), got %#v", labels)
+	}
+
+	if _, err := NewClient(Config{Endpoint: "http://127.0.0.1", ShardStreams: ShardStreamsConfig{Enabled: true, Shards: 1}}); err == nil {
+		t.Fatal("expected validation error for a single shard")
+	}
+}
+
+func TestMergedLabelsSharesOnlyClientOwnedStaticMap(t *testing.T) {
+	static := map[string]string{"service": "api"}
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", StaticLabels: static})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+
+	a := c.mergedLabels(Entry{})
+	b := c.mergedLabels(Entry{})
+	if fmt.Sprintf("%p", a) != fmt.Sprintf("%p", b) {
+		t.Fatal("expected label-less entries to share the cached static map")
+	}
+	static["service"] = "mutated"
+	if a["service"] != "api" {
+		t.Fatalf("expected cached map to be a client-owned copy, got %#v", a)
+	}
+
+	merged := c.mergedLabels(Entry{Labels: map[string]string{"service": "worker"}})
+	if merged["service"] != "worker" || c.staticLabels["service"] != "api" {
+		t.Fatalf("merging must not touch the cached static map: merged=%#v static=%#v", merged, c.staticLabels)
+	}
+}
+
+func TestEmptyLabelValuesAreStripped(t *testing.T) {
+	for _, enc := range []Encoding{EncodingJSON, EncodingProtobufSnappy} {
+		c, err := NewClient(Config{Endpoint: "http://127.0.0.1", Encoding: enc, StaticLabels: map[string]string{"service": "api", "region": ""}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.cancel()
+
+		e := Entry{Line: "x", Labels: map[string]string{"env": "", "app": "web"}}
+		got := c.entryLabels(e)
+		if len(got) != 2 || got["service"] != "api" || got["app"] != "web" {
+			t.Fatalf("encoding %v: expected empty values stripped, got %#v", enc, got)
+		}
+		if _, _, _, err := c.buildPayload([]Entry{e}); err != nil {
+			t.Fatal(err)
+		}
+		if key := c.streamKey(e); key != `{app="web",service="api"}` {
+			t.Fatalf("encoding %v: unexpected stream key %s", enc, key)
+		}
+	}
+}
+
+func TestEmptyLabelsMetricAndFallback(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	var last Metrics
+	var mu sync.Mutex
+	c, err := NewClient(Config{
+		Endpoint:          srv.URL,
+		Encoding:          EncodingJSON,
+		StaticLabels:      map[string]string{"env": ""},
+		EmptyStreamLabels: map[string]string{"job": "lokigo"},
+		BatchMaxEntries:   2,
+		BatchMaxWait:      time.Minute,
+		OnFlush: func(m Metrics) {
+			mu.Lock()
+			last = m
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Send(context.Background(), Entry{Line: "a", Labels: map[string]string{"app": ""}})
+	_ = c.Send(context.Background(), Entry{Line: "b", Labels: map[string]string{"env": "prod"}})
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, s := range streams() {
+		got[toLokiLabelSet(s.labels)] = ""
+	}
+	if _, ok := got[`{job="lokigo"}`]; !ok {
+		t.Fatalf("expected fallback stream, got %v", got)
+	}
+	if _, ok := got[`{env="prod"}`]; !ok {
+		t.Fatalf("expected overriding entry label kept, got %v", got)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last.EmptyLabelsDropped != 2 {
+		t.Fatalf("expected 2 empty labels counted, got %d", last.EmptyLabelsDropped)
+	}
+}
+
+func TestKeepEmptyLabels(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", KeepEmptyLabels: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+	if got := c.entryLabels(Entry{Labels: map[string]string{"env": ""}}); len(got) != 1 {
+		t.Fatalf("expected empty value kept, got %#v", got)
+	}
+}
+
+func TestSlogHandlerEmptyAttrLabelStripped(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(NewSlogHandler(c, WithLabelAllowList("tenant")))
+	logger.Info("hello", "tenant", "")
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := streams()
+	if len(got) != 1 || len(got[0].labels) != 1 || got[0].labels["level"] != "INFO" {
+		t.Fatalf("expected only the level label, got %+v", got)
+	}
+}
+
+func TestReservedLabelPolicies(t *testing.T) {
+	labels := map[string]string{"__name__": "x", "__tenant_id__": "t", "app": "web"}
+	cases := map[ReservedLabelPolicy]map[string]string{
+		"":                  {"app": "web"},
+		ReservedLabelStrip:  {"app": "web"},
+		ReservedLabelRename: {"app": "web", "user__name__": "x", "user__tenant_id__": "t"},
+		ReservedLabelKeep:   labels,
+	}
+	for policy, want := range cases {
+		c, err := NewClient(Config{Endpoint: "http://127.0.0.1", ReservedLabelPolicy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.cancel()
+		if got := c.entryLabels(Entry{Labels: labels}); toLokiLabelSet(got) != toLokiLabelSet(want) {
+			t.Fatalf("policy %q: expected %v, got %v", policy, want, got)
+		}
+	}
+}
+
+func TestReservedLabelRejectAtSend(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", ReservedLabelPolicy: ReservedLabelReject, ReservedLabelAllowList: []string{"__ok__"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+
+	err = c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"__name__": "x"}})
+	var rerr *ReservedLabelError
+	if !errors.As(err, &rerr) || rerr.Key != "__name__" {
+		t.Fatalf("expected ReservedLabelError naming __name__, got %v", err)
+	}
+	err = c.SendWithLabelSet(context.Background(), time.Time{}, "x", c.NewLabelSet(map[string]string{"__id__": "1"}))
+	if !errors.As(err, &rerr) || rerr.Key != "__id__" {
+		t.Fatalf("expected ReservedLabelError for a label set, got %v", err)
+	}
+	if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"__ok__": "1"}}); err != nil {
+		t.Fatalf("expected allow-listed label accepted, got %v", err)
+	}
+
+	_, err = NewClient(Config{Endpoint: "http://127.0.0.1", ReservedLabelPolicy: ReservedLabelReject, StaticLabels: map[string]string{"__x__": "1"}})
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected NewClient to reject reserved static labels, got %v", err)
+	}
+}
+
+func TestReservedLabelStripKeepsShardLabel(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", ShardStreams: ShardStreamsConfig{Enabled: true, Shards: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+	got := c.entryLabels(Entry{Line: "x", Labels: map[string]string{"__name__": "x", "app": "web"}})
+	if _, ok := got[defaultShardLabel]; !ok || len(got) != 2 {
+		t.Fatalf("expected app and shard labels only, got %#v", got)
+	}
+}
+
+func TestLabelValueLimits(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{
+		Endpoint:           srv.URL,
+		Encoding:           EncodingJSON,
+		StaticLabels:       map[string]string{"region": "eu-west-1a"},
+		MaxLabelValueBytes: 8,
+		LabelValueLimits:   map[string]int{"pod": 32, "msg": 7},
+		BatchMaxWait:       time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{
+		"city": "Zürich-Höngg",        // ü and ö are two bytes each
+		"pod":  "api-7d9f8b6c4-x2x9z", // within the pod override
+		"msg":  "日本語テキスト",             // three-byte runes
+		"app":  "shop",                // short values are untouched
+	}
+	if err := c.Send(context.Background(), Entry{Line: "a", Labels: labels}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendWithLabelSet(context.Background(), time.Time{}, "b", c.NewLabelSet(labels)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"region": "eu-we…",
+		"city":   "Züri…",
+		"pod":    "api-7d9f8b6c4-x2x9z",
+		"msg":    "日…", // the cut falls inside the second rune
+		"app":    "shop",
+	}
+	got := streams()
+	if len(got) != 1 || got[0].entries != 2 || !reflect.DeepEqual(got[0].labels, want) {
+		t.Fatalf("streams = %+v, want one stream of 2 with %v", got, want)
+	}
+	for k, v := range got[0].labels {
+		if !utf8.ValidString(v) {
+			t.Fatalf("label %s = %q is not valid UTF-8", k, v)
+		}
+	}
+	if m := c.Metrics().TruncatedLabelValues; !reflect.DeepEqual(m, map[string]uint64{"region": 2, "city": 2, "msg": 2}) {
+		t.Fatalf("TruncatedLabelValues = %v", m)
+	}
+}
@@ -0,0 +1,76 @@
+package lokigo
+
+import (
+	"context"
+	"time"
+)
+
+// LabelSet is an immutable, precomputed set of stream labels created with
+// Client.NewLabelSet. It holds the static labels of the client that created
+// it merged with the provided labels, along with the encoded forms used to
+// group entries into streams, so sending through SendWithLabelSet skips all
+// per-entry label work. The zero LabelSet carries no labels at all, not even
+// the static ones.
+type LabelSet struct {
+	p *labelSet
+}
+
+type labelSet struct {
+	labels       map[string]string
+	emptyDropped int      // empty-valued labels stripped from labels
+	truncated    []string // label keys whose values were truncated
+	reserved     string   // a reserved label name rejected at send, if any
+	key          string   // Loki label-set string, the stream key of both encoders
+}
+
+// NewLabelSet precomputes the stream labels for labels merged over the
+// client's StaticLabels. The provided map is copied.
+func (c *Client) NewLabelSet(labels map[string]string) LabelSet {
+	raw := mergeLabels(c.staticLabels, labels)
+	truncated := c.truncatedKeys(raw)
+	merged, emptyDropped := c.sanitizeLabels(raw)
+	ls := &labelSet{labels: merged, emptyDropped: emptyDropped, truncated: truncated, key: toLokiLabelSet(merged)}
+	if err := c.checkReservedLabels(Entry{Labels: labels}); err != nil {
+		ls.reserved = err.(*ReservedLabelError).Key
+	}
+	return LabelSet{p: ls}
+}
+
+// Labels returns a copy of the merged labels.
+func (ls LabelSet) Labels() map[string]string {
+	if ls.p == nil {
+		return map[string]string{}
+	}
+	return mergeLabels(ls.p.labels, nil)
+}
+
+// SendWithLabelSet enqueues a line under a precomputed LabelSet. It behaves
+// like Send with Entry{Timestamp: ts, Line: line} and the set's labels; a
+// zero ts is replaced with the current time.
+func (c *Client) SendWithLabelSet(ctx context.Context, ts time.Time, line string, ls LabelSet) error {
+	e := Entry{Timestamp: ts, Line: line}
+	if ls.p != nil {
+		e.Labels = ls.p.labels
+		e.labelSet = ls.p
+	}
+	return c.Send(ctx, e)
+}
+
+// jsonStream returns the read-only stream labels of e and the key the JSON
+// encoder groups streams by: the label-set string of protoStream, so both
+// encodings partition a batch into the same streams.
+func (c *Client) jsonStream(e Entry) (map[string]string, string) {
+	if e.labelSet != nil && !c.cfg.ShardStreams.Enabled {
+		return e.labelSet.labels, e.labelSet.key
+	}
+	labels := c.entryLabels(e)
+	return labels, toLokiLabelSet(labels)
+}
+
+// protoStream returns the Loki label-set string of e.
+func (c *Client) protoStream(e Entry) string {
+	if e.labelSet != nil && !c.cfg.ShardStreams.Enabled {
+		return e.labelSet.key
+	}
+	return toLokiLabelSet(c.entryLabels(e))
+}
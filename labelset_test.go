@@ -0,0 +1,66 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLabelSetPayloadMatchesPlainEntries(t *testing.T) {
+	for _, enc := range []Encoding{EncodingJSON, EncodingProtobufSnappy} {
+		c, err := NewClient(Config{Endpoint: "http://127.0.0.1", Encoding: enc, StaticLabels: map[string]string{"service": "api", "env": "dev"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.cancel()
+
+		labels := map[string]string{"env": "prod", "stream": "s1"}
+		ls := c.NewLabelSet(labels)
+		labels["env"] = "mutated"
+
+		base := time.Unix(1700000000, 0)
+		var plain, pre []Entry
+		for i := 0; i < 10; i++ {
+			ts := base.Add(time.Duration(i) * time.Millisecond)
+			plain = append(plain, Entry{Timestamp: ts, Line: "line", Labels: map[string]string{"env": "prod", "stream": "s1"}})
+			pre = append(pre, Entry{Timestamp: ts, Line: "line", Labels: ls.p.labels, labelSet: ls.p})
+		}
+		want, _, _, err := c.buildPayload(plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, _, err := c.buildPayload(pre)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("encoding %v: label set payload differs from plain payload", enc)
+		}
+	}
+}
+
+func TestSendWithLabelSet(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, StaticLabels: map[string]string{"service": "api"}, BatchMaxEntries: 5, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := c.NewLabelSet(map[string]string{"app": "x"})
+	for i := 0; i < 5; i++ {
+		if err := c.SendWithLabelSet(context.Background(), time.Time{}, "hello", ls); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := streams()
+	if len(got) != 1 || got[0].labels["service"] != "api" || got[0].labels["app"] != "x" || got[0].entries != 5 {
+		t.Fatalf("expected one merged stream with 5 entries, got %+v", got)
+	}
+	if l := ls.Labels(); len(l) != 2 {
+		t.Fatalf("expected merged labels from Labels, got %#v", l)
+	}
+}
@@ -0,0 +1,65 @@
+package lokigo
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// QueueLatency summarizes how long the entries of a flush waited between
+// Send accepting them and the flush starting, in the queue and in the batch.
+type QueueLatency struct {
+	Min  time.Duration
+	Mean time.Duration
+	Max  time.Duration
+}
+
+// queuedEntry is an Entry waiting in the queue, with the time Send accepted
+// it. The time is never serialized.
+type queuedEntry struct {
+	Entry
+	enqueued time.Time
+}
+
+// queueLatency summarizes now minus each of enqueued.
+func queueLatency(now time.Time, enqueued []time.Time) QueueLatency {
+	if len(enqueued) == 0 {
+		return QueueLatency{}
+	}
+	var out QueueLatency
+	var sum time.Duration
+	for i, t := range enqueued {
+		d := max(now.Sub(t), 0)
+		if i == 0 || d < out.Min {
+			out.Min = d
+		}
+		out.Max = max(out.Max, d)
+		sum += d
+	}
+	out.Mean = sum / time.Duration(len(enqueued))
+	return out
+}
+
+// withLatencyMetadata returns e with its queue latency d attached as
+// structured metadata under key. The entry's Metadata map is copied, since
+// it may be shared, for example by WithStaticMetadata.
+func withLatencyMetadata(e Entry, key string, d time.Duration) Entry {
+	md := make(map[string]string, len(e.Metadata)+1)
+	maps.Copy(md, e.Metadata)
+	md[key] = d.String()
+	e.Metadata = md
+	return e
+}
+
+type queueLatencyKey struct{}
+
+// withQueueLatency carries the QueueLatency of a flush to the FlushStats of
+// its pushes.
+func withQueueLatency(ctx context.Context, l QueueLatency) context.Context {
+	return context.WithValue(ctx, queueLatencyKey{}, l)
+}
+
+func queueLatencyFrom(ctx context.Context) QueueLatency {
+	l, _ := ctx.Value(queueLatencyKey{}).(QueueLatency)
+	return l
+}
@@ -0,0 +1,74 @@
+package lokigo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueLatencyMath(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var enqueued []time.Time
+	for _, step := range []time.Duration{0, time.Second, 2 * time.Second} {
+		_ = clock.Sleep(context.Background(), step)
+		enqueued = append(enqueued, clock.Now())
+	}
+	_ = clock.Sleep(context.Background(), time.Second)
+
+	// Waits are 4s, 3s and 1s.
+	got := queueLatency(clock.Now(), enqueued)
+	want := QueueLatency{Min: time.Second, Mean: 8 * time.Second / 3, Max: 4 * time.Second}
+	if got != want {
+		t.Fatalf("queueLatency = %+v, want %+v", got, want)
+	}
+	if got := queueLatency(clock.Now(), nil); got != (QueueLatency{}) {
+		t.Fatalf("empty batch latency = %+v", got)
+	}
+	// A clock stepping back never yields negative latency.
+	if got := queueLatency(time.Unix(0, 0), enqueued[:1]); got != (QueueLatency{}) {
+		t.Fatalf("latency before enqueue = %+v", got)
+	}
+}
+
+func TestQueueLatencyInFlushStatsAndMetadata(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+
+	stats := make(chan FlushStats, 1)
+	c, err := NewClient(Config{
+		Endpoint:                srv.URL,
+		Encoding:                EncodingJSON,
+		BatchMaxWait:            time.Minute,
+		QueueLatencyMetadata:    "queue_latency",
+		QueueLatencySampleEvery: 2,
+		OnFlushStats:            func(s FlushStats) { stats <- s },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a", "b", "c", "d"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	l := (<-stats).QueueLatency
+	if l.Min < 30*time.Millisecond || l.Min > l.Mean || l.Mean > l.Max {
+		t.Fatalf("QueueLatency = %+v, want ordered values of at least 30ms", l)
+	}
+	var sampled []string
+	for _, e := range rec.entries() {
+		if md, ok := e.metadata["queue_latency"]; ok {
+			if _, err := time.ParseDuration(md); err != nil {
+				t.Errorf("queue_latency metadata: %v", err)
+			}
+			sampled = append(sampled, e.line)
+		}
+	}
+	if len(sampled) != 2 || sampled[0] != "b" || sampled[1] != "d" {
+		t.Fatalf("sampled entries = %v, want [b d]", sampled)
+	}
+}
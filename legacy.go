@@ -0,0 +1,38 @@
+package lokigo
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// legacyStream is a stream of the Loki 1.x /api/prom/push JSON schema.
+type legacyStream struct {
+	Labels  string        `json:"labels"`
+	Entries []legacyEntry `json:"entries"`
+}
+
+type legacyEntry struct {
+	Timestamp string `json:"ts"`
+	Line      string `json:"line"`
+}
+
+// buildJSONLegacyPayload encodes entries for EncodingJSONLegacy. Streams are
+// in order of their first entry; metadata is dropped.
+func (c *Client) buildJSONLegacyPayload(entries []Entry) ([]byte, error) {
+	var streams []legacyStream
+	index := map[string]int{}
+	for _, e := range entries {
+		labels := c.protoStream(e)
+		i, ok := index[labels]
+		if !ok {
+			i = len(streams)
+			index[labels] = i
+			c.sawStream(labels)
+			streams = append(streams, legacyStream{Labels: labels})
+		}
+		streams[i].Entries = append(streams[i].Entries, legacyEntry{Timestamp: e.Timestamp.UTC().Format(time.RFC3339Nano), Line: e.Line})
+	}
+	return json.Marshal(struct {
+		Streams []legacyStream `json:"streams"`
+	}{Streams: streams})
+}
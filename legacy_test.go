@@ -0,0 +1,87 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestJSONLegacyEncodingMatchesFixture(t *testing.T) {
+	want, err := os.ReadFile(filepath.Join("testdata", "legacy", "push.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- r
+		bodies <- b
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		Endpoint:             srv.URL,
+		CompleteEndpointPath: true,
+		Encoding:             EncodingJSONLegacy,
+		StaticLabels:         map[string]string{"env": "prod"},
+		BatchMaxWait:         time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2018, 12, 18, 9, 28, 6, 801064000, time.FixedZone("CET", 3600))
+	for _, e := range []Entry{
+		{Timestamp: ts, Line: "GET /healthz 200", Labels: map[string]string{"app": "api"}},
+		{Timestamp: ts, Line: `job "sync" done`, Labels: map[string]string{"app": "worker"}, Metadata: map[string]string{"trace_id": "abc"}},
+		{Timestamp: ts.Add(198936001 * time.Nanosecond), Line: "GET /metrics 200", Labels: map[string]string{"app": "api"}},
+	} {
+		if err := c.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r := <-got
+	if r.URL.Path != "/api/prom/push" || r.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("push to %s with Content-Type %q, want /api/prom/push as application/json", r.URL.Path, r.Header.Get("Content-Type"))
+	}
+	if body := <-bodies; !bytes.Equal(body, bytes.TrimSpace(want)) {
+		t.Fatalf("payload:\n%s\nwant:\n%s", body, want)
+	}
+}
+
+func TestCompleteEndpointPath(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint string
+		enc      Encoding
+		want     string
+	}{
+		{"http://loki:3100", EncodingProtobufSnappy, "http://loki:3100/loki/api/v1/push"},
+		{"http://loki:3100/", EncodingJSON, "http://loki:3100/loki/api/v1/push"},
+		{"http://loki:3100", EncodingJSONLegacy, "http://loki:3100/api/prom/push"},
+		{"https://gw.example.com/custom/push", EncodingJSONLegacy, "https://gw.example.com/custom/push"},
+	} {
+		if got := completePushPath(tc.endpoint, tc.enc); got != tc.want {
+			t.Errorf("completePushPath(%q, %s) = %q, want %q", tc.endpoint, tc.enc, got, tc.want)
+		}
+	}
+	if w := (Config{Endpoint: "http://loki:3100", Encoding: EncodingJSONLegacy}).Warnings(); len(w) != 1 {
+		t.Fatalf("Warnings = %q, want the structured metadata warning", w)
+	}
+	if w := (Config{Endpoint: "http://loki:3100"}).Warnings(); len(w) != 0 {
+		t.Fatalf("Warnings = %q, want none", w)
+	}
+	if !slices.Contains(BuildInfo().Encodings, EncodingJSONLegacy) {
+		t.Fatal("BuildInfo does not list json-legacy")
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/zabihimohsen/lokigo/lokigoaws
+
+go 1.24.0
+
+require github.com/aws/aws-sdk-go-v2 v1.39.6
+
+require github.com/aws/smithy-go v1.23.2 // indirect
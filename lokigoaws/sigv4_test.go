@@ -0,0 +1,156 @@
+package lokigoaws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var authPattern = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20260301/us-east-1/aps/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=[0-9a-f]{64}$`)
+
+func staticCreds() aws.CredentialsProvider {
+	return aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Source: "test"}, nil
+	})
+}
+
+func TestSigV4SignerAuthorizationHeaderStructure(t *testing.T) {
+	seen := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Clone()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	tr := SigV4Signer("us-east-1", "aps", staticCreds())
+	tr.now = func() time.Time { return time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC) }
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/loki/api/v1/push", bytes.NewReader([]byte(`{"streams":[]}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Scope-OrgID", "tenant")
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	h := <-seen
+	m := authPattern.FindStringSubmatch(h.Get("Authorization"))
+	if m == nil {
+		t.Fatalf("unexpected Authorization header: %q", h.Get("Authorization"))
+	}
+	for _, want := range []string{"host", "x-amz-date", "x-scope-orgid"} {
+		if !regexp.MustCompile(`(^|;)` + want + `(;|$)`).MatchString(m[1]) {
+			t.Fatalf("expected %q in signed headers %q", want, m[1])
+		}
+	}
+	if got := h.Get("X-Amz-Date"); got != "20260301T120000Z" {
+		t.Fatalf("unexpected X-Amz-Date %q", got)
+	}
+	if req.Header.Get("Authorization") != "" {
+		t.Fatal("expected the caller's request to be left unsigned")
+	}
+}
+
+func TestSigV4SignerResignsEveryAttempt(t *testing.T) {
+	var auths []string
+	var dates []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		dates = append(dates, r.Header.Get("X-Amz-Date"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var tick atomic.Int64
+	tr := SigV4Signer("us-east-1", "aps", staticCreds())
+	tr.now = func() time.Time {
+		return time.Date(2026, 3, 1, 12, 0, int(tick.Add(1)), 0, time.UTC)
+	}
+	hc := &http.Client{Transport: tr}
+	// The same request is replayed, the way lokigo retries a batch.
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, bytes.NewReader([]byte("payload")))
+	for i := 0; i < 2; i++ {
+		r := req.Clone(context.Background())
+		r.Body, _ = req.GetBody()
+		resp, err := hc.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if len(auths) != 2 || auths[0] == auths[1] || dates[0] == dates[1] {
+		t.Fatalf("expected distinct signatures per attempt, got %v / %v", auths, dates)
+	}
+}
+
+func TestSigV4SignerCredentialsError(t *testing.T) {
+	tr := SigV4Signer("us-east-1", "aps", aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{}, context.DeadlineExceeded
+	}))
+	req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:1", bytes.NewReader(nil))
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Fatal("expected credentials error")
+	}
+}
+
+// closeRecorder is a request body that records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeRecorder) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestSigV4SignerClosesRequestBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	failingCreds := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{}, context.DeadlineExceeded
+	})
+
+	for _, tc := range []struct {
+		name    string
+		creds   aws.CredentialsProvider
+		body    io.Reader
+		wantErr bool
+	}{
+		{"success", staticCreds(), strings.NewReader("payload"), false},
+		{"credentials error", failingCreds, strings.NewReader("payload"), true},
+		{"read error", staticCreds(), iotest.ErrReader(errors.New("boom")), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := &closeRecorder{Reader: tc.body}
+			req, _ := http.NewRequest(http.MethodPost, srv.URL, body)
+			req.GetBody = func() (io.ReadCloser, error) {
+				return io.NopCloser(strings.NewReader("payload")), nil
+			}
+			resp, err := SigV4Signer("us-east-1", "aps", tc.creds).RoundTrip(req)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("RoundTrip error = %v, want error %v", err, tc.wantErr)
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if !body.closed {
+				t.Fatal("expected RoundTrip to close the request body")
+			}
+		})
+	}
+}
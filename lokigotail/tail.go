@@ -0,0 +1,457 @@
+// Package lokigotail ships lines appended to a log file to Loki through a
+// lokigo.Client, for hosts where running promtail is not an option.
+//
+//	err := lokigotail.Tail(ctx, client, "/var/log/app.log",
+//		lokigotail.WithLabels(map[string]string{"job": "app"}),
+//		lokigotail.WithPositionFile("/var/lib/app/app.log.pos"),
+//	)
+//
+// The file is polled. Complete lines are sent as they appear; a trailing
+// line without a newline waits until it is finished. A file that shrinks is
+// treated as truncated and re-read from the start. A file replaced by rename
+// (logrotate's default) is read to its end before the new file at the path
+// is followed from its start.
+package lokigotail
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/zabihimohsen/lokigo"
+)
+
+// DefaultFilenameLabel is the label carrying the tailed path unless
+// WithFilenameLabel changes it.
+const DefaultFilenameLabel = "filename"
+
+const (
+	defaultPollInterval = 250 * time.Millisecond
+	// maxMultilineLines caps how many lines are joined into one entry, like
+	// promtail's multiline stage default.
+	maxMultilineLines = 128
+)
+
+// Option configures Tail.
+type Option func(*tailer)
+
+// WithLabels adds static labels to every entry, on top of the client's own
+// StaticLabels and the filename label.
+func WithLabels(labels map[string]string) Option {
+	return func(t *tailer) {
+		for k, v := range labels {
+			t.labels[k] = v
+		}
+	}
+}
+
+// WithFilenameLabel sets the label name carrying the tailed path; an empty
+// name leaves the path out.
+func WithFilenameLabel(name string) Option {
+	return func(t *tailer) { t.filenameLabel = name }
+}
+
+// WithPositionFile persists the read offset to path, so a restarted Tail
+// resumes after the last line it handed to the client instead of re-shipping
+// the file. Lines still queued in the client when the process exits are only
+// delivered if the client is closed, so close it before exiting.
+func WithPositionFile(path string) Option {
+	return func(t *tailer) { t.positionFile = path }
+}
+
+// WithPollInterval sets how often the file is checked for new data,
+// truncation and rotation. Defaults to 250ms.
+func WithPollInterval(d time.Duration) Option {
+	return func(t *tailer) {
+		if d > 0 {
+			t.poll = d
+		}
+	}
+}
+
+// WithMultiline joins lines into one entry: a line matching firstLine starts
+// a new entry and other lines are appended to the current one, separated by
+// "\n". An entry is sent once the next one starts, once the file goes quiet
+// for a poll interval, or after 128 lines.
+func WithMultiline(firstLine *regexp.Regexp) Option {
+	return func(t *tailer) { t.multiline = firstLine }
+}
+
+// WithLevelLabel detects a severity in each entry (a level=/lvl=/severity=
+// field, or a bare upper-case level word such as ERROR) and attaches it,
+// normalized to trace, debug, info, warn, error or fatal, under the label
+// name. Entries without a recognizable level get no level label.
+func WithLevelLabel(name string) Option {
+	return func(t *tailer) { t.levelLabel = name }
+}
+
+// Tail follows the file at path and sends each line to client until ctx is
+// done, then saves its position and returns ctx.Err(). A missing file is
+// waited for. Backpressure pauses reading: under BackpressureBlock Send
+// blocks, and under BackpressureDropNew an ErrDropped line is retried every
+// poll interval instead of being lost. Other Send errors stop Tail. A nil
+// client means lokigo.Default(), looked up once when Tail starts.
+func Tail(ctx context.Context, client *lokigo.Client, path string, opts ...Option) error {
+	if client == nil {
+		if client = lokigo.Default(); client == nil {
+			return lokigo.ErrNoDefaultClient
+		}
+	}
+	t := &tailer{
+		client:        client,
+		path:          path,
+		labels:        map[string]string{},
+		filenameLabel: DefaultFilenameLabel,
+		poll:          defaultPollInterval,
+		sets:          map[string]lokigo.LabelSet{},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t.run(ctx)
+}
+
+type tailer struct {
+	client        *lokigo.Client
+	path          string
+	labels        map[string]string
+	filenameLabel string
+	positionFile  string
+	poll          time.Duration
+	multiline     *regexp.Regexp
+	levelLabel    string
+
+	sets    map[string]lokigo.LabelSet // by detected level, "" for none
+	file    *os.File
+	reader  *bufio.Reader
+	readOff int64  // offset after the last complete line read
+	partial []byte // bytes after readOff not yet ending in a newline
+	pending *pendingEntry
+
+	committed int64 // offset after the last line handed to the client
+	saved     int64 // committed as last written to the position file
+}
+
+// pendingEntry is a multiline entry still collecting lines.
+type pendingEntry struct {
+	ts    time.Time
+	line  string
+	lines int
+	end   int64
+}
+
+// position is the position file's JSON content.
+type position struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+}
+
+func (t *tailer) run(ctx context.Context) error {
+	start, err := t.loadPosition()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if t.file != nil {
+			t.file.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(t.poll)
+	defer ticker.Stop()
+	for {
+		if t.file == nil {
+			if err := t.open(start); err != nil {
+				return err
+			}
+			start = 0
+		}
+		if t.file != nil {
+			if err := t.step(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if err := t.savePosition(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// open opens the file at path at offset, or from the start if the file is
+// now shorter than offset. A missing file leaves t.file nil.
+func (t *tailer) open(offset int64) error {
+	f, err := os.Open(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("lokigotail: %w", err)
+	}
+	if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("lokigotail: %w", err)
+	}
+	t.file, t.reader = f, bufio.NewReader(f)
+	t.readOff, t.committed, t.partial = offset, offset, nil
+	return nil
+}
+
+// step sends what was appended since the last poll, then handles truncation
+// and rotation.
+func (t *tailer) step(ctx context.Context) error {
+	got, err := t.readAvailable(ctx)
+	if err != nil {
+		return err
+	}
+	if !got {
+		if err := t.flushPending(ctx); err != nil {
+			return err
+		}
+	}
+
+	fi, err := t.file.Stat()
+	if err != nil {
+		return fmt.Errorf("lokigotail: %w", err)
+	}
+	if fi.Size() < t.readOff+int64(len(t.partial)) {
+		if err := t.flushPending(ctx); err != nil {
+			return err
+		}
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("lokigotail: %w", err)
+		}
+		t.reader.Reset(t.file)
+		t.readOff, t.committed, t.partial = 0, 0, nil
+		return t.savePosition()
+	}
+
+	// A missing path mid-rotation keeps the old file until a new one appears.
+	if cur, err := os.Stat(t.path); err == nil && !os.SameFile(fi, cur) {
+		if err := t.finishRotated(ctx); err != nil {
+			return err
+		}
+		return t.open(0)
+	}
+	return t.savePosition()
+}
+
+// finishRotated ships the rest of a file that was renamed away, including a
+// final line without a newline, and closes it.
+func (t *tailer) finishRotated(ctx context.Context) error {
+	if _, err := t.readAvailable(ctx); err != nil {
+		return err
+	}
+	if len(t.partial) > 0 {
+		end := t.readOff + int64(len(t.partial))
+		line := string(t.partial)
+		t.partial = nil
+		if err := t.handleLine(ctx, line, end); err != nil {
+			return err
+		}
+	}
+	if err := t.flushPending(ctx); err != nil {
+		return err
+	}
+	t.file.Close()
+	t.file, t.reader = nil, nil
+	return nil
+}
+
+// readAvailable handles every complete line up to the current end of file
+// and reports whether any bytes were read.
+func (t *tailer) readAvailable(ctx context.Context) (bool, error) {
+	got := false
+	for {
+		chunk, err := t.reader.ReadBytes('\n')
+		if len(chunk) > 0 {
+			got = true
+		}
+		if err == io.EOF {
+			t.partial = append(t.partial, chunk...)
+			return got, nil
+		}
+		if err != nil {
+			return got, fmt.Errorf("lokigotail: %w", err)
+		}
+		line := append(t.partial, chunk...)
+		t.partial = nil
+		t.readOff += int64(len(line))
+		text := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+		if err := t.handleLine(ctx, text, t.readOff); err != nil {
+			return got, err
+		}
+	}
+}
+
+// handleLine sends line, or adds it to the pending multiline entry. end is
+// the file offset just past the line.
+func (t *tailer) handleLine(ctx context.Context, line string, end int64) error {
+	if t.multiline == nil {
+		if err := t.send(ctx, time.Now(), line); err != nil {
+			return err
+		}
+		t.committed = end
+		return nil
+	}
+	if p := t.pending; p != nil && p.lines < maxMultilineLines && !t.multiline.MatchString(line) {
+		p.line += "\n" + line
+		p.lines++
+		p.end = end
+		return nil
+	}
+	if err := t.flushPending(ctx); err != nil {
+		return err
+	}
+	t.pending = &pendingEntry{ts: time.Now(), line: line, lines: 1, end: end}
+	return nil
+}
+
+func (t *tailer) flushPending(ctx context.Context) error {
+	p := t.pending
+	if p == nil {
+		return nil
+	}
+	if err := t.send(ctx, p.ts, p.line); err != nil {
+		return err
+	}
+	t.pending = nil
+	t.committed = p.end
+	return nil
+}
+
+// send hands one entry to the client, retrying ErrDropped so that a full
+// queue pauses reading instead of losing lines.
+func (t *tailer) send(ctx context.Context, ts time.Time, line string) error {
+	ls := t.labelSet(line)
+	for {
+		err := t.client.SendWithLabelSet(ctx, ts, line, ls)
+		if !errors.Is(err, lokigo.ErrDropped) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.poll):
+		}
+	}
+}
+
+func (t *tailer) labelSet(line string) lokigo.LabelSet {
+	level := ""
+	if t.levelLabel != "" {
+		level = detectLevel(line)
+	}
+	if ls, ok := t.sets[level]; ok {
+		return ls
+	}
+	labels := make(map[string]string, len(t.labels)+2)
+	for k, v := range t.labels {
+		labels[k] = v
+	}
+	if t.filenameLabel != "" {
+		labels[t.filenameLabel] = t.path
+	}
+	if level != "" {
+		labels[t.levelLabel] = level
+	}
+	ls := t.client.NewLabelSet(labels)
+	t.sets[level] = ls
+	return ls
+}
+
+var (
+	levelField = regexp.MustCompile(`(?i)\b(?:level|lvl|severity)"?\s*[=:]\s*"?([a-z]+)`)
+	levelWord  = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|WARN|WARNING|ERROR|ERR|FATAL|CRITICAL|CRIT|PANIC)\b`)
+)
+
+// detectLevel returns the normalized severity of line, or "" if none is
+// recognized.
+func detectLevel(line string) string {
+	var raw string
+	if m := levelField.FindStringSubmatch(line); m != nil {
+		raw = m[1]
+	} else if m := levelWord.FindStringSubmatch(line); m != nil {
+		raw = m[1]
+	}
+	switch raw = strings.ToLower(raw); raw {
+	case "trace", "debug", "info", "error", "fatal":
+		return raw
+	case "warn", "warning":
+		return "warn"
+	case "err":
+		return "error"
+	case "critical", "crit", "panic":
+		return "fatal"
+	}
+	return ""
+}
+
+// loadPosition returns the saved offset for the tailed path, or 0 when
+// there is no position file yet.
+func (t *tailer) loadPosition() (int64, error) {
+	if t.positionFile == "" {
+		return 0, nil
+	}
+	b, err := os.ReadFile(t.positionFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lokigotail: %w", err)
+	}
+	var pos position
+	if err := json.Unmarshal(b, &pos); err != nil {
+		return 0, fmt.Errorf("lokigotail: position file %s: %w", t.positionFile, err)
+	}
+	if pos.Path != t.path {
+		return 0, fmt.Errorf("lokigotail: position file %s belongs to %s, not %s", t.positionFile, pos.Path, t.path)
+	}
+	t.saved = pos.Offset
+	return pos.Offset, nil
+}
+
+// savePosition writes the committed offset if it changed, replacing the
+// position file atomically.
+func (t *tailer) savePosition() error {
+	if t.positionFile == "" || t.committed == t.saved {
+		return nil
+	}
+	b, err := json.Marshal(position{Path: t.path, Offset: t.committed})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.positionFile), filepath.Base(t.positionFile)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("lokigotail: %w", err)
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), t.positionFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("lokigotail: %w", err)
+	}
+	t.saved = t.committed
+	return nil
+}
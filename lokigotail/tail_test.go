@@ -0,0 +1,260 @@
+package lokigotail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zabihimohsen/lokigo"
+)
+
+type capturedLine struct {
+	labels map[string]string
+	line   string
+}
+
+// newTailClient returns a client pushing JSON to a capture server, and a
+// func returning the received lines in arrival order. gate, if non-nil, is
+// waited on before each push is answered.
+func newTailClient(t *testing.T, cfg lokigo.Config, gate <-chan struct{}) (*lokigo.Client, func() []capturedLine) {
+	t.Helper()
+	var mu sync.Mutex
+	var lines []capturedLine
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if gate != nil {
+			<-gate
+		}
+		var payload struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		mu.Lock()
+		for _, s := range payload.Streams {
+			for _, v := range s.Values {
+				lines = append(lines, capturedLine{labels: s.Stream, line: v[1]})
+			}
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg.Endpoint = srv.URL
+	cfg.Encoding = lokigo.EncodingJSON
+	if cfg.BatchMaxWait == 0 {
+		cfg.BatchMaxWait = 5 * time.Millisecond
+	}
+	client, err := lokigo.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close(context.Background()) })
+	return client, func() []capturedLine {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]capturedLine(nil), lines...)
+	}
+}
+
+// startTail runs Tail in the background and returns a func that stops it
+// and returns its error.
+func startTail(t *testing.T, client *lokigo.Client, path string, opts ...Option) func() error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	opts = append([]Option{WithPollInterval(5 * time.Millisecond)}, opts...)
+	go func() { done <- Tail(ctx, client, path, opts...) }()
+	return func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("Tail did not return after cancel")
+			return nil
+		}
+	}
+}
+
+// waitLines waits until exactly the want lines were received and returns
+// them sorted by line; entries of different streams arrive in no fixed order.
+func waitLines(t *testing.T, got func() []capturedLine, want ...string) []capturedLine {
+	t.Helper()
+	want = slices.Sorted(slices.Values(want))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lines := got()
+		slices.SortFunc(lines, func(a, b capturedLine) int { return strings.Compare(a.line, b.line) })
+		texts := make([]string, len(lines))
+		for i, l := range lines {
+			texts[i] = l.line
+		}
+		if reflect.DeepEqual(texts, want) {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lines = %q, want %q", texts, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailFollowsRotationAndTruncation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendFile(t, path, "a\nb\n")
+
+	client, got := newTailClient(t, lokigo.Config{}, nil)
+	stop := startTail(t, client, path, WithLabels(map[string]string{"job": "app"}))
+	waitLines(t, got, "a", "b")
+
+	// Rename-based rotation: the old file gets a last write after the
+	// rename, including an unterminated line, then a new file appears.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "c\npartial")
+	appendFile(t, path, "dddddd\n")
+	waitLines(t, got, "a", "b", "c", "partial", "dddddd")
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "e\n")
+	lines := waitLines(t, got, "a", "b", "c", "partial", "dddddd", "e")
+
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Tail err = %v, want context.Canceled", err)
+	}
+	want := map[string]string{"job": "app", DefaultFilenameLabel: path}
+	for _, l := range lines {
+		if !reflect.DeepEqual(l.labels, want) {
+			t.Fatalf("labels of %q = %v, want %v", l.line, l.labels, want)
+		}
+	}
+}
+
+func TestTailResumesFromPositionFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	pos := filepath.Join(dir, "app.pos")
+	appendFile(t, path, "one\ntwo\nhalf")
+
+	client, got := newTailClient(t, lokigo.Config{}, nil)
+	stop := startTail(t, client, path, WithPositionFile(pos))
+	waitLines(t, got, "one", "two")
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Tail err = %v", err)
+	}
+	b, err := os.ReadFile(pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"path":"` + path + `","offset":8}`; string(b) != want {
+		t.Fatalf("position file = %s, want %s", b, want)
+	}
+
+	// A restarted tail ships only what follows the saved offset.
+	appendFile(t, path, "-line\nthree\n")
+	client, got = newTailClient(t, lokigo.Config{}, nil)
+	stop = startTail(t, client, path, WithPositionFile(pos))
+	waitLines(t, got, "half-line", "three")
+	if err := stop(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Tail err = %v", err)
+	}
+
+	if err := Tail(context.Background(), client, filepath.Join(dir, "other.log"), WithPositionFile(pos)); err == nil {
+		t.Fatal("expected an error for a position file of another path")
+	}
+}
+
+func TestTailMultilineAndLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendFile(t, path, "2024-01-02 level=error boom\n\tat frame1\n\tat frame2\n2024-01-02 INFO ok\n")
+
+	client, got := newTailClient(t, lokigo.Config{}, nil)
+	stop := startTail(t, client, path,
+		WithFilenameLabel(""),
+		WithMultiline(regexp.MustCompile(`^\d{4}-`)),
+		WithLevelLabel("level"),
+	)
+	lines := waitLines(t, got, "2024-01-02 level=error boom\n\tat frame1\n\tat frame2", "2024-01-02 INFO ok")
+	stop()
+
+	if !reflect.DeepEqual(lines[0].labels, map[string]string{"level": "info"}) {
+		t.Fatalf("INFO entry labels = %v", lines[0].labels)
+	}
+	if lvl := lines[1].labels["level"]; lvl != "error" {
+		t.Fatalf("multiline entry level = %q", lvl)
+	}
+}
+
+func TestTailPausesOnBackpressure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendFile(t, path, "1\n2\n3\n4\n5\n")
+
+	gate := make(chan struct{})
+	client, got := newTailClient(t, lokigo.Config{
+		BackpressureMode: lokigo.BackpressureDropNew,
+		QueueSize:        1,
+		BatchMaxEntries:  1,
+	}, gate)
+	stop := startTail(t, client, path)
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.Metrics().Dropped == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("queue never filled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(gate)
+	waitLines(t, got, "1", "2", "3", "4", "5")
+}
+
+func TestDetectLevel(t *testing.T) {
+	cases := map[string]string{
+		`ts=1 level=warning msg=x`:     "warn",
+		`{"lvl":"DEBUG","msg":"x"}`:    "debug",
+		`severity: Critical disk full`: "fatal",
+		`2024 ERR failed`:              "error",
+		`information only`:             "",
+		`[INFO] started`:               "info",
+	}
+	for line, want := range cases {
+		if got := detectLevel(line); got != want {
+			t.Errorf("detectLevel(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package lokigo
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ndjsonEntry is the NDJSON schema of an Entry. It is stable: fields are
+// only ever added.
+type ndjsonEntry struct {
+	Timestamp string            `json:"ts"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Line      string            `json:"line"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	TenantID  string            `json:"tenant,omitempty"`
+}
+
+// ExportNDJSON writes entries to w as newline-delimited JSON, one object
+// per entry with the fields ts (RFC 3339 with nanoseconds, UTC), labels,
+// line, metadata and tenant, for shipping them later with ImportNDJSON, for
+// example entries kept while Loki was unreachable. Empty fields other than
+// ts and line are omitted. Lines and values that are not valid UTF-8 are
+// written with U+FFFD in place of invalid bytes.
+func ExportNDJSON(w io.Writer, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	for _, e := range entries {
+		if err := enc.Encode(ndjsonEntry{
+			Timestamp: e.Timestamp.UTC().Format(time.RFC3339Nano),
+			Labels:    e.Labels,
+			Line:      e.Line,
+			Metadata:  e.Metadata,
+			TenantID:  e.TenantID,
+		}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportNDJSON reads entries written by ExportNDJSON. Blank lines are
+// skipped; a malformed line fails the import with its line number.
+func ImportNDJSON(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	sc := bufio.NewScanner(r)
+	// Lines can be as long as the entries they hold.
+	sc.Buffer(nil, 64<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var ne ndjsonEntry
+		if err := json.Unmarshal(line, &ne); err != nil {
+			return entries, fmt.Errorf("lokigo: ndjson line %d: %w", n, err)
+		}
+		ts, err := time.Parse(time.RFC3339Nano, ne.Timestamp)
+		if err != nil {
+			return entries, fmt.Errorf("lokigo: ndjson line %d: %w", n, err)
+		}
+		entries = append(entries, Entry{Timestamp: ts, Labels: ne.Labels, Line: ne.Line, Metadata: ne.Metadata, TenantID: ne.TenantID})
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return entries, fmt.Errorf("lokigo: ndjson line longer than 64MiB: %w", err)
+		}
+		return entries, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,57 @@
+package lokigo
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Timestamp: time.Unix(1700000000, 123456789).UTC(), Line: "plain", Labels: map[string]string{"app": "api"}},
+		{
+			Timestamp: time.Date(2024, 2, 29, 23, 59, 59, 1, time.UTC),
+			Line:      "multi\nline \"quoted\" <b>&amp; tab\t nul\x00 emoji 🚀 sep\u2028 ü",
+			Labels:    map[string]string{"path": `C:\logs\{app}`, "unicode": "日本語"},
+			Metadata:  map[string]string{"trace_id": "abc", "json": `{"a":[1,2]}`},
+			TenantID:  "team-a",
+		},
+		{Timestamp: time.Unix(0, 0).UTC(), Line: ""},
+	}
+	var buf bytes.Buffer
+	if err := ExportNDJSON(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != len(entries) {
+		t.Fatalf("export has %d lines, want one per entry:\n%s", n, buf.String())
+	}
+	got, err := ImportNDJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Fatalf("round trip =\n%+v\nwant\n%+v", got, entries)
+	}
+}
+
+func TestNDJSONSchema(t *testing.T) {
+	var buf bytes.Buffer
+	e := Entry{Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 600, time.FixedZone("CET", 3600)), Line: "x<y", Labels: map[string]string{"app": "a"}, TenantID: "t"}
+	if err := ExportNDJSON(&buf, []Entry{e}); err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"ts":"2024-01-02T02:04:05.0000006Z","labels":{"app":"a"},"line":"x<y","tenant":"t"}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("export = %s, want %s", buf.String(), want)
+	}
+}
+
+func TestImportNDJSONReportsBadLine(t *testing.T) {
+	in := `{"ts":"2024-01-01T00:00:00Z","line":"ok"}` + "\n\n" + `{"ts":"yesterday","line":"bad"}` + "\n"
+	got, err := ImportNDJSON(strings.NewReader(in))
+	if err == nil || !strings.Contains(err.Error(), "line 3") || len(got) != 1 {
+		t.Fatalf("ImportNDJSON = %d entries, %v; want 1 entry and an error on line 3", len(got), err)
+	}
+}
@@ -0,0 +1,95 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// batchEncoding returns the encoding of entries pushed to target, by size
+// under AdaptiveEncoding.
+func (c *Client) batchEncoding(ctx context.Context, target pushTarget, entries []Entry) Encoding {
+	if a := c.cfg.AdaptiveEncoding; a.Enabled {
+		if lineBytes(entries) > a.ProtobufAboveBytes {
+			return EncodingProtobufSnappy
+		}
+		return EncodingJSON
+	}
+	return c.payloadEncoding(ctx, target)
+}
+
+// payloadEncoding returns the encoding of the next push to target. With
+// NegotiateEncoding it probes the endpoint first, once per client; until a
+// probe is conclusive, Config.Encoding is used.
+func (c *Client) payloadEncoding(ctx context.Context, target pushTarget) Encoding {
+	if !c.cfg.NegotiateEncoding {
+		return c.cfg.Encoding
+	}
+	if enc := c.negotiated.Load(); enc != nil {
+		return *enc
+	}
+	c.negotiateMu.Lock()
+	defer c.negotiateMu.Unlock()
+	if enc := c.negotiated.Load(); enc != nil {
+		return *enc
+	}
+	return c.negotiate(ctx, target)
+}
+
+// renegotiate re-probes after err rejected a push in enc as an unsupported
+// media type. ok reports whether the push should be repeated in next.
+func (c *Client) renegotiate(ctx context.Context, target pushTarget, enc Encoding, err error) (next Encoding, ok bool) {
+	var statusErr *HTTPStatusPushError
+	if !c.cfg.NegotiateEncoding || !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusUnsupportedMediaType {
+		return "", false
+	}
+	c.negotiateMu.Lock()
+	defer c.negotiateMu.Unlock()
+	if cur := c.negotiated.Load(); cur != nil && *cur != enc {
+		// Another push already switched encodings.
+		return *cur, true
+	}
+	c.negotiated.Store(nil)
+	next = c.negotiate(ctx, target)
+	return next, next != enc
+}
+
+// negotiate probes target with an empty protobuf push and records protobuf
+// if it is accepted, or JSON if it is refused as unsupported. Other
+// outcomes, such as network errors, leave the decision open and return
+// Config.Encoding. It must be called with negotiateMu held.
+//
+// The probe goes through pushOnce, so it carries the push headers, tenant
+// and credentials, but skips PushInterceptors, retries and metrics.
+func (c *Client) negotiate(ctx context.Context, target pushTarget) Encoding {
+	payload, err := compressProtobuf(c.cfg.ProtobufCompression, nil)
+	if err != nil {
+		return c.cfg.Encoding
+	}
+	header, err := buildHeaders(c.cfg, batchHeaders{
+		encoder:  c.encoderHeader(EncodingProtobufSnappy, "application/x-protobuf", protobufContentEncoding(c.cfg.ProtobufCompression)),
+		tenantID: target.tenantID,
+	})
+	if err != nil {
+		return c.cfg.Encoding
+	}
+	err = c.pushOnce(ctx, &PushRequestInfo{
+		Endpoint: target.endpoint,
+		TenantID: target.tenantID,
+		Header:   header,
+		Payload:  payload,
+	})
+	var statusErr *HTTPStatusPushError
+	enc := EncodingProtobufSnappy
+	switch {
+	case err == nil:
+	case errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusUnsupportedMediaType || statusErr.StatusCode == http.StatusBadRequest):
+		enc = EncodingJSON
+	default:
+		c.debug("encoding negotiation inconclusive", "endpoint", target.endpoint, "error", err)
+		return c.cfg.Encoding
+	}
+	c.negotiated.Store(&enc)
+	c.debug("negotiated encoding", "endpoint", target.endpoint, "encoding", enc)
+	return enc
+}
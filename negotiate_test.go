@@ -0,0 +1,147 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/zabihimohsen/lokigo/internal/push"
+)
+
+// formatServer accepts pushes in the enabled formats, answering 415
+// otherwise, and records the lines received.
+type formatServer struct {
+	*httptest.Server
+	protobuf atomic.Bool
+	json     atomic.Bool
+
+	mu    sync.Mutex
+	lines []string
+}
+
+func newFormatServer(t *testing.T, protobuf, json bool) *formatServer {
+	t.Helper()
+	s := &formatServer{}
+	s.protobuf.Store(protobuf)
+	s.json.Store(json)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *formatServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var lines []string
+	switch r.Header.Get("Content-Type") {
+	case "application/x-protobuf":
+		if !s.protobuf.Load() {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		raw, _ := snappy.Decode(nil, body)
+		var req push.PushRequest
+		_ = req.Unmarshal(raw)
+		for _, st := range req.Streams {
+			for _, e := range st.Entries {
+				lines = append(lines, e.Line)
+			}
+		}
+	case "application/json":
+		if !s.json.Load() {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		var payload struct {
+			Streams []jsonStreamValues `json:"streams"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		for _, st := range payload.Streams {
+			for _, v := range st.Values {
+				lines = append(lines, v[1])
+			}
+		}
+	}
+	s.mu.Lock()
+	s.lines = append(s.lines, lines...)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *formatServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+func TestNegotiateEncodingSelectsAcceptedFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		protobuf, json bool
+		configured     Encoding
+		want           Encoding
+	}{
+		{"json only", false, true, EncodingProtobufSnappy, EncodingJSON},
+		{"protobuf only", true, false, EncodingJSON, EncodingProtobufSnappy},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFormatServer(t, tc.protobuf, tc.json)
+			c, err := NewClient(Config{Endpoint: srv.URL, Encoding: tc.configured, NegotiateEncoding: true, BatchMaxEntries: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range []string{"a", "b"} {
+				if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := c.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if got := *c.negotiated.Load(); got != tc.want {
+				t.Fatalf("negotiated %q, want %q", got, tc.want)
+			}
+			if got := srv.received(); len(got) != 2 {
+				t.Fatalf("received %v, want both lines", got)
+			}
+			if m := c.Metrics(); m.Pushed != 2 || m.PushErrors != 0 {
+				t.Fatalf("Pushed = %d, PushErrors = %d; the probe must not count as a push", m.Pushed, m.PushErrors)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncodingRenegotiatesOn415(t *testing.T) {
+	srv := newFormatServer(t, true, false)
+	c, err := NewClient(Config{Endpoint: srv.URL, NegotiateEncoding: true, BatchMaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(context.Background(), Entry{Line: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the first push so the flip below affects the second.
+	for len(srv.received()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	srv.protobuf.Store(false)
+	srv.json.Store(true)
+	if err := c.Send(context.Background(), Entry{Line: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("Close = %v, want the rejected batch repeated in JSON", err)
+	}
+	if got := srv.received(); len(got) != 2 || got[1] != "b" {
+		t.Fatalf("received %v, want [a b]", got)
+	}
+	if got := *c.negotiated.Load(); got != EncodingJSON {
+		t.Fatalf("negotiated %q after the switch, want json", got)
+	}
+}
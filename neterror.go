@@ -0,0 +1,79 @@
+package lokigo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// NetworkErrorKind classifies a NetworkPushError.
+type NetworkErrorKind string
+
+const (
+	// NetworkErrorTimeout is a request, dial or per-attempt deadline that
+	// expired: the server may be overloaded, or the timeout too low for the
+	// batch size.
+	NetworkErrorTimeout NetworkErrorKind = "timeout"
+	// NetworkErrorConnectionRefused means nothing accepted the connection:
+	// wrong endpoint or server down.
+	NetworkErrorConnectionRefused NetworkErrorKind = "connection_refused"
+	// NetworkErrorDNS is a failed name lookup.
+	NetworkErrorDNS NetworkErrorKind = "dns"
+	// NetworkErrorTLS is a failed handshake or certificate verification.
+	NetworkErrorTLS NetworkErrorKind = "tls"
+	// NetworkErrorOther is any other transport failure.
+	NetworkErrorOther NetworkErrorKind = "other"
+)
+
+func newNetworkPushError(err error) *NetworkPushError {
+	return &NetworkPushError{Err: err, Kind: networkErrorKind(err)}
+}
+
+// Timeout reports whether the push failed because a deadline expired,
+// either in the transport or through context.DeadlineExceeded.
+func (e *NetworkPushError) Timeout() bool {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(e.Err, &ne) && ne.Timeout()
+}
+
+// Temporary delegates to the wrapped error's Temporary method when there is
+// one, and otherwise reports Timeout.
+func (e *NetworkPushError) Temporary() bool {
+	var te interface{ Temporary() bool }
+	if errors.As(e.Err, &te) {
+		return te.Temporary()
+	}
+	return e.Timeout()
+}
+
+func networkErrorKind(err error) NetworkErrorKind {
+	var (
+		dnsErr     *net.DNSError
+		recordErr  tls.RecordHeaderError
+		alertErr   tls.AlertError
+		verifyErr  *tls.CertificateVerificationError
+		unknownCA  x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+	)
+	e := &NetworkPushError{Err: err}
+	switch {
+	case e.Timeout():
+		return NetworkErrorTimeout
+	case errors.As(err, &dnsErr):
+		return NetworkErrorDNS
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &unknownCA), errors.As(err, &hostErr), errors.As(err, &invalidErr):
+		return NetworkErrorTLS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return NetworkErrorConnectionRefused
+	default:
+		return NetworkErrorOther
+	}
+}
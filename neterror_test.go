@@ -0,0 +1,143 @@
+package lokigo
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestNetworkPushErrorKind(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	cases := []struct {
+		name      string
+		err       error
+		kind      NetworkErrorKind
+		timeout   bool
+		temporary bool
+	}{
+		{"refused", &url.Error{Op: "Post", URL: "http://loki", Err: refused}, NetworkErrorConnectionRefused, false, false},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, NetworkErrorTimeout, true, true},
+		{"attempt deadline", &url.Error{Op: "Post", URL: "http://loki", Err: context.DeadlineExceeded}, NetworkErrorTimeout, true, true},
+		{"dns not found", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "loki", IsNotFound: true}}, NetworkErrorDNS, false, false},
+		{"dns timeout", &net.DNSError{Err: "timeout", Name: "loki", IsTimeout: true}, NetworkErrorTimeout, true, true},
+		{"tls record", fmt.Errorf("handshake: %w", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), NetworkErrorTLS, false, false},
+		{"tls unknown ca", &url.Error{Op: "Post", URL: "https://loki", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}, NetworkErrorTLS, false, false},
+		{"other", errors.New("connection reset"), NetworkErrorOther, false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := newNetworkPushError(tc.err)
+			if e.Kind != tc.kind {
+				t.Fatalf("expected kind %s, got %s", tc.kind, e.Kind)
+			}
+			if e.Timeout() != tc.timeout {
+				t.Fatalf("expected Timeout()=%v", tc.timeout)
+			}
+			if e.Temporary() != tc.temporary {
+				t.Fatalf("expected Temporary()=%v", tc.temporary)
+			}
+		})
+	}
+}
+
+func TestNetworkErrorsCountedByKind(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c, err := NewClient(Config{Endpoint: "http://" + addr, DisableBatching: true, Retry: RetryConfig{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+
+	err = c.Send(context.Background(), Entry{Line: "x"})
+	var netErr *NetworkPushError
+	if !errors.As(err, &netErr) || netErr.Kind != NetworkErrorConnectionRefused {
+		t.Fatalf("expected a connection refused NetworkPushError, got %v", err)
+	}
+	if got := c.Metrics().NetworkErrorsByKind[NetworkErrorConnectionRefused]; got != 2 {
+		t.Fatalf("expected 2 refused attempts counted, got %d", got)
+	}
+}
+
+func TestRetryInitialDelayAfterConnectErrors(t *testing.T) {
+	retry := RetryConfig{MaxAttempts: 4, MinBackoff: 200 * time.Millisecond, MaxBackoff: time.Second, InitialDelay: 20 * time.Millisecond}
+	for _, tc := range []struct {
+		name     string
+		refused  int
+		status   int
+		minWait  time.Duration
+		maxWait  time.Duration
+		attempts int
+	}{
+		// The initial delay alone covers a single refused dial.
+		{"refused once", 1, 0, 10 * time.Millisecond, 150 * time.Millisecond, 2},
+		// The wait after it is MinBackoff, not the second backoff step.
+		{"refused twice", 2, 0, 160 * time.Millisecond, 300 * time.Millisecond, 3},
+		// HTTP-level failures back off as before.
+		{"status 503", 0, http.StatusServiceUnavailable, 160 * time.Millisecond, time.Second, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var failed atomic.Bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				if tc.status != 0 && failed.CompareAndSwap(false, true) {
+					w.WriteHeader(tc.status)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+			var dials atomic.Int32
+			var d net.Dialer
+			c, err := NewClient(Config{
+				Endpoint:        srv.URL,
+				DisableBatching: true,
+				Retry:           retry,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					if int(dials.Add(1)) <= tc.refused {
+						return nil, &net.OpError{Op: "dial", Net: network, Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+					}
+					return d.DialContext(ctx, network, addr)
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close(context.Background())
+			start := time.Now()
+			if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			if elapsed < tc.minWait || elapsed >= tc.maxWait {
+				t.Errorf("push took %v, want within [%v, %v)", elapsed, tc.minWait, tc.maxWait)
+			}
+			if n := c.Metrics().Retries; n != uint64(tc.attempts-1) {
+				t.Errorf("Retries = %d, want %d", n, tc.attempts-1)
+			}
+		})
+	}
+}
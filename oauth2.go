@@ -0,0 +1,116 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oauthExpiryDelta refreshes tokens this long before they expire, so a token
+// does not run out while a push is in flight.
+const oauthExpiryDelta = 10 * time.Second
+
+// OAuth2Config enables the OAuth2 client credentials grant. The access token
+// is cached and refreshed shortly before it expires, and sent as a Bearer
+// Authorization header; NewClient rejects an Authorization in Headers
+// alongside it.
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+func (o OAuth2Config) enabled() bool {
+	return o.TokenURL != "" || o.ClientID != "" || o.ClientSecret != ""
+}
+
+// oauthTokenSource fetches and caches client-credentials tokens. It is safe
+// for concurrent use.
+type oauthTokenSource struct {
+	cfg        OAuth2Config
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newOAuthTokenSource(cfg OAuth2Config, hc *http.Client) *oauthTokenSource {
+	if !cfg.enabled() {
+		return nil
+	}
+	return &oauthTokenSource{cfg: cfg, httpClient: hc, now: time.Now}
+}
+
+// Token returns a valid access token, fetching a new one when the cached one
+// is missing or about to expire.
+func (s *oauthTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && (s.expires.IsZero() || s.now().Add(oauthExpiryDelta).Before(s.expires)) {
+		return s.token, nil
+	}
+	token, expiresIn, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	s.expires = time.Time{}
+	if expiresIn > 0 {
+		s.expires = s.now().Add(time.Duration(expiresIn) * time.Second)
+	}
+	return s.token, nil
+}
+
+// invalidate drops the cached token, e.g. after the server answered 401.
+func (s *oauthTokenSource) invalidate() {
+	s.mu.Lock()
+	s.token = ""
+	s.mu.Unlock()
+}
+
+func (s *oauthTokenSource) fetch(ctx context.Context) (string, int64, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.cfg.ClientID), url.QueryEscape(s.cfg.ClientSecret))
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("oauth2 token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", 0, fmt.Errorf("oauth2 token response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", 0, fmt.Errorf("oauth2 token request failed: %d", resp.StatusCode)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", 0, fmt.Errorf("oauth2 token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", 0, errors.New("oauth2 token response: missing access_token")
+	}
+	return tok.AccessToken, tok.ExpiresIn, nil
+}
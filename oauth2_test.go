@@ -0,0 +1,116 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newFakeTokenServer(t *testing.T, expiresIn int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "s3cret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" || r.PostForm.Get("scope") != "logs:write logs:read" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"tok-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &issued
+}
+
+func TestOAuth2TokenCachedAndRefreshedAfterExpiry(t *testing.T) {
+	tokenSrv, issued := newFakeTokenServer(t, 3600)
+	var mu sync.Mutex
+	var auths []string
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer loki.Close()
+
+	c, err := NewClient(Config{
+		Endpoint:        loki.URL,
+		Encoding:        EncodingJSON,
+		DisableBatching: true,
+		OAuth2:          OAuth2Config{TokenURL: tokenSrv.URL, ClientID: "client", ClientSecret: "s3cret", Scopes: []string{"logs:write", "logs:read"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	c.oauth.now = clock.Now
+
+	send := func() {
+		t.Helper()
+		if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send()
+	send()
+	_ = clock.Sleep(context.Background(), time.Hour)
+	send()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"Bearer tok-1", "Bearer tok-1", "Bearer tok-2"}
+	for i := range want {
+		if auths[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, auths)
+		}
+	}
+	if n := issued.Load(); n != 2 {
+		t.Fatalf("expected 2 token fetches, got %d", n)
+	}
+}
+
+func TestOAuth2TokenFailureIsRetryableNetworkError(t *testing.T) {
+	var tokenAttempts atomic.Int32
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenAttempts.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer tokenSrv.Close()
+
+	c, err := NewClient(Config{
+		Endpoint:        "http://127.0.0.1:1",
+		Encoding:        EncodingJSON,
+		DisableBatching: true,
+		OAuth2:          OAuth2Config{TokenURL: tokenSrv.URL, ClientID: "client", ClientSecret: "s3cret"},
+		Retry:           RetryConfig{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Send(context.Background(), Entry{Line: "x"})
+	var netErr *NetworkPushError
+	if !errors.As(err, &netErr) {
+		t.Fatalf("expected NetworkPushError, got %v", err)
+	}
+	if n := tokenAttempts.Load(); n != 3 {
+		t.Fatalf("expected token fetch retried on every attempt, got %d", n)
+	}
+}
+
+func TestOAuth2Validation(t *testing.T) {
+	if _, err := NewClient(Config{Endpoint: "http://127.0.0.1", OAuth2: OAuth2Config{ClientID: "x"}}); err == nil {
+		t.Fatal("expected validation error without tokenURL")
+	}
+}
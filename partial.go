@@ -0,0 +1,212 @@
+package lokigo
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PartialSuccess describes entries rejected within an accepted push, as
+// reported by the response body. Bodies are recognized in two formats: an
+// OTLP-style partialSuccess object, as returned by OpenTelemetry gateways,
+// and Loki's per-entry rejection messages, such as "entry for stream
+// '{app="api"}' has timestamp too old". Other bodies leave the push a plain
+// success, with FlushStats.Warning only.
+type PartialSuccess struct {
+	// Rejected is the number of entries the server rejected.
+	Rejected int
+	// Streams lists the rejected entries by stream when the body names
+	// them, in order of first mention.
+	Streams []StreamRejection
+	// Message is the server's description of the first rejection.
+	Message string
+}
+
+// StreamRejection is the rejected part of one stream in a PartialSuccess.
+type StreamRejection struct {
+	// Stream is the Loki label set, for example {app="api"}.
+	Stream  string
+	Entries int
+	// Reason is the server's message for the stream's first rejection.
+	Reason string
+}
+
+// otlpPartialSuccess matches the JSON encoding of OTLP export responses,
+// in either field casing protobuf JSON allows.
+type otlpPartialSuccess struct {
+	PartialSuccess *otlpRejection `json:"partialSuccess"`
+	SnakeCase      *otlpRejection `json:"partial_success"`
+}
+
+type otlpRejection struct {
+	Rejected      json.RawMessage `json:"rejectedLogRecords"`
+	RejectedSnake json.RawMessage `json:"rejected_log_records"`
+	Message       string          `json:"errorMessage"`
+	MessageSnake  string          `json:"error_message"`
+}
+
+var (
+	// lokiStreamPattern finds the stream of a Loki rejection message:
+	// "... for stream '{...}' ..." or "... for stream: {...},".
+	lokiStreamPattern = regexp.MustCompile(`for stream(?: '(\{.*?\})'|: (\{.*\}),?\s*$)`)
+	// lokiTotalPattern finds the summary Loki adds after out-of-order
+	// rejections.
+	lokiTotalPattern = regexp.MustCompile(`total ignored: (\d+) out of \d+`)
+)
+
+// parsePartialSuccess returns the partial success body reports, or nil
+// when body is in no known format or reports nothing rejected.
+func parsePartialSuccess(body string) *PartialSuccess {
+	if strings.HasPrefix(body, "{") {
+		return parseOTLPPartialSuccess(body)
+	}
+	return parseLokiRejections(body)
+}
+
+func parseOTLPPartialSuccess(body string) *PartialSuccess {
+	var resp otlpPartialSuccess
+	if json.Unmarshal([]byte(body), &resp) != nil {
+		return nil
+	}
+	r := resp.PartialSuccess
+	if r == nil {
+		r = resp.SnakeCase
+	}
+	if r == nil {
+		return nil
+	}
+	raw := r.Rejected
+	if raw == nil {
+		raw = r.RejectedSnake
+	}
+	// Protobuf JSON encodes int64 as a string, but accept numbers too.
+	n, err := strconv.Atoi(strings.Trim(string(raw), `"`))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	return &PartialSuccess{Rejected: n, Message: r.Message + r.MessageSnake}
+}
+
+func parseLokiRejections(body string) *PartialSuccess {
+	ps := &PartialSuccess{}
+	index := map[string]int{}
+	// summarized marks streams whose count comes from Loki's "total
+	// ignored" summary rather than from their per-entry lines.
+	var summarized []bool
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		m := lokiStreamPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		stream := m[1] + m[2]
+		i, ok := index[stream]
+		if !ok {
+			i = len(ps.Streams)
+			index[stream] = i
+			ps.Streams = append(ps.Streams, StreamRejection{Stream: stream, Reason: line})
+			summarized = append(summarized, false)
+		}
+		if t := lokiTotalPattern.FindStringSubmatch(line); t != nil {
+			n, _ := strconv.Atoi(t[1])
+			ps.Streams[i].Entries, summarized[i] = n, true
+		} else if !summarized[i] {
+			ps.Streams[i].Entries++
+		}
+		if ps.Message == "" {
+			ps.Message = line
+		}
+	}
+	if len(ps.Streams) == 0 {
+		return nil
+	}
+	for _, s := range ps.Streams {
+		ps.Rejected += s.Entries
+	}
+	return ps
+}
+
+// StreamRejectedError is the OnDeadLetter error of the entries of a stream
+// rejected within an accepted push, as named by PartialSuccess.Streams.
+type StreamRejectedError struct {
+	StreamRejection
+}
+
+func (e *StreamRejectedError) Error() string {
+	return fmt.Sprintf("loki rejected %d entries of stream %s: %s", e.Entries, e.Stream, e.Reason)
+}
+
+// deadLetterRejected passes the entries of the streams rejected reports to
+// FallbackWriter and OnDeadLetter, one call per stream. Streams are matched
+// on the tagged entries, which are what the server saw, and their original
+// entries are passed on. The server does not say which entries of a stream
+// it rejected, so all of them are.
+func (c *Client) deadLetterRejected(onWorker bool, original, tagged []Entry, rejected []*PartialSuccess) {
+	if len(rejected) == 0 || c.fallback == nil && c.cfg.OnDeadLetter == nil {
+		return
+	}
+	var order []string
+	byStream := map[string]StreamRejection{}
+	for _, ps := range rejected {
+		for _, r := range ps.Streams {
+			labels, ok := parseLokiLabelSet(r.Stream)
+			if !ok {
+				continue
+			}
+			key := toLokiLabelSet(labels)
+			if _, seen := byStream[key]; !seen {
+				order = append(order, key)
+				byStream[key] = r
+			}
+		}
+	}
+	if len(order) == 0 {
+		return
+	}
+	groups := make(map[string][]Entry, len(order))
+	for i, e := range tagged {
+		key := c.streamKey(e)
+		if _, ok := byStream[key]; ok {
+			groups[key] = append(groups[key], original[i])
+		}
+	}
+	for _, key := range order {
+		entries := groups[key]
+		if len(entries) == 0 {
+			continue
+		}
+		c.writeFallback(entries)
+		if c.cfg.OnDeadLetter != nil {
+			c.deadLetter(onWorker, entries, &StreamRejectedError{StreamRejection: byStream[key]})
+		}
+	}
+}
+
+// parseLokiLabelSet parses a stream as Loki prints it, such as
+// {app="api", env="prod"}.
+func parseLokiLabelSet(s string) (map[string]string, bool) {
+	s, ok := strings.CutPrefix(s, "{")
+	if !ok {
+		return nil, false
+	}
+	labels := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if rest, ok := strings.CutPrefix(s, "}"); ok {
+			return labels, rest == ""
+		}
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, false
+		}
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return nil, false
+		}
+		value, _ := strconv.Unquote(quoted)
+		labels[strings.TrimSpace(name)] = value
+		s = rest[len(quoted):]
+	}
+}
@@ -0,0 +1,174 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePartialSuccessFixtures(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want *PartialSuccess
+	}{
+		{"otlp.json", &PartialSuccess{Rejected: 2, Message: "2 log records rejected: timestamp too old"}},
+		{"otlp_snake.json", &PartialSuccess{Rejected: 4, Message: "per-tenant rate limit"}},
+		{"otlp_ok.json", nil},
+		{"loki.txt", &PartialSuccess{
+			Rejected: 5,
+			Streams: []StreamRejection{
+				{Stream: `{app="api", env="prod"}`, Entries: 2, Reason: `entry for stream '{app="api", env="prod"}' has timestamp too old: 2024-01-01T00:00:00Z, oldest acceptable timestamp is: 2024-01-08T00:00:00Z`},
+				{Stream: `{app="web"}`, Entries: 3, Reason: `user 'fake', total ignored: 3 out of 5 for stream: {app="web"}`},
+			},
+			Message: `entry for stream '{app="api", env="prod"}' has timestamp too old: 2024-01-01T00:00:00Z, oldest acceptable timestamp is: 2024-01-08T00:00:00Z`,
+		}},
+		{"unknown.txt", nil},
+	} {
+		body, err := os.ReadFile("testdata/partial/" + tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := parsePartialSuccess(strings.TrimSpace(string(body)))
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parsePartialSuccess = %+v, want %+v", tc.file, got, tc.want)
+		}
+	}
+}
+
+func TestAcceptedPushWithPartialFailure(t *testing.T) {
+	for _, tc := range []struct {
+		file     string
+		rejected int
+	}{
+		{"loki.txt", 5},
+		{"otlp.json", 2},
+		{"unknown.txt", 0},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			body, err := os.ReadFile("testdata/partial/" + tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				_, _ = w.Write(body)
+			}))
+			defer srv.Close()
+			stats := make(chan FlushStats, 1)
+			c, err := NewClient(Config{Endpoint: srv.URL, DisableBatching: true, OnFlushStats: func(s FlushStats) { stats <- s }})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close(context.Background())
+			if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+				t.Fatalf("a partial success must not fail Send: %v", err)
+			}
+			s := <-stats
+			if s.Warning == "" {
+				t.Fatal("Warning not recorded")
+			}
+			got := 0
+			if s.PartialSuccess != nil {
+				got = s.PartialSuccess.Rejected
+			}
+			if got != tc.rejected || s.Err != nil {
+				t.Fatalf("PartialSuccess = %+v, Err = %v; want %d rejected and no error", s.PartialSuccess, s.Err, tc.rejected)
+			}
+			if m := c.Metrics(); m.RejectedByServer != uint64(tc.rejected) || m.Pushed != 1 {
+				t.Fatalf("RejectedByServer = %d, Pushed = %d; want %d and 1", m.RejectedByServer, m.Pushed, tc.rejected)
+			}
+		})
+	}
+}
+
+func TestPartialSuccessRejectionsAreDeadLettered(t *testing.T) {
+	body, err := os.ReadFile("testdata/partial/loki.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	type deadLetter struct {
+		lines []string
+		err   *StreamRejectedError
+	}
+	var letters []deadLetter
+	var fallback strings.Builder
+	c, err := NewClient(Config{
+		Endpoint:       srv.URL,
+		BatchMaxWait:   time.Hour,
+		FallbackWriter: &fallback,
+		OnDeadLetter: func(entries []Entry, err error) {
+			var lines []string
+			for _, e := range entries {
+				lines = append(lines, e.Line)
+			}
+			var rejected *StreamRejectedError
+			if !errors.As(err, &rejected) {
+				t.Errorf("err = %v, want a *StreamRejectedError", err)
+			}
+			letters = append(letters, deadLetter{lines, rejected})
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := map[string]string{"app": "api", "env": "prod"}
+	for _, e := range []Entry{
+		{Labels: api, Line: "api 1"},
+		{Labels: map[string]string{"app": "web"}, Line: "web 1"},
+		{Labels: map[string]string{"app": "other"}, Line: "other 1"},
+		{Labels: api, Line: "api 2"},
+	} {
+		if err := c.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("a partial success must not fail Close: %v", err)
+	}
+
+	if len(letters) != 2 {
+		t.Fatalf("OnDeadLetter called %d times, want once per rejected stream", len(letters))
+	}
+	if want := []string{"api 1", "api 2"}; !reflect.DeepEqual(letters[0].lines, want) || letters[0].err.Stream != `{app="api", env="prod"}` || letters[0].err.Entries != 2 {
+		t.Fatalf("first dead letter = %v %+v, want %v of the api stream", letters[0].lines, letters[0].err, want)
+	}
+	if want := []string{"web 1"}; !reflect.DeepEqual(letters[1].lines, want) || letters[1].err.Stream != `{app="web"}` || letters[1].err.Entries != 3 {
+		t.Fatalf("second dead letter = %v %+v, want %v of the web stream", letters[1].lines, letters[1].err, want)
+	}
+	if got := strings.Count(fallback.String(), "\n"); got != 3 || strings.Contains(fallback.String(), "other 1") {
+		t.Fatalf("fallback got:\n%s\nwant the three rejected entries only", fallback.String())
+	}
+}
+
+func TestParseLokiLabelSet(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want map[string]string
+		ok   bool
+	}{
+		{`{}`, map[string]string{}, true},
+		{`{app="api", env="prod"}`, map[string]string{"app": "api", "env": "prod"}, true},
+		{`{msg="a, \"b\"=c"}`, map[string]string{"msg": `a, "b"=c`}, true},
+		{`{app="api"`, nil, false},
+		{`app="api"`, nil, false},
+		{`{app=api}`, nil, false},
+	} {
+		got, ok := parseLokiLabelSet(tc.in)
+		if ok != tc.ok || (ok && !reflect.DeepEqual(got, tc.want)) {
+			t.Errorf("parseLokiLabelSet(%s) = %v, %v; want %v, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package lokigo
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/zabihimohsen/lokigo/internal/push"
+)
+
+// retainLimits bounds what a scratch may keep when it is returned to its
+// pool, per Config.MemoryReleasePolicy. A scratch grown by an unusually
+// large batch is dropped instead, so one burst does not pin its memory for
+// the life of the process.
+type retainLimits struct {
+	entries int
+	bytes   int
+}
+
+func (c *Client) retainLimits() retainLimits {
+	p := c.cfg.MemoryReleasePolicy
+	return retainLimits{entries: p.ShrinkFactor * c.cfg.BatchMaxEntries, bytes: p.MaxRetainedBytes}
+}
+
+// jsonStreamValues is one stream of a JSON push body.
+type jsonStreamValues struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// streamSlots assigns the entries of a batch to streams in first-seen order.
+type streamSlots struct {
+	index  map[string]int // stream key -> stream position
+	slots  []int          // stream position of each entry
+	counts []int          // entries per stream
+}
+
+// slot records that the next entry belongs to the stream with key and
+// reports whether that stream is new.
+func (s *streamSlots) slot(key string) (added bool) {
+	i, ok := s.index[key]
+	if !ok {
+		i = len(s.counts)
+		s.index[key] = i
+		s.counts = append(s.counts, 0)
+	}
+	s.counts[i]++
+	s.slots = append(s.slots, i)
+	return !ok
+}
+
+func (s *streamSlots) reset() {
+	clear(s.index)
+	s.slots, s.counts = s.slots[:0], s.counts[:0]
+}
+
+// jsonScratch holds the intermediate containers of one JSON payload build.
+type jsonScratch struct {
+	streamSlots
+	streams []jsonStreamValues
+	// values backs every stream's Values, so a batch needs one allocation.
+	values [][2]string
+}
+
+var jsonScratchPool = sync.Pool{New: func() any { return &jsonScratch{streamSlots: streamSlots{index: map[string]int{}}} }}
+
+// release zeroes everything the build referenced, so no label map or line
+// outlives its flush, and returns s to the pool.
+func (s *jsonScratch) release(limits retainLimits) {
+	clear(s.streams)
+	clear(s.values)
+	if cap(s.values) > limits.entries || cap(s.values)*int(unsafe.Sizeof([2]string{})) > limits.bytes {
+		return
+	}
+	s.reset()
+	s.streams, s.values = s.streams[:0], s.values[:0]
+	jsonScratchPool.Put(s)
+}
+
+// protoScratch holds the intermediate containers of one protobuf payload
+// build, including the uncompressed encoding.
+type protoScratch struct {
+	streamSlots
+	req push.PushRequest
+	// entries backs every stream's Entries, so a batch needs one allocation.
+	entries []push.Entry
+	raw     []byte
+}
+
+var protoScratchPool = sync.Pool{New: func() any { return &protoScratch{streamSlots: streamSlots{index: map[string]int{}}} }}
+
+// release zeroes everything the build referenced, so no line outlives its
+// flush, and returns s to the pool.
+func (s *protoScratch) release(limits retainLimits) {
+	clear(s.req.Streams)
+	clear(s.entries)
+	if cap(s.entries) > limits.entries || cap(s.entries)*int(unsafe.Sizeof(push.Entry{}))+cap(s.raw) > limits.bytes {
+		return
+	}
+	s.reset()
+	s.req.Streams, s.entries, s.raw = s.req.Streams[:0], s.entries[:0], s.raw[:0]
+	protoScratchPool.Put(s)
+}
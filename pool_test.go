@@ -0,0 +1,74 @@
+package lokigo
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScratchReleaseZeroesEntries(t *testing.T) {
+	js := &jsonScratch{streamSlots: streamSlots{index: map[string]int{}}}
+	js.slot(`{app="a"}`)
+	js.streams = append(js.streams, jsonStreamValues{Stream: map[string]string{"app": "a"}})
+	js.values = append(js.values, [2]string{"1", "secret line"})
+	js.streams[0].Values = js.values[:1]
+	js.release(retainLimits{entries: 16, bytes: 1 << 20})
+	if v := js.values[:1][0]; v != [2]string{} {
+		t.Fatalf("json values kept %q after release", v)
+	}
+	if s := js.streams[:1][0]; s.Stream != nil || s.Values != nil {
+		t.Fatalf("json stream kept %+v after release", s)
+	}
+	if len(js.index) != 0 || len(js.slots) != 0 || len(js.counts) != 0 {
+		t.Fatal("json stream slots not reset")
+	}
+
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1:3100/loki/api/v1/push"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+	limits := c.retainLimits()
+	big := make([]Entry, limits.entries+1)
+	for i := range big {
+		big[i] = Entry{Timestamp: time.Unix(0, int64(i)), Line: "x"}
+	}
+	// An oversized build must not leave its capacity in the pool.
+	if _, err := c.buildJSONPayload(big); err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		if s := jsonScratchPool.Get().(*jsonScratch); cap(s.values) > limits.entries {
+			t.Fatalf("pooled scratch kept %d values", cap(s.values))
+		}
+	}
+}
+
+func TestMemoryReleasePolicyDropsBurstBuffers(t *testing.T) {
+	const maxRetained = 1 << 20
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1:3100/loki/api/v1/push", MemoryReleasePolicy: MemoryReleasePolicy{MaxRetainedBytes: maxRetained}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+	if got := c.EffectiveConfig().MemoryReleasePolicy; got.ShrinkFactor != 4 || got.MaxRetainedBytes != maxRetained {
+		t.Fatalf("MemoryReleasePolicy = %+v, want the default factor and the configured byte cap", got)
+	}
+
+	// One burst with a line of several MiB, then ordinary batches.
+	huge := []Entry{{Timestamp: time.Unix(1, 0), Line: strings.Repeat("x", 4*maxRetained)}}
+	if _, err := c.buildProtobufSnappyPayload(huge); err != nil {
+		t.Fatal(err)
+	}
+	small := benchmarkEntries(50)
+	for range 20 {
+		if _, err := c.buildProtobufSnappyPayload(small); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range 4 {
+		if s := protoScratchPool.Get().(*protoScratch); cap(s.raw) > maxRetained {
+			t.Fatalf("pooled scratch retained %d bytes after the burst", cap(s.raw))
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package lokigo
+
+import (
+	"io"
+	"net/http"
+)
+
+// preconnect sends a HEAD request to the endpoint through the push HTTP
+// client, so the DNS lookup and TCP and TLS handshakes are done and the
+// connection is pooled before the first flush. The response status does not
+// matter; failures are only logged. It runs until done, or until a Close
+// deadline aborts pushes.
+func (c *Client) preconnect() {
+	c.preconnectDone = make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(c.preconnectDone)
+		req, err := http.NewRequestWithContext(c.abortCtx, http.MethodHead, c.cfg.Endpoint, nil)
+		if err != nil {
+			c.debug("preconnect failed", "error", err)
+			return
+		}
+		for k, v := range c.cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		req.Header.Set("User-Agent", c.cfg.UserAgent)
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			c.debug("preconnect failed", "error", newNetworkPushError(err))
+			return
+		}
+		// Drain so the connection returns to the pool.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		c.debug("preconnected", "status", resp.StatusCode)
+	}()
+}
@@ -0,0 +1,64 @@
+package lokigo
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPreconnectOnStartWarmsConnection(t *testing.T) {
+	var conns, heads, pushes atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		pushes.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, PreconnectOnStart: true, BatchMaxEntries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.preconnectDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("preconnect did not finish")
+	}
+	if heads.Load() != 1 || conns.Load() != 1 {
+		t.Fatalf("after preconnect: heads = %d, conns = %d", heads.Load(), conns.Load())
+	}
+	if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pushes.Load() != 1 || conns.Load() != 1 {
+		t.Fatalf("pushes = %d, conns = %d; want the push on the warmed connection", pushes.Load(), conns.Load())
+	}
+}
+
+func TestPreconnectFailureDoesNotFailClient(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1:1", PreconnectOnStart: true, Retry: RetryConfig{MaxAttempts: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-c.preconnectDone
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("Close = %v, want nil with nothing sent", err)
+	}
+}
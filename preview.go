@@ -0,0 +1,46 @@
+package lokigo
+
+// StreamSummary is one stream of a PreviewStreams result.
+type StreamSummary struct {
+	// Stream is the Loki label-set string, such as {app="api"}.
+	Stream string
+	// Labels are the stream labels as they would be pushed.
+	Labels  map[string]string
+	Entries int
+	// Bytes is the length of the stream's lines, like FlushStats.Bytes.
+	Bytes int
+}
+
+// PreviewStreams returns the streams a client built from cfg would push
+// entries as, in first-seen order, without starting a client or doing any
+// I/O. It runs the label merging, sanitizing and grouping of the payload
+// encoders, so it predicts the stream count of a new label setup exactly.
+// Entries Send would reject, for example under ReservedLabelReject, are
+// left out. Routes and tenants are not taken into account: entries of one
+// stream pushed to two targets count once.
+func PreviewStreams(entries []Entry, cfg Config) []StreamSummary {
+	cfg.setDefaults()
+	c := &Client{cfg: cfg}
+	c.initLabels()
+	accepted := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if c.checkEntry(&e) == nil {
+			accepted = append(accepted, e)
+		}
+	}
+	return c.summarizeStreams(accepted)
+}
+
+// initLabels sets up the client-owned label state derived from cfg.
+func (c *Client) initLabels() {
+	c.staticLabels = mergeLabels(c.cfg.StaticLabels, nil)
+	c.limitsLabels = c.cfg.MaxLabelValueBytes > 0 || len(c.cfg.LabelValueLimits) > 0
+	if len(c.cfg.EmptyStreamLabels) > 0 {
+		c.emptyStream = mergeLabels(c.cfg.EmptyStreamLabels, nil)
+	}
+	for _, v := range c.staticLabels {
+		if v == "" {
+			c.staticEmpty++
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package lokigo
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreviewStreamsMatchesPayload(t *testing.T) {
+	srv, captured := captureJSONStreams(t)
+	cfg := Config{
+		Endpoint:            srv.URL,
+		Encoding:            EncodingJSON,
+		BatchMaxWait:        time.Minute,
+		StaticLabels:        map[string]string{"env": "prod", "region": ""},
+		EmptyStreamLabels:   map[string]string{"job": "unlabeled"},
+		MaxLabelValueBytes:  8,
+		ReservedLabelPolicy: ReservedLabelReject,
+	}
+	entries := []Entry{
+		{Line: "a", Labels: map[string]string{"app": "api"}},
+		{Line: "bb", Labels: map[string]string{"app": "api", "trace": ""}},
+		{Line: "ccc", Labels: map[string]string{"app": strings.Repeat("x", 20)}},
+		{Line: "d", Labels: map[string]string{"env": ""}},
+		{Line: "e", Labels: map[string]string{"__name__": "x"}},
+		{Line: "ffff", Labels: map[string]string{"app": strings.Repeat("x", 30)}},
+	}
+
+	preview := PreviewStreams(entries, cfg)
+
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		_ = c.Send(context.Background(), e)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := captured()
+	if len(preview) != len(got) {
+		t.Fatalf("PreviewStreams = %+v, payload carries %+v", preview, got)
+	}
+	for i, s := range preview {
+		if !maps.Equal(s.Labels, got[i].labels) || s.Entries != got[i].entries {
+			t.Errorf("stream %d: preview %v with %d entries, payload %v with %d", i, s.Labels, s.Entries, got[i].labels, got[i].entries)
+		}
+	}
+	if n := len(preview); n != 3 {
+		t.Fatalf("%d streams, want 3: %+v", n, preview)
+	}
+	if preview[1].Bytes != 7 || preview[1].Stream != `{app="xxxxx…",env="prod"}` {
+		t.Errorf("truncated stream = %+v, want 7 bytes", preview[1])
+	}
+	if !slices.Equal(slices.Sorted(maps.Keys(preview[2].Labels)), []string{"job"}) {
+		t.Errorf("emptied stream labels = %v, want EmptyStreamLabels", preview[2].Labels)
+	}
+}
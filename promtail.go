@@ -0,0 +1,202 @@
+package lokigo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// promtailClient is the subset of promtail's client config that maps onto
+// Config. Fields promtail knows but lokigo cannot honour are decoded as
+// yaml.Node so they can be rejected by name.
+type promtailClient struct {
+	URL                    string            `yaml:"url"`
+	TenantID               string            `yaml:"tenant_id"`
+	BatchWait              string            `yaml:"batchwait"`
+	BatchSize              int               `yaml:"batchsize"`
+	Timeout                string            `yaml:"timeout"`
+	Headers                map[string]string `yaml:"headers"`
+	ExternalLabels         map[string]string `yaml:"external_labels"`
+	BearerToken            string            `yaml:"bearer_token"`
+	BackoffConfig          promtailBackoff   `yaml:"backoff_config"`
+	BasicAuth              *promtailBasic    `yaml:"basic_auth"`
+	OAuth2                 *promtailOAuth2   `yaml:"oauth2"`
+	BearerTokenFile        yaml.Node         `yaml:"bearer_token_file"`
+	TLSConfig              yaml.Node         `yaml:"tls_config"`
+	ProxyURL               yaml.Node         `yaml:"proxy_url"`
+	DropRateLimitedBatches yaml.Node         `yaml:"drop_rate_limited_batches"`
+	StreamLagLabels        yaml.Node         `yaml:"stream_lag_labels"`
+}
+
+type promtailBackoff struct {
+	MinPeriod  string `yaml:"min_period"`
+	MaxPeriod  string `yaml:"max_period"`
+	MaxRetries int    `yaml:"max_retries"`
+}
+
+type promtailBasic struct {
+	Username     string    `yaml:"username"`
+	Password     string    `yaml:"password"`
+	PasswordFile yaml.Node `yaml:"password_file"`
+}
+
+type promtailOAuth2 struct {
+	ClientID         string    `yaml:"client_id"`
+	ClientSecret     string    `yaml:"client_secret"`
+	TokenURL         string    `yaml:"token_url"`
+	Scopes           []string  `yaml:"scopes"`
+	ClientSecretFile yaml.Node `yaml:"client_secret_file"`
+	EndpointParams   yaml.Node `yaml:"endpoint_params"`
+}
+
+// FromPromtailConfig maps a promtail client config onto Config. yamlBytes is
+// either a single client block or a document with a "clients" list holding
+// exactly one client.
+//
+// Mapped fields: url, tenant_id, batchwait (BatchMaxWait), batchsize
+// (BatchMaxBytes), timeout (HTTPClient timeout), headers, external_labels
+// (StaticLabels), bearer_token and basic_auth (Authorization header), oauth2
+// (OAuth2) and backoff_config (Retry; max_retries is the total number of
+// attempts, as in promtail). Unknown fields and fields lokigo cannot honour,
+// such as tls_config or *_file credentials, are errors rather than ignored.
+// The result is not validated; NewClient or Config.Validate does that.
+func FromPromtailConfig(yamlBytes []byte) (Config, error) {
+	var doc struct {
+		Clients []yaml.Node `yaml:"clients"`
+	}
+	if err := yaml.Unmarshal(yamlBytes, &doc); err != nil {
+		return Config{}, fmt.Errorf("promtail config: %w", err)
+	}
+	raw := yamlBytes
+	switch len(doc.Clients) {
+	case 0:
+	case 1:
+		b, err := yaml.Marshal(&doc.Clients[0])
+		if err != nil {
+			return Config{}, fmt.Errorf("promtail config: %w", err)
+		}
+		raw = b
+	default:
+		return Config{}, errors.New("promtail config: multiple clients are not supported; use one Config per client")
+	}
+
+	var pc promtailClient
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	if err := dec.Decode(&pc); err != nil {
+		return Config{}, fmt.Errorf("promtail config: %w", err)
+	}
+	return pc.config()
+}
+
+func (pc promtailClient) config() (Config, error) {
+	unsupported := map[string]yaml.Node{
+		"bearer_token_file":         pc.BearerTokenFile,
+		"tls_config":                pc.TLSConfig,
+		"proxy_url":                 pc.ProxyURL,
+		"drop_rate_limited_batches": pc.DropRateLimitedBatches,
+		"stream_lag_labels":         pc.StreamLagLabels,
+	}
+	if pc.BasicAuth != nil {
+		unsupported["basic_auth.password_file"] = pc.BasicAuth.PasswordFile
+	}
+	if pc.OAuth2 != nil {
+		unsupported["oauth2.client_secret_file"] = pc.OAuth2.ClientSecretFile
+		unsupported["oauth2.endpoint_params"] = pc.OAuth2.EndpointParams
+	}
+	for name, node := range unsupported {
+		if !node.IsZero() {
+			return Config{}, fmt.Errorf("promtail config: %s is not supported", name)
+		}
+	}
+
+	cfg := Config{
+		Endpoint:      pc.URL,
+		TenantID:      pc.TenantID,
+		BatchMaxBytes: pc.BatchSize,
+		StaticLabels:  pc.ExternalLabels,
+		Retry:         RetryConfig{MaxAttempts: pc.BackoffConfig.MaxRetries},
+	}
+	var err error
+	durations := []struct {
+		name string
+		raw  string
+		dst  *time.Duration
+	}{
+		{"batchwait", pc.BatchWait, &cfg.BatchMaxWait},
+		{"backoff_config.min_period", pc.BackoffConfig.MinPeriod, &cfg.Retry.MinBackoff},
+		{"backoff_config.max_period", pc.BackoffConfig.MaxPeriod, &cfg.Retry.MaxBackoff},
+	}
+	for _, d := range durations {
+		if *d.dst, err = parsePromtailDuration(d.name, d.raw); err != nil {
+			return Config{}, err
+		}
+	}
+	if pc.Timeout != "" {
+		timeout, err := parsePromtailDuration("timeout", pc.Timeout)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.HTTPClient = &http.Client{Timeout: timeout}
+	}
+
+	if len(pc.Headers) > 0 {
+		cfg.Headers = mergeLabels(pc.Headers, nil)
+	}
+	setAuth := func(value string) error {
+		if cfg.Headers["Authorization"] != "" {
+			return errors.New("promtail config: only one of bearer_token, basic_auth and an Authorization header may be set")
+		}
+		if cfg.Headers == nil {
+			cfg.Headers = map[string]string{}
+		}
+		cfg.Headers["Authorization"] = value
+		return nil
+	}
+	if pc.BearerToken != "" {
+		if err := setAuth("Bearer " + pc.BearerToken); err != nil {
+			return Config{}, err
+		}
+	}
+	if pc.BasicAuth != nil {
+		creds := base64.StdEncoding.EncodeToString([]byte(pc.BasicAuth.Username + ":" + pc.BasicAuth.Password))
+		if err := setAuth("Basic " + creds); err != nil {
+			return Config{}, err
+		}
+	}
+	if pc.OAuth2 != nil {
+		cfg.OAuth2 = OAuth2Config{TokenURL: pc.OAuth2.TokenURL, ClientID: pc.OAuth2.ClientID, ClientSecret: pc.OAuth2.ClientSecret, Scopes: pc.OAuth2.Scopes}
+	}
+	return cfg, nil
+}
+
+// parsePromtailDuration parses a promtail duration; empty means unset.
+// Promtail's day and week units are accepted as 24h and 168h.
+func parsePromtailDuration(name, raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch raw[len(raw)-1] {
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		if n, err := strconv.Atoi(raw[:len(raw)-1]); err == nil && n >= 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return 0, fmt.Errorf("promtail config: invalid %s %q", name, raw)
+	}
+	return d, nil
+}
@@ -0,0 +1,96 @@
+package lokigo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readPromtailFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "promtail", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFromPromtailConfigClientsList(t *testing.T) {
+	cfg, err := FromPromtailConfig(readPromtailFixture(t, "clients.yaml"))
+	if err != nil {
+		t.Fatalf("FromPromtailConfig: %v", err)
+	}
+	if cfg.Endpoint != "https://logs-prod-us-central1.grafana.net/loki/api/v1/push" || cfg.TenantID != "team-a" {
+		t.Fatalf("endpoint/tenant = %q/%q", cfg.Endpoint, cfg.TenantID)
+	}
+	if cfg.BatchMaxWait != time.Second || cfg.BatchMaxBytes != 1048576 {
+		t.Fatalf("batch = %v/%d", cfg.BatchMaxWait, cfg.BatchMaxBytes)
+	}
+	wantRetry := RetryConfig{MaxAttempts: 10, MinBackoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Minute}
+	if cfg.Retry != wantRetry {
+		t.Fatalf("retry = %+v, want %+v", cfg.Retry, wantRetry)
+	}
+	if !reflect.DeepEqual(cfg.StaticLabels, map[string]string{"cluster": "prod", "env": "us-central1"}) {
+		t.Fatalf("static labels = %v", cfg.StaticLabels)
+	}
+	if got := cfg.Headers["Authorization"]; got != "Basic MTIzNDU2OmdsY19zZWNyZXQ=" {
+		t.Fatalf("authorization = %q", got)
+	}
+	if cfg.HTTPClient == nil || cfg.HTTPClient.Timeout != 10*time.Second {
+		t.Fatalf("http client = %+v", cfg.HTTPClient)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestFromPromtailConfigSingleClient(t *testing.T) {
+	cfg, err := FromPromtailConfig(readPromtailFixture(t, "single.yaml"))
+	if err != nil {
+		t.Fatalf("FromPromtailConfig: %v", err)
+	}
+	want := map[string]string{"Authorization": "Bearer abc123", "X-Scope-Team": "platform"}
+	if !reflect.DeepEqual(cfg.Headers, want) {
+		t.Fatalf("headers = %v, want %v", cfg.Headers, want)
+	}
+	if cfg.HTTPClient != nil || cfg.Retry != (RetryConfig{}) {
+		t.Fatalf("unset fields should stay zero: %+v", cfg)
+	}
+}
+
+func TestFromPromtailConfigOAuth2(t *testing.T) {
+	cfg, err := FromPromtailConfig(readPromtailFixture(t, "oauth2.yaml"))
+	if err != nil {
+		t.Fatalf("FromPromtailConfig: %v", err)
+	}
+	want := OAuth2Config{TokenURL: "https://auth.example.com/oauth/token", ClientID: "promtail", ClientSecret: "s3cret", Scopes: []string{"logs.write"}}
+	if !reflect.DeepEqual(cfg.OAuth2, want) {
+		t.Fatalf("oauth2 = %+v, want %+v", cfg.OAuth2, want)
+	}
+}
+
+func TestFromPromtailConfigErrors(t *testing.T) {
+	cases := map[string]string{
+		"tls.yaml":      "tls_config is not supported",
+		"unknown.yaml":  "batch_wait",
+		"multiple.yaml": "multiple clients",
+	}
+	for fixture, want := range cases {
+		_, err := FromPromtailConfig(readPromtailFixture(t, fixture))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: err = %v, want it to mention %q", fixture, err, want)
+		}
+	}
+
+	_, err := FromPromtailConfig([]byte("url: http://loki/push\nbatchwait: soon\n"))
+	if err == nil || !strings.Contains(err.Error(), "invalid batchwait") {
+		t.Fatalf("bad duration err = %v", err)
+	}
+	_, err = FromPromtailConfig([]byte("url: http://loki/push\nbearer_token: x\nbasic_auth:\n  username: u\n"))
+	if err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Fatalf("conflicting auth err = %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package lokigo
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// QueueClass is a Send queue for entries at or above MinLevel, with its own
+// capacity and backpressure. See Config.QueueClasses.
+type QueueClass struct {
+	// Name keys the class in Metrics.DroppedByClass. Defaults to
+	// MinLevel.String(), for example "ERROR".
+	Name     string
+	MinLevel slog.Level
+	// QueueSize defaults to Config.QueueSize.
+	QueueSize int
+	// BackpressureMode defaults to Config.BackpressureMode.
+	BackpressureMode BackpressureMode
+}
+
+// SeverityFromLabel returns a Config.Severity extractor reading the entry
+// label name: "debug", "info", "warn"/"warning", "error" and the
+// "trace"/"fatal"/"critical" extremes, case-insensitively. Entries without a
+// recognized value are treated as slog.LevelInfo.
+func SeverityFromLabel(name string) func(Entry) slog.Level {
+	return func(e Entry) slog.Level {
+		switch strings.ToLower(e.Labels[name]) {
+		case "trace":
+			return slog.LevelDebug - 4
+		case "debug":
+			return slog.LevelDebug
+		case "warn", "warning":
+			return slog.LevelWarn
+		case "error", "err":
+			return slog.LevelError
+		case "fatal", "critical", "crit", "panic":
+			return slog.LevelError + 4
+		}
+		return slog.LevelInfo
+	}
+}
+
+type queueClass struct {
+	name     string
+	minLevel slog.Level
+	mode     BackpressureMode
+	ch       chan queuedEntry
+}
+
+// newQueueClasses builds the class queues, highest MinLevel first.
+func newQueueClasses(classes []QueueClass) []*queueClass {
+	out := make([]*queueClass, 0, len(classes))
+	for _, qc := range classes {
+		out = append(out, &queueClass{name: qc.Name, minLevel: qc.MinLevel, mode: qc.BackpressureMode, ch: make(chan queuedEntry, qc.QueueSize)})
+	}
+	slices.SortFunc(out, func(a, b *queueClass) int { return cmp.Compare(b.minLevel, a.minLevel) })
+	return out
+}
+
+// classFor returns the class receiving e.
+func (c *Client) classFor(e Entry) *queueClass {
+	level := c.cfg.Severity(e)
+	for _, qc := range c.classes {
+		if level >= qc.minLevel {
+			return qc
+		}
+	}
+	return c.classes[len(c.classes)-1]
+}
+
+// wakeWorker tells the worker that a class queue has entries. classWake
+// holds at most one signal; the worker consumes it before draining, so an
+// entry enqueued after the drain starts always leaves a signal behind.
+func (c *Client) wakeWorker() {
+	select {
+	case c.classWake <- struct{}{}:
+	default:
+	}
+}
+
+// nextClassed receives the next entry from the highest non-empty class.
+func (c *Client) nextClassed() (queuedEntry, bool) {
+	for _, qc := range c.classes {
+		select {
+		case e := <-qc.ch:
+			return e, true
+		default:
+		}
+	}
+	return queuedEntry{}, false
+}
+
+func (c *Client) classedLen() int {
+	n := 0
+	for _, qc := range c.classes {
+		n += len(qc.ch)
+	}
+	return n
+}
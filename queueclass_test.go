@@ -0,0 +1,93 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestQueueClassesIsolateBackpressure(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv, rec := captureJSONPushes(t)
+	rec.onPush(func(http.ResponseWriter, *http.Request) {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+	})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	defer unblock()
+
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		BatchMaxEntries: 1,
+		Severity:        SeverityFromLabel("level"),
+		QueueClasses: []QueueClass{
+			{MinLevel: slog.LevelDebug, QueueSize: 2, BackpressureMode: BackpressureDropNew},
+			{MinLevel: slog.LevelError, QueueSize: 100},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	errEntry := func(line string) Entry { return Entry{Line: line, Labels: map[string]string{"level": "error"}} }
+
+	// Hold the worker in a push so both classes fill up behind it.
+	if err := c.Send(ctx, errEntry("e0")); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	dropped := 0
+	for range 10 {
+		err := c.Send(ctx, Entry{Line: "debug", Labels: map[string]string{"level": "debug"}})
+		if errors.Is(err, ErrDropped) {
+			dropped++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, line := range []string{"e1", "e2", "e3"} {
+		if err := c.Send(ctx, errEntry(line)); err != nil {
+			t.Fatalf("error class must keep accepting entries: %v", err)
+		}
+	}
+	// e0 is in flight, so only the two debug and three error entries queue.
+	if dropped != 8 || c.QueueLen() != 5 {
+		t.Fatalf("dropped %d debug entries with QueueLen %d, want 8 and 5", dropped, c.QueueLen())
+	}
+	if got := c.Metrics().DroppedByClass; !reflect.DeepEqual(got, map[string]uint64{"DEBUG": 8}) {
+		t.Fatalf("DroppedByClass = %v", got)
+	}
+
+	unblock()
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, e := range rec.entries() {
+		lines = append(lines, e.line)
+	}
+	want := []string{"e0", "e1", "e2", "e3", "debug", "debug"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("push order = %q, want errors drained first: %q", lines, want)
+	}
+}
+
+func TestSeverityFromLabel(t *testing.T) {
+	severity := SeverityFromLabel("lvl")
+	cases := map[string]slog.Level{"DEBUG": slog.LevelDebug, "warning": slog.LevelWarn, "err": slog.LevelError, "": slog.LevelInfo, "verbose": slog.LevelInfo}
+	for value, want := range cases {
+		if got := severity(Entry{Labels: map[string]string{"lvl": value}}); got != want {
+			t.Errorf("%q: got %v, want %v", value, got, want)
+		}
+	}
+}
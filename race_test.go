@@ -0,0 +1,111 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConcurrentSendMetricsAndClose races producers, a metrics reader and
+// Close, and checks that every entry is accounted for exactly once: pushed,
+// failed or dropped. Run it under -race.
+func TestConcurrentSendMetricsAndClose(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status int
+	}{
+		{"healthy", http.StatusNoContent},
+		{"failing", http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			c, err := NewClient(Config{
+				Endpoint:         srv.URL,
+				QueueSize:        64,
+				BatchMaxEntries:  16,
+				BatchMaxWait:     time.Millisecond,
+				BackpressureMode: BackpressureDropNew,
+				// One attempt per batch, so PushErrors counts each entry once.
+				Retry: RetryConfig{MaxAttempts: 1},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const producers, perProducer = 8, 500
+			var sent, rejected atomic.Int64
+			var wg sync.WaitGroup
+			for p := range producers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := range perProducer {
+						err := c.Send(context.Background(), Entry{Line: fmt.Sprintf("p%d-%d", p, i), Labels: map[string]string{"producer": fmt.Sprint(p)}})
+						sent.Add(1)
+						if err != nil {
+							if !errors.Is(err, ErrDropped) && !errors.Is(err, ErrClosed) {
+								t.Errorf("Send: %v", err)
+							}
+							rejected.Add(1)
+						}
+					}
+				}()
+			}
+			stop := make(chan struct{})
+			var readers sync.WaitGroup
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					_ = c.Metrics()
+					_ = c.QueueLen()
+					_ = c.PendingBytes()
+				}
+			}()
+
+			time.Sleep(5 * time.Millisecond)
+			closed := make(chan ShutdownStats, 1)
+			go func() {
+				stats, _ := c.CloseWithStats(context.Background())
+				closed <- stats
+			}()
+			var stats ShutdownStats
+			select {
+			case stats = <-closed:
+			case <-time.After(10 * time.Second):
+				t.Fatal("Close deadlocked with concurrent producers")
+			}
+			wg.Wait()
+			close(stop)
+			readers.Wait()
+
+			m := c.Metrics()
+			if got := int64(m.Pushed + m.PushErrors + m.Dropped); got != sent.Load() {
+				t.Fatalf("pushed %d + failed %d + dropped %d = %d, want %d sent (shutdown %+v)", m.Pushed, m.PushErrors, m.Dropped, got, sent.Load(), stats)
+			}
+			if rejected.Load() != int64(m.Dropped) {
+				t.Fatalf("%d Sends returned ErrDropped or ErrClosed, Metrics.Dropped = %d", rejected.Load(), m.Dropped)
+			}
+			if n := c.QueueLen(); n != 0 {
+				t.Fatalf("QueueLen = %d after Close, want 0", n)
+			}
+		})
+	}
+}
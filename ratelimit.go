@@ -0,0 +1,104 @@
+package lokigo
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter paces pushes with two token buckets (entries and line bytes).
+// A reservation larger than the bucket is allowed and puts the bucket into
+// debt, so oversized batches are delayed rather than rejected. It is safe for
+// concurrent use so every push path shares the same budget.
+type rateLimiter struct {
+	mu      sync.Mutex
+	entries tokenBucket
+	bytes   tokenBucket
+	now     func() time.Time
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) *rateLimiter {
+	if cfg.EntriesPerSecond <= 0 && cfg.BytesPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(cfg.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, cfg.EntriesPerSecond)
+	}
+	l := &rateLimiter{now: time.Now, sleep: sleepContext}
+	now := l.now()
+	l.entries = tokenBucket{rate: cfg.EntriesPerSecond, capacity: burst, tokens: burst, last: now}
+	l.bytes = tokenBucket{rate: cfg.BytesPerSecond, capacity: cfg.BytesPerSecond, tokens: cfg.BytesPerSecond, last: now}
+	return l
+}
+
+// wait blocks until entries/bytes tokens are available and returns how long
+// it waited. When ctx ends the wait, the reserved tokens are given back, so
+// a push that never happened does not slow down later ones.
+func (l *rateLimiter) wait(ctx context.Context, entries, bytes int) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.mu.Lock()
+	now := l.now()
+	d := l.entries.reserve(now, float64(entries))
+	if bd := l.bytes.reserve(now, float64(bytes)); bd > d {
+		d = bd
+	}
+	l.mu.Unlock()
+	if d <= 0 {
+		return 0, nil
+	}
+	if err := l.sleep(ctx, d); err != nil {
+		l.mu.Lock()
+		l.entries.refund(float64(entries))
+		l.bytes.refund(float64(bytes))
+		waited := l.now().Sub(now)
+		l.mu.Unlock()
+		return waited, err
+	}
+	return d, nil
+}
+
+func (b *tokenBucket) reserve(now time.Time, n float64) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund gives back n tokens of a reservation that was not used.
+func (b *tokenBucket) refund(n float64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.capacity, b.tokens+n)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
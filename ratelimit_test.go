@@ -0,0 +1,219 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manual clock for the rate limiter and, through
+// newClient, the worker timers, which fire as Advance reaches them.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	c        chan time.Time
+	deadline time.Time
+	armed    bool
+}
+
+func (f *fakeClock) NewTimer(d time.Duration) clockTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1), deadline: f.now.Add(d), armed: true}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Advance moves the clock by d and fires the timers it reaches.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	for _, t := range f.timers {
+		if t.armed && !t.deadline.After(f.now) {
+			t.armed = false
+			select {
+			case t.c <- f.now:
+			default:
+			}
+		}
+	}
+}
+
+// armedAt reports whether a timer is armed to fire at deadline.
+func (f *fakeClock) armedAt(deadline time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, t := range f.timers {
+		if t.armed && t.deadline.Equal(deadline) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	was := t.armed
+	t.deadline, t.armed = t.clock.now.Add(d), true
+	return was
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	was := t.armed
+	t.armed = false
+	return was
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Sleep(_ context.Context, d time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	return nil
+}
+
+func TestRateLimiterPacesBackToBackLargeBatches(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := newRateLimiter(RateLimitConfig{BytesPerSecond: 1000})
+	l.now, l.sleep = clock.Now, clock.Sleep
+	l.bytes.last = clock.Now()
+
+	var waits []time.Duration
+	for i := 0; i < 3; i++ {
+		d, err := l.wait(context.Background(), 100, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waits = append(waits, d)
+	}
+	want := []time.Duration{0, time.Second, time.Second}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Fatalf("unexpected waits: got %v want %v", waits, want)
+		}
+	}
+	if got := clock.Now().Sub(time.Unix(1700000000, 0)); got != 2*time.Second {
+		t.Fatalf("expected 2s of pacing, got %v", got)
+	}
+}
+
+func TestRateLimiterEntriesBurstThenRate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := newRateLimiter(RateLimitConfig{EntriesPerSecond: 10, Burst: 5})
+	l.now, l.sleep = clock.Now, clock.Sleep
+	l.entries.last = clock.Now()
+
+	if d, _ := l.wait(context.Background(), 5, 0); d != 0 {
+		t.Fatalf("expected burst to pass without waiting, got %v", d)
+	}
+	if d, _ := l.wait(context.Background(), 5, 0); d != 500*time.Millisecond {
+		t.Fatalf("expected 500ms wait after burst, got %v", d)
+	}
+}
+
+func TestRateLimiterWaitRespectsContext(t *testing.T) {
+	l := newRateLimiter(RateLimitConfig{EntriesPerSecond: 1, Burst: 1})
+	_, _ = l.wait(context.Background(), 1, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.wait(ctx, 1, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestRateLimiterCanceledWaitRefundsTokens(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	l := newRateLimiter(RateLimitConfig{EntriesPerSecond: 10, Burst: 5, BytesPerSecond: 1000})
+	l.now = clock.Now
+	l.entries.last, l.bytes.last = clock.Now(), clock.Now()
+	if d, _ := l.wait(context.Background(), 5, 0); d != 0 {
+		t.Fatalf("expected burst to pass without waiting, got %v", d)
+	}
+
+	// The wait is cut short after 100ms of the 500ms it planned.
+	l.sleep = func(context.Context, time.Duration) error {
+		_ = clock.Sleep(context.Background(), 100*time.Millisecond)
+		return context.Canceled
+	}
+	d, err := l.wait(context.Background(), 5, 500)
+	if !errors.Is(err, context.Canceled) || d != 100*time.Millisecond {
+		t.Fatalf("wait = %v, %v; want 100ms actually waited and context.Canceled", d, err)
+	}
+
+	// Only the 100ms of refill count against the next wait, not the
+	// canceled reservation.
+	l.sleep = clock.Sleep
+	if d, _ := l.wait(context.Background(), 5, 500); d != 400*time.Millisecond {
+		t.Fatalf("expected 400ms wait after the refund, got %v", d)
+	}
+	if got := l.bytes.tokens; got != 500 {
+		t.Fatalf("byte tokens = %v, want 500 with the canceled reservation refunded", got)
+	}
+}
+
+func TestRateLimitWaitReportedInFlushStats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var stats []FlushStats
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		BatchMaxEntries: 1,
+		RateLimit:       RateLimitConfig{BytesPerSecond: 1000},
+		OnFlushStats: func(s FlushStats) {
+			mu.Lock()
+			stats = append(stats, s)
+			mu.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 600)
+	for i := 0; i < 2; i++ {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 flush stats, got %d", len(stats))
+	}
+	if stats[0].RateLimitWait != 0 || stats[1].RateLimitWait < 100*time.Millisecond {
+		t.Fatalf("unexpected rate limit waits: %v, %v", stats[0].RateLimitWait, stats[1].RateLimitWait)
+	}
+	if stats[1].Entries != 1 || stats[1].Bytes != 600 || stats[1].Attempts != 1 || stats[1].Err != nil {
+		t.Fatalf("unexpected flush stats: %+v", stats[1])
+	}
+}
@@ -0,0 +1,82 @@
+package lokigo
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// rateBucket is the resolution of Client.Rates and rateBuckets the
+	// number of buckets kept, which bounds the longest window.
+	rateBucket  = time.Second
+	rateBuckets = 300
+)
+
+// Rates holds counter increments observed within a recent window, as
+// returned by Client.Rates.
+type Rates struct {
+	// Window is the span actually covered, after rounding up to whole
+	// seconds and capping at five minutes.
+	Window     time.Duration
+	Dropped    uint64
+	Pushed     uint64
+	PushErrors uint64
+	Retries    uint64
+}
+
+// PerSecond converts a count from r to a per-second rate over r.Window.
+func (r Rates) PerSecond(n uint64) float64 {
+	if r.Window <= 0 {
+		return 0
+	}
+	return float64(n) / r.Window.Seconds()
+}
+
+// rateRing keeps per-second counter increments for the last rateBuckets
+// seconds. Buckets are reused lazily: a bucket whose stamp is stale is
+// zeroed the next time it is written.
+type rateRing struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	stamps  [rateBuckets]int64
+	buckets [rateBuckets]Rates
+}
+
+func newRateRing() *rateRing {
+	return &rateRing{now: time.Now}
+}
+
+func (r *rateRing) add(delta Rates) {
+	sec := r.now().Unix()
+	i := int(sec % rateBuckets)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stamps[i] != sec {
+		r.stamps[i] = sec
+		r.buckets[i] = Rates{}
+	}
+	b := &r.buckets[i]
+	b.Dropped += delta.Dropped
+	b.Pushed += delta.Pushed
+	b.PushErrors += delta.PushErrors
+	b.Retries += delta.Retries
+}
+
+func (r *rateRing) sum(window time.Duration) Rates {
+	n := int64((window + rateBucket - 1) / rateBucket)
+	n = max(1, min(n, rateBuckets))
+	out := Rates{Window: time.Duration(n) * rateBucket}
+	now := r.now().Unix()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, stamp := range r.stamps {
+		if stamp > now-n && stamp <= now {
+			b := r.buckets[i]
+			out.Dropped += b.Dropped
+			out.Pushed += b.Pushed
+			out.PushErrors += b.PushErrors
+			out.Retries += b.Retries
+		}
+	}
+	return out
+}
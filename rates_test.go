@@ -0,0 +1,67 @@
+package lokigo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRatesAndMetricsReset(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, DisableBatching: true, Retry: RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	c.rates.now = clock.Now
+
+	for i := 0; i < 2; i++ {
+		if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		clock.Sleep(context.Background(), 10*time.Second)
+	}
+
+	got := c.Rates(time.Minute)
+	if got.Window != time.Minute || got.Pushed != 2 || got.PushErrors != 1 || got.Retries != 1 {
+		t.Fatalf("unexpected rates: %+v", got)
+	}
+	if r := got.PerSecond(got.Pushed); r != 2.0/60 {
+		t.Fatalf("unexpected per-second rate %v", r)
+	}
+	if got := c.Rates(5 * time.Second); got.Pushed != 0 {
+		t.Fatalf("expected nothing in the last 5s, got %+v", got)
+	}
+
+	clock.Sleep(context.Background(), 2*time.Minute)
+	if got := c.Rates(time.Minute); got.Pushed != 0 || got.PushErrors != 0 {
+		t.Fatalf("expected the minute window to have expired, got %+v", got)
+	}
+	if got := c.Rates(time.Hour); got.Window != 5*time.Minute || got.Pushed != 2 {
+		t.Fatalf("expected the capped window to still hold the pushes, got %+v", got)
+	}
+
+	m := c.MetricsReset()
+	if m.Pushed != 2 || m.PushErrors != 1 || m.Retries != 1 {
+		t.Fatalf("unexpected reset snapshot: %+v", m)
+	}
+	if m := c.Metrics(); m.Pushed != 0 || m.PushErrors != 0 || m.Retries != 0 {
+		t.Fatalf("expected counters zeroed, got %+v", m)
+	}
+	if got := c.Rates(time.Hour); got.Pushed != 2 {
+		t.Fatalf("expected Rates unaffected by reset, got %+v", got)
+	}
+}
@@ -0,0 +1,156 @@
+package lokigo
+
+import (
+	"context"
+	"maps"
+	"sync"
+)
+
+const (
+	// defaultRequestBufferEntries is the default WithBufferMaxEntries.
+	defaultRequestBufferEntries = 1000
+	// defaultRequestBufferBytes is the default WithBufferMaxBytes.
+	defaultRequestBufferBytes = 1 << 20
+)
+
+// BufferOption configures a RequestBuffer.
+type BufferOption func(*requestBufferConfig)
+
+type requestBufferConfig struct {
+	maxEntries int
+	maxBytes   int
+}
+
+// WithBufferMaxEntries bounds how many entries a RequestBuffer holds
+// (default 1000). Past it, the oldest entries are evicted.
+func WithBufferMaxEntries(n int) BufferOption {
+	return func(c *requestBufferConfig) { c.maxEntries = n }
+}
+
+// WithBufferMaxBytes bounds the line bytes a RequestBuffer holds (default
+// 1MiB). Past it, the oldest entries are evicted; the newest entry is kept
+// even when it alone is larger.
+func WithBufferMaxBytes(n int) BufferOption {
+	return func(c *requestBufferConfig) { c.maxBytes = n }
+}
+
+// RequestBuffer holds the entries of one request, typically its debug
+// logs, until the request's outcome is known: FlushToClient ships them,
+// say when the request failed, and DiscardOnSuccess drops them. It is safe
+// for concurrent use and bounded by WithBufferMaxEntries and
+// WithBufferMaxBytes, evicting the oldest entries first.
+type RequestBuffer struct {
+	client *Client
+	cfg    requestBufferConfig
+
+	mu sync.Mutex
+	// entries[head:] are the buffered entries; evicted ones before head
+	// are compacted away once they make up half the slice.
+	entries []Entry
+	head    int
+	bytes   int
+	evicted int
+}
+
+// NewRequestBuffer returns an empty RequestBuffer flushing to c.
+func NewRequestBuffer(c *Client, opts ...BufferOption) *RequestBuffer {
+	cfg := requestBufferConfig{maxEntries: defaultRequestBufferEntries, maxBytes: defaultRequestBufferBytes}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &RequestBuffer{client: c, cfg: cfg}
+}
+
+// Log adds e to the buffer. Labels and Metadata are copied, and e gets the
+// timestamp defaulting and checks of Send now, so a failing entry is
+// reported here and buffered entries keep the time they were logged.
+func (b *RequestBuffer) Log(e Entry) error {
+	if e.labelSet == nil {
+		e.Labels = maps.Clone(e.Labels)
+	}
+	e.Metadata = maps.Clone(e.Metadata)
+	return b.logOwned(e)
+}
+
+func (b *RequestBuffer) logOwned(e Entry) error {
+	if err := b.client.checkEntry(&e); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, e)
+	b.bytes += len(e.Line)
+	for len(b.entries)-b.head > 1 && b.over() {
+		b.bytes -= len(b.entries[b.head].Line)
+		b.entries[b.head] = Entry{}
+		b.head++
+		b.evicted++
+	}
+	if b.head > 0 && b.head >= len(b.entries)/2 {
+		n := copy(b.entries, b.entries[b.head:])
+		clear(b.entries[n:])
+		b.entries, b.head = b.entries[:n], 0
+	}
+	return nil
+}
+
+// over reports whether the buffered entries exceed a size bound.
+func (b *RequestBuffer) over() bool {
+	n := len(b.entries) - b.head
+	return b.cfg.maxEntries > 0 && n > b.cfg.maxEntries || b.cfg.maxBytes > 0 && b.bytes > b.cfg.maxBytes
+}
+
+// Len returns the number of entries buffered.
+func (b *RequestBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries) - b.head
+}
+
+// Evicted returns how many entries were evicted to stay within the size
+// bounds since the buffer was created.
+func (b *RequestBuffer) Evicted() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.evicted
+}
+
+// DiscardOnSuccess drops the buffered entries. The buffer stays usable.
+func (b *RequestBuffer) DiscardOnSuccess() {
+	b.take()
+}
+
+// FlushToClient hands the buffered entries to the client in the order they
+// were logged, with a single SendBatchOwned, and empties the buffer. The
+// entries are lost if it returns an error.
+func (b *RequestBuffer) FlushToClient(ctx context.Context) error {
+	entries := b.take()
+	if len(entries) == 0 {
+		return nil
+	}
+	return b.client.SendBatchOwned(ctx, entries)
+}
+
+// take empties the buffer and returns its entries.
+func (b *RequestBuffer) take() []Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries := b.entries[b.head:]
+	b.entries, b.head, b.bytes = nil, 0, 0
+	return entries
+}
+
+type requestBufferKey struct{}
+
+// ContextWithRequestBuffer returns a copy of ctx carrying b, for
+// RequestBufferFromContext.
+func ContextWithRequestBuffer(ctx context.Context, b *RequestBuffer) context.Context {
+	return context.WithValue(ctx, requestBufferKey{}, b)
+}
+
+// RequestBufferFromContext returns the RequestBuffer of ctx, or nil. It
+// suits WithRequestBuffering.
+func RequestBufferFromContext(ctx context.Context) *RequestBuffer {
+	b, _ := ctx.Value(requestBufferKey{}).(*RequestBuffer)
+	return b
+}
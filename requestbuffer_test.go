@@ -0,0 +1,171 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestBufferDiscardOnSuccess(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewRequestBuffer(c)
+	for _, line := range []string{"parsing body", "querying db"} {
+		if err := b.Log(Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.DiscardOnSuccess()
+	if n := b.Len(); n != 0 {
+		t.Fatalf("Len after DiscardOnSuccess = %d, want 0", n)
+	}
+	if err := b.FlushToClient(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("%d pushes, want none for a discarded buffer", n)
+	}
+}
+
+func TestRequestBufferFlushToClient(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewRequestBuffer(c)
+	labels := map[string]string{"app": "api"}
+	for _, line := range []string{"one", "two", "three"} {
+		if err := b.Log(Entry{Line: line, Labels: labels}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	labels["app"] = "changed"
+	if err := b.FlushToClient(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	streams := rec.streams()
+	if len(streams) != 1 || streams[0].Stream["app"] != "api" {
+		t.Fatalf("streams = %+v, want one app=api stream", streams)
+	}
+	var lines []string
+	for _, v := range streams[0].Values {
+		lines = append(lines, v.line)
+	}
+	if got := strings.Join(lines, ","); got != "one,two,three" {
+		t.Fatalf("lines = %s, want one,two,three in log order", got)
+	}
+}
+
+func TestRequestBufferEvictsOldest(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", DisableBatching: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	b := NewRequestBuffer(c, WithBufferMaxEntries(3), WithBufferMaxBytes(10))
+	for _, line := range []string{"a", "b", "c", "d", "e"} {
+		if err := b.Log(Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := bufferedLines(b); got != "c,d,e" || b.Evicted() != 2 {
+		t.Fatalf("buffered %s with %d evicted, want c,d,e and 2", got, b.Evicted())
+	}
+	// "0123456789" alone fills the byte bound, evicting everything before.
+	if err := b.Log(Entry{Line: "0123456789"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Log(Entry{Line: "0123456789x"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := bufferedLines(b); got != "0123456789x" || b.Evicted() != 6 {
+		t.Fatalf("buffered %s with %d evicted, want only the oversized newest entry and 6", got, b.Evicted())
+	}
+}
+
+func bufferedLines(b *RequestBuffer) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	for _, e := range b.entries[b.head:] {
+		lines = append(lines, e.Line)
+	}
+	return strings.Join(lines, ",")
+}
+
+func TestRequestBufferRejectsInvalidEntries(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", DisableBatching: true, ValidateOnSend: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	b := NewRequestBuffer(c)
+	var verr *ValidationError
+	if err := b.Log(Entry{Line: "x", Labels: map[string]string{"bad-name": "v"}}); !errors.As(err, &verr) {
+		t.Fatalf("Log = %v, want a ValidationError", err)
+	}
+	if b.Len() != 0 {
+		t.Fatal("invalid entry was buffered")
+	}
+}
+
+func TestSlogHandlerWithRequestBuffering(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(NewSlogHandler(c, WithSlogLevel(slog.LevelDebug), WithRequestBuffering(RequestBufferFromContext)))
+
+	ok := NewRequestBuffer(c)
+	ctx := ContextWithRequestBuffer(context.Background(), ok)
+	logger.DebugContext(ctx, "request succeeded")
+	ok.DiscardOnSuccess()
+
+	failed := NewRequestBuffer(c)
+	ctx = ContextWithRequestBuffer(context.Background(), failed)
+	logger.DebugContext(ctx, "request step")
+	logger.ErrorContext(ctx, "request failed")
+	if failed.Len() != 2 {
+		t.Fatalf("buffer holds %d records, want 2", failed.Len())
+	}
+	logger.Info("no buffer")
+	if err := failed.FlushToClient(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, s := range rec.streams() {
+		for _, v := range s.Values {
+			lines = append(lines, s.Stream["level"]+":"+v.line)
+		}
+	}
+	slices.Sort(lines)
+	if got := strings.Join(lines, ","); got != "DEBUG:request step,ERROR:request failed,INFO:no buffer" {
+		t.Fatalf("pushed %s, want the unbuffered record and the failed request's records", got)
+	}
+}
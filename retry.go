@@ -5,11 +5,14 @@ import (
 	"errors"
 	"math"
 	"math/rand"
+	"net/http"
+	"strconv"
 	"time"
 )
 
 func doRetry(ctx context.Context, cfg RetryConfig, fn func(attempt int) error) error {
 	var lastErr error
+	step := 0
 	for i := 0; i < cfg.MaxAttempts; i++ {
 		if err := fn(i); err == nil {
 			return nil
@@ -22,7 +25,18 @@ func doRetry(ctx context.Context, cfg RetryConfig, fn func(attempt int) error) e
 		if i == cfg.MaxAttempts-1 {
 			break
 		}
-		wait := backoffWithJitter(cfg, i)
+		var wait time.Duration
+		if i == 0 && cfg.InitialDelay > 0 && isConnectError(lastErr) {
+			wait = initialDelayWithJitter(cfg.InitialDelay)
+		} else {
+			wait = backoffWithJitter(cfg, step)
+			step++
+		}
+		// Wait at least as long as the server asked, within MaxBackoff.
+		var statusErr *HTTPStatusPushError
+		if errors.As(lastErr, &statusErr) {
+			wait = max(wait, min(statusErr.RetryAfter, cfg.MaxBackoff))
+		}
 		t := time.NewTimer(wait)
 		select {
 		case <-ctx.Done():
@@ -60,3 +74,30 @@ func backoffWithJitter(cfg RetryConfig, attempt int) time.Duration {
 	}
 	return time.Duration(base * jitter)
 }
+
+// initialDelayWithJitter returns a random wait between d/2 and d.
+func initialDelayWithJitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isConnectError reports whether err is a push that never reached the
+// endpoint: connection refused or a failed name lookup.
+func isConnectError(err error) bool {
+	var netErr *NetworkPushError
+	return errors.As(err, &netErr) && (netErr.Kind == NetworkErrorConnectionRefused || netErr.Kind == NetworkErrorDNS)
+}
+
+// parseRetryAfter returns the delay of a Retry-After header value, given in
+// seconds or as an HTTP date, or zero when v is empty or malformed.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,118 @@
+package lokigo
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Route sends matching entries to a different endpoint and/or tenant.
+//
+// An entry matches when every Match label equals the given value and every
+// MatchRegex label fully matches the given pattern, both evaluated against
+// the merged (static + entry) labels. Routes are evaluated in order and the
+// first match wins; unmatched entries use the base Endpoint and TenantID.
+type Route struct {
+	Match      map[string]string
+	MatchRegex map[string]string
+	// Endpoint overrides Config.Endpoint when non-empty.
+	Endpoint string
+	// TenantID overrides Config.TenantID when non-empty.
+	TenantID string
+}
+
+// pushTarget is where a batch is pushed to.
+type pushTarget struct {
+	endpoint string
+	tenantID string
+}
+
+type compiledRoute struct {
+	match  map[string]string
+	regex  map[string]*regexp.Regexp
+	target pushTarget
+}
+
+func compileRoutes(cfg Config) ([]compiledRoute, error) {
+	out := make([]compiledRoute, 0, len(cfg.Routes))
+	for i, r := range cfg.Routes {
+		if r.Endpoint == "" && r.TenantID == "" {
+			return nil, fmt.Errorf("routes[%d]: endpoint or tenantID is required", i)
+		}
+		if r.Endpoint != "" && !validPushURL(r.Endpoint) {
+			return nil, fmt.Errorf("routes[%d]: endpoint must be an absolute http or https URL", i)
+		}
+		cr := compiledRoute{match: r.Match, target: pushTarget{endpoint: cfg.Endpoint, tenantID: cfg.TenantID}}
+		if r.Endpoint != "" {
+			cr.target.endpoint = r.Endpoint
+		}
+		if r.TenantID != "" {
+			cr.target.tenantID = r.TenantID
+		}
+		if len(r.MatchRegex) > 0 {
+			cr.regex = make(map[string]*regexp.Regexp, len(r.MatchRegex))
+			for k, pattern := range r.MatchRegex {
+				re, err := regexp.Compile("^(?:" + pattern + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("routes[%d]: invalid regex for %q: %w", i, k, err)
+				}
+				cr.regex[k] = re
+			}
+		}
+		out = append(out, cr)
+	}
+	return out, nil
+}
+
+func (r compiledRoute) matches(labels map[string]string) bool {
+	for k, v := range r.match {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	for k, re := range r.regex {
+		got, ok := labels[k]
+		if !ok || !re.MatchString(got) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Client) defaultTarget() pushTarget {
+	return pushTarget{endpoint: c.cfg.Endpoint, tenantID: c.cfg.TenantID}
+}
+
+// routeBatch partitions entries by their first matching route and
+// Entry.TenantID. Partitions are returned in first-seen order and keep the
+// relative entry order.
+func (c *Client) routeBatch(entries []Entry) ([]pushTarget, [][]Entry) {
+	if len(c.routes) == 0 && !slices.ContainsFunc(entries, func(e Entry) bool { return e.TenantID != "" }) {
+		return []pushTarget{c.defaultTarget()}, [][]Entry{entries}
+	}
+	var targets []pushTarget
+	var parts [][]Entry
+	index := map[pushTarget]int{}
+	for _, e := range entries {
+		target := c.defaultTarget()
+		labels := c.mergedLabels(e)
+		for _, r := range c.routes {
+			if r.matches(labels) {
+				target = r.target
+				break
+			}
+		}
+		if e.TenantID != "" {
+			target.tenantID = e.TenantID
+		}
+		i, ok := index[target]
+		if !ok {
+			i = len(targets)
+			index[target] = i
+			targets = append(targets, target)
+			parts = append(parts, nil)
+		}
+		parts[i] = append(parts[i], e)
+	}
+	return targets, parts
+}
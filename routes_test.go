@@ -0,0 +1,82 @@
+package lokigo
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRoutesPartitionEntriesByTarget(t *testing.T) {
+	type target struct{ path, tenant string }
+	srv, rec := captureJSONPushes(t)
+
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL + "/shared",
+		TenantID:        "shared",
+		Encoding:        EncodingJSON,
+		BatchMaxEntries: 10,
+		BatchMaxWait:    time.Minute,
+		Routes: []Route{
+			{Match: map[string]string{"kind": "audit"}, Endpoint: srv.URL + "/locked", TenantID: "audit"},
+			{MatchRegex: map[string]string{"service": "pay.*"}, TenantID: "payments"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []Entry{
+		{Line: "login", Labels: map[string]string{"kind": "audit", "service": "payments"}},
+		{Line: "charge", Labels: map[string]string{"service": "payments"}},
+		{Line: "hello", Labels: map[string]string{"service": "web"}},
+		{Line: "repay", Labels: map[string]string{"service": "repay"}},
+	} {
+		if err := c.Send(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[target][]string{}
+	for _, p := range rec.received() {
+		key := target{path: p.path, tenant: p.header.Get("X-Scope-OrgID")}
+		for _, s := range p.streams {
+			for _, v := range s.Values {
+				got[key] = append(got[key], v.line)
+			}
+		}
+	}
+	want := map[target][]string{
+		{path: "/locked", tenant: "audit"}:    {"login"},
+		{path: "/shared", tenant: "payments"}: {"charge"},
+		{path: "/shared", tenant: "shared"}:   {"hello", "repay"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d distinct targets, got %v", len(want), got)
+	}
+	for k, lines := range want {
+		sort.Strings(got[k]) // streams within one payload are unordered
+		if len(got[k]) != len(lines) {
+			t.Fatalf("target %+v: expected %v, got %v", k, lines, got[k])
+		}
+		for i := range lines {
+			if got[k][i] != lines[i] {
+				t.Fatalf("target %+v: expected %v, got %v", k, lines, got[k])
+			}
+		}
+	}
+}
+
+func TestRoutesValidation(t *testing.T) {
+	cases := map[string][]Route{
+		"no override": {{Match: map[string]string{"a": "b"}}},
+		"bad regex":   {{MatchRegex: map[string]string{"a": "("}, TenantID: "x"}},
+	}
+	for name, routes := range cases {
+		if _, err := NewClient(Config{Endpoint: "http://127.0.0.1", Routes: routes}); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+}
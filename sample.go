@@ -0,0 +1,94 @@
+package lokigo
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSamplerKeys bounds the keys a RateSampler tracks. Entries of further
+// keys share one overflow count until tracked keys' windows end.
+const maxSamplerKeys = 4096
+
+// RateSampler returns a Config.Sampler that, per key and window, keeps the
+// first initial entries and then every thereafter-th one; thereafter 0
+// drops the rest of the window. A nil keyFn samples all entries under one
+// key, and a window of zero or less defaults to one second. It is safe for
+// concurrent use and tracks at most 4096 keys: past that, entries of new
+// keys are sampled together.
+func RateSampler(keyFn func(Entry) string, initial, thereafter int, window time.Duration) func(Entry) bool {
+	return newRateSampler(keyFn, initial, thereafter, window, time.Now)
+}
+
+func newRateSampler(keyFn func(Entry) string, initial, thereafter int, window time.Duration, now func() time.Time) func(Entry) bool {
+	if window <= 0 {
+		window = time.Second
+	}
+	s := &rateSampler{initial: initial, thereafter: thereafter, window: window, now: now, counts: map[string]*sampleCount{}}
+	return func(e Entry) bool {
+		key := ""
+		if keyFn != nil {
+			key = keyFn(e)
+		}
+		return s.keep(key)
+	}
+}
+
+type rateSampler struct {
+	initial, thereafter int
+	window              time.Duration
+	now                 func() time.Time
+
+	mu       sync.Mutex
+	counts   map[string]*sampleCount
+	overflow sampleCount
+}
+
+type sampleCount struct {
+	start time.Time
+	n     int
+}
+
+func (s *rateSampler) keep(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	c, ok := s.counts[key]
+	if !ok {
+		if len(s.counts) >= maxSamplerKeys {
+			s.pruneLocked(now)
+		}
+		if len(s.counts) < maxSamplerKeys {
+			c = &sampleCount{start: now}
+			s.counts[key] = c
+		} else {
+			c = &s.overflow
+		}
+	}
+	if now.Sub(c.start) >= s.window {
+		c.start, c.n = now, 0
+	}
+	c.n++
+	if c.n <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (c.n-s.initial)%s.thereafter == 0
+}
+
+// pruneLocked forgets keys whose window ended.
+func (s *rateSampler) pruneLocked(now time.Time) {
+	for k, c := range s.counts {
+		if now.Sub(c.start) >= s.window {
+			delete(s.counts, k)
+		}
+	}
+}
+
+// sampledOut reports whether Config.Sampler discards e, counting it in
+// Metrics.Sampled if so.
+func (c *Client) sampledOut(e Entry) bool {
+	if c.cfg.Sampler == nil || c.cfg.Sampler(e) {
+		return false
+	}
+	c.sampled.Add(1)
+	return true
+}
@@ -0,0 +1,101 @@
+package lokigo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateSamplerRateMath(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	keep := newRateSampler(func(e Entry) string { return e.Labels["app"] }, 3, 4, time.Second, clock.Now)
+	kept := func(app string, n int) (out []int) {
+		for i := 1; i <= n; i++ {
+			if keep(Entry{Labels: map[string]string{"app": app}}) {
+				out = append(out, i)
+			}
+		}
+		return out
+	}
+	if got := fmt.Sprint(kept("api", 20)); got != "[1 2 3 7 11 15 19]" {
+		t.Fatalf("kept %s of 20, want the first 3 and every 4th after", got)
+	}
+	// Keys are sampled independently.
+	if got := fmt.Sprint(kept("web", 4)); got != "[1 2 3]" {
+		t.Fatalf("kept %s of another key, want [1 2 3]", got)
+	}
+	// A new window starts the count over.
+	_ = clock.Sleep(context.Background(), time.Second)
+	if got := fmt.Sprint(kept("api", 4)); got != "[1 2 3]" {
+		t.Fatalf("kept %s in a new window, want [1 2 3]", got)
+	}
+
+	dropRest := newRateSampler(nil, 2, 0, time.Second, clock.Now)
+	n := 0
+	for range 10 {
+		if dropRest(Entry{}) {
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatalf("thereafter 0 kept %d of 10, want 2", n)
+	}
+}
+
+func TestRateSamplerBoundsKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	keep := newRateSampler(func(e Entry) string { return e.Line }, 1, 0, time.Second, clock.Now)
+	for i := range maxSamplerKeys {
+		if !keep(Entry{Line: fmt.Sprint(i)}) {
+			t.Fatalf("first entry of key %d sampled out", i)
+		}
+	}
+	// New keys share the overflow count once the map is full.
+	if !keep(Entry{Line: "overflow-a"}) || keep(Entry{Line: "overflow-b"}) {
+		t.Fatal("want new keys past the bound sampled together")
+	}
+	// Expired keys make room again.
+	_ = clock.Sleep(context.Background(), time.Second)
+	if !keep(Entry{Line: "fresh"}) || !keep(Entry{Line: "fresh-2"}) {
+		t.Fatal("want keys tracked again after the window")
+	}
+}
+
+func TestSamplerAppliesBeforeEnqueue(t *testing.T) {
+	srv, captured := captureJSONStreams(t)
+	c, err := NewClient(Config{
+		Endpoint:     srv.URL,
+		Encoding:     EncodingJSON,
+		BatchMaxWait: time.Minute,
+		Sampler:      func(e Entry) bool { return e.Labels["level"] != "debug" },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		level := "info"
+		if i%2 == 0 {
+			level = "debug"
+		}
+		if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"level": level}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	batch := []Entry{{Line: "a", Labels: map[string]string{"level": "debug"}}, {Line: "b", Labels: map[string]string{"level": "info"}}}
+	if err := c.SendBatchOwned(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m := c.Metrics()
+	if m.Sampled != 6 || m.Dropped != 0 || m.Pushed != 6 {
+		t.Fatalf("Sampled = %d, Dropped = %d, Pushed = %d; want 6, 0 and 6", m.Sampled, m.Dropped, m.Pushed)
+	}
+	for _, s := range captured() {
+		if s.labels["level"] != "info" {
+			t.Fatalf("pushed stream %v, want info entries only", s.labels)
+		}
+	}
+}
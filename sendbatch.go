@@ -0,0 +1,157 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// bulkHandoff is a slice passed whole to the worker by SendBatchOwned.
+type bulkHandoff struct {
+	entries  []Entry
+	bytes    int64
+	enqueued time.Time
+}
+
+// SendBatchOwned enqueues entries with a single handoff to the worker
+// instead of one queue operation per entry, for bulk importers. Ownership of
+// the slice passes to the client: the caller must not read or modify it, or
+// the entries, after calling SendBatchOwned, unless it returns an error from
+// the checks below, in which case nothing was enqueued.
+//
+// Each entry gets the timestamp defaulting and checks of Send first; the
+// first failing entry's error is returned. Config.Sampler then applies as
+// for Send. The worker then adds the entries to its batch in order,
+// flushing at BatchMaxEntries and BatchMaxBytes as usual. Entries bypass
+// QueueClasses, and entries already queued by Send may be pushed after them.
+//
+// One handoff may wait for the worker at a time. When another is waiting,
+// BackpressureMode applies to the handoff as a whole: BackpressureBlock
+// waits, BackpressureDropNew drops entries and returns ErrDropped, and
+// BackpressureDropOldest drops the waiting handoff instead.
+func (c *Client) SendBatchOwned(ctx context.Context, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for i := range entries {
+		if err := c.checkEntry(&entries[i]); err != nil {
+			return err
+		}
+	}
+	if c.cfg.Sampler != nil {
+		entries = slices.DeleteFunc(entries, c.sampledOut)
+		if len(entries) == 0 {
+			return nil
+		}
+	}
+	var size int64
+	for _, e := range entries {
+		size += int64(len(e.Line))
+		c.countEmptyLabels(e)
+		c.countTruncatedLabels(e)
+	}
+	defer c.endSend()
+	if !c.beginSend() {
+		return c.rejectStopped(len(entries))
+	}
+	if c.cfg.DisableBatching {
+		return c.flushBatch(ctx, entries)
+	}
+	h := bulkHandoff{entries: entries, bytes: size, enqueued: time.Now()}
+	c.queuedBytes.Add(size)
+	c.bulkLen.Add(int64(len(entries)))
+	mode, grace := c.cfg.BackpressureMode, c.cfg.DropNewGrace
+	if c.inWorkerCallback() {
+		if mode == BackpressureBlock {
+			mode = BackpressureDropNew
+		}
+		grace = 0
+	}
+	var evicted []bulkHandoff
+	n := 0
+	_, err := enqueueWithMode(ctx, c.bulk, h, mode, grace, func(old bulkHandoff) {
+		evicted = append(evicted, old)
+		n += len(old.entries)
+		c.bulkTaken(old)
+	})
+	if n > 0 {
+		c.drop(DropQueueEvicted, n)
+		for _, old := range evicted {
+			c.reportDrops(old.entries, DropQueueEvicted)
+		}
+	}
+	if err != nil {
+		c.bulkTaken(h)
+		if errors.Is(err, errDroppedInternal) {
+			c.drop(DropQueueFull, len(entries))
+			c.reportDrops(entries, DropQueueFull)
+			return ErrDropped
+		}
+		return err
+	}
+	c.observeQueueDepth()
+	return nil
+}
+
+// bulkTaken accounts for h leaving the handoff channel.
+func (c *Client) bulkTaken(h bulkHandoff) {
+	c.queuedBytes.Add(-h.bytes)
+	c.bulkLen.Add(-int64(len(h.entries)))
+}
+
+// Push pushes entries on the caller's goroutine, bypassing the queue, and
+// returns the final push error after retries, for CLI tools and short-lived
+// functions that must know their logs reached Loki before exiting. Entries
+// get the timestamp defaulting, checks and sampling of Send, and are split
+// into requests at BatchMaxEntries and BatchMaxBytes; the errors of failed
+// requests are joined. The caller's slice is not modified or retained.
+//
+// With DisableBatching no background worker runs at all, so Push (or Send)
+// is the whole client and Close only waits for pushes in flight. Otherwise
+// entries queued by Send are pushed independently and may arrive after.
+// Push returns ErrClosed once Close was called.
+func (c *Client) Push(ctx context.Context, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	entries = slices.Clone(entries)
+	for i := range entries {
+		if err := c.checkEntry(&entries[i]); err != nil {
+			return err
+		}
+	}
+	if c.cfg.Sampler != nil {
+		entries = slices.DeleteFunc(entries, c.sampledOut)
+	}
+	for _, e := range entries {
+		c.countEmptyLabels(e)
+		c.countTruncatedLabels(e)
+	}
+	defer c.endSend()
+	if !c.beginSend() {
+		return c.rejectStopped(len(entries))
+	}
+	var errs []error
+	for len(entries) > 0 {
+		n := c.pushChunkLen(entries)
+		if err := c.flushBatch(ctx, entries[:n]); err != nil {
+			errs = append(errs, err)
+		}
+		entries = entries[n:]
+	}
+	return errors.Join(errs...)
+}
+
+// pushChunkLen returns how many leading entries fit one Push request under
+// BatchMaxEntries and BatchMaxBytes, at least one.
+func (c *Client) pushChunkLen(entries []Entry) int {
+	size := 0
+	for i, e := range entries {
+		size += len(e.Line)
+		if i > 0 && (c.cfg.BatchMaxEntries > 0 && i >= c.cfg.BatchMaxEntries || c.cfg.BatchMaxBytes > 0 && size > c.cfg.BatchMaxBytes) {
+			return i
+		}
+	}
+	return len(entries)
+}
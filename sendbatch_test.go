@@ -0,0 +1,186 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendBatchOwnedSplitsAtBatchLimits(t *testing.T) {
+	srv, pushes := newJSONCaptureServer(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxEntries: 4, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]Entry, 10)
+	for i := range entries {
+		entries[i] = Entry{Line: fmt.Sprint(i)}
+	}
+	if err := c.SendBatchOwned(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	for _, p := range pushes() {
+		sizes = append(sizes, p.entries)
+	}
+	if fmt.Sprint(sizes) != "[4 4 2]" {
+		t.Fatalf("push sizes = %v, want [4 4 2]", sizes)
+	}
+	if m := c.Metrics(); m.Pushed != 10 || c.QueueLen() != 0 || c.PendingBytes() != 0 {
+		t.Fatalf("Pushed = %d, QueueLen = %d, PendingBytes = %d", m.Pushed, c.QueueLen(), c.PendingBytes())
+	}
+}
+
+func TestSendBatchOwnedBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, BackpressureMode: BackpressureDropNew, BatchMaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := func() []Entry { return []Entry{{Line: "a"}, {Line: "b"}} }
+	// The worker takes the first chunk and blocks pushing it; the second
+	// waits in the handoff slot.
+	if err := c.SendBatchOwned(context.Background(), chunk()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Metrics().InFlight == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first chunk never went in flight")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.SendBatchOwned(context.Background(), chunk()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendBatchOwned(context.Background(), chunk()); !errors.Is(err, ErrDropped) {
+		t.Fatalf("third chunk err = %v, want ErrDropped", err)
+	}
+	if got := c.QueueLen(); got != 2 {
+		t.Fatalf("QueueLen = %d, want the 2 waiting entries", got)
+	}
+	unblock()
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m := c.Metrics(); m.Pushed != 4 || m.DroppedByReason[DropQueueFull] != 2 {
+		t.Fatalf("Pushed = %d, dropped = %v", m.Pushed, m.DroppedByReason)
+	}
+}
+
+func TestSendBatchOwnedChecksBeforeEnqueueing(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1", ReservedLabelPolicy: ReservedLabelReject})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+	err = c.SendBatchOwned(context.Background(), []Entry{{Line: "ok"}, {Line: "bad", Labels: map[string]string{"__name__": "x"}}})
+	var reserved *ReservedLabelError
+	if !errors.As(err, &reserved) {
+		t.Fatalf("err = %v, want *ReservedLabelError", err)
+	}
+	if got := c.QueueLen(); got != 0 {
+		t.Fatalf("QueueLen = %d, want nothing enqueued", got)
+	}
+}
+
+func TestPushRetriesOnCallerGoroutine(t *testing.T) {
+	var requests sync.WaitGroup
+	var mu sync.Mutex
+	statuses := []int{http.StatusInternalServerError, http.StatusNoContent}
+	var entries []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer requests.Done()
+		var payload struct {
+			Streams []jsonStreamValues `json:"streams"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		n := 0
+		for _, s := range payload.Streams {
+			n += len(s.Values)
+		}
+		entries = append(entries, n)
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		Encoding:        EncodingJSON,
+		DisableBatching: true,
+		BatchMaxEntries: 2,
+		Retry:           RetryConfig{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three requests of at most two entries, the first retried once.
+	requests.Add(4)
+	batch := make([]Entry, 5)
+	for i := range batch {
+		batch[i] = Entry{Line: fmt.Sprint(i)}
+	}
+	if err := c.Push(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+	requests.Wait()
+	if !batch[0].Timestamp.IsZero() {
+		t.Fatal("Push modified the caller's entries")
+	}
+	if m := c.Metrics(); m.Pushed != 5 || m.Retries != 1 {
+		t.Fatalf("Pushed = %d, Retries = %d, want 5 and 1", m.Pushed, m.Retries)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if fmt.Sprint(entries) != "[2 2 2 1]" {
+		t.Fatalf("entries per request = %v, want [2 2 2 1] with no request from Close", entries)
+	}
+	if err := c.Push(context.Background(), batch); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Push after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestPushReturnsPushError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	var statusErr *HTTPStatusPushError
+	if err := c.Push(context.Background(), []Entry{{Line: "x"}}); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Push = %v, want the 400 push error", err)
+	}
+	if n := c.QueueLen(); n != 0 {
+		t.Fatalf("QueueLen = %d, want Push to bypass the queue", n)
+	}
+}
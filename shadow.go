@@ -0,0 +1,111 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// shadowQueueSize bounds payloads waiting for the shadow endpoint. When full,
+// new payloads are skipped and counted as shadow errors.
+const shadowQueueSize = 16
+
+// shadowPusher mirrors already-encoded payloads to Config.ShadowEndpoint on
+// its own goroutine, so a slow or failing shadow never delays real pushes.
+type shadowPusher struct {
+	mu     sync.Mutex
+	closed bool
+	ch     chan encodedPayload
+}
+
+func (c *Client) startShadow() {
+	if c.cfg.ShadowEndpoint == "" {
+		return
+	}
+	c.shadow = &shadowPusher{ch: make(chan encodedPayload, shadowQueueSize)}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for p := range c.shadow.ch {
+			c.pushShadow(p)
+		}
+	}()
+}
+
+// mirrorToShadow queues payload for the shadow endpoint without blocking.
+func (c *Client) mirrorToShadow(p encodedPayload) {
+	if c.shadow == nil {
+		return
+	}
+	c.shadow.mu.Lock()
+	defer c.shadow.mu.Unlock()
+	if c.shadow.closed {
+		return
+	}
+	select {
+	case c.shadow.ch <- p:
+	default:
+		c.shadowErrors.Add(1)
+		c.debug("shadow push skipped: queue full", "endpoint", c.cfg.ShadowEndpoint)
+	}
+}
+
+// closeShadow stops accepting payloads; queued ones are still pushed.
+func (c *Client) closeShadow() {
+	if c.shadow == nil {
+		return
+	}
+	c.shadow.mu.Lock()
+	defer c.shadow.mu.Unlock()
+	if !c.shadow.closed {
+		c.shadow.closed = true
+		close(c.shadow.ch)
+	}
+}
+
+// pushShadow makes at most two attempts (one retry on transient errors).
+// Failures are only counted and logged; they never reach OnError.
+func (c *Client) pushShadow(p encodedPayload) {
+	tenantID := c.cfg.ShadowTenantID
+	if tenantID == "" {
+		tenantID = c.cfg.TenantID
+	}
+	headers := c.cfg.ShadowHeaders
+	if headers == nil {
+		headers = c.cfg.Headers
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = c.pushShadowOnce(p, headers, tenantID); err == nil || !shouldRetryPushError(err) {
+			break
+		}
+	}
+	if err != nil {
+		c.shadowErrors.Add(1)
+		c.debug("shadow push failed", "endpoint", c.cfg.ShadowEndpoint, "error", err)
+	}
+}
+
+func (c *Client) pushShadowOnce(p encodedPayload, headers map[string]string, tenantID string) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.cfg.ShadowEndpoint, bytes.NewReader(p.payload))
+	if err != nil {
+		return err
+	}
+	cfg := c.cfg
+	cfg.Headers = headers
+	if req.Header, err = buildHeaders(cfg, batchHeaders{encoder: p.header, tenantID: tenantID}); err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return newNetworkPushError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &HTTPStatusPushError{StatusCode: resp.StatusCode, Body: c.readErrorBody(resp)}
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	return nil
+}
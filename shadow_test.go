@@ -0,0 +1,138 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShadowReceivesSamePayloadBytes(t *testing.T) {
+	type req struct {
+		body        []byte
+		contentType string
+		tenant      string
+		auth        string
+	}
+	var mu sync.Mutex
+	var primary, shadow []req
+	record := func(dst *[]req) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			*dst = append(*dst, req{body: b, contentType: r.Header.Get("Content-Type"), tenant: r.Header.Get("X-Scope-OrgID"), auth: r.Header.Get("Authorization")})
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+	p := httptest.NewServer(record(&primary))
+	defer p.Close()
+	s := httptest.NewServer(record(&shadow))
+	defer s.Close()
+
+	c, err := NewClient(Config{
+		Endpoint:        p.URL,
+		TenantID:        "prod",
+		Headers:         map[string]string{"Authorization": "Bearer prod"},
+		BatchMaxEntries: 1,
+		ShadowEndpoint:  s.URL,
+		ShadowHeaders:   map[string]string{"Authorization": "Bearer canary"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one", "two"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(primary) != 2 || len(shadow) != 2 {
+		t.Fatalf("expected 2 primary and 2 shadow pushes, got %d and %d", len(primary), len(shadow))
+	}
+	for i := range primary {
+		if !bytes.Equal(primary[i].body, shadow[i].body) || primary[i].contentType != shadow[i].contentType {
+			t.Fatalf("push %d: shadow payload differs from primary", i)
+		}
+	}
+	if shadow[0].tenant != "prod" || shadow[0].auth != "Bearer canary" {
+		t.Fatalf("unexpected shadow headers: tenant=%q auth=%q", shadow[0].tenant, shadow[0].auth)
+	}
+}
+
+func TestShadowFailuresDoNotFailBatches(t *testing.T) {
+	var shadowAttempts atomic.Int32
+	p := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer p.Close()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowAttempts.Add(1)
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	var onErr atomic.Int32
+	var last atomic.Value
+	var logs bytes.Buffer
+	var logMu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(&lockedWriter{mu: &logMu, w: &logs}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c, err := NewClient(Config{
+		Endpoint:        p.URL,
+		Encoding:        EncodingJSON,
+		BatchMaxEntries: 1,
+		ShadowEndpoint:  s.URL,
+		DebugLogger:     logger,
+		OnError:         func(error) { onErr.Add(1) },
+		OnFlush:         func(m Metrics) { last.Store(m) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("expected shadow failure not to fail the batch, got %v", err)
+	}
+
+	if n := onErr.Load(); n != 0 {
+		t.Fatalf("expected OnError not to be called, got %d calls", n)
+	}
+	if n := shadowAttempts.Load(); n != 2 {
+		t.Fatalf("expected one shadow retry (2 attempts), got %d", n)
+	}
+	if n := c.shadowErrors.Load(); n != 1 {
+		t.Fatalf("expected 1 shadow error, got %d", n)
+	}
+	if m := last.Load().(Metrics); m.Pushed != 1 || m.PushErrors != 0 {
+		t.Fatalf("expected primary push to succeed, got %+v", m)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	if !strings.Contains(logs.String(), "shadow push failed") {
+		t.Fatalf("expected shadow failure in debug log, got %q", logs.String())
+	}
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,213 @@
+package lokigo
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownStats summarizes the work done by CloseWithStats, counting pushes
+// that finished after Close was called, including those already in flight.
+type ShutdownStats struct {
+	// Flushed is the number of entries pushed successfully.
+	Flushed int
+	// Failed is the number of entries whose push failed for another reason
+	// than the Close context ending, such as a rejected batch or exhausted
+	// retries.
+	Failed int
+	// Abandoned is the number of entries given up because the Close context
+	// ended before they could be pushed, including those still queued or in
+	// flight when CloseWithStats returned.
+	Abandoned int
+	// Discarded is the number of queued entries the drain gave up on
+	// without pushing them, because of ShutdownMaxDrainEntries or the Close
+	// deadline being too near. They are included in Abandoned.
+	Discarded int
+	// Batches is the number of successful push requests.
+	Batches int
+	// Duration is how long CloseWithStats took.
+	Duration time.Duration
+}
+
+// shutdownCounters collects ShutdownStats once Close has been called.
+type shutdownCounters struct {
+	closing   atomic.Bool
+	flushed   atomic.Int64
+	failed    atomic.Int64
+	abandoned atomic.Int64
+	discarded atomic.Int64
+	batches   atomic.Int64
+}
+
+// record accounts for a finished push of n entries while shutting down.
+// aborted reports whether the push context had ended.
+func (s *shutdownCounters) record(n int, err error, aborted bool) {
+	if !s.closing.Load() {
+		return
+	}
+	switch {
+	case err == nil:
+		s.flushed.Add(int64(n))
+		s.batches.Add(1)
+	case aborted:
+		s.abandoned.Add(int64(n))
+	default:
+		s.failed.Add(int64(n))
+	}
+}
+
+// CloseWithStats stops the client like Close and reports what happened to
+// the entries still pending. Queued entries are drained and pushed, and
+// pushes in flight are awaited. The drain pushes under ctx and stops early,
+// discarding the entries left, at ShutdownMaxDrainEntries or once ctx's
+// deadline is nearer than its last push took. If ctx ends first, in-flight
+// retries and the drain are interrupted, the remaining entries are abandoned
+// and ctx's error is returned; otherwise the error is the last flush error,
+// if any.
+//
+// Close and CloseWithStats may be called more than once and from several
+// goroutines: the first call starts the shutdown, its ctx bounds the drain,
+// and a single background waiter records the outcome once the worker and
+// in-flight pushes are done. Every call waits for that outcome, or for its
+// own ctx, so all calls that see the shutdown finish return the same stats
+// and error, and repeated calls never add goroutines.
+func (c *Client) CloseWithStats(ctx context.Context) (ShutdownStats, error) {
+	if _, ok := ctx.Deadline(); !ok && c.cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	if c.closeStarted.CompareAndSwap(false, true) {
+		defer context.AfterFunc(ctx, c.abort)()
+		c.startShutdown(ctx)
+	}
+	select {
+	case <-c.closeDone:
+		return c.closeStats, c.closeErr
+	default:
+	}
+	if ctx.Err() == nil {
+		select {
+		case <-c.closeDone:
+			return c.closeStats, c.closeErr
+		case <-ctx.Done():
+		}
+	}
+	return c.shutdownStats(true), ctx.Err()
+}
+
+// startShutdown stops the worker and starts the one goroutine that waits
+// for the shutdown to finish, then publishes its outcome in closeStats and
+// closeErr and closes closeDone.
+func (c *Client) startShutdown(ctx context.Context) {
+	start := time.Now()
+	c.shutdown.closing.Store(true)
+	if d, ok := ctx.Deadline(); ok {
+		c.closeDeadline.Store(d.UnixNano())
+	}
+	c.cancel()
+	if c.cfg.DisableBatching {
+		c.closeShadow()
+	}
+	go func() {
+		c.wg.Wait()
+		_ = c.inFlight.wait(context.Background())
+		stats := c.shutdownStats(false)
+		stats.Duration = time.Since(start)
+		c.errMu.Lock()
+		c.closeStats, c.closeErr = stats, c.lastErr
+		c.errMu.Unlock()
+		close(c.closeDone)
+	}()
+}
+
+// shutdownStats snapshots the shutdown counters. When Close gives up early,
+// entries still queued or in flight are counted as abandoned.
+func (c *Client) shutdownStats(gaveUp bool) ShutdownStats {
+	s := &c.shutdown
+	stats := ShutdownStats{
+		Flushed:   int(s.flushed.Load()),
+		Failed:    int(s.failed.Load()),
+		Abandoned: int(s.abandoned.Load()),
+		Discarded: int(s.discarded.Load()),
+		Batches:   int(s.batches.Load()),
+	}
+	if gaveUp {
+		stats.Abandoned += c.QueueLen() + c.inFlight.entryCount()
+	}
+	return stats
+}
+
+// drainTimeUp reports whether the shutdown drain should stop pushing: the
+// Close context ended, or its deadline is nearer than the last push took.
+func (c *Client) drainTimeUp(pushCtx context.Context, lastFlush time.Duration) bool {
+	if pushCtx.Err() != nil {
+		return true
+	}
+	d := c.closeDeadline.Load()
+	return d != 0 && time.Until(time.Unix(0, d)) < lastFlush
+}
+
+// discardQueued empties the queues without pushing and returns the number
+// of entries removed.
+func (c *Client) discardQueued() int {
+	n := 0
+	for {
+		if e, ok := c.nextClassed(); ok {
+			c.dequeued(e)
+			n++
+			continue
+		}
+		select {
+		case e := <-c.queue:
+			c.dequeued(e)
+			n++
+		case h := <-c.bulk:
+			c.bulkTaken(h)
+			n += len(h.entries)
+		default:
+			if c.sending.Load() > 0 {
+				runtime.Gosched()
+				continue
+			}
+			return n
+		}
+	}
+}
+
+// discardOnShutdown accounts for n entries the drain gave up on.
+func (c *Client) discardOnShutdown(n int) {
+	if n == 0 {
+		return
+	}
+	c.workerDrop(DropShutdown, n)
+	c.shutdown.discarded.Add(int64(n))
+	c.shutdown.abandoned.Add(int64(n))
+}
+
+// beginSend registers a Send in progress and reports whether the client
+// still accepts entries; it must be paired with endSend. Entries are
+// refused once Close was called or the worker stopped. A Send that got in
+// before Close is drained like any queued entry: the drain only ends once
+// no Send is in progress, so an accepted entry is never left behind in the
+// queue.
+func (c *Client) beginSend() bool {
+	c.sending.Add(1)
+	return !c.shutdown.closing.Load() && !c.stopped.Load()
+}
+
+func (c *Client) endSend() {
+	c.sending.Add(-1)
+}
+
+// rejectStopped accounts for n entries sent once the client stopped
+// accepting them, and returns the error for their Send.
+func (c *Client) rejectStopped(n int) error {
+	if c.workerDown.Load() && !c.shutdown.closing.Load() {
+		c.drop(DropWorkerDown, n)
+		return ErrWorkerDown
+	}
+	c.drop(DropClosed, n)
+	return ErrClosed
+}
@@ -0,0 +1,367 @@
+package lokigo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCloseWithStatsPartialFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+			} `json:"streams"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Streams[0].Stream["app"] == "bad" {
+			http.Error(w, "rejected", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Minute, MaxStreamsPerBatch: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		app := "good"
+		if i%2 == 1 {
+			app = "bad"
+		}
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprint(i), Labels: map[string]string{"app": app}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := c.CloseWithStats(context.Background())
+	var statusErr *HTTPStatusPushError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected the rejected batch error, got %v", err)
+	}
+	if stats.Flushed != 3 || stats.Failed != 2 || stats.Abandoned != 0 || stats.Batches != 1 || stats.Duration <= 0 {
+		t.Fatalf("unexpected shutdown stats: %+v", stats)
+	}
+}
+
+func TestCloseWithStatsCountsAbandoned(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		arrived <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxEntries: 2, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-arrived
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stats, err := c.CloseWithStats(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if stats.Abandoned != 3 || stats.Flushed != 0 || stats.Batches != 0 {
+		t.Fatalf("expected all 3 entries abandoned, got %+v", stats)
+	}
+}
+
+func TestCloseDiscardsBacklogBeforeDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		time.Sleep(20 * time.Millisecond)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	const backlog = 50000
+	c, err := NewClient(Config{
+		Endpoint:        srv.URL,
+		QueueSize:       backlog,
+		BatchMaxEntries: 100,
+		BatchMaxWait:    time.Minute,
+		Retry:           RetryConfig{MaxAttempts: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < backlog; i++ {
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const deadline = 400 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	start := time.Now()
+	stats, _ := c.CloseWithStats(ctx)
+	if elapsed := time.Since(start); elapsed > deadline+100*time.Millisecond {
+		t.Fatalf("Close took %v, want it within the %v deadline", elapsed, deadline)
+	}
+	if stats.Discarded < backlog/2 || stats.Abandoned < stats.Discarded {
+		t.Fatalf("stats = %+v, want most of the backlog discarded and counted as abandoned", stats)
+	}
+	// The worker may still be discarding when Close gives up at the
+	// deadline.
+	wait := time.Now().Add(time.Second)
+	for c.QueueLen() != 0 || c.Metrics().DroppedByReason[DropShutdown] < uint64(stats.Discarded) {
+		if time.Now().After(wait) {
+			t.Fatalf("backlog of %d entries not discarded", c.QueueLen())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownMaxDrainEntries(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxEntries: 1, BatchMaxWait: time.Minute, ShutdownMaxDrainEntries: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const sent = 40
+	for i := 0; i < sent; i++ {
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for c.Metrics().InFlight != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("first push not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	type result struct {
+		stats ShutdownStats
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stats, err := c.CloseWithStats(context.Background())
+		done <- result{stats, err}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	unblock()
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	// The worker may take a few entries before it notices Close; the drain
+	// itself pushes 4.
+	if r.stats.Flushed < 5 || r.stats.Discarded == 0 || r.stats.Flushed+r.stats.Discarded != sent || r.stats.Abandoned != r.stats.Discarded {
+		t.Fatalf("stats = %+v, want at least 5 flushed and the rest of %d discarded", r.stats, sent)
+	}
+	if got := c.Metrics().DroppedByReason[DropShutdown]; got != uint64(r.stats.Discarded) {
+		t.Fatalf("DroppedByReason[shutdown] = %d, want %d", got, r.stats.Discarded)
+	}
+}
+
+func TestSendAfterCloseReturnsErrClosed(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		var requests atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, DisableBatching: disable, BackpressureMode: BackpressureBlock, QueueSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Send(context.Background(), Entry{Line: "late"}); !errors.Is(err, ErrClosed) {
+			t.Fatalf("disableBatching=%v: Send after Close = %v, want ErrClosed", disable, err)
+		}
+		if err := c.SendBatchOwned(context.Background(), []Entry{{Line: "a"}, {Line: "b"}}); !errors.Is(err, ErrClosed) {
+			t.Fatalf("disableBatching=%v: SendBatchOwned after Close = %v, want ErrClosed", disable, err)
+		}
+		if got := c.Metrics().DroppedByReason[DropClosed]; got != 3 {
+			t.Fatalf("disableBatching=%v: DroppedByReason[closed] = %d, want 3", disable, got)
+		}
+		if n := requests.Load(); n != 0 {
+			t.Fatalf("disableBatching=%v: %d pushes after Close, want none", disable, n)
+		}
+		srv.Close()
+	}
+}
+
+func TestSendConcurrentWithCloseIsDrainedOrRefused(t *testing.T) {
+	var received atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Streams []jsonStreamValues `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		for _, s := range body.Streams {
+			received.Add(int64(len(s.Values)))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, QueueSize: 4, BatchMaxEntries: 8, BackpressureMode: BackpressureBlock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for p := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				err := c.Send(context.Background(), Entry{Line: fmt.Sprintf("p%d-%d", p, i)})
+				switch {
+				case err == nil:
+					accepted.Add(1)
+				case errors.Is(err, ErrClosed):
+					return
+				default:
+					t.Errorf("Send: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if got, want := received.Load(), accepted.Load(); got != want {
+		t.Fatalf("server received %d entries, want the %d accepted by Send", got, want)
+	}
+}
+
+func TestConcurrentCloseShutsDownOnce(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		http.Error(w, "bad request", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a", "b", "c"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type result struct {
+		stats ShutdownStats
+		err   error
+	}
+	const closers = 8
+	results := make(chan result, closers)
+	for range closers {
+		go func() {
+			stats, err := c.CloseWithStats(context.Background())
+			results <- result{stats, err}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	first := <-results
+	var statusErr *HTTPStatusPushError
+	if !errors.As(first.err, &statusErr) || first.stats.Failed != 3 {
+		t.Fatalf("CloseWithStats = %+v, %v, want 3 failed entries and the push error", first.stats, first.err)
+	}
+	for range closers - 1 {
+		if r := <-results; r.err != first.err || r.stats != first.stats {
+			t.Fatalf("CloseWithStats = %+v, %v, want %+v, %v like the first call", r.stats, r.err, first.stats, first.err)
+		}
+	}
+	if err := c.Close(context.Background()); err != first.err {
+		t.Fatalf("Close after shutdown = %v, want %v", err, first.err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("%d pushes, want the drain to run once", n)
+	}
+}
+
+func TestRepeatedCloseTimeoutsDoNotLeakGoroutines(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	tr := &http.Transport{}
+	defer tr.CloseIdleConnections()
+	before := runtime.NumGoroutine()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, DisableBatching: true, HTTPClient: &http.Client{Transport: tr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan error, 1)
+	go func() { sent <- c.Send(context.Background(), Entry{Line: "x"}) }()
+	for c.Metrics().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for range 50 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		err := c.Close(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Close with a push in flight = %v, want DeadlineExceeded", err)
+		}
+	}
+	close(release)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatalf("Close after the push finished = %v, want nil", err)
+	}
+
+	tr.CloseIdleConnections()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after Close, want at most %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
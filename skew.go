@@ -0,0 +1,53 @@
+package lokigo
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// skewSmoothing is the weight of a new sample in the clock skew estimate.
+const skewSmoothing = 0.2
+
+// clockSkew estimates how far the server clock is ahead of the local one
+// from the Date header of push responses. The zero value is ready to use.
+type clockSkew struct {
+	mu      sync.Mutex
+	sampled bool
+	// est is the estimate in nanoseconds, read without mu.
+	est atomic.Int64
+}
+
+// observe folds the Date header of resp, received at now, into the
+// estimate. Date has second precision, so its midpoint is used.
+func (s *clockSkew) observe(resp *http.Response, now time.Time) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	sample := date.Add(500 * time.Millisecond).Sub(now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sampled {
+		s.sampled = true
+		s.est.Store(int64(sample))
+		return
+	}
+	prev := s.est.Load()
+	s.est.Store(prev + int64(skewSmoothing*float64(int64(sample)-prev)))
+}
+
+func (s *clockSkew) get() time.Duration {
+	return time.Duration(s.est.Load())
+}
+
+// now returns the timestamp given to entries without one: the local time,
+// moved by the clock skew estimate under CorrectClockSkew.
+func (c *Client) now() time.Time {
+	now := time.Now().UTC()
+	if c.cfg.CorrectClockSkew {
+		now = now.Add(c.skew.get())
+	}
+	return now
+}
@@ -0,0 +1,54 @@
+package lokigo
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestClockSkewFromDateHeader(t *testing.T) {
+	const ahead = time.Hour
+	srv, rec := captureJSONPushes(t)
+	rec.onPush(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Date", time.Now().Add(ahead).UTC().Format(http.TimeFormat))
+	})
+
+	for _, correct := range []bool{false, true} {
+		sent := len(rec.entries())
+		c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, DisableBatching: true, CorrectClockSkew: correct})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Send(context.Background(), Entry{Line: "first"}); err != nil {
+			t.Fatal(err)
+		}
+		if skew := c.Metrics().ClockSkew; skew < ahead-2*time.Second || skew > ahead+2*time.Second {
+			t.Fatalf("ClockSkew = %v, want about %v", skew, ahead)
+		}
+		fixed := time.Unix(1700000000, 0)
+		before := time.Now()
+		for _, e := range []Entry{{Line: "zero"}, {Line: "fixed", Timestamp: fixed}} {
+			if err := c.Send(context.Background(), e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = c.Close(context.Background())
+
+		pushed := rec.entries()[sent:]
+		zeroNs, _ := strconv.ParseInt(pushed[1].ts, 10, 64)
+		got, _ := strconv.ParseInt(pushed[2].ts, 10, 64)
+		zero := time.Unix(0, zeroNs)
+		if got != fixed.UnixNano() {
+			t.Fatalf("correct=%t: caller timestamp changed to %d", correct, got)
+		}
+		offset := zero.Sub(before)
+		if correct && (offset < ahead-2*time.Second || offset > ahead+2*time.Second) {
+			t.Fatalf("corrected default timestamp is %v from local time, want about %v", offset, ahead)
+		}
+		if !correct && (offset < 0 || offset > 2*time.Second) {
+			t.Fatalf("default timestamp is %v from local time without correction", offset)
+		}
+	}
+}
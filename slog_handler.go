@@ -2,19 +2,46 @@ package lokigo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 type SlogHandlerOption func(*slogHandlerConfig)
 
+const (
+	// defaultSlogSendTimeout bounds how long Handle waits for queue space.
+	defaultSlogSendTimeout = 5 * time.Second
+	// defaultSlogLabelValueMax is the default WithSlogLabelValueLimit.
+	defaultSlogLabelValueMax = 128
+)
+
 type slogHandlerConfig struct {
 	level      slog.Leveler
 	levelLabel string
 	labelAllow map[string]struct{}
 	labelDeny  map[string]struct{}
+	// allowGlobs are WithLabelAllowList patterns. NewSlogHandler compiles
+	// the allow and deny options into matcher.
+	allowGlobs  []string
+	allowAll    bool
+	matcher     *labelMatcher
+	sendTimeout time.Duration
+	fallback    slog.Handler
+	// labelValueMax and rawLabelValues control sanitizeLabelValue.
+	labelValueMax  int
+	rawLabelValues bool
+	staticMetadata map[string]string
+	// pkgLabel is set by WithSlogPackageLabel.
+	pkgLabel *slogPackageLabel
+	// requestBuffer is set by WithRequestBuffering.
+	requestBuffer func(context.Context) *RequestBuffer
 }
 
 // WithSlogLevel sets the minimum level this handler accepts.
@@ -28,10 +55,80 @@ func WithSlogLevelLabel(label string) SlogHandlerOption {
 	return func(c *slogHandlerConfig) { c.levelLabel = label }
 }
 
+// WithSlogSendTimeout bounds how long Handle waits for the client to accept
+// a record, whatever context the caller passed. Under BackpressureBlock a
+// full queue otherwise blocks the logging goroutine; when the timeout
+// expires the record is dropped (or passed to the WithSlogFallback handler)
+// and Handle returns an error. Defaults to 5s; zero or less waits as long
+// as the caller's context allows.
+func WithSlogSendTimeout(d time.Duration) SlogHandlerOption {
+	return func(c *slogHandlerConfig) { c.sendTimeout = d }
+}
+
+// WithSlogFallback sets a handler that receives records dropped because the
+// send timeout expired, for example a stderr slog.TextHandler. It follows
+// WithAttrs and WithGroup like the handler itself.
+func WithSlogFallback(h slog.Handler) SlogHandlerOption {
+	return func(c *slogHandlerConfig) { c.fallback = h }
+}
+
+// WithSlogLabelValueLimit sets the byte limit for label values promoted
+// from attrs, record time and message (default 128). Longer values are cut
+// at a UTF-8 boundary and end in "…". Zero or less disables truncation; the
+// other sanitizing still applies.
+func WithSlogLabelValueLimit(maxBytes int) SlogHandlerOption {
+	return func(c *slogHandlerConfig) { c.labelValueMax = maxBytes }
+}
+
+// WithSlogRawLabelValues passes promoted label values through unchanged,
+// turning off the default sanitizing: control characters removed, newline
+// and tab runs collapsed to one space, and the WithSlogLabelValueLimit cut.
+// It is independent of any label limits of the client.
+func WithSlogRawLabelValues() SlogHandlerOption {
+	return func(c *slogHandlerConfig) { c.rawLabelValues = true }
+}
+
+// WithStaticMetadata stamps fixed structured metadata, such as a handler
+// version or deployment hash, on every record as Entry.Metadata. Unlike
+// labels it creates no streams. The map is copied.
+func WithStaticMetadata(metadata map[string]string) SlogHandlerOption {
+	return func(c *slogHandlerConfig) {
+		if len(metadata) == 0 {
+			return
+		}
+		c.staticMetadata = mergeLabels(c.staticMetadata, metadata)
+	}
+}
+
+// WithSlogPackageLabel promotes the package of each record's call site,
+// read from Record.PC, to the label labelName, trimmed to its last depth
+// path elements: with depth 2, a record logged from
+// example.com/shop/internal/payments gets "internal/payments". Zero or less
+// keeps the whole import path. Records without a PC get no such label, and
+// the deny list still applies. Packages are resolved once per PC.
+func WithSlogPackageLabel(labelName string, depth int) SlogHandlerOption {
+	return func(c *slogHandlerConfig) {
+		c.pkgLabel = nil
+		if labelName != "" {
+			c.pkgLabel = &slogPackageLabel{name: labelName, depth: depth}
+		}
+	}
+}
+
+// WithRequestBuffering routes each record into the RequestBuffer fromCtx
+// finds on the Handle context instead of sending it, for example with
+// RequestBufferFromContext. Records whose context has no buffer are sent as
+// usual. Buffered records ignore FlushNow and the send timeout.
+func WithRequestBuffering(fromCtx func(ctx context.Context) *RequestBuffer) SlogHandlerOption {
+	return func(c *slogHandlerConfig) { c.requestBuffer = fromCtx }
+}
+
 // WithLabelAllowList configures which slog attrs are promoted to Loki labels.
 //
 // Keys must use flattened dot notation for grouped attrs (for example: "http.status").
-// By default, no attrs are promoted to labels.
+// A key containing "*" is a glob matched against the whole flattened key:
+// "k8s.*" promotes everything under the k8s group and "*_id" every key
+// ending in "_id". By default, no attrs are promoted to labels.
 func WithLabelAllowList(keys ...string) SlogHandlerOption {
 	return func(c *slogHandlerConfig) {
 		if c.labelAllow == nil {
@@ -39,14 +136,25 @@ func WithLabelAllowList(keys ...string) SlogHandlerOption {
 		}
 		for _, key := range keys {
 			key = strings.TrimSpace(key)
-			if key == "" {
-				continue
+			switch {
+			case key == "":
+			case strings.Contains(key, "*"):
+				c.allowGlobs = append(c.allowGlobs, key)
+			default:
+				c.labelAllow[key] = struct{}{}
 			}
-			c.labelAllow[key] = struct{}{}
 		}
 	}
 }
 
+// WithLabelAllowAll promotes every attr to a label, for controlled
+// environments where attr keys and values are known to be bounded. The deny
+// list still takes precedence. The record time and message are only
+// promoted when allow-listed by exact key.
+func WithLabelAllowAll() SlogHandlerOption {
+	return func(c *slogHandlerConfig) { c.allowAll = true }
+}
+
 // WithLabelDenyList configures slog attrs that should never be promoted to Loki labels.
 //
 // Deny list has precedence over allow list.
@@ -72,13 +180,22 @@ func WithLabelDenyList(keys ...string) SlogHandlerOption {
 //   - message + attrs -> Entry.Line
 //   - allow-listed attrs/groups (+ optional level) -> Entry.Labels
 func NewSlogHandler(client *Client, opts ...SlogHandlerOption) slog.Handler {
-	cfg := slogHandlerConfig{level: slog.LevelInfo, levelLabel: "level"}
+	cfg := slogHandlerConfig{level: slog.LevelInfo, levelLabel: "level", sendTimeout: defaultSlogSendTimeout, labelValueMax: defaultSlogLabelValueMax}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
+	cfg.matcher = newLabelMatcher(cfg.labelAllow, cfg.labelDeny, cfg.allowGlobs, cfg.allowAll)
 	return &slogHandler{client: client, cfg: cfg}
 }
 
+// NewDefaultSlogHandler is NewSlogHandler for the default client, looked up
+// for every record, so it may be created before SetDefault is called.
+// Records handled while no default client is set fail with
+// ErrNoDefaultClient.
+func NewDefaultSlogHandler(opts ...SlogHandlerOption) slog.Handler {
+	return NewSlogHandler(nil, opts...)
+}
+
 type slogHandler struct {
 	client *Client
 	cfg    slogHandlerConfig
@@ -100,13 +217,20 @@ func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
 	if h.cfg.levelLabel != "" {
 		labels[h.cfg.levelLabel] = r.Level.String()
 	}
+	if p := h.cfg.pkgLabel; p != nil && r.PC != 0 {
+		if !h.cfg.matcher.denies(p.name) {
+			if pkg := p.lookup(r.PC); pkg != "" {
+				labels[p.name] = h.labelValue(pkg)
+			}
+		}
+	}
 	// Promote record time to labels when allow-listed and non-zero.
-	if !r.Time.IsZero() && h.shouldPromoteToLabel(slog.TimeKey) {
-		labels[slog.TimeKey] = r.Time.Format(time.RFC3339Nano)
+	if !r.Time.IsZero() && h.promotesBuiltin(slog.TimeKey) {
+		labels[slog.TimeKey] = h.labelValue(r.Time.Format(time.RFC3339Nano))
 	}
 	// Promote message to labels when allow-listed and non-empty.
-	if r.Message != "" && h.shouldPromoteToLabel(slog.MessageKey) {
-		labels[slog.MessageKey] = r.Message
+	if r.Message != "" && h.promotesBuiltin(slog.MessageKey) {
+		labels[slog.MessageKey] = h.labelValue(r.Message)
 	}
 	if r.Message != "" {
 		parts = append(parts, r.Message)
@@ -115,7 +239,12 @@ func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
 	for _, a := range h.attrs {
 		h.collectAttr(labels, &parts, nil, a)
 	}
+	flush := false
 	r.Attrs(func(a slog.Attr) bool {
+		if isFlushNow(a) {
+			flush = true
+			return true
+		}
 		h.collectAttr(labels, &parts, h.group, a)
 		return true
 	})
@@ -124,27 +253,113 @@ func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
 	if line == "" {
 		line = "log entry"
 	}
-	ts := r.Time
-	if ts.IsZero() {
-		ts = time.Now().UTC()
+	// A zero r.Time is defaulted by Send, like other entries.
+	e := NewEntry(line, WithTime(r.Time), WithLabels(labels))
+	// The static metadata map is shared read-only by every entry.
+	e.Metadata = h.cfg.staticMetadata
+	if h.cfg.requestBuffer != nil {
+		if b := h.cfg.requestBuffer(ctx); b != nil {
+			return b.logOwned(e)
+		}
+	}
+	client, err := h.resolveClient()
+	if err != nil {
+		return err
+	}
+	err = h.send(ctx, client, r, e)
+	if flush && !errors.Is(err, ErrClosed) {
+		err = errors.Join(err, client.Flush(ctx))
 	}
-	return h.client.Send(ctx, Entry{Timestamp: ts, Line: line, Labels: labels})
+	return err
+}
+
+// FlushNow returns an attr that makes the lokigo slog handler push the
+// record, and everything queued before it, before Handle returns, for
+// code that holds only a *slog.Logger:
+//
+//	logger.Error("request failed", "status", 500, lokigo.FlushNow())
+//
+// The push runs as Client.Flush under the record's context, so it is bounded
+// by ShutdownTimeout when that context has no deadline, and Handle returns
+// ErrClosed once the client was closed. The attr never appears in the line
+// or labels. It only takes effect among the record's own attrs, not through
+// Logger.With, and other handlers see it as an ordinary attr with an empty
+// value.
+func FlushNow() slog.Attr {
+	return slog.Any(flushNowKey, flushNowValue{})
+}
+
+const flushNowKey = "lokigo.flush"
+
+type flushNowValue struct{}
+
+// LogValue keeps the sentinel out of the output of other handlers.
+func (flushNowValue) LogValue() slog.Value { return slog.GroupValue() }
+
+func isFlushNow(a slog.Attr) bool {
+	if a.Key != flushNowKey || a.Value.Kind() != slog.KindLogValuer {
+		return false
+	}
+	_, ok := a.Value.Any().(flushNowValue)
+	return ok
+}
+
+// resolveClient returns the handler's client, or the default client for a
+// handler created without one.
+func (h *slogHandler) resolveClient() (*Client, error) {
+	if h.client != nil {
+		return h.client, nil
+	}
+	if c := Default(); c != nil {
+		return c, nil
+	}
+	return nil, ErrNoDefaultClient
+}
+
+// send hands e to the client within the send timeout, passing r to the
+// fallback handler if the timeout expires first.
+func (h *slogHandler) send(ctx context.Context, client *Client, r slog.Record, e Entry) error {
+	if h.cfg.sendTimeout <= 0 {
+		return client.sendOwned(ctx, e)
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, h.cfg.sendTimeout)
+	defer cancel()
+	err := client.sendOwned(sendCtx, e)
+	if err == nil || ctx.Err() != nil || sendCtx.Err() == nil {
+		return err
+	}
+	err = fmt.Errorf("lokigo: slog record dropped: client queue still full after %s: %w", h.cfg.sendTimeout, err)
+	if h.cfg.fallback != nil {
+		if ferr := h.cfg.fallback.Handle(ctx, r); ferr != nil {
+			return errors.Join(err, ferr)
+		}
+	}
+	return err
 }
 
 func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
 	next := *h
 	grouped := prefixAttrsWithGroup(attrs, h.group)
 	next.attrs = append(append([]slog.Attr{}, h.attrs...), grouped...)
+	if h.cfg.fallback != nil {
+		next.cfg.fallback = h.cfg.fallback.WithAttrs(attrs)
+	}
 	return &next
 }
 
 func (h *slogHandler) WithGroup(name string) slog.Handler {
 	next := *h
 	next.group = append(append([]string{}, h.group...), name)
+	if h.cfg.fallback != nil {
+		next.cfg.fallback = h.cfg.fallback.WithGroup(name)
+	}
 	return &next
 }
 
 func (h *slogHandler) collectAttr(labels map[string]string, parts *[]string, group []string, attr slog.Attr) {
+	if isFlushNow(attr) {
+		return
+	}
 	attr.Value = attr.Value.Resolve()
 	if attr.Equal(slog.Attr{}) {
 		return
@@ -168,20 +383,104 @@ func (h *slogHandler) collectAttr(labels map[string]string, parts *[]string, gro
 	}
 	val := valueToString(attr.Value)
 	if h.shouldPromoteToLabel(key) {
-		labels[key] = val
+		labels[key] = h.labelValue(val)
 	}
 	*parts = append(*parts, fmt.Sprintf("%s=%s", key, val))
 }
 
 func (h *slogHandler) shouldPromoteToLabel(key string) bool {
-	if _, denied := h.cfg.labelDeny[key]; denied {
-		return false
+	return h.cfg.matcher.promotes(key)
+}
+
+// slogPackageLabel resolves the WithSlogPackageLabel value of a PC. It is
+// shared by the handlers derived with WithAttrs and WithGroup.
+type slogPackageLabel struct {
+	name  string
+	depth int
+	// byPC maps a PC to its trimmed package path.
+	byPC sync.Map
+}
+
+func (p *slogPackageLabel) lookup(pc uintptr) string {
+	if v, ok := p.byPC.Load(pc); ok {
+		return v.(string)
 	}
-	if len(h.cfg.labelAllow) == 0 {
-		return false
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	pkg := trimPackagePath(funcPackage(frame.Function), p.depth)
+	p.byPC.Store(pc, pkg)
+	return pkg
+}
+
+// funcPackage returns the import path of the package of the function named
+// fn, as reported by runtime.Frame.Function, such as
+// "example.com/app/store.(*DB).Get".
+func funcPackage(fn string) string {
+	slash := strings.LastIndexByte(fn, '/')
+	if dot := strings.IndexByte(fn[slash+1:], '.'); dot >= 0 {
+		fn = fn[:slash+1+dot]
+	}
+	// The linker escapes dots in the last path element, as in yaml%2ev3.
+	return strings.ReplaceAll(fn, "%2e", ".")
+}
+
+// trimPackagePath keeps the last depth elements of path; all of them when
+// depth <= 0.
+func trimPackagePath(path string, depth int) string {
+	if depth <= 0 {
+		return path
+	}
+	i := len(path)
+	for range depth {
+		i = strings.LastIndexByte(path[:i], '/')
+		if i < 0 {
+			return path
+		}
+	}
+	return path[i+1:]
+}
+
+// promotesBuiltin reports whether the record time or message key is
+// promoted; only an exact allow-list entry does that.
+func (h *slogHandler) promotesBuiltin(key string) bool {
+	return h.cfg.matcher.promotesBuiltin(key)
+}
+
+func (h *slogHandler) labelValue(v string) string {
+	if h.cfg.rawLabelValues {
+		return v
+	}
+	return sanitizeLabelValue(v, h.cfg.labelValueMax)
+}
+
+// sanitizeLabelValue removes control characters from v, collapses runs of
+// newlines, carriage returns and tabs into one space, and cuts the result
+// to maxBytes (if > 0) at a rune boundary, ending in truncatedMarker.
+func sanitizeLabelValue(v string, maxBytes int) string {
+	clean := utf8.ValidString(v)
+	for i := 0; clean && i < len(v); i++ {
+		clean = v[i] >= 0x20 && v[i] != 0x7f
+	}
+	if clean && (maxBytes <= 0 || len(v) <= maxBytes) {
+		return v
+	}
+	var b strings.Builder
+	b.Grow(len(v))
+	inSpace := false
+	for _, r := range v {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			if !inSpace && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			inSpace = true
+			continue
+		case unicode.IsControl(r):
+			continue
+		}
+		inSpace = false
+		b.WriteRune(r)
 	}
-	_, allowed := h.cfg.labelAllow[key]
-	return allowed
+	return truncateLabelValue(strings.TrimRight(b.String(), " "), maxBytes)
 }
 
 func prefixAttrsWithGroup(attrs []slog.Attr, group []string) []slog.Attr {
@@ -3,11 +3,17 @@ package lokigo
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSlogHandlerDefaultDoesNotPromoteAttrsToLabels(t *testing.T) {
@@ -131,3 +137,391 @@ func TestSlogHandlerLevelFilter(t *testing.T) {
 		t.Fatal("expected error to be enabled")
 	}
 }
+
+func TestSlogHandlerSendTimeoutOnSaturatedClient(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxEntries: 1, QueueSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One entry held in a push and one filling the queue saturate the client.
+	if err := c.Send(context.Background(), Entry{Line: "in flight"}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := c.Send(context.Background(), Entry{Line: "queued"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var fallback strings.Builder
+	h := NewSlogHandler(c,
+		WithSlogSendTimeout(50*time.Millisecond),
+		WithSlogFallback(slog.NewTextHandler(&fallback, &slog.HandlerOptions{ReplaceAttr: dropTime})),
+	).WithAttrs([]slog.Attr{slog.String("svc", "api")})
+
+	start := time.Now()
+	err = h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelError, "stuck", 0))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Handle blocked for %s", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "50ms") {
+		t.Fatalf("Handle err = %v, want a send-timeout error", err)
+	}
+	if got := fallback.String(); got != "level=ERROR msg=stuck svc=api\n" {
+		t.Fatalf("fallback output = %q", got)
+	}
+}
+
+func dropTime(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
+}
+
+// slogLabels logs one record through a handler built with opts and returns
+// the labels it was pushed with.
+func slogLabels(t *testing.T, log func(*slog.Logger), opts ...SlogHandlerOption) map[string]string {
+	t.Helper()
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log(slog.New(NewSlogHandler(c, append([]SlogHandlerOption{WithSlogLevelLabel("")}, opts...)...)))
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := streams()
+	if len(got) != 1 {
+		t.Fatalf("got %d streams, want 1", len(got))
+	}
+	return got[0].labels
+}
+
+func TestSlogHandlerAllowListGlobs(t *testing.T) {
+	labels := slogLabels(t, func(l *slog.Logger) {
+		l.With("service", "api").WithGroup("k8s").Info("hi", "pod", "p-1", slog.Group("node", "name", "n-1"), "request_id", "r-1")
+	}, WithLabelAllowList("k8s.*", "*_id", "service"), WithLabelDenyList("k8s.request_id"))
+	want := map[string]string{"service": "api", "k8s.pod": "p-1", "k8s.node.name": "n-1"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels = %v, want %v", labels, want)
+	}
+
+	// A glob matches the whole flattened key, not a prefix of it.
+	labels = slogLabels(t, func(l *slog.Logger) {
+		l.Info("hi", "user_id", "u-1", "user_id_hash", "h", "k8s", "flat")
+	}, WithLabelAllowList("*_id", "k8s.*"))
+	if want := map[string]string{"user_id": "u-1"}; !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels = %v, want %v", labels, want)
+	}
+}
+
+func TestLabelMatcherMemoKeepsDenyPrecedence(t *testing.T) {
+	h := NewSlogHandler(nil, WithLabelAllowList("k8s.*", "*_id"), WithLabelDenyList("k8s.secret", "trace_id")).(*slogHandler)
+	derived := h.WithGroup("k8s").WithAttrs([]slog.Attr{slog.String("pod", "p")}).(*slogHandler)
+	if derived.cfg.matcher != h.cfg.matcher {
+		t.Fatal("derived handlers should share the matcher")
+	}
+	m := h.cfg.matcher
+	cases := map[string]bool{"k8s.pod": true, "user_id": true, "k8s.secret": false, "trace_id": false, "other": false}
+	// The second round is served from the memo.
+	for range 2 {
+		for key, want := range cases {
+			if got := m.promotes(key); got != want {
+				t.Fatalf("promotes(%q) = %v, want %v", key, got, want)
+			}
+		}
+	}
+	if _, ok := m.memo.Load().m.Load("k8s.secret"); ok {
+		t.Fatal("a denied key should be decided before the memo")
+	}
+}
+
+func TestLabelMatcherMemoIsBounded(t *testing.T) {
+	m := newLabelMatcher(nil, nil, []string{"*_id"}, false)
+	first := m.memo.Load()
+	for i := range maxLabelVerdicts + 1 {
+		key := fmt.Sprintf("key_%d", i)
+		if m.promotes(key) {
+			t.Fatalf("promotes(%q) = true", key)
+		}
+	}
+	if m.memo.Load() == first {
+		t.Fatal("a full memo should start over")
+	}
+	if !m.promotes("user_id") || !m.promotes("user_id") {
+		t.Fatal("verdicts should still match after the memo started over")
+	}
+}
+
+func TestSlogHandlerAllowAll(t *testing.T) {
+	labels := slogLabels(t, func(l *slog.Logger) {
+		l.WithGroup("http").Info("done", "status", 200, "trace_id", "t-1")
+	}, WithLabelAllowAll(), WithLabelDenyList("http.trace_id"))
+	want := map[string]string{"http.status": "200"}
+	if !reflect.DeepEqual(labels, want) {
+		t.Fatalf("labels = %v, want %v (deny wins; time and msg need an exact allow)", labels, want)
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	cases := []struct {
+		in   string
+		max  int
+		want string
+	}{
+		{"plain", 128, "plain"},
+		{"line one\nline two\r\n\tindented", 128, "line one line two indented"},
+		{"bell\a and nul\x00 gone\n", 128, "bell and nul gone"},
+		{"bad utf8 \xff", 128, "bad utf8 �"},
+		{strings.Repeat("a", 20), 10, "aaaaaaa…"},
+		// "é" is two bytes; the cut must not split it.
+		{"ééééé", 8, "éé…"},
+		{"日本語", 2, ""},
+		{strings.Repeat("x", 300), 0, strings.Repeat("x", 300)},
+	}
+	for _, tc := range cases {
+		got := sanitizeLabelValue(tc.in, tc.max)
+		if got != tc.want {
+			t.Errorf("sanitizeLabelValue(%q, %d) = %q, want %q", tc.in, tc.max, got, tc.want)
+		}
+		if tc.max > 0 && len(got) > tc.max {
+			t.Errorf("sanitizeLabelValue(%q, %d) is %d bytes", tc.in, tc.max, len(got))
+		}
+	}
+}
+
+func TestSlogHandlerSanitizesPromotedLabels(t *testing.T) {
+	errMsg := "dial tcp: connection refused\n\tat main.go:12\n" + strings.Repeat("x", 200)
+	log := func(l *slog.Logger) { l.Error("failed", "err", errMsg) }
+
+	labels := slogLabels(t, log, WithLabelAllowList("err"))
+	if got := labels["err"]; len(got) > 128 || !strings.HasPrefix(got, "dial tcp: connection refused at main.go:12 xxx") || !strings.HasSuffix(got, "…") {
+		t.Fatalf("sanitized label = %q", got)
+	}
+	labels = slogLabels(t, log, WithLabelAllowList("err"), WithSlogLabelValueLimit(16))
+	if got := labels["err"]; got != "dial tcp: con…" {
+		t.Fatalf("label with 16-byte limit = %q", got)
+	}
+	labels = slogLabels(t, log, WithLabelAllowList("err"), WithSlogRawLabelValues())
+	if got := labels["err"]; got != errMsg {
+		t.Fatalf("raw label = %q, want the value unchanged", got)
+	}
+}
+
+func TestSlogHandlerStaticMetadata(t *testing.T) {
+	var mu sync.Mutex
+	var metadata []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Streams []struct {
+				Values [][]json.RawMessage `json:"values"`
+			} `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, s := range payload.Streams {
+			for _, v := range s.Values {
+				var md map[string]string
+				if len(v) == 3 {
+					if err := json.Unmarshal(v[2], &md); err != nil {
+						t.Errorf("metadata: %v", err)
+					}
+				}
+				metadata = append(metadata, md)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	static := map[string]string{"handler_version": "v2", "deploy": "abc123"}
+	logger := slog.New(NewSlogHandler(c, WithStaticMetadata(static)))
+	static["deploy"] = "mutated"
+	logger.Info("plain")
+	logger.With("svc", "api").WithGroup("http").Info("cloned", "status", 200)
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"handler_version": "v2", "deploy": "abc123"}
+	if len(metadata) != 2 || !reflect.DeepEqual(metadata[0], want) || !reflect.DeepEqual(metadata[1], want) {
+		t.Fatalf("metadata = %v, want %v on both records", metadata, want)
+	}
+}
+
+// callerPC captures the PC of the encoding/json code calling MarshalJSON,
+// a call site in another package.
+type callerPC struct{ pc *uintptr }
+
+func (c callerPC) MarshalJSON() ([]byte, error) {
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	*c.pc = pcs[0]
+	return []byte("null"), nil
+}
+
+func TestSlogHandlerPackageLabel(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewSlogHandler(c, WithSlogPackageLabel("logger", 2), WithSlogLevelLabel(""))
+	slog.New(h).Info("from the test")
+	if err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "no pc", 0)); err != nil {
+		t.Fatal(err)
+	}
+	denied := NewSlogHandler(c, WithSlogPackageLabel("logger", 2), WithSlogLevelLabel(""), WithLabelDenyList("logger"))
+	slog.New(denied).Info("denied")
+	// Whole import paths, from a call site in encoding/json; some Go
+	// versions implement it in encoding/json/v2.
+	var pc uintptr
+	if _, err := json.Marshal(callerPC{&pc}); err != nil {
+		t.Fatal(err)
+	}
+	full := NewSlogHandler(c, WithSlogPackageLabel("logger", 0), WithSlogLevelLabel(""))
+	for range 2 {
+		if err := full.WithAttrs([]slog.Attr{slog.String("k", "v")}).Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "from json", pc)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]int{}
+	for _, s := range streams() {
+		logger := s.labels["logger"]
+		if strings.HasPrefix(logger, "encoding/json") {
+			logger = "encoding/json"
+		}
+		got[logger] += s.entries
+	}
+	want := map[string]int{"zabihimohsen/lokigo": 1, "encoding/json": 2, "": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("entries by logger label = %v, want %v", got, want)
+	}
+
+	for fn, want := range map[string]string{
+		"main.main": "main",
+		"example.com/shop/internal/payments.(*Charger).Charge": "example.com/shop/internal/payments",
+		"gopkg.in/yaml%2ev3.Marshal":                           "gopkg.in/yaml.v3",
+		"example.com/app.init.func1":                           "example.com/app",
+	} {
+		if got := funcPackage(fn); got != want {
+			t.Errorf("funcPackage(%q) = %q, want %q", fn, got, want)
+		}
+	}
+	for depth, want := range map[int]string{0: "example.com/shop/internal/payments", 1: "payments", 2: "internal/payments", 9: "example.com/shop/internal/payments"} {
+		if got := trimPackagePath("example.com/shop/internal/payments", depth); got != want {
+			t.Errorf("trimPackagePath(depth %d) = %q, want %q", depth, got, want)
+		}
+	}
+}
+
+func TestSlogHandlerFlushNow(t *testing.T) {
+	var mu sync.Mutex
+	var lines []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Streams []jsonStreamValues `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		mu.Lock()
+		for _, s := range payload.Streams {
+			for _, v := range s.Values {
+				lines = append(lines, v[1])
+			}
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	pushed := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), lines...)
+	}
+
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, BatchMaxWait: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	logger := slog.New(NewSlogHandler(c, WithLabelAllowAll()))
+	logger.Info("ordinary", "k", "v")
+	time.Sleep(20 * time.Millisecond)
+	if got := pushed(); len(got) != 0 {
+		t.Fatalf("ordinary record pushed without a flush: %q", got)
+	}
+	logger.With("svc", "api").Error("request failed", "status", 500, FlushNow())
+	got := pushed()
+	if len(got) != 2 || got[0] != "ordinary k=v" || got[1] != "request failed svc=api status=500" {
+		t.Fatalf("pushed %q before Handle returned, want both records without the sentinel", got)
+	}
+
+	// Other handlers print nothing for it.
+	var buf strings.Builder
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("x", FlushNow())
+	if strings.Contains(buf.String(), "lokigo") {
+		t.Fatalf("text handler output %q mentions the sentinel", buf.String())
+	}
+}
+
+func TestSlogHandlerFlushNowBoundedAndClosed(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c, err := NewClient(Config{Endpoint: srv.URL, BatchMaxWait: time.Hour, ShutdownTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewSlogHandler(c)
+	record := slog.NewRecord(time.Now(), slog.LevelError, "request failed", 0)
+	record.AddAttrs(FlushNow())
+
+	// A stuck push gives up at ShutdownTimeout, not at the record's
+	// deadline-free context.
+	start := time.Now()
+	if err := h.Handle(context.Background(), record); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Handle = %v, want the ShutdownTimeout deadline", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("Handle took %s, want it bounded by ShutdownTimeout", d)
+	}
+
+	_ = c.Close(context.Background())
+	if err := h.Handle(context.Background(), record); err != ErrClosed {
+		t.Fatalf("Handle after Close = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,114 @@
+package lokigo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// streamBufferSize is the write buffer between a streamed payload encoder
+// and the request body pipe.
+const streamBufferSize = 32 << 10
+
+// streamsPayload reports whether entries in enc are encoded into the
+// request body as it is sent, under StreamLargePayloads.
+func (c *Client) streamsPayload(enc Encoding, entries []Entry) bool {
+	return c.cfg.StreamLargePayloads && enc == EncodingJSON &&
+		len(c.cfg.PushInterceptors) == 0 && c.shadow == nil &&
+		lineBytes(entries) >= c.cfg.StreamPayloadAboveBytes
+}
+
+// jsonPayloadWriter returns a function writing the EncodingJSON payload of
+// entries, the same bytes buildJSONPayload returns, to w. Streams are
+// grouped once; each call encodes the entries again, one value at a time.
+func (c *Client) jsonPayloadWriter(entries []Entry) func(w io.Writer) error {
+	var streams []map[string]string
+	var members [][]int
+	index := map[string]int{}
+	for i, e := range entries {
+		labels, key := c.jsonStream(e)
+		s, ok := index[key]
+		if !ok {
+			s = len(streams)
+			index[key] = s
+			c.sawStream(key)
+			streams = append(streams, labels)
+			members = append(members, nil)
+		}
+		members[s] = append(members[s], i)
+	}
+	return func(w io.Writer) error {
+		bw := bufio.NewWriterSize(w, streamBufferSize)
+		enc := newValueEncoder(bw)
+		bw.WriteString(`{"streams":[`)
+		for s, labels := range streams {
+			if s > 0 {
+				bw.WriteByte(',')
+			}
+			bw.WriteString(`{"stream":`)
+			if err := enc.encode(labels); err != nil {
+				return err
+			}
+			bw.WriteString(`,"values":[`)
+			for j, i := range members[s] {
+				if j > 0 {
+					bw.WriteByte(',')
+				}
+				e := &entries[i]
+				bw.WriteString(`["`)
+				bw.WriteString(strconv.FormatInt(e.Timestamp.UnixNano(), 10))
+				bw.WriteString(`",`)
+				if err := enc.encode(e.Line); err != nil {
+					return err
+				}
+				if len(e.Metadata) > 0 {
+					bw.WriteByte(',')
+					if err := enc.encode(e.Metadata); err != nil {
+						return err
+					}
+				}
+				bw.WriteByte(']')
+			}
+			bw.WriteString(`]}`)
+		}
+		bw.WriteString(`]}`)
+		return bw.Flush()
+	}
+}
+
+// valueEncoder writes JSON values as json.Marshal does, through a reused
+// buffer rather than a new slice per value.
+type valueEncoder struct {
+	w   *bufio.Writer
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+func newValueEncoder(w *bufio.Writer) *valueEncoder {
+	v := &valueEncoder{w: w}
+	v.enc = json.NewEncoder(&v.buf)
+	return v
+}
+
+func (v *valueEncoder) encode(x any) error {
+	v.buf.Reset()
+	if err := v.enc.Encode(x); err != nil {
+		return err
+	}
+	// Drop the newline Encode ends each value with.
+	_, err := v.w.Write(v.buf.Bytes()[:v.buf.Len()-1])
+	return err
+}
+
+// streamBody returns a request body fed by write from a new goroutine. The
+// goroutine ends when write returns, or fails its next write once the
+// transport closes the body.
+func streamBody(write func(io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(write(pw))
+	}()
+	return pr
+}
@@ -0,0 +1,112 @@
+package lokigo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStreamedJSONPayloadMatchesBuffered(t *testing.T) {
+	c, err := NewClient(Config{Endpoint: "http://127.0.0.1:3100/loki/api/v1/push", Encoding: EncodingJSON, StaticLabels: map[string]string{"env": "test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.cancel()
+	plain := benchmarkEntries(50)
+	plain[3].Line = "quote \" <tag> & tab\t"
+	withMetadata := benchmarkEntries(20)
+	withMetadata[7].Metadata = map[string]string{"trace_id": "abc"}
+	for name, entries := range map[string][]Entry{"plain": plain, "metadata": withMetadata} {
+		want, err := c.buildJSONPayload(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		if err := c.jsonPayloadWriter(entries)(&got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("%s: streamed payload\n%s\nwant\n%s", name, got.Bytes(), want)
+		}
+	}
+}
+
+func TestStreamLargePayloadsRetriesAfterMidStreamFailure(t *testing.T) {
+	line := strings.Repeat("x", 64<<10)
+	var mu sync.Mutex
+	var requests int
+	var chunked bool
+	var values int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			// Take part of the body, then drop the connection.
+			_, _ = io.CopyN(io.Discard, r.Body, 4096)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		var payload struct {
+			Streams []jsonStreamValues `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		mu.Lock()
+		chunked = r.ContentLength == -1 && len(r.TransferEncoding) > 0 && r.TransferEncoding[0] == "chunked"
+		for _, s := range payload.Streams {
+			for _, v := range s.Values {
+				if v[1] != line {
+					t.Errorf("line of %d bytes, want %d", len(v[1]), len(line))
+				}
+				values++
+			}
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		Endpoint:                srv.URL,
+		Encoding:                EncodingJSON,
+		BatchMaxWait:            time.Minute,
+		BatchMaxBytes:           4 << 20,
+		StreamLargePayloads:     true,
+		StreamPayloadAboveBytes: 256 << 10,
+		Retry:                   RetryConfig{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 16 {
+		if err := c.Send(context.Background(), Entry{Line: line, Labels: map[string]string{"app": "big"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 || values != 16 || !chunked {
+		t.Fatalf("%d requests, %d values, chunked %v; want a retry delivering all 16 entries chunked", requests, values, chunked)
+	}
+	if m := c.Metrics(); m.Retries != 1 || m.Pushed != 16 {
+		t.Fatalf("Retries = %d, Pushed = %d; want 1 and 16", m.Retries, m.Pushed)
+	}
+}
@@ -0,0 +1,109 @@
+package lokigo
+
+import (
+	"cmp"
+	"slices"
+)
+
+// maxStreamOverflows bounds Metrics.StreamOverflows.
+const maxStreamOverflows = 10
+
+// StreamCount is a count for one stream, identified by its Loki label set,
+// for example {app="api"}.
+type StreamCount struct {
+	Stream string
+	Count  uint64
+}
+
+// topStreams returns the n largest counts, largest first.
+func topStreams(counts map[string]uint64, n int) []StreamCount {
+	if len(counts) == 0 {
+		return nil
+	}
+	out := make([]StreamCount, 0, len(counts))
+	for k, v := range counts {
+		out = append(out, StreamCount{Stream: k, Count: v})
+	}
+	slices.SortFunc(out, func(a, b StreamCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Stream, b.Stream)
+	})
+	return out[:min(n, len(out))]
+}
+
+// streamBudget enforces MaxBytesPerStreamPerBatch for the worker's batch.
+// Entries it does not admit are held until the next batch. It is used from
+// the worker goroutine only.
+type streamBudget struct {
+	max int
+	// used is the line bytes per stream key in the current batch.
+	used map[string]int
+	// held are entries spilled to the next batch, in arrival order, and
+	// heldStreams counts them by stream key.
+	held        []queuedEntry
+	heldStreams map[string]int
+	heldBytes   int
+}
+
+func newStreamBudget(max int) *streamBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &streamBudget{max: max, used: map[string]int{}, heldStreams: map[string]int{}}
+}
+
+// admit reports whether the entry of stream key with size line bytes fits
+// the stream's share of the current batch, and accounts for it if so. A
+// stream with held entries admits nothing more, so its order is kept.
+func (b *streamBudget) admit(key string, size int) bool {
+	if b.heldStreams[key] > 0 {
+		return false
+	}
+	if used := b.used[key]; used > 0 && used+size > b.max {
+		return false
+	}
+	b.used[key] += size
+	return true
+}
+
+func (b *streamBudget) hold(key string, e queuedEntry) {
+	b.held = append(b.held, e)
+	b.heldStreams[key]++
+	b.heldBytes += len(e.Line)
+}
+
+// next starts a new batch and returns the held entries to add to it.
+func (b *streamBudget) next() []queuedEntry {
+	clear(b.used)
+	held := b.held
+	b.held = nil
+	clear(b.heldStreams)
+	b.heldBytes = 0
+	return held
+}
+
+// release replaces the per-stream maps, which keep their size after being
+// cleared, before next starts a batch. It is nil-safe.
+func (b *streamBudget) release() {
+	if b == nil {
+		return
+	}
+	b.used, b.heldStreams = map[string]int{}, map[string]int{}
+}
+
+// heldLen and heldSize are nil-safe, for QueueLen and PendingBytes.
+func (b *streamBudget) heldLen() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.held)
+}
+
+func (b *streamBudget) heldSize() int {
+	if b == nil {
+		return 0
+	}
+	return b.heldBytes
+}
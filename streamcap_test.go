@@ -0,0 +1,104 @@
+package lokigo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// linesByApp returns the lines of each push by their "app" label.
+func linesByApp(pushes []jsonPush) []map[string][]string {
+	var got []map[string][]string
+	for _, p := range pushes {
+		push := map[string][]string{}
+		for _, s := range p.streams {
+			for _, v := range s.Values {
+				push[s.Stream["app"]] = append(push[s.Stream["app"]], v.line)
+			}
+		}
+		got = append(got, push)
+	}
+	return got
+}
+
+func sendNoisyThenQuiet(t *testing.T, c *Client) {
+	t.Helper()
+	for i := range 20 {
+		if err := c.Send(context.Background(), Entry{Line: fmt.Sprintf("n%03d", i), Labels: map[string]string{"app": "noisy"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Send(context.Background(), Entry{Line: "quiet", Labels: map[string]string{"app": "quiet"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxBytesPerStreamPerBatchSpillsNoisyStream(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+	c, err := NewClient(Config{
+		Endpoint:                  srv.URL,
+		Encoding:                  EncodingJSON,
+		BatchMaxEntries:           10,
+		BatchMaxWait:              time.Minute,
+		MaxBytesPerStreamPerBatch: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendNoisyThenQuiet(t, c)
+
+	got := linesByApp(rec.received())
+	// Without the cap the quiet entry would wait behind two full batches
+	// of the noisy stream.
+	if !reflect.DeepEqual(got[0]["quiet"], []string{"quiet"}) {
+		t.Fatalf("first push = %v, want the quiet entry in it", got[0])
+	}
+	var noisy []string
+	for _, p := range got {
+		if len(p["noisy"]) > 2 {
+			t.Fatalf("push carries %d noisy entries over the 8 byte share: %v", len(p["noisy"]), p)
+		}
+		noisy = append(noisy, p["noisy"]...)
+	}
+	for i, line := range noisy {
+		if want := fmt.Sprintf("n%03d", i); line != want {
+			t.Fatalf("noisy entry %d = %q, want %q; spilling must keep stream order", i, line, want)
+		}
+	}
+	if len(noisy) != 20 {
+		t.Fatalf("got %d noisy entries, want 20", len(noisy))
+	}
+	m := c.Metrics()
+	if m.Dropped != 0 || !reflect.DeepEqual(m.StreamOverflows, []StreamCount{{Stream: `{app="noisy"}`, Count: 18}}) {
+		t.Fatalf("Dropped = %d, StreamOverflows = %v", m.Dropped, m.StreamOverflows)
+	}
+}
+
+func TestMaxBytesPerStreamPerBatchDropPolicy(t *testing.T) {
+	srv, rec := captureJSONPushes(t)
+	c, err := NewClient(Config{
+		Endpoint:                  srv.URL,
+		Encoding:                  EncodingJSON,
+		BatchMaxEntries:           10,
+		BatchMaxWait:              time.Minute,
+		MaxBytesPerStreamPerBatch: 8,
+		StreamOverflowPolicy:      StreamOverflowDrop,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendNoisyThenQuiet(t, c)
+
+	want := []map[string][]string{{"noisy": {"n000", "n001"}, "quiet": {"quiet"}}}
+	if got := linesByApp(rec.received()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("pushes = %v, want %v", got, want)
+	}
+	if got := c.Metrics().DroppedByReason[DropStreamOverflow]; got != 18 {
+		t.Fatalf("stream_overflow drops = %d, want 18", got)
+	}
+}
@@ -0,0 +1,80 @@
+package lokigo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rotateConnections closes idle pooled connections once MaxConnLifetime has
+// elapsed since the previous rotation, so the next push re-dials the endpoint.
+// Connections in use are left alone; they return to the pool after the
+// current request and are closed on the following rotation.
+func (c *Client) rotateConnections() {
+	if c.cfg.MaxConnLifetime <= 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := c.connRotatedAt.Load()
+	if now-last < int64(c.cfg.MaxConnLifetime) {
+		return
+	}
+	if c.connRotatedAt.CompareAndSwap(last, now) {
+		c.httpClient.CloseIdleConnections()
+	}
+}
+
+// noRedirectClient returns a shallow copy of hc that hands redirect responses
+// back to the caller, so pushOnce can re-send the payload itself.
+func noRedirectClient(hc *http.Client) *http.Client {
+	out := *hc
+	out.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &out
+}
+
+func (c *Client) shouldFollowRedirect(status int) bool {
+	switch status {
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	case http.StatusMovedPermanently, http.StatusFound:
+		return c.cfg.Redirect.FollowMoved
+	default:
+		return false
+	}
+}
+
+// followRedirect re-sends the push to the Location of resp. If the response
+// carries no usable Location, resp is returned unchanged and surfaces as an
+// HTTPStatusPushError.
+func (c *Client) followRedirect(ctx context.Context, req *http.Request, resp *http.Response) (*http.Response, error) {
+	loc, err := resp.Location()
+	if err != nil {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	next, err := http.NewRequestWithContext(ctx, http.MethodPost, loc.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if next.Body, err = req.GetBody(); err != nil {
+		return nil, err
+	}
+	next.GetBody, next.ContentLength = req.GetBody, req.ContentLength
+	next.Header = req.Header.Clone()
+	if c.cfg.Redirect.StripAuthCrossHost && !strings.EqualFold(loc.Host, req.URL.Host) {
+		next.Header.Del("Authorization")
+		next.Header.Del("Cookie")
+	}
+	c.redirects.Add(1)
+	out, err := c.httpClient.Do(next)
+	if err != nil {
+		return nil, newNetworkPushError(err)
+	}
+	return out, nil
+}
@@ -0,0 +1,64 @@
+package lokigo
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxFutureSkew is how far past the current time, corrected under
+// CorrectClockSkew, ValidateOnSend accepts an entry timestamp, matching
+// Loki's default creation_grace_period.
+const maxFutureSkew = 10 * time.Minute
+
+// ValidationError is returned by Send under Config.ValidateOnSend for an
+// entry Loki would reject. The entry is not enqueued.
+type ValidationError struct {
+	// Field is "line", "timestamp", "labels" or "labels.<name>".
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("lokigo: invalid entry %s: %s", e.Field, e.Reason)
+}
+
+// validateEntry checks e as it will be pushed: its labels go through the same
+// mergedLabels/sanitizeLabels pipeline the encoders use, so what passes here
+// is exactly what reaches the wire.
+func (c *Client) validateEntry(e Entry) *ValidationError {
+	if len(e.Line) > c.cfg.BatchMaxBytes {
+		return &ValidationError{Field: "line", Reason: fmt.Sprintf("%d bytes exceeds BatchMaxBytes (%d)", len(e.Line), c.cfg.BatchMaxBytes)}
+	}
+	if e.Timestamp.Before(time.Unix(0, 0)) {
+		return &ValidationError{Field: "timestamp", Reason: "before the Unix epoch"}
+	}
+	if e.Timestamp.After(c.now().Add(maxFutureSkew)) {
+		return &ValidationError{Field: "timestamp", Reason: fmt.Sprintf("more than %s in the future", maxFutureSkew)}
+	}
+	labels := c.entryLabels(e)
+	if len(labels) == 0 {
+		return &ValidationError{Field: "labels", Reason: "stream has no labels"}
+	}
+	for k := range labels {
+		if !validLabelName(k) {
+			return &ValidationError{Field: "labels." + k, Reason: "invalid label name"}
+		}
+	}
+	return nil
+}
+
+// validLabelName reports whether k matches Loki's [a-zA-Z_][a-zA-Z0-9_]*.
+func validLabelName(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i, r := range k {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateOnSendRejectsBadEntries(t *testing.T) {
+	srv, got := newJSONCaptureServer(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON, ValidateOnSend: true, BatchMaxBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	app := map[string]string{"app": "api"}
+	cases := []struct {
+		name  string
+		entry Entry
+		field string
+	}{
+		{"oversize line", Entry{Line: strings.Repeat("x", 65), Labels: app}, "line"},
+		{"before epoch", Entry{Timestamp: time.Unix(-1, 0), Line: "x", Labels: app}, "timestamp"},
+		{"far future", Entry{Timestamp: time.Now().Add(time.Hour), Line: "x", Labels: app}, "timestamp"},
+		{"no labels", Entry{Line: "x"}, "labels"},
+		{"only empty labels", Entry{Line: "x", Labels: map[string]string{"app": ""}}, "labels"},
+		{"dashed name", Entry{Line: "x", Labels: map[string]string{"app-name": "api"}}, "labels.app-name"},
+		{"leading digit", Entry{Line: "x", Labels: map[string]string{"1app": "api"}}, "labels.1app"},
+	}
+	for _, tc := range cases {
+		err := c.Send(ctx, tc.entry)
+		var verr *ValidationError
+		if !errors.As(err, &verr) || verr.Field != tc.field {
+			t.Fatalf("%s: err = %v, want ValidationError on %q", tc.name, err, tc.field)
+		}
+	}
+
+	// Labels are judged after sanitizing, as they will be pushed: the
+	// stripped reserved label does not make the entry invalid.
+	ok := Entry{Line: "ok", Labels: map[string]string{"app": "api", "__internal__": "x", "trace_id": ""}}
+	if err := c.Send(ctx, ok); err != nil {
+		t.Fatalf("valid entry rejected: %v", err)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	entries := 0
+	for _, p := range got() {
+		entries += p.entries
+	}
+	if entries != 1 {
+		t.Fatalf("pushed %d entries, want only the valid one", entries)
+	}
+}
+
+func TestValidateOnSendOffKeepsEnqueueing(t *testing.T) {
+	srv, _ := newJSONCaptureServer(t)
+	c, err := NewClient(Config{Endpoint: srv.URL, Encoding: EncodingJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	if err := c.Send(context.Background(), Entry{Line: "x", Labels: map[string]string{"app-name": "api"}}); err != nil {
+		t.Fatalf("Send without ValidateOnSend: %v", err)
+	}
+}
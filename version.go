@@ -0,0 +1,80 @@
+package lokigo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// Version is the lokigo release this source tree belongs to. It is bumped
+// as part of cutting a release; BuildInfo prefers the module version the
+// Go toolchain recorded in the binary, which also covers pseudo-versions.
+const Version = "0.1.7"
+
+// modulePath is the import path of this module.
+const modulePath = "github.com/zabihimohsen/lokigo"
+
+// BuildInfoSummary identifies the lokigo build linked into the binary.
+type BuildInfoSummary struct {
+	// Version is the module version recorded in the binary, or Version when
+	// none is recorded, as in tests or builds of this module itself.
+	Version string
+	// GoVersion is the Go toolchain that built the binary.
+	GoVersion string
+	// Encodings lists the push encodings this build supports.
+	Encodings []Encoding
+	// Modules lists the lokigo modules linked into the binary, such as
+	// github.com/zabihimohsen/lokigo/lokigoaws, with their versions.
+	Modules map[string]string
+}
+
+var buildInfo = sync.OnceValue(func() BuildInfoSummary {
+	out := BuildInfoSummary{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		Encodings: []Encoding{EncodingProtobufSnappy, EncodingJSON, EncodingJSONLegacy},
+		Modules:   map[string]string{},
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return out
+	}
+	mods := append([]*debug.Module{&bi.Main}, bi.Deps...)
+	for _, m := range mods {
+		if m.Path != modulePath && !strings.HasPrefix(m.Path, modulePath+"/") {
+			continue
+		}
+		v := m.Version
+		if m.Replace != nil {
+			v = m.Replace.Version
+		}
+		if v == "" || v == "(devel)" {
+			v = Version
+		}
+		if m.Path == modulePath {
+			out.Version = v
+		}
+		out.Modules[m.Path] = v
+	}
+	return out
+})
+
+// BuildInfo returns the lokigo version and the encodings and modules linked
+// into the binary, for diagnostics and bug reports. The Modules map is owned
+// by the caller.
+func BuildInfo() BuildInfoSummary {
+	out := buildInfo()
+	out.Encodings = append([]Encoding(nil), out.Encodings...)
+	mods := make(map[string]string, len(out.Modules))
+	for k, v := range out.Modules {
+		mods[k] = v
+	}
+	out.Modules = mods
+	return out
+}
+
+// defaultUserAgent is the Config.UserAgent default, "lokigo/<version>".
+func defaultUserAgent() string {
+	return "lokigo/" + buildInfo().Version
+}
@@ -0,0 +1,55 @@
+package lokigo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestVersionInUserAgent(t *testing.T) {
+	if Version == "" {
+		t.Fatal("Version must not be empty")
+	}
+	info := BuildInfo()
+	if info.Version == "" || info.GoVersion == "" {
+		t.Fatalf("incomplete build info: %+v", info)
+	}
+	if !slices.Contains(info.Encodings, EncodingJSON) || !slices.Contains(info.Encodings, EncodingProtobufSnappy) {
+		t.Fatalf("encodings = %v", info.Encodings)
+	}
+
+	agents := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.Header.Get("User-Agent")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		headers map[string]string
+		want    string
+	}{
+		{nil, "lokigo/" + info.Version},
+		{map[string]string{"user-agent": "my-app/2"}, "my-app/2"},
+	} {
+		c, err := NewClient(Config{Endpoint: srv.URL, Headers: tc.headers, BatchMaxEntries: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Send(context.Background(), Entry{Line: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-agents; got != tc.want {
+			t.Fatalf("User-Agent = %q, want %q", got, tc.want)
+		}
+		if !strings.Contains(c.EffectiveConfig().String(), "UserAgent:lokigo/") {
+			t.Fatalf("config dump lacks the default user agent: %s", c.EffectiveConfig())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrWorkerDown is returned by Send once the background worker panicked
+// more than maxWorkerRestarts times within workerRestartWindow and was not
+// restarted. Health then reports HealthDown.
+var ErrWorkerDown = errors.New("lokigo: background worker is down after repeated panics")
+
+const (
+	// maxWorkerRestarts bounds the worker restarts within
+	// workerRestartWindow, so a deterministic panic does not hot-loop.
+	maxWorkerRestarts   = 3
+	workerRestartWindow = time.Minute
+)
+
+// WorkerPanicError describes a panic recovered from the background worker,
+// such as one raised by a Batcher or a PushInterceptor. It is passed to
+// OnError.
+type WorkerPanicError struct {
+	Value any
+	Stack []byte
+	// Lost is the number of entries the worker held, in the batch being
+	// assembled or pushed, which were dropped as DropWorkerPanic.
+	Lost int
+	// Restarted reports whether the worker was restarted. Queued entries
+	// are kept for the new worker; otherwise they are dropped as
+	// DropWorkerDown.
+	Restarted bool
+}
+
+func (e *WorkerPanicError) Error() string {
+	return fmt.Sprintf("lokigo: background worker panicked: %v", e.Value)
+}
+
+// run supervises the worker loop: a panic is reported and the loop started
+// again with the queue as it is, up to maxWorkerRestarts times within
+// workerRestartWindow. Past that, the client is marked down: queued entries
+// are dropped and Send fails fast with ErrWorkerDown.
+func (c *Client) run(ctx context.Context) {
+	defer c.wg.Done()
+	defer close(c.workerDone)
+	defer c.closeShadow()
+	var panics []time.Time
+	for {
+		p := c.runWorker(ctx)
+		if p == nil {
+			return
+		}
+		now := time.Now()
+		panics = append(panics, now)
+		for len(panics) > 0 && now.Sub(panics[0]) > workerRestartWindow {
+			panics = panics[1:]
+		}
+		p.Restarted = len(panics) <= maxWorkerRestarts
+		c.workerPanics.Add(1)
+		c.batchLen.Store(0)
+		c.batchBytes.Store(0)
+		if p.Lost > 0 {
+			c.workerDrop(DropWorkerPanic, p.Lost)
+		}
+		c.debug("background worker panicked", "error", p, "restarted", p.Restarted)
+		if !p.Restarted {
+			c.workerDown.Store(true)
+			c.stopped.Store(true)
+			c.health.down(p)
+			if n := c.discardQueued(); n > 0 {
+				c.workerDrop(DropWorkerDown, n)
+			}
+		}
+		c.setErr(p, true)
+		if !p.Restarted {
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package lokigo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// panickingInterceptor panics on the first n pushes.
+func panickingInterceptor(n int64) func(PushFunc) PushFunc {
+	var pushes atomic.Int64
+	return func(next PushFunc) PushFunc {
+		return func(ctx context.Context, req *PushRequestInfo) error {
+			if pushes.Add(1) <= n {
+				panic("encoder bug")
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+func TestWorkerRecoversFromPanic(t *testing.T) {
+	srv, streams := captureJSONStreams(t)
+	var mu sync.Mutex
+	var panics []*WorkerPanicError
+	c, err := NewClient(Config{
+		Endpoint:         srv.URL,
+		Encoding:         EncodingJSON,
+		BatchMaxEntries:  2,
+		BatchMaxWait:     time.Minute,
+		PushInterceptors: []func(PushFunc) PushFunc{panickingInterceptor(1)},
+		OnError: func(err error) {
+			var p *WorkerPanicError
+			if errors.As(err, &p) {
+				mu.Lock()
+				panics = append(panics, p)
+				mu.Unlock()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a", "b", "c", "d", "e"} {
+		if err := c.Send(context.Background(), Entry{Line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close reports the last background error, the panic.
+	if err := c.Close(context.Background()); !errors.As(err, new(*WorkerPanicError)) {
+		t.Fatalf("Close = %v, want the worker panic", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(panics) != 1 || panics[0].Value != "encoder bug" || !panics[0].Restarted || len(panics[0].Stack) == 0 {
+		t.Fatalf("OnError panics = %+v, want one restarted panic with its stack", panics)
+	}
+	m := c.Metrics()
+	var pushed int
+	for _, s := range streams() {
+		pushed += s.entries
+	}
+	if m.WorkerPanics != 1 || m.DroppedByReason[DropWorkerPanic] != uint64(panics[0].Lost) || pushed+panics[0].Lost != 5 {
+		t.Fatalf("pushed %d, lost %d, metrics %+v; want all 5 entries accounted for", pushed, panics[0].Lost, m)
+	}
+	if h := c.Health(); h.State == HealthDown {
+		t.Fatalf("Health = %+v after a single panic", h)
+	}
+}
+
+func TestWorkerDownAfterRepeatedPanics(t *testing.T) {
+	srv, _ := captureJSONStreams(t)
+	var onError atomic.Int64
+	c, err := NewClient(Config{
+		Endpoint:         srv.URL,
+		Encoding:         EncodingJSON,
+		BatchMaxEntries:  1,
+		BatchMaxWait:     time.Minute,
+		PushInterceptors: []func(PushFunc) PushFunc{panickingInterceptor(1 << 30)},
+		OnError:          func(error) { onError.Add(1) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(context.Background())
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Health().State != HealthDown {
+		if time.Now().After(deadline) {
+			t.Fatalf("Health = %+v, want down", c.Health())
+		}
+		err := c.Send(context.Background(), Entry{Line: "x"})
+		if err != nil && !errors.Is(err, ErrWorkerDown) {
+			t.Fatalf("Send: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Send(context.Background(), Entry{Line: "x"}); !errors.Is(err, ErrWorkerDown) {
+		t.Fatalf("Send after the worker went down = %v, want ErrWorkerDown", err)
+	}
+	var p *WorkerPanicError
+	if h := c.Health(); !errors.As(h.LastError, &p) || p.Restarted {
+		t.Fatalf("Health.LastError = %v, want the final worker panic", h.LastError)
+	}
+	if m := c.Metrics(); m.WorkerPanics != maxWorkerRestarts+1 || onError.Load() != maxWorkerRestarts+1 {
+		t.Fatalf("WorkerPanics = %d, OnError calls = %d; want %d", m.WorkerPanics, onError.Load(), maxWorkerRestarts+1)
+	}
+	if n := c.QueueLen(); n != 0 {
+		t.Fatalf("QueueLen = %d after the worker went down, want 0", n)
+	}
+}